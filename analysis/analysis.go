@@ -0,0 +1,530 @@
+// Package analysis measures the frames cameras send: statistics of the pixels, the background, and the stars, with
+// their HFR and FWHM, which is enough to judge focus and the quality of a frame as soon as it arrives:
+//
+//	client.HandleBlobs(filter, 1, indiclient.DropPolicyOldest, func(b *indiclient.Blob) {
+//		result, err := analysis.BlobAnalysis(b)
+//		...
+//		fmt.Println(result.StarCount, result.HFR)
+//	})
+//
+// BlobAnalysis attaches the analysis to the BLOB, so it is done just once however many handlers of the BLOB ask for
+// it. AnalyzeFITS analyzes a FITS file from any reader, and Analyze an image that has already been decoded.
+package analysis
+
+import (
+	"io"
+	"math"
+	"sort"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/fits"
+)
+
+const (
+	// DefaultDetectionSigma is how many standard deviations of noise above the background a star must peak at.
+	DefaultDetectionSigma = 5
+
+	// DefaultRadius is the radius in pixels of the box stars are measured in.
+	DefaultRadius = 10
+
+	// DefaultMinPixels is the number of pixels above the detection threshold a star needs, so hot pixels and cosmic
+	// rays are not taken for stars.
+	DefaultMinPixels = 4
+
+	// DefaultMaxStars is the most stars measured, the brightest first.
+	DefaultMaxStars = 500
+
+	// maxSamples is the most pixels the background is estimated from.
+	maxSamples = 1 << 20
+
+	// sigmaToFWHM converts the standard deviation of a gaussian to its full width at half maximum.
+	sigmaToFWHM = 2.3548200450309493
+)
+
+// Options controls how a frame is analyzed. The zero value uses the defaults.
+type Options struct {
+	// Saturation is the value at and above which a pixel is saturated. If it is 0, it is the largest value of the
+	// type of data in the image, treating integers as unsigned the way INDI cameras write them. Floating point images
+	// are never saturated unless it is set.
+	Saturation float64
+
+	// DetectionSigma is how many standard deviations of noise above the background a star must peak at.
+	DetectionSigma float64
+
+	// Radius is the radius in pixels of the box stars are measured in. It should be bigger than the biggest star.
+	Radius int
+
+	// MinPixels is the number of pixels above the detection threshold a star needs.
+	MinPixels int
+
+	// MaxStars is the most stars measured, the brightest first.
+	MaxStars int
+}
+
+// Star is a single star found in a frame.
+type Star struct {
+	// X and Y are the centroid of the star, from the bottom left of the frame.
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+
+	// Peak is the brightest pixel of the star, and Flux the sum of its pixels, both without the background.
+	Peak float64 `json:"peak"`
+	Flux float64 `json:"flux"`
+
+	// HFR is the half flux radius in pixels, the mean distance of the flux of the star from its centroid.
+	HFR float64 `json:"hfr"`
+
+	// FWHM is the full width at half maximum in pixels, from the second moments of the star.
+	FWHM float64 `json:"fwhm"`
+
+	// Eccentricity is 0 for a round star, rising towards 1 the more elongated it is.
+	Eccentricity float64 `json:"eccentricity"`
+
+	// Saturated is true if any pixel of the star is saturated, which makes its measurements less reliable.
+	Saturated bool `json:"saturated"`
+}
+
+// Result is the analysis of a frame. For a color frame, the statistics are of the mean of its planes.
+type Result struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stdDev"`
+
+	// SaturatedFraction is the fraction of pixels that are saturated, from 0 to 1.
+	SaturatedFraction float64 `json:"saturatedFraction"`
+
+	// Background is the level of the sky, and Noise its standard deviation, with the stars left out.
+	Background float64 `json:"background"`
+	Noise      float64 `json:"noise"`
+
+	Stars     []Star `json:"stars"`
+	StarCount int    `json:"starCount"`
+
+	// HFR, FWHM and Eccentricity are the medians of those of the stars, or 0 if there are none.
+	HFR          float64 `json:"hfr"`
+	FWHM         float64 `json:"fwhm"`
+	Eccentricity float64 `json:"eccentricity"`
+}
+
+// AnalyzeFITS analyzes the image in the primary HDU of a FITS file read from r.
+func AnalyzeFITS(r io.Reader, opts Options) (*Result, error) {
+	f, err := fits.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	im, err := f.Primary().Image()
+	if err != nil {
+		return nil, err
+	}
+
+	return Analyze(im, opts), nil
+}
+
+// AnalyzeBlob analyzes the image in a FITS BLOB delivered by HandleBlobs, reading it back from the BlobStore it was
+// stored in. Each call analyzes the BLOB again, so a BLOB shared by several handlers is best analyzed by just one.
+func AnalyzeBlob(b *indiclient.Blob, opts Options) (*Result, error) {
+	rdr, err := b.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	return AnalyzeFITS(rdr, opts)
+}
+
+// attachmentKey is the key the analysis of a BLOB is attached to it under.
+type attachmentKey struct{}
+
+// BlobAnalysis returns the analysis of the image in a FITS BLOB with the default Options, which is attached to the BLOB
+// the first time it is asked for, so every handler of the BLOB shares it. Use AnalyzeBlob for other Options.
+func BlobAnalysis(b *indiclient.Blob) (*Result, error) {
+	v, err := b.Attachment(attachmentKey{}, func(b *indiclient.Blob) (interface{}, error) {
+		return AnalyzeBlob(b, Options{})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*Result), nil
+}
+
+// Analyze analyzes an image.
+func Analyze(im *fits.Image, opts Options) *Result {
+	opts = opts.withDefaults(im)
+
+	width, height := im.Width(), im.Height()
+	pixels := luminance(im)
+
+	res := &Result{
+		Width:  width,
+		Height: height,
+		Stars:  []Star{},
+	}
+
+	if len(pixels) == 0 {
+		return res
+	}
+
+	res.Min, res.Max = pixels[0], pixels[0]
+
+	var sum float64
+	var saturated int
+
+	for _, p := range pixels {
+		sum += p
+
+		if p < res.Min {
+			res.Min = p
+		}
+
+		if p > res.Max {
+			res.Max = p
+		}
+
+		if opts.Saturation > 0 && p >= opts.Saturation {
+			saturated++
+		}
+	}
+
+	res.Mean = sum / float64(len(pixels))
+	res.StdDev = stdDev(pixels, res.Mean)
+	res.Median = median(append([]float64(nil), pixels...))
+	res.SaturatedFraction = float64(saturated) / float64(len(pixels))
+
+	res.Background, res.Noise = background(pixels)
+
+	res.Stars = findStars(pixels, width, height, res.Background, res.Noise, opts)
+	res.StarCount = len(res.Stars)
+
+	if res.StarCount > 0 {
+		hfr := make([]float64, res.StarCount)
+		fwhm := make([]float64, res.StarCount)
+		ecc := make([]float64, res.StarCount)
+
+		for i, s := range res.Stars {
+			hfr[i] = s.HFR
+			fwhm[i] = s.FWHM
+			ecc[i] = s.Eccentricity
+		}
+
+		res.HFR = median(hfr)
+		res.FWHM = median(fwhm)
+		res.Eccentricity = median(ecc)
+	}
+
+	return res
+}
+
+func (opts Options) withDefaults(im *fits.Image) Options {
+	if opts.Saturation == 0 && im.Bitpix > 0 {
+		opts.Saturation = math.Pow(2, float64(im.Bitpix)) - 1
+	}
+
+	if opts.DetectionSigma <= 0 {
+		opts.DetectionSigma = DefaultDetectionSigma
+	}
+
+	if opts.Radius <= 0 {
+		opts.Radius = DefaultRadius
+	}
+
+	if opts.MinPixels <= 0 {
+		opts.MinPixels = DefaultMinPixels
+	}
+
+	if opts.MaxStars <= 0 {
+		opts.MaxStars = DefaultMaxStars
+	}
+
+	return opts
+}
+
+// luminance returns the pixels of a mono image, or the mean of the planes of a color one.
+func luminance(im *fits.Image) []float64 {
+	planes := im.Planes()
+	if planes == 1 {
+		return im.Plane(0)
+	}
+
+	pixels := make([]float64, im.Width()*im.Height())
+
+	for plane := 0; plane < planes; plane++ {
+		for i, p := range im.Plane(plane) {
+			pixels[i] += p / float64(planes)
+		}
+	}
+
+	return pixels
+}
+
+// background estimates the level and noise of the sky by clipping the stars, and anything else bright, from a sample
+// of the pixels.
+func background(pixels []float64) (float64, float64) {
+	step := len(pixels)/maxSamples + 1
+
+	sample := make([]float64, 0, len(pixels)/step+1)
+	for i := 0; i < len(pixels); i += step {
+		sample = append(sample, pixels[i])
+	}
+
+	level := median(append([]float64(nil), sample...))
+	noise := stdDev(sample, level)
+
+	for i := 0; i < 5; i++ {
+		clipped := sample[:0]
+
+		for _, p := range sample {
+			if math.Abs(p-level) <= 3*noise {
+				clipped = append(clipped, p)
+			}
+		}
+
+		if len(clipped) == 0 || len(clipped) == len(sample) {
+			break
+		}
+
+		sample = clipped
+		level = median(append([]float64(nil), sample...))
+		noise = stdDev(sample, level)
+	}
+
+	return level, noise
+}
+
+// findStars finds the local maxima above the detection threshold, and measures each of them that looks like a star.
+func findStars(pixels []float64, width, height int, bg, noise float64, opts Options) []Star {
+	threshold := bg + opts.DetectionSigma*noise
+
+	type peak struct {
+		x, y  int
+		value float64
+	}
+
+	var peaks []peak
+
+	r := opts.Radius
+
+	for y := r; y < height-r; y++ {
+		for x := r; x < width-r; x++ {
+			v := pixels[y*width+x]
+			if v <= threshold || !localMaximum(pixels, width, x, y) {
+				continue
+			}
+
+			peaks = append(peaks, peak{x: x, y: y, value: v})
+		}
+	}
+
+	sort.Slice(peaks, func(i, j int) bool {
+		return peaks[i].value > peaks[j].value
+	})
+
+	stars := []Star{}
+
+	for _, p := range peaks {
+		if len(stars) == opts.MaxStars {
+			break
+		}
+
+		// The fainter peaks within a star, or right next to one, are part of it.
+		near := false
+
+		for _, s := range stars {
+			if math.Abs(s.X-float64(p.x)) <= float64(r) && math.Abs(s.Y-float64(p.y)) <= float64(r) {
+				near = true
+				break
+			}
+		}
+
+		if near {
+			continue
+		}
+
+		if s, ok := measure(pixels, width, p.x, p.y, bg, threshold, opts); ok {
+			stars = append(stars, s)
+		}
+	}
+
+	return stars
+}
+
+// localMaximum returns true if the pixel at x, y is brighter than the pixels around it. Of a flat top, only the first
+// pixel counts.
+func localMaximum(pixels []float64, width, x, y int) bool {
+	v := pixels[y*width+x]
+
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+
+			n := pixels[(y+dy)*width+x+dx]
+
+			// Neighbors before this pixel must be darker, and those after no brighter.
+			if n > v || (n == v && (dy < 0 || (dy == 0 && dx < 0))) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// measure measures the star peaking at x, y, in the box of opts.Radius around it.
+func measure(pixels []float64, width, x, y int, bg, threshold float64, opts Options) (Star, bool) {
+	r := opts.Radius
+
+	var s Star
+	var above int
+	var sx, sy float64
+
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			v := pixels[(y+dy)*width+x+dx]
+
+			if v > threshold {
+				above++
+			}
+
+			if opts.Saturation > 0 && v >= opts.Saturation {
+				s.Saturated = true
+			}
+
+			w := v - bg
+			if w <= 0 {
+				continue
+			}
+
+			s.Flux += w
+			sx += w * float64(x+dx)
+			sy += w * float64(y+dy)
+		}
+	}
+
+	if above < opts.MinPixels || s.Flux <= 0 {
+		return Star{}, false
+	}
+
+	s.X = sx / s.Flux
+	s.Y = sy / s.Flux
+	s.Peak = pixels[y*width+x] - bg
+
+	var hfr, xx, yy, xy float64
+
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			w := pixels[(y+dy)*width+x+dx] - bg
+			if w <= 0 {
+				continue
+			}
+
+			ddx := float64(x+dx) - s.X
+			ddy := float64(y+dy) - s.Y
+
+			hfr += w * math.Sqrt(ddx*ddx+ddy*ddy)
+			xx += w * ddx * ddx
+			yy += w * ddy * ddy
+			xy += w * ddx * ddy
+		}
+	}
+
+	s.HFR = hfr / s.Flux
+
+	xx /= s.Flux
+	yy /= s.Flux
+	xy /= s.Flux
+
+	// The eigenvalues of the covariance matrix are the variances along the major and minor axes of the star.
+	mean := (xx + yy) / 2
+	diff := math.Sqrt((xx-yy)*(xx-yy)/4 + xy*xy)
+	major, minor := mean+diff, mean-diff
+
+	s.FWHM = sigmaToFWHM * math.Sqrt(mean)
+
+	if major > 0 && minor >= 0 {
+		s.Eccentricity = math.Sqrt(1 - minor/major)
+	}
+
+	return s, true
+}
+
+func stdDev(values []float64, mean float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+
+	return math.Sqrt(sum / float64(len(values)))
+}
+
+// median returns the median of values, which it reorders.
+func median(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+
+	upper := selectNth(values, n/2)
+	if n%2 == 1 {
+		return upper
+	}
+
+	// The lower middle value is the largest of those before the upper one.
+	lower := values[0]
+	for _, v := range values[1 : n/2] {
+		if v > lower {
+			lower = v
+		}
+	}
+
+	return (lower + upper) / 2
+}
+
+// selectNth reorders values so the nth smallest is at n, with the smaller values before it, and returns it.
+func selectNth(values []float64, n int) float64 {
+	lo, hi := 0, len(values)-1
+
+	for lo < hi {
+		pivot := values[(lo+hi)/2]
+		i, j := lo, hi
+
+		for i <= j {
+			for values[i] < pivot {
+				i++
+			}
+
+			for values[j] > pivot {
+				j--
+			}
+
+			if i <= j {
+				values[i], values[j] = values[j], values[i]
+				i++
+				j--
+			}
+		}
+
+		switch {
+		case n <= j:
+			hi = j
+		case n >= i:
+			lo = i
+		default:
+			return values[n]
+		}
+	}
+
+	return values[n]
+}
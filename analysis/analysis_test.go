@@ -0,0 +1,226 @@
+package analysis_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/analysis"
+	"github.com/goastro/indiclient/fits"
+	"github.com/goastro/indiclient/inditest"
+)
+
+// star adds a gaussian star to pixels.
+func star(pixels []float64, width int, x, y, peak, sigmaX, sigmaY float64) {
+	height := len(pixels) / width
+
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			dx := (float64(px) - x) / sigmaX
+			dy := (float64(py) - y) / sigmaY
+			pixels[py*width+px] += peak * math.Exp(-(dx*dx+dy*dy)/2)
+		}
+	}
+}
+
+// frame returns a 16 bit frame with a background of 100, a little noise, three stars and a hot pixel.
+func frame() *fits.Image {
+	const width, height = 120, 120
+
+	rnd := rand.New(rand.NewSource(1))
+
+	pixels := make([]float64, width*height)
+	for i := range pixels {
+		pixels[i] = math.Round(100 + rnd.Float64()*2 - 1)
+	}
+
+	star(pixels, width, 30, 30, 1000, 2, 2)
+	star(pixels, width, 80, 40.5, 2000, 3, 3)
+	star(pixels, width, 50, 80, 1500, 4, 1.5)
+
+	pixels[100*width+100] = 65535
+
+	return &fits.Image{Naxis: []int{width, height}, Bitpix: 16, Pixels: pixels}
+}
+
+func Test_Analyze(t *testing.T) {
+	res := analysis.Analyze(frame(), analysis.Options{})
+
+	assert.Equal(t, 120, res.Width)
+	assert.Equal(t, 120, res.Height)
+	assert.Equal(t, 65535.0, res.Max)
+	assert.InDelta(t, 100, res.Median, 1)
+	assert.InDelta(t, 100, res.Background, 1)
+	assert.InDelta(t, 0.6, res.Noise, 0.3)
+	assert.Greater(t, res.Mean, res.Median)
+	assert.Equal(t, 1.0/(120*120), res.SaturatedFraction)
+
+	// The hot pixel is not a star.
+	require.Equal(t, 3, res.StarCount)
+	require.Len(t, res.Stars, 3)
+
+	// The brightest star comes first.
+	bright := res.Stars[0]
+	assert.InDelta(t, 80, bright.X, 0.1)
+	assert.InDelta(t, 40.5, bright.Y, 0.1)
+	assert.InDelta(t, 3*2.3548, bright.FWHM, 0.5)
+	assert.Less(t, bright.Eccentricity, 0.3)
+	assert.False(t, bright.Saturated)
+
+	var round, elongated analysis.Star
+
+	for _, s := range res.Stars {
+		switch {
+		case math.Abs(s.X-30) < 1:
+			round = s
+		case math.Abs(s.X-50) < 1:
+			elongated = s
+		}
+	}
+
+	assert.InDelta(t, 2*2.3548, round.FWHM, 0.3)
+	assert.InDelta(t, 2*math.Sqrt(math.Pi/2), round.HFR, 0.3)
+	assert.Less(t, round.Eccentricity, 0.3)
+
+	assert.InDelta(t, math.Sqrt(1-1.5*1.5/16), elongated.Eccentricity, 0.1)
+
+	hfr := []float64{res.Stars[0].HFR, res.Stars[1].HFR, res.Stars[2].HFR}
+	sort.Float64s(hfr)
+	assert.Equal(t, hfr[1], res.HFR)
+}
+
+func Test_Analyze_Options(t *testing.T) {
+	res := analysis.Analyze(frame(), analysis.Options{MaxStars: 1, Saturation: 1000})
+
+	require.Equal(t, 1, res.StarCount)
+	assert.InDelta(t, 80, res.Stars[0].X, 0.1)
+	assert.True(t, res.Stars[0].Saturated)
+	assert.Greater(t, res.SaturatedFraction, 1.0/(120*120))
+
+	// Without stars, there is nothing to measure.
+	res = analysis.Analyze(&fits.Image{Naxis: []int{20, 20}, Bitpix: -32, Pixels: make([]float64, 400)}, analysis.Options{})
+	assert.Equal(t, 0, res.StarCount)
+	assert.Empty(t, res.Stars)
+	assert.Equal(t, 0.0, res.HFR)
+	assert.Equal(t, 0.0, res.SaturatedFraction)
+}
+
+// fitsFile returns frame as a 32 bit floating point FITS file.
+func fitsFile(t *testing.T) []byte {
+	im := frame()
+
+	var b bytes.Buffer
+
+	for _, r := range []string{"SIMPLE  =                    T", "BITPIX  =                  -32", "NAXIS   =                    2",
+		"NAXIS1  =                  120", "NAXIS2  =                  120", "END"} {
+		b.WriteString(fmt.Sprintf("%-80s", r))
+	}
+
+	for b.Len()%2880 != 0 {
+		b.WriteByte(' ')
+	}
+
+	for _, p := range im.Pixels {
+		require.NoError(t, binary.Write(&b, binary.BigEndian, float32(p)))
+	}
+
+	return b.Bytes()
+}
+
+func Test_AnalyzeFITS(t *testing.T) {
+	res, err := analysis.AnalyzeFITS(bytes.NewReader(fitsFile(t)), analysis.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.StarCount)
+
+	// Floating point frames are never saturated unless Saturation is set.
+	assert.Equal(t, 0.0, res.SaturatedFraction)
+
+	_, err = analysis.AnalyzeFITS(bytes.NewReader([]byte("not a FITS file")), analysis.Options{})
+	assert.Error(t, err)
+}
+
+func Test_AnalyzeBlob(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	require.NoError(t, s.Define(&indiclient.DefBlobVector{
+		Device: "Camera",
+		Name:   "CCD1",
+		State:  indiclient.PropertyStateIdle,
+		Perm:   indiclient.PropertyPermissionReadOnly,
+		Blobs:  []indiclient.DefBlob{{Name: "CCD1"}},
+	}))
+
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+
+	c := indiclient.NewINDIClient(log, s.Dialer(), afero.NewMemMapFs(), 10)
+	require.NoError(t, c.Connect("tcp", ""))
+	defer c.Disconnect()
+
+	require.NoError(t, c.GetProperties("", ""))
+
+	assert.Eventually(t, func() bool {
+		_, err := c.BlobProperty("Camera", "CCD1")
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	blobs := make(chan *indiclient.Blob, 2)
+
+	h := c.HandleBlobs(indiclient.EventFilter{}, 2, indiclient.DropPolicyNewest, func(b *indiclient.Blob) {
+		blobs <- b
+	})
+	defer h.Close()
+
+	require.NoError(t, c.EnableBlob("Camera", "CCD1", indiclient.BlobEnableAlso))
+
+	_, ok := s.WaitFor(time.Second, func(cmd inditest.Command) bool {
+		_, ok := cmd.Element.(*indiclient.EnableBlob)
+		return ok
+	})
+	require.True(t, ok)
+
+	require.NoError(t, s.SendBlob("Camera", "CCD1", "CCD1", fitsFile(t), ".fits"))
+	require.NoError(t, s.SendBlob("Camera", "CCD1", "CCD1", []byte("hello"), ".txt"))
+
+	for _, want := range []bool{true, false} {
+		select {
+		case b := <-blobs:
+			res, err := analysis.AnalyzeBlob(b, analysis.Options{})
+			if !want {
+				assert.Error(t, err)
+
+				_, err = analysis.BlobAnalysis(b)
+				assert.Error(t, err)
+
+				continue
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, 120, res.Width)
+			assert.Equal(t, 3, res.StarCount)
+
+			// The analysis attached to the BLOB is done once, and shared.
+			attached, err := analysis.BlobAnalysis(b)
+			require.NoError(t, err)
+			assert.Equal(t, res, attached)
+
+			again, err := analysis.BlobAnalysis(b)
+			require.NoError(t, err)
+			assert.True(t, attached == again)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "timed out waiting for BLOB")
+		}
+	}
+}
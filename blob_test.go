@@ -0,0 +1,378 @@
+package indiclient_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+func Test_SetBlobVector_Streamed(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	stream, id, err := c.GetBlobStream("Camera", "CCD1", "CCD1")
+	require.NoError(t, err)
+	defer c.CloseBlobStream("Camera", "CCD1", "CCD1", id)
+
+	streamed := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 11)
+		io.ReadFull(stream, buf)
+		streamed <- string(buf)
+	}()
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, `<setBLOBVector device="Camera" name="CCD1" state="Ok" timeout="0">
+	<oneBLOB name="CCD1" size="11" format=".fits">
+aGVsbG8g
+d29ybGQ=
+	</oneBLOB>
+</setBLOBVector>`)
+
+	e := nextEvent(t, s)
+	assert.Equal(t, indiclient.PropertyKindBlob, e.Kind)
+	assert.Equal(t, "hello world", <-streamed)
+
+	rdr, fileName, length, err := c.GetBlob("Camera", "CCD1", "CCD1")
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	assert.Equal(t, "Camera_CCD1_CCD1.fits", fileName)
+	assert.Equal(t, int64(11), length)
+
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func setBlobXML(format string, size int, data []byte) string {
+	return fmt.Sprintf(`<setBLOBVector device="Camera" name="CCD1" state="Ok" timeout="0"><oneBLOB name="CCD1" size="%d" format="%s">%s</oneBLOB></setBLOBVector>`,
+		size, format, base64.StdEncoding.EncodeToString(data))
+}
+
+func Test_SetBlobVector_Decompress(t *testing.T) {
+	image := bytes.Repeat([]byte("SIMPLE  =                    T"), 100)
+
+	var zlibbed, gzipped bytes.Buffer
+
+	zw := zlib.NewWriter(&zlibbed)
+	zw.Write(image)
+	zw.Close()
+
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(image)
+	gw.Close()
+
+	tests := []struct {
+		name      string
+		format    string
+		data      []byte
+		size      int
+		file      string
+		effective string
+		contents  []byte
+	}{
+		{name: "zlib", format: ".fits.z", data: zlibbed.Bytes(), size: len(image), file: "Camera_CCD1_CCD1.fits", effective: ".fits", contents: image},
+		{name: "gzip", format: ".fits.gz", data: gzipped.Bytes(), size: len(image), file: "Camera_CCD1_CCD1.fits", effective: ".fits", contents: image},
+		{name: "tile compressed", format: ".fits.fz", data: []byte("fpacked"), size: len(image), file: "Camera_CCD1_CCD1.fits.fz", effective: ".fits.fz", contents: []byte("fpacked")},
+		{name: "uncompressed", format: ".fits", data: image, size: 0, file: "Camera_CCD1_CCD1.fits", effective: ".fits", contents: image},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newPipeClient(t)
+			defer c.Disconnect()
+
+			defineProperties(t, c, server, cameraDefXML, 1)
+
+			s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+			defer s.Close()
+
+			io.WriteString(server, setBlobXML(tt.format, tt.size, tt.data))
+			nextEvent(t, s)
+
+			prop, err := c.BlobProperty("Camera", "CCD1")
+			require.NoError(t, err)
+
+			v := prop.Values["CCD1"]
+			assert.Equal(t, tt.format, v.Format)
+			assert.Equal(t, tt.effective, v.EffectiveFormat)
+			assert.Equal(t, int64(len(tt.contents)), v.Size)
+
+			rdr, fileName, _, err := c.GetBlob("Camera", "CCD1", "CCD1")
+			require.NoError(t, err)
+			defer rdr.Close()
+
+			assert.Equal(t, tt.file, fileName)
+
+			b, err := ioutil.ReadAll(rdr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.contents, b)
+		})
+	}
+}
+
+func Test_SetBlobVector_SizeMismatch(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, setBlobXML(".fits", 100, []byte("truncated")))
+	nextEvent(t, s)
+
+	prop, err := c.BlobProperty("Camera", "CCD1")
+	require.NoError(t, err)
+
+	// The property is still updated, but the BLOB is left out of it.
+	assert.Equal(t, indiclient.PropertyStateOk, prop.State)
+	assert.Empty(t, prop.Values["CCD1"].Value)
+	assert.Zero(t, prop.Values["CCD1"].Size)
+}
+
+// countingProcessor passes BLOBs through unchanged, counting the bytes read from them.
+type countingProcessor struct {
+	n int64
+}
+
+func (p *countingProcessor) ProcessBlob(info indiclient.BlobInfo, r io.Reader) (io.Reader, error) {
+	return readerFunc(func(b []byte) (int, error) {
+		n, err := r.Read(b)
+		atomic.AddInt64(&p.n, int64(n))
+
+		return n, err
+	}), nil
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(b []byte) (int, error) {
+	return f(b)
+}
+
+func Test_SetBlobVector_TooBig(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	p := &countingProcessor{}
+	c.SetBlobProcessor(p)
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, setBlobXML(".fits", 5, bytes.Repeat([]byte("x"), 100000)))
+	nextEvent(t, s)
+
+	prop, err := c.BlobProperty("Camera", "CCD1")
+	require.NoError(t, err)
+	assert.Empty(t, prop.Values["CCD1"].Value)
+
+	// Reading stops one byte past the size, rather than going through the whole BLOB.
+	assert.Equal(t, int64(6), atomic.LoadInt64(&p.n))
+}
+
+// readBlob reads the contents of b from the store.
+func readBlob(t *testing.T, b *indiclient.Blob) string {
+	rdr, err := b.Open()
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer rdr.Close()
+
+	data, err := ioutil.ReadAll(rdr)
+	assert.NoError(t, err)
+
+	return string(data)
+}
+
+func Test_HandleBlobs(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	all := c.Subscribe(indiclient.EventFilter{}, 10, indiclient.DropPolicyNewest)
+	defer all.Close()
+
+	blobs := make(chan *indiclient.Blob, 10)
+
+	h := c.HandleBlobs(indiclient.EventFilter{Device: "Camera"}, 10, indiclient.DropPolicyNewest, func(b *indiclient.Blob) {
+		blobs <- b
+	})
+
+	other := c.HandleBlobs(indiclient.EventFilter{Device: "Guider"}, 10, indiclient.DropPolicyNewest, func(b *indiclient.Blob) {
+		assert.Fail(t, "handler for another device called")
+	})
+	defer other.Close()
+
+	io.WriteString(server, setBlobXML(".fits", 5, []byte("first")))
+	io.WriteString(server, `<setBLOBVector device="Camera" name="CCD1" state="Busy" timestamp="2020-01-02T03:04:05"><oneBLOB name="CCD1" size="6" format=".txt">c2Vjb25k</oneBLOB></setBLOBVector>`)
+
+	next := func() *indiclient.Blob {
+		select {
+		case b := <-blobs:
+			return b
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "timed out waiting for BLOB")
+		}
+
+		return nil
+	}
+
+	b := next()
+	assert.Equal(t, "Camera", b.Device)
+	assert.Equal(t, "CCD1", b.Property)
+	assert.Equal(t, "CCD1", b.Name)
+	assert.Equal(t, ".fits", b.Format)
+	assert.Equal(t, int64(5), b.Size)
+	assert.Equal(t, indiclient.PropertyStateOk, b.State)
+	assert.Equal(t, "Camera_CCD1_CCD1.fits", b.StoredAs)
+	assert.Equal(t, "first", readBlob(t, b))
+
+	b = next()
+	assert.Equal(t, ".txt", b.EffectiveFormat)
+	assert.Equal(t, indiclient.PropertyStateBusy, b.State)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), b.Timestamp)
+
+	assert.Equal(t, "second", readBlob(t, b))
+
+	// Other subscriptions only see the property updates.
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, indiclient.EventPropertyUpdated, nextEvent(t, all).Type)
+	}
+
+	h.Close()
+
+	io.WriteString(server, setBlobXML(".fits", 5, []byte("third")))
+	nextEvent(t, all)
+
+	select {
+	case <-blobs:
+		assert.Fail(t, "handler called after Close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_Subscribe_BlobReceived(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated, indiclient.EventBlobReceived}}, 10, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, setBlobXML(".fits", 5, []byte("first")))
+
+	// A subscription that asks for BLOBs gets them right after the update they came with.
+	assert.Equal(t, indiclient.EventPropertyUpdated, nextEvent(t, s).Type)
+
+	e := nextEvent(t, s)
+	assert.Equal(t, indiclient.EventBlobReceived, e.Type)
+	require.NotNil(t, e.Blob)
+	assert.Equal(t, "first", readBlob(t, e.Blob))
+}
+
+func Test_HandleBlobs_SlowHandler(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, cameraDefXML+focuserDefXML, 2)
+
+	release := make(chan struct{})
+	handling := make(chan string, 10)
+
+	h := c.HandleBlobs(indiclient.EventFilter{}, 1, indiclient.DropPolicyOldest, func(b *indiclient.Blob) {
+		handling <- readBlob(t, b)
+		<-release
+	})
+	defer h.Close()
+
+	s := c.Subscribe(indiclient.EventFilter{Device: "Focuser"}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, setBlobXML(".txt", 3, []byte("one")))
+	assert.Equal(t, "one", <-handling)
+
+	for _, data := range []string{"two", "three", "four"} {
+		io.WriteString(server, setBlobXML(".txt", len(data), []byte(data)))
+	}
+
+	// The client keeps going while the handler is stuck.
+	io.WriteString(server, focuserMoveXML)
+	nextEvent(t, s)
+
+	close(release)
+
+	// Only the newest of the BLOBs that arrived while the handler was stuck was kept for it.
+	assert.Equal(t, "four", <-handling)
+	assert.Equal(t, uint64(2), h.Dropped())
+}
+
+func Test_Blob_Attachment(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	blobs := make(chan *indiclient.Blob, 1)
+
+	h := c.HandleBlobs(indiclient.EventFilter{}, 1, indiclient.DropPolicyNewest, func(b *indiclient.Blob) {
+		blobs <- b
+	})
+	defer h.Close()
+
+	io.WriteString(server, setBlobXML(".txt", 5, []byte("first")))
+
+	var b *indiclient.Blob
+
+	select {
+	case b = <-blobs:
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for BLOB")
+	}
+
+	type lengthKey struct{}
+
+	calls := 0
+	length := func(b *indiclient.Blob) (interface{}, error) {
+		calls++
+		return len(readBlob(t, b)), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		v, err := b.Attachment(lengthKey{}, length)
+		require.NoError(t, err)
+		assert.Equal(t, 5, v)
+	}
+
+	assert.Equal(t, 1, calls)
+
+	// Other keys are worked out separately.
+	v, err := b.Attachment(struct{}{}, func(b *indiclient.Blob) (interface{}, error) {
+		return "other", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "other", v)
+}
@@ -0,0 +1,103 @@
+package indiclient
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Blob is a single BLOB received by the client, as delivered to the handlers registered with HandleBlobs. It is shared
+// with every other handler, so treat it as read-only.
+type Blob struct {
+	Device   string
+	Property string
+	Name     string
+
+	// Format is the format the BLOB was sent in, and EffectiveFormat the format of Reader, after any decompression by
+	// the client.
+	Format          string
+	EffectiveFormat string
+
+	Size      int64
+	Timestamp time.Time
+
+	// State is the state of the property the BLOB came with.
+	State PropertyState
+
+	// StoredAs is the name the BLOB was stored under in the BlobStore.
+	StoredAs string
+
+	store BlobStore
+
+	mu          sync.Mutex
+	attachments map[interface{}]*attachment
+}
+
+// attachment is a value worked out from a BLOB by Blob.Attachment.
+type attachment struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+// Open opens the BLOB in the BlobStore it was stored in. Each call returns a new reader, starting at the beginning. Be
+// sure to close it when you are done with it.
+//
+// The stores made by this package only show a BLOB once it is complete, and a reader that is already open keeps reading
+// the BLOB it opened even once it is replaced. But a BlobStore that keeps only the latest BLOB, like
+// NewLatestBlobStore, replaces it with the next one sent for the same element, and NewRetentionBlobStore removes it
+// once it is too old, so a handler that falls behind may open a later BLOB, or none at all. Use a store that keeps
+// every BLOB, like NewSequenceBlobStore, if that matters.
+func (b *Blob) Open() (io.ReadCloser, error) {
+	return b.store.Open(b.StoredAs)
+}
+
+// Attachment returns the value attached to the BLOB under key, working it out with fn the first time it is asked for,
+// so every handler of the BLOB shares it. This is how packages that work something out from a BLOB, such as the
+// analysis of the image by analysis.BlobAnalysis, attach it to the BLOB without the client knowing about them. As with
+// context.WithValue, key should be of an unexported type of the package that uses it.
+func (b *Blob) Attachment(key interface{}, fn func(b *Blob) (interface{}, error)) (interface{}, error) {
+	b.mu.Lock()
+
+	if b.attachments == nil {
+		b.attachments = map[interface{}]*attachment{}
+	}
+
+	a, ok := b.attachments[key]
+	if !ok {
+		a = &attachment{}
+		b.attachments[key] = a
+	}
+
+	b.mu.Unlock()
+
+	a.once.Do(func() {
+		a.value, a.err = fn(b)
+	})
+
+	return a.value, a.err
+}
+
+// HandleBlobs calls fn with each BLOB received that matches filter, one at a time, on a goroutine of its own, once it
+// has been stored. Handlers read the BLOB back from the BlobStore with Blob.Open, so it is never held in memory, which
+// makes this safer than GetBlobStream: a slow handler never holds up the client, and only loses its own BLOBs once
+// bufferSize of them are waiting, with policy deciding which. Close the returned Subscription to stop handling BLOBs.
+//
+// The Types and Kinds of filter are ignored.
+func (c *INDIClient) HandleBlobs(filter EventFilter, bufferSize int, policy DropPolicy, fn func(*Blob)) *Subscription {
+	filter.Types = nil
+	filter.Kinds = nil
+
+	s := c.newSubscription(filter, bufferSize, policy)
+	s.blobs = true
+
+	c.subscriptions.Store(s.id, s)
+
+	go func() {
+		for e := range s.events {
+			fn(e.Blob)
+		}
+	}()
+
+	return s
+}
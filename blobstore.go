@@ -0,0 +1,458 @@
+package indiclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+)
+
+// BlobInfo describes a BLOB that is about to be stored.
+type BlobInfo struct {
+	Device   string
+	Property string
+	Name     string
+
+	// Format is the format the BLOB will be stored in, after any decompression by the client.
+	Format string
+
+	// Timestamp is when the driver sent the BLOB, or when it was received if the driver did not say.
+	Timestamp time.Time
+
+	// Snapshot holds every device as it was when the BLOB arrived, so a BlobStore can name the BLOB after the current
+	// target, filter, and so on.
+	Snapshot Snapshot
+}
+
+// BlobWriter writes a BLOB into a BlobStore. The BLOB is complete once the writer is closed.
+type BlobWriter interface {
+	io.WriteCloser
+
+	// Name returns the name the BLOB is stored under, which is what BlobStore.Open and BlobStore.Remove take, and what
+	// BlobValue.Value is set to.
+	Name() string
+}
+
+// BlobStore decides where the BLOBs a client receives are kept, and how many of them. Set one with SetBlobStore. A
+// BlobStore is only ever given one BLOB at a time to write, but may be opened from other goroutines meanwhile.
+type BlobStore interface {
+	// Create returns a writer for a new BLOB.
+	Create(info BlobInfo) (BlobWriter, error)
+
+	// Open opens a BLOB stored earlier.
+	Open(name string) (io.ReadCloser, error)
+
+	// Remove removes a BLOB stored earlier.
+	Remove(name string) error
+}
+
+// SetBlobStore sets where received BLOBs are kept. The default is NewLatestBlobStore with the afero.Fs given to
+// NewINDIClient. This should be called before Connect.
+func (c *INDIClient) SetBlobStore(store BlobStore) {
+	c.blobs = store
+}
+
+// BlobProcessor changes BLOBs as they are received, before they are stored or handed to HandleBlobs. Set one with
+// SetBlobProcessor.
+type BlobProcessor interface {
+	// ProcessBlob returns a reader over the processed BLOB, read from r after it has been decompressed. r is streamed
+	// from the connection, so it should be read as the returned reader is, rather than all at once. If an error is
+	// returned, the BLOB is discarded.
+	ProcessBlob(info BlobInfo, r io.Reader) (io.Reader, error)
+}
+
+// SetBlobProcessor sets a BlobProcessor to run on every BLOB received, or none if processor is nil, which is the
+// default. This should be called before Connect.
+func (c *INDIClient) SetBlobProcessor(processor BlobProcessor) {
+	c.processor = processor
+}
+
+// fsBlobStore keeps BLOBs as files in an afero.Fs, named by a function.
+type fsBlobStore struct {
+	fs   afero.Fs
+	name func(info BlobInfo) (string, error)
+}
+
+func (s *fsBlobStore) Create(info BlobInfo) (BlobWriter, error) {
+	name, err := s.name(info)
+	if err != nil {
+		return nil, err
+	}
+
+	// The names are made from what devices send, so make sure none of it leads out of the store.
+	name = filepath.Clean(name)
+	if filepath.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return nil, ErrUnsafeBlobName
+	}
+
+	if dir := filepath.Dir(name); dir != "." {
+		err = s.fs.MkdirAll(dir, 0777)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The BLOB is written under a temporary name and renamed over any BLOB of the same name once it is complete, so
+	// readers that opened the old BLOB keep reading it, and nobody opens a BLOB that is half written.
+	tmp := filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".part")
+
+	f, err := s.fs.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsBlobWriter{File: f, fs: s.fs, name: name}, nil
+}
+
+func (s *fsBlobStore) Open(name string) (io.ReadCloser, error) {
+	return s.fs.Open(name)
+}
+
+func (s *fsBlobStore) Remove(name string) error {
+	return s.fs.Remove(name)
+}
+
+// fsBlobWriter writes a BLOB to a temporary file, which it renames to the name of the BLOB when it is closed.
+type fsBlobWriter struct {
+	afero.File
+	fs   afero.Fs
+	name string
+}
+
+func (w *fsBlobWriter) Name() string {
+	return w.name
+}
+
+func (w *fsBlobWriter) Close() error {
+	tmp := w.File.Name()
+
+	err := w.File.Close()
+	if err == nil {
+		err = w.fs.Rename(tmp, w.name)
+	}
+
+	if err != nil {
+		w.fs.Remove(tmp)
+	}
+
+	return err
+}
+
+// NewLatestBlobStore keeps only the latest BLOB for each BLOB element, in a file named
+// "<device>_<property>_<element><format>" in the root of fs. Each BLOB received overwrites the one before it.
+func NewLatestBlobStore(fs afero.Fs) BlobStore {
+	return &fsBlobStore{
+		fs: fs,
+		name: func(info BlobInfo) (string, error) {
+			return safeName(fmt.Sprintf("%s_%s_%s%s", info.Device, info.Property, info.Name, info.Format)), nil
+		},
+	}
+}
+
+// NewMemoryBlobStore keeps only the latest BLOB for each BLOB element, like NewLatestBlobStore, but only in memory.
+func NewMemoryBlobStore() BlobStore {
+	return NewLatestBlobStore(afero.NewMemMapFs())
+}
+
+// NewSequenceBlobStore keeps every BLOB, in a directory for each device and the local date it was sent, named with the
+// UTC time it was sent and a frame number counting up from 1 for each BLOB element:
+//
+//	<device>/<2006-01-02>/<property>_<element>_<20060102T150405.000>_<0001><format>
+//
+// Combine it with NewRetentionBlobStore to limit how many are kept.
+func NewSequenceBlobStore(fs afero.Fs) BlobStore {
+	var frames frameCounter
+
+	return &fsBlobStore{
+		fs: fs,
+		name: func(info BlobInfo) (string, error) {
+			name := fmt.Sprintf("%s_%s_%s_%04d%s", info.Property, info.Name, info.Timestamp.UTC().Format("20060102T150405.000"),
+				frames.next(info), info.Format)
+
+			return filepath.Join(safeName(info.Device), info.Timestamp.Local().Format("2006-01-02"), safeName(name)), nil
+		},
+	}
+}
+
+// BlobTemplateData is what the template given to NewTemplateBlobStore is executed with. Target, Filter and Exposure
+// are taken from the standard INDI properties when the devices have them, and are empty otherwise.
+//
+// The template can also look up any property value with these functions, which return an empty string if the value
+// is not found:
+//
+//	{{text "device" "property" "element"}}
+//	{{number "device" "property" "element"}}
+//	{{switch "device" "property"}}, which returns the name of the switch that is On
+type BlobTemplateData struct {
+	BlobInfo
+
+	// Frame counts up from 1 for each BLOB element.
+	Frame int
+
+	// Target is FITS_OBJECT in the FITS_HEADER property of the device that sent the BLOB.
+	Target string
+
+	// Filter is the name of the current filter, from FILTER_SLOT and FILTER_NAME on any device.
+	Filter string
+
+	// Exposure is CCD_EXPOSURE_VALUE in the CCD_EXPOSURE property of the device that sent the BLOB. Cameras count it
+	// down during an exposure, and some reset it to 0 once done, so it is only the exposure time with those that don't.
+	Exposure string
+}
+
+// NewTemplateBlobStore keeps every BLOB in fs, with a file name made by executing tmpl, a text/template, with a
+// BlobTemplateData. The format is added to the end of the name, and a "/" in the name separates directories. For
+// example:
+//
+//	{{.Device}}/{{.Target}}/{{.Target}}_{{.Filter}}_{{.Exposure}}s_{{printf "%04d" .Frame}}
+//
+// Names are not made unique, so a template that gives two BLOBs the same name overwrites the first.
+func NewTemplateBlobStore(fs afero.Fs, tmpl string) (BlobStore, error) {
+	var snapshot Snapshot
+
+	t, err := template.New("blob").Funcs(template.FuncMap{
+		"text": func(device, prop, elem string) string {
+			d, _ := snapshot.Device(device)
+			return d.TextProperties[prop].Values[elem].Value
+		},
+		"number": func(device, prop, elem string) string {
+			d, _ := snapshot.Device(device)
+			return d.NumberProperties[prop].Values[elem].Value
+		},
+		"switch": func(device, prop string) string {
+			d, _ := snapshot.Device(device)
+			return d.SwitchProperties[prop].active()
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		frames frameCounter
+	)
+
+	return &fsBlobStore{
+		fs: fs,
+		name: func(info BlobInfo) (string, error) {
+			data := BlobTemplateData{
+				BlobInfo: info,
+				Frame:    frames.next(info),
+			}
+
+			if d, ok := info.Snapshot.Device(info.Device); ok {
+				data.Target = strings.TrimSpace(d.TextProperties["FITS_HEADER"].Values["FITS_OBJECT"].Value)
+				data.Exposure = strings.TrimSpace(d.NumberProperties["CCD_EXPOSURE"].Values["CCD_EXPOSURE_VALUE"].Value)
+			}
+
+			data.Filter = currentFilter(info.Snapshot)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			// The template functions look in snapshot, so it must not change until the template is done.
+			snapshot = info.Snapshot
+
+			var buf bytes.Buffer
+
+			err := t.Execute(&buf, data)
+			if err != nil {
+				return "", err
+			}
+
+			parts := strings.Split(buf.String(), "/")
+			for i := range parts {
+				parts[i] = safeName(parts[i])
+			}
+
+			return filepath.Join(parts...) + info.Format, nil
+		},
+	}, nil
+}
+
+// currentFilter returns the name of the filter the first filter wheel in snapshot is on.
+func currentFilter(snapshot Snapshot) string {
+	for _, d := range snapshot.Devices {
+		slot, ok := d.NumberProperties["FILTER_SLOT"].Values["FILTER_SLOT_VALUE"]
+		if !ok {
+			continue
+		}
+
+		n, err := ParseNumber(slot.Value)
+		if err != nil {
+			continue
+		}
+
+		return strings.TrimSpace(d.TextProperties["FILTER_NAME"].Values["FILTER_SLOT_NAME_"+strconv.Itoa(int(n))].Value)
+	}
+
+	return ""
+}
+
+// frameCounter counts the BLOBs received for each BLOB element.
+type frameCounter struct {
+	mu     sync.Mutex
+	frames map[string]int
+}
+
+func (f *frameCounter) next(info BlobInfo) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.frames == nil {
+		f.frames = map[string]int{}
+	}
+
+	key := info.Device + "\x00" + info.Property + "\x00" + info.Name
+	f.frames[key]++
+
+	return f.frames[key]
+}
+
+// safeName replaces the characters that cannot be part of a file name with underscores, as well as a name that is
+// only "." or "..", which would refer to a directory.
+func safeName(name string) string {
+	if name == "." || name == ".." {
+		return strings.Repeat("_", len(name))
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+
+		return r
+	}, name)
+}
+
+// RetentionPolicy limits how many BLOBs a BlobStore made by NewRetentionBlobStore keeps. A limit of 0 means no limit.
+type RetentionPolicy struct {
+	MaxCount int
+	MaxBytes int64
+}
+
+type storedBlob struct {
+	name string
+	size int64
+}
+
+// retentionBlobStore removes the oldest BLOBs from another BlobStore once there are too many.
+type retentionBlobStore struct {
+	BlobStore
+	log    logging.Logger
+	policy RetentionPolicy
+
+	mu     sync.Mutex
+	stored []storedBlob
+	size   int64
+}
+
+// NewRetentionBlobStore stores BLOBs in store, removing the oldest ones once there are more than policy allows. The
+// BLOB just stored is never removed, even if it is bigger than MaxBytes on its own. Only BLOBs stored through the
+// returned BlobStore are counted, not any that were already there. A BLOB that cannot be removed is logged to log and
+// forgotten, rather than failing the BLOB that was just stored.
+func NewRetentionBlobStore(log logging.Logger, store BlobStore, policy RetentionPolicy) BlobStore {
+	return &retentionBlobStore{
+		BlobStore: store,
+		log:       log,
+		policy:    policy,
+	}
+}
+
+func (s *retentionBlobStore) Create(info BlobInfo) (BlobWriter, error) {
+	w, err := s.BlobStore.Create(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &retentionWriter{BlobWriter: w, store: s}, nil
+}
+
+func (s *retentionBlobStore) Remove(name string) error {
+	s.mu.Lock()
+	s.forget(name)
+	s.mu.Unlock()
+
+	return s.BlobStore.Remove(name)
+}
+
+// forget stops counting a BLOB. s.mu must be held.
+func (s *retentionBlobStore) forget(name string) {
+	for i, b := range s.stored {
+		if b.name == name {
+			s.size -= b.size
+			s.stored = append(s.stored[:i], s.stored[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// add counts a BLOB that has just been stored, and removes the oldest BLOBs that no longer fit.
+func (s *retentionBlobStore) add(name string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A store that overwrites, like NewLatestBlobStore, can store the same name again.
+	s.forget(name)
+
+	s.stored = append(s.stored, storedBlob{name: name, size: size})
+	s.size += size
+
+	for len(s.stored) > 1 && s.over() {
+		oldest := s.stored[0]
+
+		s.stored = s.stored[1:]
+		s.size -= oldest.size
+
+		err := s.BlobStore.Remove(oldest.name)
+		if err != nil && !os.IsNotExist(err) {
+			s.log.WithField("file", oldest.name).WithError(err).Warn("error in s.BlobStore.Remove")
+		}
+	}
+}
+
+// over returns true if more BLOBs are stored than the policy allows. s.mu must be held.
+func (s *retentionBlobStore) over() bool {
+	if s.policy.MaxCount > 0 && len(s.stored) > s.policy.MaxCount {
+		return true
+	}
+
+	return s.policy.MaxBytes > 0 && s.size > s.policy.MaxBytes
+}
+
+// retentionWriter counts what is written, so the retentionBlobStore knows how big each BLOB is.
+type retentionWriter struct {
+	BlobWriter
+	store *retentionBlobStore
+	size  int64
+}
+
+func (w *retentionWriter) Write(p []byte) (int, error) {
+	n, err := w.BlobWriter.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+func (w *retentionWriter) Close() error {
+	err := w.BlobWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	w.store.add(w.Name(), w.size)
+
+	return nil
+}
@@ -0,0 +1,267 @@
+package indiclient_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+// storeBlob stores data in store, and returns the name it was stored under.
+func storeBlob(t *testing.T, store indiclient.BlobStore, info indiclient.BlobInfo, data string) string {
+	w, err := store.Create(info)
+	require.NoError(t, err)
+
+	_, err = io.WriteString(w, data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return w.Name()
+}
+
+// files returns the name of every file in fs.
+func files(t *testing.T, fs afero.Fs) []string {
+	var names []string
+
+	err := afero.Walk(fs, "", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			names = append(names, path)
+		}
+
+		return err
+	})
+	require.NoError(t, err)
+
+	sort.Strings(names)
+
+	return names
+}
+
+var discardLog = logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+
+var blobInfo = indiclient.BlobInfo{
+	Device:    "CCD Simulator",
+	Property:  "CCD1",
+	Name:      "CCD1",
+	Format:    ".fits",
+	Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC),
+}
+
+func Test_LatestBlobStore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := indiclient.NewLatestBlobStore(fs)
+
+	name := storeBlob(t, store, blobInfo, "first")
+
+	first, err := store.Open(name)
+	require.NoError(t, err)
+	defer first.Close()
+
+	// The next BLOB is not visible until it is complete.
+	w, err := store.Create(blobInfo)
+	require.NoError(t, err)
+
+	_, err = io.WriteString(w, "sec")
+	require.NoError(t, err)
+
+	r, err := store.Open(name)
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(b))
+	r.Close()
+
+	_, err = io.WriteString(w, "ond")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, "CCD Simulator_CCD1_CCD1.fits", w.Name())
+	assert.Equal(t, []string{"CCD Simulator_CCD1_CCD1.fits"}, files(t, fs))
+
+	r, err = store.Open(name)
+	require.NoError(t, err)
+	defer r.Close()
+
+	b, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(b))
+
+	// A reader opened before the BLOB was replaced keeps reading the old one.
+	b, err = ioutil.ReadAll(first)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(b))
+}
+
+func Test_SequenceBlobStore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := indiclient.NewSequenceBlobStore(fs)
+
+	first := storeBlob(t, store, blobInfo, "first")
+	second := storeBlob(t, store, blobInfo, "second")
+
+	dir := "CCD Simulator/" + blobInfo.Timestamp.Local().Format("2006-01-02") + "/"
+
+	assert.Equal(t, dir+"CCD1_CCD1_20200102T030405.600_0001.fits", first)
+	assert.Equal(t, dir+"CCD1_CCD1_20200102T030405.600_0002.fits", second)
+	assert.Equal(t, []string{first, second}, files(t, fs))
+
+	info := blobInfo
+	info.Device = ".."
+	assert.Equal(t, "__/"+blobInfo.Timestamp.Local().Format("2006-01-02")+"/CCD1_CCD1_20200102T030405.600_0001.fits", storeBlob(t, store, info, "third"))
+}
+
+func Test_TemplateBlobStore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	store, err := indiclient.NewTemplateBlobStore(fs, `{{.Target}}/{{.Target}}_{{.Filter}}_{{.Exposure}}s_{{printf "%03d" .Frame}}_{{switch "CCD Simulator" "CCD_FRAME_TYPE"}}`)
+	require.NoError(t, err)
+
+	info := blobInfo
+	info.Snapshot = indiclient.Snapshot{
+		Devices: []indiclient.Device{
+			{
+				Name: "CCD Simulator",
+				TextProperties: map[string]indiclient.TextProperty{
+					"FITS_HEADER": {Values: map[string]indiclient.TextValue{"FITS_OBJECT": {Value: "M31"}}},
+				},
+				NumberProperties: map[string]indiclient.NumberProperty{
+					"CCD_EXPOSURE": {Values: map[string]indiclient.NumberValue{"CCD_EXPOSURE_VALUE": {Value: "120"}}},
+				},
+				SwitchProperties: map[string]indiclient.SwitchProperty{
+					"CCD_FRAME_TYPE": {Values: map[string]indiclient.SwitchValue{
+						"FRAME_LIGHT": {Value: indiclient.SwitchStateOn},
+						"FRAME_DARK":  {Value: indiclient.SwitchStateOff},
+					}},
+				},
+			},
+			{
+				Name: "Filter Simulator",
+				TextProperties: map[string]indiclient.TextProperty{
+					"FILTER_NAME": {Values: map[string]indiclient.TextValue{
+						"FILTER_SLOT_NAME_1": {Value: "Red"},
+						"FILTER_SLOT_NAME_2": {Value: "Ha"},
+					}},
+				},
+				NumberProperties: map[string]indiclient.NumberProperty{
+					"FILTER_SLOT": {Values: map[string]indiclient.NumberValue{"FILTER_SLOT_VALUE": {Value: "2"}}},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, "M31/M31_Ha_120s_001_FRAME_LIGHT.fits", storeBlob(t, store, info, "first"))
+	assert.Equal(t, "M31/M31_Ha_120s_002_FRAME_LIGHT.fits", storeBlob(t, store, info, "second"))
+
+	// Without the properties, the fields are empty.
+	assert.Equal(t, "__s_003_.fits", storeBlob(t, store, blobInfo, "third"))
+
+	// Names that lead out of the store are not followed.
+	info.Snapshot.Devices[0].TextProperties["FITS_HEADER"].Values["FITS_OBJECT"] = indiclient.TextValue{Value: "../.."}
+	assert.Equal(t, "__/__/__/.._Ha_120s_004_FRAME_LIGHT.fits", storeBlob(t, store, info, "fourth"))
+
+	_, err = indiclient.NewTemplateBlobStore(fs, "{{.Target")
+	assert.Error(t, err)
+}
+
+func Test_RetentionBlobStore(t *testing.T) {
+	t.Run("MaxCount", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		store := indiclient.NewRetentionBlobStore(discardLog, indiclient.NewSequenceBlobStore(fs), indiclient.RetentionPolicy{MaxCount: 2})
+
+		first := storeBlob(t, store, blobInfo, "1")
+		second := storeBlob(t, store, blobInfo, "2")
+		assert.Equal(t, []string{first, second}, files(t, fs))
+
+		third := storeBlob(t, store, blobInfo, "3")
+		assert.Equal(t, []string{second, third}, files(t, fs))
+
+		require.NoError(t, store.Remove(second))
+
+		fourth := storeBlob(t, store, blobInfo, "4")
+		assert.Equal(t, []string{third, fourth}, files(t, fs))
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		store := indiclient.NewRetentionBlobStore(discardLog, indiclient.NewSequenceBlobStore(fs), indiclient.RetentionPolicy{MaxBytes: 10})
+
+		first := storeBlob(t, store, blobInfo, "12345")
+		second := storeBlob(t, store, blobInfo, "12345")
+		assert.Equal(t, []string{first, second}, files(t, fs))
+
+		third := storeBlob(t, store, blobInfo, "1")
+		assert.Equal(t, []string{second, third}, files(t, fs))
+
+		// A BLOB bigger than MaxBytes on its own is still kept.
+		fourth := storeBlob(t, store, blobInfo, "12345678901")
+		assert.Equal(t, []string{fourth}, files(t, fs))
+	})
+
+	t.Run("RemoveError", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		store := indiclient.NewRetentionBlobStore(discardLog, failingRemove{indiclient.NewSequenceBlobStore(fs)}, indiclient.RetentionPolicy{MaxCount: 1})
+
+		first := storeBlob(t, store, blobInfo, "1")
+
+		// The new BLOB is still stored, and the old one is forgotten.
+		second := storeBlob(t, store, blobInfo, "2")
+		assert.Equal(t, []string{first, second}, files(t, fs))
+
+		third := storeBlob(t, store, blobInfo, "3")
+		assert.Equal(t, []string{first, second, third}, files(t, fs))
+	})
+}
+
+// failingRemove is a BlobStore that cannot remove anything.
+type failingRemove struct {
+	indiclient.BlobStore
+}
+
+func (s failingRemove) Remove(name string) error {
+	return errors.New("read-only")
+}
+
+func Test_SetBlobStore(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	fs := afero.NewMemMapFs()
+	c.SetBlobStore(indiclient.NewSequenceBlobStore(fs))
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, setBlobXML(".fits", 5, []byte("hello")))
+	nextEvent(t, s)
+
+	// A BLOB that is not received whole is removed again.
+	io.WriteString(server, setBlobXML(".fits", 100, []byte("truncated")))
+	nextEvent(t, s)
+
+	stored := files(t, fs)
+	require.Len(t, stored, 1)
+
+	r, fileName, length, err := c.GetBlob("Camera", "CCD1", "CCD1")
+	require.NoError(t, err)
+	defer r.Close()
+
+	assert.Equal(t, int64(5), length)
+	assert.Regexp(t, `^CCD1_CCD1_.*_0001\.fits$`, fileName)
+
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
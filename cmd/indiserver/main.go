@@ -0,0 +1,75 @@
+// Command indiserver is an INDI server written in Go. It launches the drivers given as arguments and serves clients
+// over TCP, and optionally a unix socket, until it is interrupted.
+//
+//	indiserver -port 7624 ./roof_driver ./weather_driver
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rickbassham/logging"
+
+	"github.com/goastro/indiclient/server"
+)
+
+func main() {
+	port := flag.Int("port", 7624, "TCP port to listen on for clients")
+	unixSocket := flag.String("unix", "", "unix socket to listen on for clients, as well as the TCP port")
+	maxQueue := flag.Int("maxqueue", server.DefaultMaxQueueBytes>>20, "MB that may be queued for a client before it is disconnected")
+	maxBlobQueue := flag.Int("maxblobqueue", server.DefaultMaxBlobQueueBytes>>20, "MB that may be queued for a client before BLOBs for it are dropped")
+	verbose := flag.Bool("v", false, "log debug messages")
+	flag.Parse()
+
+	level := logging.LogLevelInfo
+	if *verbose {
+		level = logging.LogLevelDebug
+	}
+
+	log := logging.NewLogger(os.Stderr, logging.JSONFormatter{}, level)
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: indiserver [flags] driver...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	s := server.New(log, server.Options{
+		MaxQueueBytes:     *maxQueue << 20,
+		MaxBlobQueueBytes: *maxBlobQueue << 20,
+	})
+
+	for _, path := range flag.Args() {
+		err := s.StartDriver(path)
+		if err != nil {
+			log.WithField("driver", path).WithError(err).Error("error in s.StartDriver")
+			s.Close()
+			os.Exit(1)
+		}
+	}
+
+	_, err := s.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.WithError(err).Error("error in s.Listen")
+		s.Close()
+		os.Exit(1)
+	}
+
+	if len(*unixSocket) > 0 {
+		_, err = s.Listen("unix", *unixSocket)
+		if err != nil {
+			log.WithError(err).Error("error in s.Listen")
+			s.Close()
+			os.Exit(1)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	s.Close()
+}
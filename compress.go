@@ -0,0 +1,42 @@
+package indiclient
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+)
+
+// decompress returns a reader that decompresses r, if format ends in a compression suffix the client understands, and
+// the format of what the reader returns. Formats that are not compressed are returned as they are.
+//
+// Supported suffixes are ".z", for zlib as described by the INDI protocol, and ".gz", for gzip. FITS files tile
+// compressed with fpack, ".fits.fz", are left compressed, since they are still FITS files that FITS readers can open.
+func decompress(format string, r io.Reader) (io.Reader, string, error) {
+	lower := strings.ToLower(format)
+
+	switch {
+	case strings.HasSuffix(lower, ".z"):
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return zr, format[:len(format)-len(".z")], nil
+	case strings.HasSuffix(lower, ".gz"):
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return gr, format[:len(format)-len(".gz")], nil
+	}
+
+	return r, format, nil
+}
+
+// tileCompressed returns true if format is a tile compressed FITS file. Drivers disagree on whether the size they send
+// for these is before or after compression.
+func tileCompressed(format string) bool {
+	return strings.HasSuffix(strings.ToLower(format), ".fz")
+}
@@ -0,0 +1,422 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/goastro/indiclient"
+)
+
+const (
+	propExposure      = "CCD_EXPOSURE"
+	propAbortExposure = "CCD_ABORT_EXPOSURE"
+	propFrame         = "CCD_FRAME"
+	propFrameReset    = "CCD_FRAME_RESET"
+	propBinning       = "CCD_BINNING"
+	propCCDTemp       = "CCD_TEMPERATURE"
+	propCooler        = "CCD_COOLER"
+	propFrameType     = "CCD_FRAME_TYPE"
+	propUploadMode    = "UPLOAD_MODE"
+	propGain          = "CCD_GAIN"
+	propOffset        = "CCD_OFFSET"
+	propControls      = "CCD_CONTROLS"
+
+	// DefaultRampInterval is how often CoolTo moves the setpoint of the cooler while ramping.
+	DefaultRampInterval = 30 * time.Second
+)
+
+// ErrNoControl is returned by SetGain and SetOffset when the camera has neither the standard property nor an element of
+// CCD_CONTROLS for it.
+var ErrNoControl = errors.New("camera has no such control")
+
+// FrameType is the kind of frame a camera takes, which drivers record in the FITS header.
+type FrameType string
+
+const (
+	// FrameLight is an ordinary exposure.
+	FrameLight = FrameType("FRAME_LIGHT")
+
+	// FrameBias is the shortest exposure possible with the shutter closed.
+	FrameBias = FrameType("FRAME_BIAS")
+
+	// FrameDark is an exposure with the shutter closed.
+	FrameDark = FrameType("FRAME_DARK")
+
+	// FrameFlat is an exposure of an evenly lit field.
+	FrameFlat = FrameType("FRAME_FLAT")
+)
+
+// UploadMode is where a camera sends its images.
+type UploadMode string
+
+const (
+	// UploadClient sends images to the client as BLOBs.
+	UploadClient = UploadMode("UPLOAD_CLIENT")
+
+	// UploadLocal saves images on the machine the driver runs on.
+	UploadLocal = UploadMode("UPLOAD_LOCAL")
+
+	// UploadBoth does both.
+	UploadBoth = UploadMode("UPLOAD_BOTH")
+)
+
+// Frame is the region of the sensor a camera reads out, in unbinned pixels.
+type Frame struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// ExposureOptions controls an exposure taken by Expose.
+type ExposureOptions struct {
+	// FrameType, if it is set, is the kind of frame to take. Otherwise the camera takes the kind it took last.
+	FrameType FrameType
+
+	// Blob is the BLOB property the image is sent in. The default is CCD1, the main sensor; CCD2 is the guide sensor
+	// of cameras that have one.
+	Blob string
+
+	// Progress, if it is set, is called with the time left each time the camera counts down.
+	Progress func(remaining time.Duration)
+}
+
+// Ramp controls how CoolTo changes the temperature of a camera.
+type Ramp struct {
+	// Rate is how fast the temperature changes, in degrees Celsius per minute. If it is 0, the camera is sent straight
+	// to the target.
+	Rate float64
+
+	// Interval is how often the setpoint is moved. The default is DefaultRampInterval.
+	Interval time.Duration
+}
+
+// CCD controls a camera through the standard INDI CCD properties.
+type CCD struct {
+	client *indiclient.INDIClient
+	name   string
+}
+
+// NewCCD returns a CCD that controls the device with the given name.
+func NewCCD(client *indiclient.INDIClient, name string) *CCD {
+	return &CCD{
+		client: client,
+		name:   name,
+	}
+}
+
+// Name returns the name of the device.
+func (c *CCD) Name() string {
+	return c.name
+}
+
+// Expose takes an exposure of duration d and returns the image. Unless the upload mode is UploadLocal, BLOBs are
+// enabled for the BLOB property until Expose returns, and Expose waits until both the exposure is finished and the
+// image has arrived; with UploadLocal, nil is returned for the image. If ctx is done first, the exposure is aborted.
+//
+// Like INDIClient.WaitForProperty, Expose waits for the camera to report CCD_EXPOSURE Busy before it believes the
+// exposure is finished, and only takes an image that arrives after that, so an update or an image left over from an
+// earlier exposure is not mistaken for this one.
+//
+// Expose has no timeout of its own, since exposures and downloads can take a long time. With a ctx that has no
+// deadline, it waits forever for a camera that never finishes or an image that never arrives.
+func (c *CCD) Expose(ctx context.Context, d time.Duration, opts ExposureOptions) (*indiclient.Blob, error) {
+	if len(opts.FrameType) > 0 {
+		err := c.SetFrameType(ctx, opts.FrameType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	blobProp := opts.Blob
+	if len(blobProp) == 0 {
+		blobProp = "CCD1"
+	}
+
+	mode, err := c.UploadMode()
+	wantBlob := err != nil || mode != UploadLocal
+
+	if wantBlob {
+		if enabled := c.client.BlobEnabled(c.name, blobProp); enabled == indiclient.BlobEnableNever {
+			err = c.client.EnableBlob(c.name, blobProp, indiclient.BlobEnableAlso)
+			if err != nil {
+				return nil, err
+			}
+
+			defer c.client.EnableBlob(c.name, blobProp, enabled)
+		}
+	}
+
+	// BLOBs come on the same subscription as the exposure, so they can be told apart by when they arrive.
+	s := c.client.Subscribe(indiclient.EventFilter{
+		Device: c.name,
+		Types:  []indiclient.EventType{indiclient.EventPropertyUpdated, indiclient.EventPropertyDeleted, indiclient.EventBlobReceived},
+	}, 0, indiclient.DropPolicyOldest)
+	defer s.Close()
+
+	err = c.client.SetNumberValue(c.name, propExposure, "CCD_EXPOSURE_VALUE", formatFloat(d.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	w := c.client.NewCompletion()
+	defer w.Stop()
+
+	exposed := false
+
+	var blob *indiclient.Blob
+
+	for !exposed || (wantBlob && blob == nil) {
+		select {
+		case <-ctx.Done():
+			c.client.SetSwitchValue(c.name, propAbortExposure, "ABORT", indiclient.SwitchStateOn)
+			return nil, ctx.Err()
+		case <-w.Expired():
+			err = w.Result()
+			if err != nil {
+				return nil, err
+			}
+
+			exposed = true
+		case e := <-s.Events():
+			switch {
+			case e.Type == indiclient.EventBlobReceived:
+				// A BLOB that arrives before the camera reports the exposure has started is left over from an earlier
+				// one.
+				if e.Property == blobProp && (w.Busy() || exposed) {
+					blob = e.Blob
+				}
+			case e.Property != propExposure:
+			case e.Type == indiclient.EventPropertyDeleted:
+				return nil, indiclient.ErrPropertyNotFound
+			case exposed:
+			default:
+				if e.State() == indiclient.PropertyStateBusy && opts.Progress != nil {
+					if remaining, err := e.New.(indiclient.NumberProperty).Values["CCD_EXPOSURE_VALUE"].Float(); err == nil {
+						opts.Progress(time.Duration(remaining * float64(time.Second)))
+					}
+				}
+
+				done, err := w.Update(e)
+				if err != nil {
+					return nil, err
+				}
+
+				exposed = done
+			}
+		}
+	}
+
+	return blob, nil
+}
+
+// Abort aborts the exposure in progress.
+func (c *CCD) Abort(ctx context.Context) error {
+	return c.client.SetSwitchValueAndWait(ctx, c.name, propAbortExposure, "ABORT", indiclient.SwitchStateOn)
+}
+
+// Frame returns the region of the sensor the camera reads out.
+func (c *CCD) Frame() (Frame, error) {
+	var f Frame
+
+	for name, v := range map[string]*int{"X": &f.X, "Y": &f.Y, "WIDTH": &f.Width, "HEIGHT": &f.Height} {
+		n, err := c.client.NumberValueFloat(c.name, propFrame, name)
+		if err != nil {
+			return Frame{}, err
+		}
+
+		*v = int(n)
+	}
+
+	return f, nil
+}
+
+// SetFrame sets the region of the sensor the camera reads out.
+func (c *CCD) SetFrame(ctx context.Context, f Frame) error {
+	return c.client.SetNumberValuesAndWait(ctx, c.name, propFrame, map[string]string{
+		"X":      strconv.Itoa(f.X),
+		"Y":      strconv.Itoa(f.Y),
+		"WIDTH":  strconv.Itoa(f.Width),
+		"HEIGHT": strconv.Itoa(f.Height),
+	})
+}
+
+// ResetFrame sets the camera back to reading out the whole sensor, without binning.
+func (c *CCD) ResetFrame(ctx context.Context) error {
+	return c.client.SetSwitchValueAndWait(ctx, c.name, propFrameReset, "RESET", indiclient.SwitchStateOn)
+}
+
+// Binning returns the horizontal and vertical binning.
+func (c *CCD) Binning() (int, int, error) {
+	x, err := c.client.NumberValueFloat(c.name, propBinning, "HOR_BIN")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	y, err := c.client.NumberValueFloat(c.name, propBinning, "VER_BIN")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(x), int(y), nil
+}
+
+// SetBinning sets the horizontal and vertical binning.
+func (c *CCD) SetBinning(ctx context.Context, x, y int) error {
+	return c.client.SetNumberValuesAndWait(ctx, c.name, propBinning, map[string]string{
+		"HOR_BIN": strconv.Itoa(x),
+		"VER_BIN": strconv.Itoa(y),
+	})
+}
+
+// Gain returns the gain of the camera, from CCD_GAIN, or the Gain element of CCD_CONTROLS, which some drivers use
+// instead.
+func (c *CCD) Gain() (float64, error) {
+	prop, elem, err := c.control(propGain, "GAIN", "Gain")
+	if err != nil {
+		return 0, err
+	}
+
+	return c.client.NumberValueFloat(c.name, prop, elem)
+}
+
+// SetGain sets the gain of the camera.
+func (c *CCD) SetGain(ctx context.Context, gain float64) error {
+	prop, elem, err := c.control(propGain, "GAIN", "Gain")
+	if err != nil {
+		return err
+	}
+
+	return c.client.SetNumberValueAndWait(ctx, c.name, prop, elem, formatFloat(gain))
+}
+
+// Offset returns the offset of the camera, from CCD_OFFSET, or the Offset element of CCD_CONTROLS, which some drivers
+// use instead.
+func (c *CCD) Offset() (float64, error) {
+	prop, elem, err := c.control(propOffset, "OFFSET", "Offset")
+	if err != nil {
+		return 0, err
+	}
+
+	return c.client.NumberValueFloat(c.name, prop, elem)
+}
+
+// SetOffset sets the offset of the camera.
+func (c *CCD) SetOffset(ctx context.Context, offset float64) error {
+	prop, elem, err := c.control(propOffset, "OFFSET", "Offset")
+	if err != nil {
+		return err
+	}
+
+	return c.client.SetNumberValueAndWait(ctx, c.name, prop, elem, formatFloat(offset))
+}
+
+// control returns the property and element of a control, which is either a standard property of its own, or an
+// element of CCD_CONTROLS.
+func (c *CCD) control(prop, elem, control string) (string, string, error) {
+	if _, err := c.client.NumberProperty(c.name, prop); err == nil {
+		return prop, elem, nil
+	}
+
+	controls, err := c.client.NumberProperty(c.name, propControls)
+	if err == nil {
+		if _, ok := controls.Values[control]; ok {
+			return propControls, control, nil
+		}
+	}
+
+	return "", "", ErrNoControl
+}
+
+// Temperature returns the temperature of the sensor, in degrees Celsius.
+func (c *CCD) Temperature() (float64, error) {
+	return c.client.NumberValueFloat(c.name, propCCDTemp, "CCD_TEMPERATURE_VALUE")
+}
+
+// SetTemperature sets the temperature the cooler holds the sensor at, in degrees Celsius, and waits for the sensor to
+// get there. Most drivers turn the cooler on when they are sent a temperature.
+func (c *CCD) SetTemperature(ctx context.Context, temp float64) error {
+	return c.client.SetNumberValueAndWait(ctx, c.name, propCCDTemp, "CCD_TEMPERATURE_VALUE", formatFloat(temp))
+}
+
+// CoolTo changes the temperature of the sensor to temp, in degrees Celsius, no faster than ramp allows, which is kinder
+// to the sensor than a sudden change, whether it is cooling down or warming up. The setpoint is moved every
+// ramp.Interval, and CoolTo returns once the sensor reaches temp.
+func (c *CCD) CoolTo(ctx context.Context, temp float64, ramp Ramp) error {
+	if ramp.Rate <= 0 {
+		return c.SetTemperature(ctx, temp)
+	}
+
+	interval := ramp.Interval
+	if interval <= 0 {
+		interval = DefaultRampInterval
+	}
+
+	setpoint, err := c.Temperature()
+	if err != nil {
+		return err
+	}
+
+	step := ramp.Rate * interval.Minutes()
+
+	for math.Abs(temp-setpoint) > step {
+		if temp < setpoint {
+			setpoint -= step
+		} else {
+			setpoint += step
+		}
+
+		err = c.client.SetNumberValue(c.name, propCCDTemp, "CCD_TEMPERATURE_VALUE", formatFloat(setpoint))
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return c.SetTemperature(ctx, temp)
+}
+
+// Cooler returns true if the cooler is on.
+func (c *CCD) Cooler() (bool, error) {
+	return switchOn(c.client, c.name, propCooler, "COOLER_ON")
+}
+
+// SetCooler turns the cooler on or off.
+func (c *CCD) SetCooler(ctx context.Context, on bool) error {
+	name := "COOLER_OFF"
+	if on {
+		name = "COOLER_ON"
+	}
+
+	return c.client.SetSwitchValueAndWait(ctx, c.name, propCooler, name, indiclient.SwitchStateOn)
+}
+
+// FrameType returns the kind of frame the camera takes.
+func (c *CCD) FrameType() (FrameType, error) {
+	t, err := c.client.ActiveSwitch(c.name, propFrameType)
+	return FrameType(t), err
+}
+
+// SetFrameType sets the kind of frame the camera takes.
+func (c *CCD) SetFrameType(ctx context.Context, t FrameType) error {
+	return c.client.SetSwitchValueAndWait(ctx, c.name, propFrameType, string(t), indiclient.SwitchStateOn)
+}
+
+// UploadMode returns where the camera sends its images.
+func (c *CCD) UploadMode() (UploadMode, error) {
+	mode, err := c.client.ActiveSwitch(c.name, propUploadMode)
+	return UploadMode(mode), err
+}
+
+// SetUploadMode sets where the camera sends its images.
+func (c *CCD) SetUploadMode(ctx context.Context, mode UploadMode) error {
+	return c.client.SetSwitchValueAndWait(ctx, c.name, propUploadMode, string(mode), indiclient.SwitchStateOn)
+}
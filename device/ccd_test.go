@@ -0,0 +1,350 @@
+package device_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/device"
+	"github.com/goastro/indiclient/inditest"
+)
+
+const camera = "CCD Simulator"
+
+func cameraProperties() []interface{} {
+	return []interface{}{
+		// As libindi defines it, with a step that is only a hint.
+		limits(numbers(camera, "CCD_EXPOSURE", "CCD_EXPOSURE_VALUE=1"), "0.01", "3600", "1"),
+		switches(camera, "CCD_ABORT_EXPOSURE", indiclient.SwitchRuleAtMostOne, "ABORT"),
+		numbers(camera, "CCD_FRAME", "X=0", "Y=0", "WIDTH=1280", "HEIGHT=1024"),
+		switches(camera, "CCD_FRAME_RESET", indiclient.SwitchRuleAtMostOne, "RESET"),
+		numbers(camera, "CCD_BINNING", "HOR_BIN=1", "VER_BIN=1"),
+		numbers(camera, "CCD_TEMPERATURE", "CCD_TEMPERATURE_VALUE=20"),
+		switches(camera, "CCD_COOLER", indiclient.SwitchRuleOneOfMany, "COOLER_OFF", "COOLER_ON"),
+		switches(camera, "CCD_FRAME_TYPE", indiclient.SwitchRuleOneOfMany, "FRAME_LIGHT", "FRAME_BIAS", "FRAME_DARK", "FRAME_FLAT"),
+		switches(camera, "UPLOAD_MODE", indiclient.SwitchRuleOneOfMany, "UPLOAD_CLIENT", "UPLOAD_LOCAL", "UPLOAD_BOTH"),
+		numbers(camera, "CCD_CONTROLS", "Gain=100"),
+		numbers(camera, "CCD_OFFSET", "OFFSET=10"),
+		&indiclient.DefBlobVector{
+			Device: camera,
+			Name:   "CCD1",
+			State:  indiclient.PropertyStateIdle,
+			Perm:   indiclient.PropertyPermissionReadOnly,
+			Blobs:  []indiclient.DefBlob{{Name: "CCD1"}},
+		},
+	}
+}
+
+// countDown makes the camera count down from 0.2 seconds, then send image, unless it is nil.
+func countDown(s *inditest.Server, image []byte) {
+	s.Handle(camera, "CCD_EXPOSURE", func(cmd inditest.Command) []inditest.Step {
+		if image != nil {
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				s.SendBlob(camera, "CCD1", "CCD1", image, ".fits")
+			}()
+		}
+
+		return []inditest.Step{
+			{State: indiclient.PropertyStateBusy, Values: map[string]string{"CCD_EXPOSURE_VALUE": "0.2"}},
+			{Delay: 20 * time.Millisecond, State: indiclient.PropertyStateBusy, Values: map[string]string{"CCD_EXPOSURE_VALUE": "0.1"}},
+			{Delay: 20 * time.Millisecond, State: indiclient.PropertyStateOk, Values: map[string]string{"CCD_EXPOSURE_VALUE": "0"}},
+		}
+	})
+}
+
+func Test_CCD_Expose(t *testing.T) {
+	s, c := connect(t, cameraProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	countDown(s, []byte("image"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ccd := device.NewCCD(c, camera)
+	assert.Equal(t, camera, ccd.Name())
+
+	var progress []time.Duration
+
+	blob, err := ccd.Expose(ctx, 200*time.Millisecond, device.ExposureOptions{
+		FrameType: device.FrameDark,
+		Progress: func(remaining time.Duration) {
+			progress = append(progress, remaining)
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, blob)
+
+	rdr, err := blob.Open()
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	data, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	assert.Equal(t, "image", string(data))
+	assert.Equal(t, []time.Duration{200 * time.Millisecond, 100 * time.Millisecond}, progress)
+	assert.Equal(t, map[string]string{"CCD_EXPOSURE_VALUE": "0.2"}, lastValues(t, s, camera, "CCD_EXPOSURE"))
+
+	frameType, err := ccd.FrameType()
+	require.NoError(t, err)
+	assert.Equal(t, device.FrameDark, frameType)
+
+	// Images saved by the driver are not waited for.
+	require.NoError(t, ccd.SetUploadMode(ctx, device.UploadLocal))
+
+	mode, err := ccd.UploadMode()
+	require.NoError(t, err)
+	assert.Equal(t, device.UploadLocal, mode)
+
+	countDown(s, nil)
+
+	blob, err = ccd.Expose(ctx, 5*time.Second, device.ExposureOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, blob)
+	assert.Equal(t, map[string]string{"CCD_EXPOSURE_VALUE": "5"}, lastValues(t, s, camera, "CCD_EXPOSURE"))
+}
+
+func Test_CCD_Expose_BlobEnable(t *testing.T) {
+	s, c := connect(t, cameraProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	countDown(s, []byte("image"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ccd := device.NewCCD(c, camera)
+
+	enables := func() []indiclient.BlobEnable {
+		var values []indiclient.BlobEnable
+
+		for _, cmd := range s.Received() {
+			if e, ok := cmd.Element.(*indiclient.EnableBlob); ok {
+				values = append(values, e.Value)
+			}
+		}
+
+		return values
+	}
+
+	// BLOBs are turned back off once the image has arrived.
+	_, err := ccd.Expose(ctx, time.Second, device.ExposureOptions{})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return len(enables()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []indiclient.BlobEnable{indiclient.BlobEnableAlso, indiclient.BlobEnableNever}, enables())
+	assert.Equal(t, indiclient.BlobEnableNever, c.BlobEnabled(camera, "CCD1"))
+
+	// BLOBs that were already enabled are left as they were.
+	s.ClearReceived()
+	require.NoError(t, c.EnableBlob(camera, "CCD1", indiclient.BlobEnableAlso))
+
+	_, ok := s.WaitFor(time.Second, func(cmd inditest.Command) bool {
+		e, ok := cmd.Element.(*indiclient.EnableBlob)
+		return ok && e.Value == indiclient.BlobEnableAlso
+	})
+	require.True(t, ok)
+
+	s.ClearReceived()
+
+	_, err = ccd.Expose(ctx, time.Second, device.ExposureOptions{})
+	require.NoError(t, err)
+
+	assert.Empty(t, enables())
+	assert.Equal(t, indiclient.BlobEnableAlso, c.BlobEnabled(camera, "CCD1"))
+}
+
+func Test_CCD_Expose_StaleUpdate(t *testing.T) {
+	s, c := connect(t, cameraProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	// The image and the end of the last exposure are still on their way when the next one starts.
+	s.Handle(camera, "CCD_EXPOSURE", func(cmd inditest.Command) []inditest.Step {
+		s.SendBlob(camera, "CCD1", "CCD1", []byte("stale"), ".fits")
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			s.SendBlob(camera, "CCD1", "CCD1", []byte("image"), ".fits")
+		}()
+
+		return []inditest.Step{
+			{State: indiclient.PropertyStateOk, Values: map[string]string{"CCD_EXPOSURE_VALUE": "0"}},
+			{State: indiclient.PropertyStateBusy},
+			{Delay: 20 * time.Millisecond, State: indiclient.PropertyStateOk, Values: map[string]string{"CCD_EXPOSURE_VALUE": "0"}},
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ccd := device.NewCCD(c, camera)
+
+	blob, err := ccd.Expose(ctx, time.Second, device.ExposureOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, blob)
+
+	rdr, err := blob.Open()
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	data, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	assert.Equal(t, "image", string(data))
+
+	// Without an image to wait for, the exposure still has to go Busy first.
+	require.NoError(t, ccd.SetUploadMode(ctx, device.UploadLocal))
+
+	s.Script(camera, "CCD_EXPOSURE",
+		inditest.Step{State: indiclient.PropertyStateOk, Values: map[string]string{"CCD_EXPOSURE_VALUE": "0"}},
+		inditest.Step{State: indiclient.PropertyStateBusy},
+		inditest.Step{Delay: 100 * time.Millisecond, State: indiclient.PropertyStateOk, Values: map[string]string{"CCD_EXPOSURE_VALUE": "0"}},
+	)
+
+	start := time.Now()
+
+	blob, err = ccd.Expose(ctx, time.Second, device.ExposureOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, blob)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func Test_CCD_Expose_Abort(t *testing.T) {
+	s, c := connect(t, cameraProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	s.Script(camera, "CCD_EXPOSURE", inditest.Step{State: indiclient.PropertyStateBusy})
+
+	ccd := device.NewCCD(c, camera)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := ccd.Expose(ctx, time.Minute, device.ExposureOptions{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	_, ok := s.WaitFor(2*time.Second, func(cmd inditest.Command) bool {
+		return cmd.Name == "CCD_ABORT_EXPOSURE" && cmd.Values["ABORT"] == string(indiclient.SwitchStateOn)
+	})
+	assert.True(t, ok)
+
+	s.Script(camera, "CCD_EXPOSURE",
+		inditest.Step{State: indiclient.PropertyStateBusy},
+		inditest.Step{State: indiclient.PropertyStateAlert, Message: "download failed"},
+	)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err = ccd.Expose(ctx, time.Second, device.ExposureOptions{})
+	require.Error(t, err)
+
+	alert, ok := err.(*indiclient.PropertyAlertError)
+	require.True(t, ok)
+	assert.Equal(t, "download failed", alert.Message)
+}
+
+func Test_CCD_Settings(t *testing.T) {
+	s, c := connect(t, cameraProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ccd := device.NewCCD(c, camera)
+
+	require.NoError(t, ccd.SetFrame(ctx, device.Frame{X: 100, Y: 200, Width: 640, Height: 480}))
+
+	frame, err := ccd.Frame()
+	require.NoError(t, err)
+	assert.Equal(t, device.Frame{X: 100, Y: 200, Width: 640, Height: 480}, frame)
+
+	require.NoError(t, ccd.ResetFrame(ctx))
+	assert.Equal(t, string(indiclient.SwitchStateOn), lastValues(t, s, camera, "CCD_FRAME_RESET")["RESET"])
+
+	require.NoError(t, ccd.SetBinning(ctx, 2, 3))
+
+	x, y, err := ccd.Binning()
+	require.NoError(t, err)
+	assert.Equal(t, 2, x)
+	assert.Equal(t, 3, y)
+
+	// Gain is only in CCD_CONTROLS, offset has a property of its own.
+	require.NoError(t, ccd.SetGain(ctx, 139))
+	assert.Equal(t, map[string]string{"Gain": "139"}, lastValues(t, s, camera, "CCD_CONTROLS"))
+
+	gain, err := ccd.Gain()
+	require.NoError(t, err)
+	assert.Equal(t, 139.0, gain)
+
+	require.NoError(t, ccd.SetOffset(ctx, 21))
+
+	offset, err := ccd.Offset()
+	require.NoError(t, err)
+	assert.Equal(t, 21.0, offset)
+
+	require.NoError(t, ccd.SetCooler(ctx, true))
+
+	on, err := ccd.Cooler()
+	require.NoError(t, err)
+	assert.True(t, on)
+
+	require.NoError(t, ccd.Abort(ctx))
+}
+
+func Test_CCD_NoControl(t *testing.T) {
+	s, c := connect(t, numbers(camera, "CCD_EXPOSURE", "CCD_EXPOSURE_VALUE=1"))
+	defer s.Close()
+	defer c.Disconnect()
+
+	ccd := device.NewCCD(c, camera)
+
+	_, err := ccd.Gain()
+	assert.Equal(t, device.ErrNoControl, err)
+
+	err = ccd.SetOffset(context.Background(), 10)
+	assert.Equal(t, device.ErrNoControl, err)
+}
+
+func Test_CCD_CoolTo(t *testing.T) {
+	s, c := connect(t, cameraProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ccd := device.NewCCD(c, camera)
+
+	// A degree every 20ms.
+	require.NoError(t, ccd.CoolTo(ctx, 16.5, device.Ramp{Rate: 3000, Interval: 20 * time.Millisecond}))
+
+	var setpoints []string
+	for _, cmd := range s.Received() {
+		if cmd.Name == "CCD_TEMPERATURE" {
+			setpoints = append(setpoints, cmd.Values["CCD_TEMPERATURE_VALUE"])
+		}
+	}
+
+	assert.Equal(t, []string{"19", "18", "17", "16.5"}, setpoints)
+
+	temp, err := ccd.Temperature()
+	require.NoError(t, err)
+	assert.Equal(t, 16.5, temp)
+
+	// Without a rate, the camera goes straight to the target.
+	s.ClearReceived()
+
+	require.NoError(t, ccd.CoolTo(ctx, 20, device.Ramp{}))
+	assert.Len(t, s.Received(), 1)
+}
@@ -0,0 +1,144 @@
+// Package device wraps an INDIClient with types for the standard kinds of INDI device, such as Telescope, so they can
+// be controlled without knowing the names of their standard properties:
+//
+//	mount := device.NewTelescope(client, "Telescope Simulator")
+//	err := mount.Track(ctx, device.Equatorial{RA: 5.5881, Dec: -5.3911})
+//
+// The calls that change something wait for the device to finish, returning nil once the property is Ok, or a
+// *indiclient.PropertyAlertError if the device reports Alert. They wait for as long as ctx allows, or the timeout the
+// device gave for the property if ctx has no deadline. The calls that read something return the value the client last
+// received, without waiting.
+package device
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/goastro/indiclient"
+)
+
+// formatFloat formats a number to send to a device.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// switchOn returns true if a switch is On.
+func switchOn(client *indiclient.INDIClient, deviceName, propName, switchName string) (bool, error) {
+	prop, err := client.SwitchProperty(deviceName, propName)
+	if err != nil {
+		return false, err
+	}
+
+	v, ok := prop.Values[switchName]
+	if !ok {
+		return false, indiclient.ErrPropertyValueNotFound
+	}
+
+	return v.Value == indiclient.SwitchStateOn, nil
+}
+
+// number is the definition of a number element, used to keep the values sent to it within what the client and the
+// device accept.
+type number struct {
+	device   string
+	property string
+	element  string
+
+	min  float64
+	max  float64
+	step float64
+
+	// checkStep is true if the client rejects values that are not a whole number of steps from min.
+	checkStep bool
+}
+
+// defNumber returns the definition of a number element.
+func defNumber(client *indiclient.INDIClient, deviceName, propName, elemName string) (number, error) {
+	prop, err := client.NumberProperty(deviceName, propName)
+	if err != nil {
+		return number{}, err
+	}
+
+	v, ok := prop.Values[elemName]
+	if !ok {
+		return number{}, indiclient.ErrPropertyValueNotFound
+	}
+
+	n := number{
+		device:    deviceName,
+		property:  propName,
+		element:   elemName,
+		checkStep: client.StepValidation(),
+	}
+
+	n.min, err = indiclient.ParseNumber(v.Min)
+	if err != nil {
+		return number{}, err
+	}
+
+	n.max, err = indiclient.ParseNumber(v.Max)
+	if err != nil {
+		return number{}, err
+	}
+
+	// Like the client, treat a step that cannot be read as no step.
+	n.step, _ = indiclient.ParseNumber(v.Step)
+
+	return n, nil
+}
+
+// clamp brings v within min and max, unless they are equal.
+func (n number) clamp(v float64) float64 {
+	if n.min < n.max {
+		v = math.Max(n.min, math.Min(n.max, v))
+	}
+
+	return v
+}
+
+// stepped returns true if the client only accepts values a whole number of steps from min. Like the client, it ignores
+// a step that min is not a whole number of.
+func (n number) stepped() bool {
+	return n.checkStep && n.step > 0 && math.Abs(n.min/n.step-math.Round(n.min/n.step)) <= 1e-6
+}
+
+// fit clamps v, and brings it to a whole number of steps from min if the client checks steps, using round to pick the
+// step.
+func (n number) fit(v float64, round func(float64) float64) float64 {
+	v = n.clamp(v)
+
+	if !n.stepped() {
+		return v
+	}
+
+	v = n.min + round((v-n.min)/n.step)*n.step
+
+	if n.min < n.max && v > n.max {
+		v -= n.step
+	}
+
+	return v
+}
+
+// check returns the *indiclient.NumberRangeError the client would if the client checks steps and v is not a whole
+// number of steps from min, so a value the caller asked for can be refused before anything is sent, rather than
+// rounded to one they did not ask for.
+func (n number) check(v float64) error {
+	if !n.stepped() {
+		return nil
+	}
+
+	k := (v - n.min) / n.step
+	if math.Abs(k-math.Round(k)) <= 1e-6 {
+		return nil
+	}
+
+	return &indiclient.NumberRangeError{
+		Device:   n.device,
+		Property: n.property,
+		Element:  n.element,
+		Bound:    indiclient.NumberBoundStep,
+		Limit:    n.step,
+		Value:    v,
+	}
+}
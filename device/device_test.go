@@ -0,0 +1,130 @@
+package device_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/inditest"
+)
+
+// numbers defines a read-write number vector. Each of values is "NAME=value".
+func numbers(device, name string, values ...string) *indiclient.DefNumberVector {
+	def := &indiclient.DefNumberVector{
+		Device:  device,
+		Name:    name,
+		State:   indiclient.PropertyStateIdle,
+		Perm:    indiclient.PropertyPermissionReadWrite,
+		Timeout: 60,
+	}
+
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		def.Numbers = append(def.Numbers, indiclient.DefNumber{Name: parts[0], Format: "%g", Min: "-1000000", Max: "1000000", Step: "0", Value: parts[1]})
+	}
+
+	return def
+}
+
+// texts defines a read-write text vector. Each of values is "NAME=value".
+func texts(device, name string, values ...string) *indiclient.DefTextVector {
+	def := &indiclient.DefTextVector{
+		Device:  device,
+		Name:    name,
+		State:   indiclient.PropertyStateIdle,
+		Perm:    indiclient.PropertyPermissionReadWrite,
+		Timeout: 60,
+	}
+
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		def.Texts = append(def.Texts, indiclient.DefText{Name: parts[0], Value: parts[1]})
+	}
+
+	return def
+}
+
+// limits sets the Min, Max and Step of every number of def.
+func limits(def *indiclient.DefNumberVector, min, max, step string) *indiclient.DefNumberVector {
+	for i := range def.Numbers {
+		def.Numbers[i].Min = min
+		def.Numbers[i].Max = max
+		def.Numbers[i].Step = step
+	}
+
+	return def
+}
+
+// switches defines a read-write switch vector with the first of names On, unless rule is AtMostOne.
+func switches(device, name string, rule indiclient.SwitchRule, names ...string) *indiclient.DefSwitchVector {
+	def := &indiclient.DefSwitchVector{
+		Device:  device,
+		Name:    name,
+		State:   indiclient.PropertyStateIdle,
+		Perm:    indiclient.PropertyPermissionReadWrite,
+		Rule:    rule,
+		Timeout: 60,
+	}
+
+	for i, n := range names {
+		state := indiclient.SwitchStateOff
+		if i == 0 && rule == indiclient.SwitchRuleOneOfMany {
+			state = indiclient.SwitchStateOn
+		}
+
+		def.Switches = append(def.Switches, indiclient.DefSwitch{Name: n, Value: state})
+	}
+
+	return def
+}
+
+// connect defines defs on a new server, and returns it with a client that knows about all of them.
+func connect(t *testing.T, defs ...interface{}) (*inditest.Server, *indiclient.INDIClient) {
+	s := inditest.NewServer()
+	require.NoError(t, s.Define(defs...))
+
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+
+	// The wrappers have to work with steps checked too, against the limits libindi drivers define.
+	c := indiclient.NewINDIClient(log, s.Dialer(), afero.NewMemMapFs(), 10)
+	c.SetStepValidation(true)
+
+	// inditest answers most commands with Ok straight away, rather than going Busy first.
+	c.SetWaitGracePeriod(20 * time.Millisecond)
+	require.NoError(t, c.Connect("tcp", ""))
+	require.NoError(t, c.GetProperties("", ""))
+
+	assert.Eventually(t, func() bool {
+		count := 0
+
+		for _, d := range c.Devices() {
+			count += len(d.TextProperties) + len(d.NumberProperties) + len(d.SwitchProperties) + len(d.LightProperties) + len(d.BlobProperties)
+		}
+
+		return count == len(defs)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	return s, c
+}
+
+// lastValues returns the values of the last command the server received for a property.
+func lastValues(t *testing.T, s *inditest.Server, device, name string) map[string]string {
+	received := s.Received()
+
+	for i := len(received) - 1; i >= 0; i-- {
+		if received[i].Device == device && received[i].Name == name {
+			return received[i].Values
+		}
+	}
+
+	require.FailNow(t, "no command received for "+name)
+
+	return nil
+}
@@ -0,0 +1,301 @@
+package device
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/goastro/indiclient"
+)
+
+const (
+	propDomeMotion   = "DOME_MOTION"
+	propDomePosition = "ABS_DOME_POSITION"
+	propDomeShutter  = "DOME_SHUTTER"
+	propDomePark     = "DOME_PARK"
+	propDomeAbort    = "DOME_ABORT_MOTION"
+	elemDomePosition = "DOME_ABSOLUTE_POSITION"
+)
+
+const (
+	// DefaultSlaveThreshold is how far, in degrees, Slave lets the dome get from where it should be before moving it.
+	DefaultSlaveThreshold = 3.0
+
+	// DefaultSlaveInterval is how often Slave checks where the dome should be.
+	DefaultSlaveInterval = 10 * time.Second
+)
+
+// DomeDirection is a direction a dome can be turned in by hand.
+type DomeDirection string
+
+const (
+	// DomeClockwise turns the dome clockwise, seen from above, towards higher azimuths.
+	DomeClockwise = DomeDirection("DOME_CW")
+
+	// DomeCounterClockwise turns the dome counterclockwise, seen from above, towards lower azimuths.
+	DomeCounterClockwise = DomeDirection("DOME_CCW")
+)
+
+// Geometry is the layout of a dome and the mount inside it, in meters, used to work out where the slit must be for the
+// mount to see out of it. The zero value is a mount at the center of a dome, where the slit just has to be at the
+// azimuth the mount points to.
+type Geometry struct {
+	// Radius is the radius of the dome.
+	Radius float64
+
+	// North, East and Up are how far the point where the axes of the mount cross is from the center of the dome.
+	North float64
+	East  float64
+	Up    float64
+
+	// Arm is how far the optical axis of a German equatorial mount is from its RA axis, along the Dec axis. It is 0
+	// for a fork mount.
+	Arm float64
+}
+
+// Azimuth returns the azimuth, in degrees, the slit must be at for a mount at latitude lat, in degrees, to see out of
+// it when it points at hour angle ha, in hours, and declination dec, in degrees. A German equatorial mount is taken to
+// be on the usual side of the pier: west when it points east of the meridian, and east otherwise.
+func (g Geometry) Azimuth(ha, dec, lat float64) float64 {
+	h := ha * 15 * math.Pi / 180
+	d := dec * math.Pi / 180
+	phi := lat * math.Pi / 180
+
+	// Directions are in a frame with x east, y north and z up, centered on the dome.
+	pointing := [3]float64{
+		-math.Cos(d) * math.Sin(h),
+		math.Sin(d)*math.Cos(phi) - math.Cos(d)*math.Sin(phi)*math.Cos(h),
+		math.Sin(d)*math.Sin(phi) + math.Cos(d)*math.Cos(phi)*math.Cos(h),
+	}
+
+	// The Dec axis is at right angles to the RA axis, 6 hours behind where the mount points, and the telescope is at
+	// the end of it away from the counterweight.
+	side := 1.0
+	if math.Sin(h) < 0 {
+		side = -1
+	}
+
+	decAxis := [3]float64{math.Cos(h), -math.Sin(phi) * math.Sin(h), math.Cos(phi) * math.Sin(h)}
+
+	origin := [3]float64{g.East, g.North, g.Up}
+	for i := range origin {
+		origin[i] += side * g.Arm * decAxis[i]
+	}
+
+	// Find where the line of sight from origin meets the dome.
+	slit := pointing
+
+	if g.Radius > 0 {
+		var b, c float64
+		for i := range origin {
+			b += origin[i] * pointing[i]
+			c += origin[i] * origin[i]
+		}
+
+		t := -b + math.Sqrt(math.Max(b*b-c+g.Radius*g.Radius, 0))
+
+		for i := range slit {
+			slit[i] = origin[i] + t*pointing[i]
+		}
+	}
+
+	return normalizeAzimuth(math.Atan2(slit[0], slit[1]) * 180 / math.Pi)
+}
+
+// SlaveOptions controls how Slave keeps a dome in line with a mount.
+type SlaveOptions struct {
+	Geometry
+
+	// Threshold is how far, in degrees, the dome can get from where it should be before it is moved. The default is
+	// DefaultSlaveThreshold.
+	Threshold float64
+
+	// Interval is how often to check where the dome should be. The default is DefaultSlaveInterval.
+	Interval time.Duration
+}
+
+// Dome controls a dome through the standard INDI dome properties.
+type Dome struct {
+	client *indiclient.INDIClient
+	name   string
+}
+
+// NewDome returns a Dome that controls the device with the given name.
+func NewDome(client *indiclient.INDIClient, name string) *Dome {
+	return &Dome{
+		client: client,
+		name:   name,
+	}
+}
+
+// Name returns the name of the device.
+func (d *Dome) Name() string {
+	return d.name
+}
+
+// Azimuth returns the azimuth of the dome, in degrees.
+func (d *Dome) Azimuth() (float64, error) {
+	return d.client.NumberValueFloat(d.name, propDomePosition, elemDomePosition)
+}
+
+// GoTo turns the dome to an azimuth, in degrees, and waits for it to get there. If the client checks steps, the
+// azimuth is rounded to the nearest Step of ABS_DOME_POSITION.
+func (d *Dome) GoTo(ctx context.Context, az float64) error {
+	az, err := d.position(az)
+	if err != nil {
+		return err
+	}
+
+	return d.client.SetNumberValueAndWait(ctx, d.name, propDomePosition, elemDomePosition, formatFloat(az))
+}
+
+// position returns the azimuth GoTo sends the dome to for az.
+func (d *Dome) position(az float64) (float64, error) {
+	n, err := defNumber(d.client, d.name, propDomePosition, elemDomePosition)
+	if err != nil {
+		return 0, err
+	}
+
+	return n.fit(normalizeAzimuth(az), math.Round), nil
+}
+
+// OpenShutter opens the shutter, and waits for it to open.
+func (d *Dome) OpenShutter(ctx context.Context) error {
+	return d.client.SetSwitchValueAndWait(ctx, d.name, propDomeShutter, "SHUTTER_OPEN", indiclient.SwitchStateOn)
+}
+
+// CloseShutter closes the shutter, and waits for it to close.
+func (d *Dome) CloseShutter(ctx context.Context) error {
+	return d.client.SetSwitchValueAndWait(ctx, d.name, propDomeShutter, "SHUTTER_CLOSE", indiclient.SwitchStateOn)
+}
+
+// ShutterOpen returns true if the shutter is open.
+func (d *Dome) ShutterOpen() (bool, error) {
+	return switchOn(d.client, d.name, propDomeShutter, "SHUTTER_OPEN")
+}
+
+// Park turns the dome to its park position.
+func (d *Dome) Park(ctx context.Context) error {
+	return d.client.SetSwitchValueAndWait(ctx, d.name, propDomePark, "PARK", indiclient.SwitchStateOn)
+}
+
+// Unpark unparks the dome, so it can be moved.
+func (d *Dome) Unpark(ctx context.Context) error {
+	return d.client.SetSwitchValueAndWait(ctx, d.name, propDomePark, "UNPARK", indiclient.SwitchStateOn)
+}
+
+// Parked returns true if the dome is parked.
+func (d *Dome) Parked() (bool, error) {
+	return switchOn(d.client, d.name, propDomePark, "PARK")
+}
+
+// StartMotion starts turning the dome in a direction, until StopMotion is called. It returns as soon as the command is
+// sent.
+func (d *Dome) StartMotion(dir DomeDirection) error {
+	return d.client.SetSwitchValue(d.name, propDomeMotion, string(dir), indiclient.SwitchStateOn)
+}
+
+// StopMotion stops turning the dome in a direction.
+func (d *Dome) StopMotion(ctx context.Context, dir DomeDirection) error {
+	return d.client.SetSwitchValueAndWait(ctx, d.name, propDomeMotion, string(dir), indiclient.SwitchStateOff)
+}
+
+// Abort stops the dome.
+func (d *Dome) Abort(ctx context.Context) error {
+	return d.client.SetSwitchValueAndWait(ctx, d.name, propDomeAbort, "ABORT", indiclient.SwitchStateOn)
+}
+
+// Slave keeps the slit of the dome in front of a mount, working out where it should be from the EQUATORIAL_EOD_COORD
+// and GEOGRAPHIC_COORD of the mount, and turning the dome when it is more than the threshold away. It returns when ctx
+// is done, with its error, or when the dome cannot be moved.
+func (d *Dome) Slave(ctx context.Context, mount *Telescope, opts SlaveOptions) error {
+	if opts.Threshold == 0 {
+		opts.Threshold = DefaultSlaveThreshold
+	}
+
+	if opts.Interval == 0 {
+		opts.Interval = DefaultSlaveInterval
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := d.follow(ctx, mount, opts)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// follow turns the dome to where it should be for mount, if it is more than the threshold away.
+func (d *Dome) follow(ctx context.Context, mount *Telescope, opts SlaveOptions) error {
+	eq, err := mount.Coordinates()
+	if err != nil {
+		return err
+	}
+
+	loc, err := mount.Location()
+	if err != nil {
+		return err
+	}
+
+	current, err := d.Azimuth()
+	if err != nil {
+		return err
+	}
+
+	target, err := d.position(opts.Azimuth(hourAngle(eq.RA, loc.Long, time.Now()), eq.Dec, loc.Lat))
+	if err != nil {
+		return err
+	}
+
+	diff := math.Abs(normalizeAzimuth(target - current))
+	if diff > 180 {
+		diff = 360 - diff
+	}
+
+	if diff <= opts.Threshold {
+		return nil
+	}
+
+	return d.GoTo(ctx, target)
+}
+
+// hourAngle returns the hour angle, in hours from -12 to 12, of right ascension ra, in hours, from longitude long, in
+// degrees east, at t.
+func hourAngle(ra, long float64, t time.Time) float64 {
+	// Greenwich mean sidereal time, in degrees, from the days since J2000.0.
+	days := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5 - 2451545.0
+	gmst := 280.46061837 + 360.98564736629*days
+
+	ha := math.Mod(gmst+long-ra*15, 360)
+	if ha < -180 {
+		ha += 360
+	} else if ha >= 180 {
+		ha -= 360
+	}
+
+	return ha / 15
+}
+
+// normalizeAzimuth brings an azimuth, in degrees, within 0 to 360.
+func normalizeAzimuth(az float64) float64 {
+	az = math.Mod(az, 360)
+	if az < 0 {
+		az += 360
+	}
+
+	// A tiny negative azimuth rounds up to 360.
+	if az >= 360 {
+		az = 0
+	}
+
+	return az
+}
@@ -0,0 +1,201 @@
+package device_test
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/device"
+	"github.com/goastro/indiclient/inditest"
+)
+
+const dome = "Dome Simulator"
+
+func domeProperties() []interface{} {
+	return []interface{}{
+		// As libindi defines it, with a step of 1 the client checks.
+		limits(numbers(dome, "ABS_DOME_POSITION", "DOME_ABSOLUTE_POSITION=180"), "0", "360", "1"),
+		switches(dome, "DOME_MOTION", indiclient.SwitchRuleAtMostOne, "DOME_CW", "DOME_CCW"),
+		switches(dome, "DOME_SHUTTER", indiclient.SwitchRuleOneOfMany, "SHUTTER_CLOSE", "SHUTTER_OPEN"),
+		switches(dome, "DOME_PARK", indiclient.SwitchRuleOneOfMany, "UNPARK", "PARK"),
+		switches(dome, "DOME_ABORT_MOTION", indiclient.SwitchRuleAtMostOne, "ABORT"),
+	}
+}
+
+// formatFloat formats a number the way the wrappers send it.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// azimuthError returns how far apart two azimuths are, in degrees.
+func azimuthError(a, b float64) float64 {
+	diff := math.Mod(math.Abs(a-b), 360)
+	return math.Min(diff, 360-diff)
+}
+
+func Test_Geometry_Azimuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		geometry device.Geometry
+		ha       float64
+		dec      float64
+		lat      float64
+		expected float64
+	}{
+		{"Pole", device.Geometry{}, 3, 90, 50, 0},
+		{"South Pole", device.Geometry{}, 3, -90, -30, 180},
+		{"Meridian", device.Geometry{}, 0, 0, 50, 180},
+		{"East", device.Geometry{}, -6, 0, 50, 90},
+		{"West", device.Geometry{}, 6, 0, 50, 270},
+		{"Center", device.Geometry{Radius: 3}, -6, 0, 50, 90},
+		{"Offset", device.Geometry{Radius: 2, East: 1}, 0, 90, 0, 30},
+		{"Arm Pier East", device.Geometry{Radius: 1, Arm: 0.5}, 0, 0, 0, 90},
+		{"Arm Pier West", device.Geometry{Radius: 1, Arm: 0.5}, -0.0001, 0, 0, 270},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			az := tt.geometry.Azimuth(tt.ha, tt.dec, tt.lat)
+			assert.True(t, az >= 0 && az < 360)
+			assert.InDelta(t, 0, azimuthError(tt.expected, az), 0.01)
+		})
+	}
+}
+
+func Test_Dome(t *testing.T) {
+	s, c := connect(t, domeProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	// The dome takes a while to turn.
+	s.Handle(dome, "ABS_DOME_POSITION", func(cmd inditest.Command) []inditest.Step {
+		return []inditest.Step{
+			{State: indiclient.PropertyStateBusy},
+			{Delay: 20 * time.Millisecond, State: indiclient.PropertyStateOk, Values: cmd.Values},
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	d := device.NewDome(c, dome)
+	assert.Equal(t, dome, d.Name())
+
+	require.NoError(t, d.GoTo(ctx, -90))
+
+	az, err := d.Azimuth()
+	require.NoError(t, err)
+	assert.Equal(t, 270.0, az)
+
+	require.NoError(t, d.GoTo(ctx, 123.6))
+	assert.Equal(t, map[string]string{"DOME_ABSOLUTE_POSITION": "124"}, lastValues(t, s, dome, "ABS_DOME_POSITION"))
+
+	require.NoError(t, d.OpenShutter(ctx))
+
+	open, err := d.ShutterOpen()
+	require.NoError(t, err)
+	assert.True(t, open)
+
+	require.NoError(t, d.CloseShutter(ctx))
+
+	open, err = d.ShutterOpen()
+	require.NoError(t, err)
+	assert.False(t, open)
+
+	require.NoError(t, d.Park(ctx))
+
+	parked, err := d.Parked()
+	require.NoError(t, err)
+	assert.True(t, parked)
+
+	require.NoError(t, d.Unpark(ctx))
+
+	parked, err = d.Parked()
+	require.NoError(t, err)
+	assert.False(t, parked)
+
+	require.NoError(t, d.StartMotion(device.DomeCounterClockwise))
+
+	_, ok := s.WaitFor(time.Second, func(cmd inditest.Command) bool {
+		return cmd.Name == "DOME_MOTION" && cmd.Values["DOME_CCW"] == string(indiclient.SwitchStateOn)
+	})
+	assert.True(t, ok)
+
+	require.NoError(t, d.StopMotion(ctx, device.DomeCounterClockwise))
+	assert.Equal(t, string(indiclient.SwitchStateOff), lastValues(t, s, dome, "DOME_MOTION")["DOME_CCW"])
+
+	require.NoError(t, d.Abort(ctx))
+	assert.Equal(t, string(indiclient.SwitchStateOn), lastValues(t, s, dome, "DOME_ABORT_MOTION")["ABORT"])
+}
+
+func Test_Dome_Slave(t *testing.T) {
+	// Pointing at a pole, where the dome has to be is the same at any time.
+	defs := append(domeProperties(),
+		numbers(mount, "EQUATORIAL_EOD_COORD", "RA=0", "DEC=90"),
+		numbers(mount, "GEOGRAPHIC_COORD", "LAT=50", "LONG=0", "ELEV=0"),
+	)
+
+	s, c := connect(t, defs...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := device.NewDome(c, dome)
+
+	// The mount is off center, so the dome has to go to fractional azimuths, which are rounded to the step of 1.
+	geometry := device.Geometry{Radius: 3, East: 0.7}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Slave(ctx, device.NewTelescope(c, mount), device.SlaveOptions{Geometry: geometry, Interval: 10 * time.Millisecond})
+	}()
+
+	domeAt := func(expected float64) func() bool {
+		return func() bool {
+			az, err := d.Azimuth()
+			return err == nil && azimuthError(expected, az) < 0.01
+		}
+	}
+
+	north := geometry.Azimuth(0, 90, 50)
+	require.NotEqual(t, math.Round(north), north)
+
+	assert.Eventually(t, domeAt(math.Round(north)), time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]string{"DOME_ABSOLUTE_POSITION": formatFloat(math.Round(north))}, lastValues(t, s, dome, "ABS_DOME_POSITION"))
+
+	require.NoError(t, s.Update(mount, "EQUATORIAL_EOD_COORD", inditest.Step{
+		State:  indiclient.PropertyStateOk,
+		Values: map[string]string{"DEC": "-90"},
+	}))
+
+	south := math.Round(geometry.Azimuth(0, -90, 50))
+	assert.Eventually(t, domeAt(south), time.Second, 10*time.Millisecond)
+
+	select {
+	case err := <-done:
+		require.FailNow(t, "Slave returned", "%v", err)
+	default:
+	}
+
+	// Within the threshold, the dome is left where it is.
+	s.ClearReceived()
+
+	require.NoError(t, s.Update(dome, "ABS_DOME_POSITION", inditest.Step{
+		State:  indiclient.PropertyStateOk,
+		Values: map[string]string{"DOME_ABSOLUTE_POSITION": formatFloat(south + 2)},
+	}))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, s.Received())
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
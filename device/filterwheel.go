@@ -0,0 +1,177 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goastro/indiclient"
+)
+
+const (
+	propFilterSlot       = "FILTER_SLOT"
+	propFilterName       = "FILTER_NAME"
+	elemFilterSlot       = "FILTER_SLOT_VALUE"
+	elemFilterNamePrefix = "FILTER_SLOT_NAME_"
+)
+
+// ErrUnknownFilter is returned by SetFilter when no slot of the filter wheel has a filter with the given name.
+var ErrUnknownFilter = errors.New("unknown filter")
+
+// FilterWheel controls a filter wheel through the FILTER_SLOT and FILTER_NAME properties. Slots are numbered as the
+// elements of FILTER_NAME are, usually from 1.
+//
+// With SetFocusOffsets, changing the filter also moves a focuser by the difference between the offsets of the two
+// filters, so filters that are not parfocal stay in focus.
+type FilterWheel struct {
+	client *indiclient.INDIClient
+	name   string
+
+	focuser *Focuser
+	offsets map[string]int
+}
+
+// NewFilterWheel returns a FilterWheel that controls the device with the given name.
+func NewFilterWheel(client *indiclient.INDIClient, name string) *FilterWheel {
+	return &FilterWheel{
+		client: client,
+		name:   name,
+	}
+}
+
+// Name returns the name of the device.
+func (w *FilterWheel) Name() string {
+	return w.name
+}
+
+// SetFocusOffsets links a focuser to the filter wheel. offsets maps filter names to focuser positions relative to
+// any one of them; a filter that is not in offsets has an offset of 0. A nil focuser unlinks it. This should be called
+// before changing filters.
+func (w *FilterWheel) SetFocusOffsets(focuser *Focuser, offsets map[string]int) {
+	w.focuser = focuser
+	w.offsets = map[string]int{}
+
+	for name, offset := range offsets {
+		w.offsets[normalizeFilter(name)] = offset
+	}
+}
+
+// Filters returns the names of the filters, in slot order. Slots are usually numbered from 1 without gaps, so the
+// filter in slot n is at index n-1, but nothing stops a driver from skipping numbers.
+func (w *FilterWheel) Filters() ([]string, error) {
+	slots, names, err := w.filterSlots()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		filters = append(filters, names[slot])
+	}
+
+	return filters, nil
+}
+
+// filterSlots returns the slots FILTER_NAME has a name for, in order, taken from the numbers at the end of the element
+// names, along with the name of the filter in each.
+func (w *FilterWheel) filterSlots() ([]int, map[int]string, error) {
+	prop, err := w.client.TextProperty(w.name, propFilterName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slots := make([]int, 0, len(prop.Values))
+	names := map[int]string{}
+
+	for element, v := range prop.Values {
+		if !strings.HasPrefix(element, elemFilterNamePrefix) {
+			continue
+		}
+
+		slot, err := strconv.Atoi(strings.TrimPrefix(element, elemFilterNamePrefix))
+		if err != nil {
+			continue
+		}
+
+		slots = append(slots, slot)
+		names[slot] = strings.TrimSpace(v.Value)
+	}
+
+	sort.Ints(slots)
+
+	return slots, names, nil
+}
+
+// Slot returns the slot the filter wheel is at.
+func (w *FilterWheel) Slot() (int, error) {
+	slot, err := w.client.NumberValueFloat(w.name, propFilterSlot, elemFilterSlot)
+	return int(slot), err
+}
+
+// Filter returns the name of the filter the filter wheel is at.
+func (w *FilterWheel) Filter() (string, error) {
+	slot, err := w.Slot()
+	if err != nil {
+		return "", err
+	}
+
+	prop, err := w.client.TextProperty(w.name, propFilterName)
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := prop.Values[elemFilterNamePrefix+strconv.Itoa(slot)]
+	if !ok {
+		return "", indiclient.ErrPropertyValueNotFound
+	}
+
+	return strings.TrimSpace(v.Value), nil
+}
+
+// SetFilter moves the filter wheel to the slot of the filter with the given name, ignoring case, and waits for it to
+// get there. It returns ErrUnknownFilter if there is no such filter.
+func (w *FilterWheel) SetFilter(ctx context.Context, name string) error {
+	slots, names, err := w.filterSlots()
+	if err != nil {
+		return err
+	}
+
+	for _, slot := range slots {
+		if normalizeFilter(names[slot]) == normalizeFilter(name) {
+			return w.SetSlot(ctx, slot)
+		}
+	}
+
+	return ErrUnknownFilter
+}
+
+// SetSlot moves the filter wheel to a slot, and waits for it to get there. If a focuser is linked, it is then moved by
+// the difference between the focus offsets of the old and new filters.
+func (w *FilterWheel) SetSlot(ctx context.Context, slot int) error {
+	if w.focuser == nil {
+		return w.client.SetNumberValueAndWait(ctx, w.name, propFilterSlot, elemFilterSlot, strconv.Itoa(slot))
+	}
+
+	// A filter without a name, or a wheel at an unknown slot, has no offset.
+	from, _ := w.Filter()
+
+	err := w.client.SetNumberValueAndWait(ctx, w.name, propFilterSlot, elemFilterSlot, strconv.Itoa(slot))
+	if err != nil {
+		return err
+	}
+
+	to, _ := w.Filter()
+
+	steps := w.offsets[normalizeFilter(to)] - w.offsets[normalizeFilter(from)]
+	if steps == 0 {
+		return nil
+	}
+
+	return w.focuser.MoveBy(ctx, steps)
+}
+
+func normalizeFilter(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,154 @@
+package device_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/device"
+	"github.com/goastro/indiclient/inditest"
+)
+
+const wheel = "Filter Simulator"
+
+func wheelProperties() []interface{} {
+	return []interface{}{
+		numbers(wheel, "FILTER_SLOT", "FILTER_SLOT_VALUE=1"),
+		texts(wheel, "FILTER_NAME",
+			"FILTER_SLOT_NAME_1=L", "FILTER_SLOT_NAME_2=R", "FILTER_SLOT_NAME_3=G", "FILTER_SLOT_NAME_4=B",
+			"FILTER_SLOT_NAME_5=Ha", "FILTER_SLOT_NAME_6=OIII", "FILTER_SLOT_NAME_7=SII", "FILTER_SLOT_NAME_8=Dark",
+			"FILTER_SLOT_NAME_9=Empty", "FILTER_SLOT_NAME_10= "),
+	}
+}
+
+func Test_FilterWheel(t *testing.T) {
+	s, c := connect(t, wheelProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	// The wheel takes a while to turn.
+	s.Handle(wheel, "FILTER_SLOT", func(cmd inditest.Command) []inditest.Step {
+		return []inditest.Step{
+			{State: indiclient.PropertyStateBusy},
+			{Delay: 20 * time.Millisecond, State: indiclient.PropertyStateOk, Values: cmd.Values},
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	w := device.NewFilterWheel(c, wheel)
+	assert.Equal(t, wheel, w.Name())
+
+	filters, err := w.Filters()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"L", "R", "G", "B", "Ha", "OIII", "SII", "Dark", "Empty", ""}, filters)
+
+	filter, err := w.Filter()
+	require.NoError(t, err)
+	assert.Equal(t, "L", filter)
+
+	require.NoError(t, w.SetFilter(ctx, "ha"))
+
+	slot, err := w.Slot()
+	require.NoError(t, err)
+	assert.Equal(t, 5, slot)
+
+	require.NoError(t, w.SetSlot(ctx, 10))
+
+	filter, err = w.Filter()
+	require.NoError(t, err)
+	assert.Equal(t, "", filter)
+
+	assert.Equal(t, device.ErrUnknownFilter, w.SetFilter(ctx, "Hb"))
+
+	slot, err = w.Slot()
+	require.NoError(t, err)
+	assert.Equal(t, 10, slot)
+}
+
+func Test_FilterWheel_SlotGaps(t *testing.T) {
+	// Slots named from 0, with 2 missing.
+	s, c := connect(t,
+		numbers(wheel, "FILTER_SLOT", "FILTER_SLOT_VALUE=0"),
+		texts(wheel, "FILTER_NAME", "FILTER_SLOT_NAME_0=L", "FILTER_SLOT_NAME_1=R", "FILTER_SLOT_NAME_3=Ha"))
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	w := device.NewFilterWheel(c, wheel)
+
+	filters, err := w.Filters()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"L", "R", "Ha"}, filters)
+
+	tests := []struct {
+		filter string
+		slot   int
+	}{
+		{"Ha", 3},
+		{"L", 0},
+		{"R", 1},
+	}
+
+	for _, tt := range tests {
+		require.NoError(t, w.SetFilter(ctx, tt.filter))
+
+		slot, err := w.Slot()
+		require.NoError(t, err)
+		assert.Equal(t, tt.slot, slot)
+
+		filter, err := w.Filter()
+		require.NoError(t, err)
+		assert.Equal(t, tt.filter, filter)
+	}
+}
+
+func Test_FilterWheel_FocusOffsets(t *testing.T) {
+	s, c := connect(t, append(wheelProperties(), focuserProperties()...)...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	w := device.NewFilterWheel(c, wheel)
+	f := device.NewFocuser(c, focuser)
+	w.SetFocusOffsets(f, map[string]int{"R": 10, "G": 20, "B": 30, "HA": 150})
+
+	tests := []struct {
+		filter   string
+		expected int
+	}{
+		{"Ha", 5150},
+		{"B", 5030},
+		{"L", 5000},
+		{"OIII", 5000},
+		{"R", 5010},
+		{"R", 5010},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			require.NoError(t, w.SetFilter(ctx, tt.filter))
+
+			pos, err := f.Position()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, pos)
+		})
+	}
+
+	// Unlinking the focuser leaves it where it is.
+	w.SetFocusOffsets(nil, nil)
+	require.NoError(t, w.SetFilter(ctx, "Ha"))
+
+	pos, err := f.Position()
+	require.NoError(t, err)
+	assert.Equal(t, 5010, pos)
+}
@@ -0,0 +1,195 @@
+package device
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/goastro/indiclient"
+)
+
+const (
+	propAbsFocus     = "ABS_FOCUS_POSITION"
+	propRelFocus     = "REL_FOCUS_POSITION"
+	propFocusMotion  = "FOCUS_MOTION"
+	propFocusTemp    = "FOCUS_TEMPERATURE"
+	propFocusAbort   = "FOCUS_ABORT_MOTION"
+	elemAbsFocus     = "FOCUS_ABSOLUTE_POSITION"
+	elemRelFocus     = "FOCUS_RELATIVE_POSITION"
+	elemFocusTemp    = "TEMPERATURE"
+	elemFocusAbort   = "ABORT"
+	elemFocusInward  = "FOCUS_INWARD"
+	elemFocusOutward = "FOCUS_OUTWARD"
+)
+
+// FocusDirection is the direction a focuser moves in.
+type FocusDirection string
+
+const (
+	// FocusInward moves the focuser in, towards lower positions.
+	FocusInward = FocusDirection(elemFocusInward)
+
+	// FocusOutward moves the focuser out, towards higher positions.
+	FocusOutward = FocusDirection(elemFocusOutward)
+)
+
+// Focuser controls a focuser through the standard INDI focuser properties.
+//
+// With SetBacklash, every move finishes in the same direction, so the slack in the gears is always taken up the same
+// way and a position always means the same focus. A move in the other direction overshoots the target by the backlash,
+// then comes back to it.
+type Focuser struct {
+	client *indiclient.INDIClient
+	name   string
+
+	backlash int
+	approach FocusDirection
+}
+
+// NewFocuser returns a Focuser that controls the device with the given name.
+func NewFocuser(client *indiclient.INDIClient, name string) *Focuser {
+	return &Focuser{
+		client: client,
+		name:   name,
+	}
+}
+
+// Name returns the name of the device.
+func (f *Focuser) Name() string {
+	return f.name
+}
+
+// SetBacklash turns on backlash compensation, overshooting moves against approach by steps, so every move finishes
+// moving in the approach direction. A steps of 0 turns it off. This should be called before moving the focuser.
+func (f *Focuser) SetBacklash(steps int, approach FocusDirection) {
+	f.backlash = steps
+	f.approach = approach
+}
+
+// Position returns the absolute position of the focuser.
+func (f *Focuser) Position() (int, error) {
+	pos, err := f.client.NumberValueFloat(f.name, propAbsFocus, elemAbsFocus)
+	return int(pos), err
+}
+
+// Limits returns the lowest and highest absolute positions of the focuser, from the Min and Max of its definition. A
+// Max of 0 means the driver gave no limit.
+func (f *Focuser) Limits() (int, int, error) {
+	abs, err := defNumber(f.client, f.name, propAbsFocus, elemAbsFocus)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(abs.min), int(abs.max), nil
+}
+
+// Temperature returns the temperature the focuser measures, in degrees Celsius.
+func (f *Focuser) Temperature() (float64, error) {
+	return f.client.NumberValueFloat(f.name, propFocusTemp, elemFocusTemp)
+}
+
+// MoveTo moves the focuser to an absolute position, and waits for it to get there. A position beyond the limits of
+// the focuser is brought within them. If the client checks steps, a position that is not a whole number of Steps of
+// ABS_FOCUS_POSITION from its Min is refused with a *indiclient.NumberRangeError before the focuser moves, and a
+// backlash overshoot goes to the first Step beyond the position.
+func (f *Focuser) MoveTo(ctx context.Context, pos int) error {
+	abs, err := defNumber(f.client, f.name, propAbsFocus, elemAbsFocus)
+	if err != nil {
+		return err
+	}
+
+	target := int(abs.clamp(float64(pos)))
+
+	err = abs.check(float64(target))
+	if err != nil {
+		return err
+	}
+
+	current, err := f.Position()
+	if err != nil {
+		return err
+	}
+
+	if f.backlash > 0 {
+		overshoot := target
+
+		switch {
+		case f.approach == FocusOutward && target < current:
+			overshoot = int(abs.fit(float64(target-f.backlash), math.Floor))
+		case f.approach == FocusInward && target > current:
+			overshoot = int(abs.fit(float64(target+f.backlash), math.Ceil))
+		}
+
+		if overshoot != target {
+			err = f.moveAbs(ctx, overshoot)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return f.moveAbs(ctx, target)
+}
+
+// MoveBy moves the focuser by steps, outward if it is positive and inward if it is negative, and waits for it to get
+// there. Focusers with an absolute position are moved with MoveTo, and the rest with REL_FOCUS_POSITION. If the client
+// checks steps, a relative move that is not a whole number of Steps of REL_FOCUS_POSITION is refused with a
+// *indiclient.NumberRangeError, and backlash is made up to a whole number of Steps.
+func (f *Focuser) MoveBy(ctx context.Context, steps int) error {
+	if current, err := f.Position(); err == nil {
+		return f.MoveTo(ctx, current+steps)
+	}
+
+	rel, err := defNumber(f.client, f.name, propRelFocus, elemRelFocus)
+	if err != nil {
+		return err
+	}
+
+	dir := FocusOutward
+	if steps < 0 {
+		dir = FocusInward
+		steps = -steps
+	}
+
+	steps = int(rel.clamp(float64(steps)))
+
+	err = rel.check(float64(steps))
+	if err != nil {
+		return err
+	}
+
+	if steps == 0 {
+		return nil
+	}
+
+	if f.backlash > 0 && dir != f.approach {
+		back := int(rel.fit(float64(f.backlash), math.Ceil))
+
+		err := f.moveRel(ctx, dir, int(rel.fit(float64(steps+back), math.Round)))
+		if err != nil {
+			return err
+		}
+
+		return f.moveRel(ctx, f.approach, back)
+	}
+
+	return f.moveRel(ctx, dir, steps)
+}
+
+// Abort stops the focuser.
+func (f *Focuser) Abort(ctx context.Context) error {
+	return f.client.SetSwitchValueAndWait(ctx, f.name, propFocusAbort, elemFocusAbort, indiclient.SwitchStateOn)
+}
+
+func (f *Focuser) moveAbs(ctx context.Context, pos int) error {
+	return f.client.SetNumberValueAndWait(ctx, f.name, propAbsFocus, elemAbsFocus, strconv.Itoa(pos))
+}
+
+func (f *Focuser) moveRel(ctx context.Context, dir FocusDirection, steps int) error {
+	err := f.client.SetSwitchValueAndWait(ctx, f.name, propFocusMotion, string(dir), indiclient.SwitchStateOn)
+	if err != nil {
+		return err
+	}
+
+	return f.client.SetNumberValueAndWait(ctx, f.name, propRelFocus, elemRelFocus, strconv.Itoa(steps))
+}
@@ -0,0 +1,258 @@
+package device_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/device"
+	"github.com/goastro/indiclient/inditest"
+)
+
+const focuser = "Focuser Simulator"
+
+func focuserProperties() []interface{} {
+	abs := numbers(focuser, "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION=5000")
+	abs.Numbers[0].Min = "0"
+	abs.Numbers[0].Max = "10000"
+
+	temp := numbers(focuser, "FOCUS_TEMPERATURE", "TEMPERATURE=12.5")
+	temp.Perm = indiclient.PropertyPermissionReadOnly
+
+	return []interface{}{
+		abs,
+		numbers(focuser, "REL_FOCUS_POSITION", "FOCUS_RELATIVE_POSITION=0"),
+		switches(focuser, "FOCUS_MOTION", indiclient.SwitchRuleOneOfMany, "FOCUS_INWARD", "FOCUS_OUTWARD"),
+		switches(focuser, "FOCUS_ABORT_MOTION", indiclient.SwitchRuleAtMostOne, "ABORT"),
+		temp,
+	}
+}
+
+// moves returns the commands the server received for a focuser, as "PROPERTY=value" for numbers and the name of the
+// switch turned on for FOCUS_MOTION.
+func moves(s *inditest.Server) []string {
+	var out []string
+
+	for _, cmd := range s.Received() {
+		if cmd.Device != focuser {
+			continue
+		}
+
+		for name, value := range cmd.Values {
+			switch cmd.Name {
+			case "FOCUS_MOTION":
+				if value == string(indiclient.SwitchStateOn) {
+					out = append(out, name)
+				}
+			default:
+				out = append(out, cmd.Name+"="+value)
+			}
+		}
+	}
+
+	return out
+}
+
+func Test_Focuser_MoveTo(t *testing.T) {
+	s, c := connect(t, focuserProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	// The focuser takes a while to get there.
+	s.Handle(focuser, "ABS_FOCUS_POSITION", func(cmd inditest.Command) []inditest.Step {
+		return []inditest.Step{
+			{State: indiclient.PropertyStateBusy},
+			{Delay: 20 * time.Millisecond, State: indiclient.PropertyStateOk, Values: cmd.Values},
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	f := device.NewFocuser(c, focuser)
+	assert.Equal(t, focuser, f.Name())
+
+	min, max, err := f.Limits()
+	require.NoError(t, err)
+	assert.Equal(t, 0, min)
+	assert.Equal(t, 10000, max)
+
+	temp, err := f.Temperature()
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, temp)
+
+	tests := []struct {
+		name     string
+		fn       func() error
+		expected int
+	}{
+		{"MoveTo", func() error { return f.MoveTo(ctx, 4200) }, 4200},
+		{"MoveBy", func() error { return f.MoveBy(ctx, 300) }, 4500},
+		{"MoveBy Inward", func() error { return f.MoveBy(ctx, -1000) }, 3500},
+		{"Clamp Max", func() error { return f.MoveTo(ctx, 20000) }, 10000},
+		{"Clamp Min", func() error { return f.MoveBy(ctx, -20000) }, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.fn())
+
+			pos, err := f.Position()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, pos)
+		})
+	}
+
+	s.ClearReceived()
+	require.NoError(t, f.Abort(ctx))
+	assert.Equal(t, string(indiclient.SwitchStateOn), lastValues(t, s, focuser, "FOCUS_ABORT_MOTION")["ABORT"])
+}
+
+func Test_Focuser_Backlash(t *testing.T) {
+	s, c := connect(t, focuserProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	f := device.NewFocuser(c, focuser)
+	f.SetBacklash(100, device.FocusOutward)
+
+	tests := []struct {
+		name     string
+		target   int
+		expected []string
+	}{
+		{"Against", 4000, []string{"ABS_FOCUS_POSITION=3900", "ABS_FOCUS_POSITION=4000"}},
+		{"With", 4500, []string{"ABS_FOCUS_POSITION=4500"}},
+		{"Overshoot Clamped", 50, []string{"ABS_FOCUS_POSITION=0", "ABS_FOCUS_POSITION=50"}},
+		{"At Limit", 0, []string{"ABS_FOCUS_POSITION=0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.ClearReceived()
+
+			require.NoError(t, f.MoveTo(ctx, tt.target))
+			assert.Equal(t, tt.expected, moves(s))
+
+			pos, err := f.Position()
+			require.NoError(t, err)
+			assert.Equal(t, tt.target, pos)
+		})
+	}
+}
+
+func Test_Focuser_Relative(t *testing.T) {
+	// Without ABS_FOCUS_POSITION, moves are made with FOCUS_MOTION and REL_FOCUS_POSITION.
+	s, c := connect(t, focuserProperties()[1:]...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	f := device.NewFocuser(c, focuser)
+
+	_, err := f.Position()
+	assert.Error(t, err)
+
+	require.NoError(t, f.MoveBy(ctx, -30))
+	assert.Equal(t, []string{"FOCUS_INWARD", "REL_FOCUS_POSITION=30"}, moves(s))
+
+	f.SetBacklash(20, device.FocusInward)
+
+	tests := []struct {
+		name     string
+		steps    int
+		expected []string
+	}{
+		{"Against", 100, []string{"FOCUS_OUTWARD", "REL_FOCUS_POSITION=120", "FOCUS_INWARD", "REL_FOCUS_POSITION=20"}},
+		{"With", -50, []string{"FOCUS_INWARD", "REL_FOCUS_POSITION=50"}},
+		{"None", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.ClearReceived()
+
+			require.NoError(t, f.MoveBy(ctx, tt.steps))
+			assert.Equal(t, tt.expected, moves(s))
+		})
+	}
+}
+
+func Test_Focuser_LibindiSteps(t *testing.T) {
+	// As libindi defines them, with steps of 1000 the client checks, since both minimums are whole steps.
+	abs := limits(numbers(focuser, "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION=50000"), "0", "100000", "1000")
+	rel := limits(numbers(focuser, "REL_FOCUS_POSITION", "FOCUS_RELATIVE_POSITION=0"), "0", "50000", "1000")
+	motion := switches(focuser, "FOCUS_MOTION", indiclient.SwitchRuleOneOfMany, "FOCUS_INWARD", "FOCUS_OUTWARD")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	t.Run("Absolute", func(t *testing.T) {
+		s, c := connect(t, abs, rel, motion)
+		defer s.Close()
+		defer c.Disconnect()
+
+		f := device.NewFocuser(c, focuser)
+		f.SetBacklash(100, device.FocusOutward)
+
+		tests := []struct {
+			target   int
+			expected []string
+			position int
+		}{
+			{12000, []string{"ABS_FOCUS_POSITION=11000", "ABS_FOCUS_POSITION=12000"}, 12000},
+			{13000, []string{"ABS_FOCUS_POSITION=13000"}, 13000},
+			{1000, []string{"ABS_FOCUS_POSITION=0", "ABS_FOCUS_POSITION=1000"}, 1000},
+			{150000, []string{"ABS_FOCUS_POSITION=100000"}, 100000},
+		}
+
+		for _, tt := range tests {
+			s.ClearReceived()
+
+			require.NoError(t, f.MoveTo(ctx, tt.target))
+			assert.Equal(t, tt.expected, moves(s))
+
+			pos, err := f.Position()
+			require.NoError(t, err)
+			assert.Equal(t, tt.position, pos)
+		}
+
+		// A position between steps is refused rather than rounded to one the caller did not ask for.
+		s.ClearReceived()
+
+		var rangeErr *indiclient.NumberRangeError
+		require.True(t, errors.As(f.MoveTo(ctx, 51234), &rangeErr))
+		assert.Equal(t, indiclient.NumberBoundStep, rangeErr.Bound)
+		assert.Equal(t, 51234.0, rangeErr.Value)
+		assert.Empty(t, moves(s))
+	})
+
+	t.Run("Relative", func(t *testing.T) {
+		s, c := connect(t, rel, motion)
+		defer s.Close()
+		defer c.Disconnect()
+
+		f := device.NewFocuser(c, focuser)
+		f.SetBacklash(100, device.FocusOutward)
+
+		require.NoError(t, f.MoveBy(ctx, -2000))
+		assert.Equal(t, []string{"FOCUS_INWARD", "REL_FOCUS_POSITION=3000", "FOCUS_OUTWARD", "REL_FOCUS_POSITION=1000"}, moves(s))
+
+		s.ClearReceived()
+
+		var rangeErr *indiclient.NumberRangeError
+		require.True(t, errors.As(f.MoveBy(ctx, 400), &rangeErr))
+		assert.Equal(t, indiclient.NumberBoundStep, rangeErr.Bound)
+		assert.Empty(t, moves(s))
+	})
+}
@@ -0,0 +1,317 @@
+package device
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/goastro/indiclient"
+)
+
+const (
+	propEquatorialEOD = "EQUATORIAL_EOD_COORD"
+	propHorizontal    = "HORIZONTAL_COORD"
+	propOnCoordSet    = "ON_COORD_SET"
+	propAbortMotion   = "TELESCOPE_ABORT_MOTION"
+	propPark          = "TELESCOPE_PARK"
+	propTrackMode     = "TELESCOPE_TRACK_MODE"
+	propTrackState    = "TELESCOPE_TRACK_STATE"
+	propMotionNS      = "TELESCOPE_MOTION_NS"
+	propMotionWE      = "TELESCOPE_MOTION_WE"
+	propSlewRate      = "TELESCOPE_SLEW_RATE"
+	propPierSide      = "TELESCOPE_PIER_SIDE"
+	propGeographic    = "GEOGRAPHIC_COORD"
+)
+
+// stopTimeout is how long Move waits for the mount to stop once its context is done, so a device that never answers
+// cannot keep Move from returning.
+const stopTimeout = 5 * time.Second
+
+// Equatorial is a position in equatorial coordinates of the current epoch, with RA in hours and Dec in degrees.
+type Equatorial struct {
+	RA  float64
+	Dec float64
+}
+
+// Horizontal is a position in horizontal coordinates, with Alt and Az in degrees.
+type Horizontal struct {
+	Alt float64
+	Az  float64
+}
+
+// Location is where a mount is on the Earth, with Lat and Long in degrees, Long east of Greenwich, and Elev in meters.
+type Location struct {
+	Lat  float64
+	Long float64
+	Elev float64
+}
+
+// TrackRate is the rate a mount tracks at.
+type TrackRate string
+
+const (
+	// TrackSidereal tracks the stars.
+	TrackSidereal = TrackRate("TRACK_SIDEREAL")
+
+	// TrackSolar tracks the sun.
+	TrackSolar = TrackRate("TRACK_SOLAR")
+
+	// TrackLunar tracks the moon.
+	TrackLunar = TrackRate("TRACK_LUNAR")
+
+	// TrackCustom tracks at the rate set in TELESCOPE_TRACK_RATE.
+	TrackCustom = TrackRate("TRACK_CUSTOM")
+)
+
+// Direction is a direction a mount can be moved in by hand.
+type Direction string
+
+const (
+	// MotionNorth moves the mount north, in Dec.
+	MotionNorth = Direction("MOTION_NORTH")
+
+	// MotionSouth moves the mount south, in Dec.
+	MotionSouth = Direction("MOTION_SOUTH")
+
+	// MotionWest moves the mount west, in RA.
+	MotionWest = Direction("MOTION_WEST")
+
+	// MotionEast moves the mount east, in RA.
+	MotionEast = Direction("MOTION_EAST")
+)
+
+// property returns the motion property d belongs to.
+func (d Direction) property() string {
+	if d == MotionNorth || d == MotionSouth {
+		return propMotionNS
+	}
+
+	return propMotionWE
+}
+
+// PierSide is the side of the pier a German equatorial mount is on.
+type PierSide string
+
+const (
+	// PierEast is the east side of the pier, pointing west.
+	PierEast = PierSide("PIER_EAST")
+
+	// PierWest is the west side of the pier, pointing east.
+	PierWest = PierSide("PIER_WEST")
+)
+
+// Telescope controls a mount through the standard INDI telescope properties.
+type Telescope struct {
+	client *indiclient.INDIClient
+	name   string
+}
+
+// NewTelescope returns a Telescope that controls the device with the given name.
+func NewTelescope(client *indiclient.INDIClient, name string) *Telescope {
+	return &Telescope{
+		client: client,
+		name:   name,
+	}
+}
+
+// Name returns the name of the device.
+func (t *Telescope) Name() string {
+	return t.name
+}
+
+// Coordinates returns where the mount is pointing, from EQUATORIAL_EOD_COORD.
+func (t *Telescope) Coordinates() (Equatorial, error) {
+	ra, err := t.client.NumberValueFloat(t.name, propEquatorialEOD, "RA")
+	if err != nil {
+		return Equatorial{}, err
+	}
+
+	dec, err := t.client.NumberValueFloat(t.name, propEquatorialEOD, "DEC")
+	if err != nil {
+		return Equatorial{}, err
+	}
+
+	return Equatorial{RA: ra, Dec: dec}, nil
+}
+
+// HorizontalCoordinates returns where the mount is pointing, from HORIZONTAL_COORD. Not every mount has it.
+func (t *Telescope) HorizontalCoordinates() (Horizontal, error) {
+	alt, err := t.client.NumberValueFloat(t.name, propHorizontal, "ALT")
+	if err != nil {
+		return Horizontal{}, err
+	}
+
+	az, err := t.client.NumberValueFloat(t.name, propHorizontal, "AZ")
+	if err != nil {
+		return Horizontal{}, err
+	}
+
+	return Horizontal{Alt: alt, Az: az}, nil
+}
+
+// Location returns where the mount is, from GEOGRAPHIC_COORD.
+func (t *Telescope) Location() (Location, error) {
+	prop, err := t.client.NumberProperty(t.name, propGeographic)
+	if err != nil {
+		return Location{}, err
+	}
+
+	var loc Location
+
+	for name, dst := range map[string]*float64{"LAT": &loc.Lat, "LONG": &loc.Long, "ELEV": &loc.Elev} {
+		v, ok := prop.Values[name]
+		if !ok {
+			return Location{}, indiclient.ErrPropertyValueNotFound
+		}
+
+		*dst, err = indiclient.ParseNumber(v.Value)
+		if err != nil {
+			return Location{}, err
+		}
+	}
+
+	return loc, nil
+}
+
+// Slew slews the mount to target and stops it there.
+func (t *Telescope) Slew(ctx context.Context, target Equatorial) error {
+	return t.goTo(ctx, "SLEW", target)
+}
+
+// Track slews the mount to target and tracks it.
+func (t *Telescope) Track(ctx context.Context, target Equatorial) error {
+	return t.goTo(ctx, "TRACK", target)
+}
+
+// Sync tells the mount it is pointing at target, without moving it.
+func (t *Telescope) Sync(ctx context.Context, target Equatorial) error {
+	return t.goTo(ctx, "SYNC", target)
+}
+
+// goTo sets what the mount does with new coordinates, then sends it target and waits for it to get there.
+func (t *Telescope) goTo(ctx context.Context, mode string, target Equatorial) error {
+	err := t.client.SetSwitchValueAndWait(ctx, t.name, propOnCoordSet, mode, indiclient.SwitchStateOn)
+	if err != nil {
+		return err
+	}
+
+	return t.client.SetNumberValuesAndWait(ctx, t.name, propEquatorialEOD, map[string]string{
+		"RA":  formatFloat(target.RA),
+		"DEC": formatFloat(target.Dec),
+	})
+}
+
+// Abort stops whatever the mount is doing.
+func (t *Telescope) Abort(ctx context.Context) error {
+	return t.client.SetSwitchValueAndWait(ctx, t.name, propAbortMotion, "ABORT", indiclient.SwitchStateOn)
+}
+
+// Park moves the mount to its park position and parks it.
+func (t *Telescope) Park(ctx context.Context) error {
+	return t.client.SetSwitchValueAndWait(ctx, t.name, propPark, "PARK", indiclient.SwitchStateOn)
+}
+
+// Unpark unparks the mount.
+func (t *Telescope) Unpark(ctx context.Context) error {
+	return t.client.SetSwitchValueAndWait(ctx, t.name, propPark, "UNPARK", indiclient.SwitchStateOn)
+}
+
+// Parked returns true if the mount is parked.
+func (t *Telescope) Parked() (bool, error) {
+	return switchOn(t.client, t.name, propPark, "PARK")
+}
+
+// TrackRate returns the rate the mount tracks at.
+func (t *Telescope) TrackRate() (TrackRate, error) {
+	rate, err := t.client.ActiveSwitch(t.name, propTrackMode)
+	return TrackRate(rate), err
+}
+
+// SetTrackRate sets the rate the mount tracks at.
+func (t *Telescope) SetTrackRate(ctx context.Context, rate TrackRate) error {
+	return t.client.SetSwitchValueAndWait(ctx, t.name, propTrackMode, string(rate), indiclient.SwitchStateOn)
+}
+
+// Tracking returns true if the mount is tracking.
+func (t *Telescope) Tracking() (bool, error) {
+	return switchOn(t.client, t.name, propTrackState, "TRACK_ON")
+}
+
+// SetTracking starts or stops tracking.
+func (t *Telescope) SetTracking(ctx context.Context, on bool) error {
+	name := "TRACK_OFF"
+	if on {
+		name = "TRACK_ON"
+	}
+
+	return t.client.SetSwitchValueAndWait(ctx, t.name, propTrackState, name, indiclient.SwitchStateOn)
+}
+
+// SlewRates returns the names of the rates the mount can be moved by hand at, in alphabetical order. They differ
+// between drivers, for example SLEW_GUIDE and SLEW_MAX, or 1x and 16x.
+func (t *Telescope) SlewRates() ([]string, error) {
+	prop, err := t.client.SwitchProperty(t.name, propSlewRate)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make([]string, 0, len(prop.Values))
+	for name := range prop.Values {
+		rates = append(rates, name)
+	}
+
+	sort.Strings(rates)
+
+	return rates, nil
+}
+
+// SlewRate returns the name of the rate the mount is moved by hand at.
+func (t *Telescope) SlewRate() (string, error) {
+	return t.client.ActiveSwitch(t.name, propSlewRate)
+}
+
+// SetSlewRate sets the rate the mount is moved by hand at to one of SlewRates.
+func (t *Telescope) SetSlewRate(ctx context.Context, rate string) error {
+	return t.client.SetSwitchValueAndWait(ctx, t.name, propSlewRate, rate, indiclient.SwitchStateOn)
+}
+
+// StartMotion starts moving the mount in a direction, at the slew rate, until StopMotion is called. It returns as
+// soon as the command is sent, since the device keeps the motion property Busy for as long as the mount moves.
+func (t *Telescope) StartMotion(dir Direction) error {
+	return t.client.SetSwitchValue(t.name, dir.property(), string(dir), indiclient.SwitchStateOn)
+}
+
+// StopMotion stops moving the mount in a direction.
+func (t *Telescope) StopMotion(ctx context.Context, dir Direction) error {
+	return t.client.SetSwitchValueAndWait(ctx, t.name, dir.property(), string(dir), indiclient.SwitchStateOff)
+}
+
+// Move moves the mount in a direction, at the slew rate, for d. The mount is stopped even if ctx is done first, in
+// which case Move waits a few seconds at most for it to stop.
+func (t *Telescope) Move(ctx context.Context, dir Direction, d time.Duration) error {
+	err := t.StartMotion(dir)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return t.StopMotion(ctx, dir)
+	case <-ctx.Done():
+		// Leaving the mount moving could run it into the pier, so stop it, even though ctx is done.
+		stopCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+
+		t.StopMotion(stopCtx, dir)
+		return ctx.Err()
+	}
+}
+
+// PierSide returns the side of the pier the mount is on.
+func (t *Telescope) PierSide() (PierSide, error) {
+	side, err := t.client.ActiveSwitch(t.name, propPierSide)
+	return PierSide(side), err
+}
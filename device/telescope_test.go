@@ -0,0 +1,224 @@
+package device_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/device"
+	"github.com/goastro/indiclient/inditest"
+)
+
+const mount = "Telescope Simulator"
+
+func mountProperties() []interface{} {
+	pier := switches(mount, "TELESCOPE_PIER_SIDE", indiclient.SwitchRuleOneOfMany, "PIER_WEST", "PIER_EAST")
+	pier.Perm = indiclient.PropertyPermissionReadOnly
+
+	return []interface{}{
+		numbers(mount, "EQUATORIAL_EOD_COORD", "RA=0", "DEC=90"),
+		numbers(mount, "HORIZONTAL_COORD", "ALT=45", "AZ=180"),
+		switches(mount, "ON_COORD_SET", indiclient.SwitchRuleOneOfMany, "TRACK", "SLEW", "SYNC"),
+		switches(mount, "TELESCOPE_ABORT_MOTION", indiclient.SwitchRuleAtMostOne, "ABORT"),
+		switches(mount, "TELESCOPE_PARK", indiclient.SwitchRuleOneOfMany, "PARK", "UNPARK"),
+		switches(mount, "TELESCOPE_TRACK_MODE", indiclient.SwitchRuleOneOfMany, "TRACK_SIDEREAL", "TRACK_SOLAR", "TRACK_LUNAR", "TRACK_CUSTOM"),
+		switches(mount, "TELESCOPE_TRACK_STATE", indiclient.SwitchRuleOneOfMany, "TRACK_OFF", "TRACK_ON"),
+		switches(mount, "TELESCOPE_MOTION_NS", indiclient.SwitchRuleAtMostOne, "MOTION_NORTH", "MOTION_SOUTH"),
+		switches(mount, "TELESCOPE_MOTION_WE", indiclient.SwitchRuleAtMostOne, "MOTION_WEST", "MOTION_EAST"),
+		switches(mount, "TELESCOPE_SLEW_RATE", indiclient.SwitchRuleOneOfMany, "SLEW_GUIDE", "SLEW_CENTERING", "SLEW_FIND", "SLEW_MAX"),
+		pier,
+	}
+}
+
+func Test_Telescope_GoTo(t *testing.T) {
+	s, c := connect(t, mountProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	// The mount takes a while to get there.
+	s.Script(mount, "EQUATORIAL_EOD_COORD",
+		inditest.Step{State: indiclient.PropertyStateBusy, Values: map[string]string{"RA": "3", "DEC": "45"}},
+		inditest.Step{Delay: 50 * time.Millisecond, State: indiclient.PropertyStateOk},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	tel := device.NewTelescope(c, mount)
+	assert.Equal(t, mount, tel.Name())
+
+	target := device.Equatorial{RA: 5.5881, Dec: -5.3911}
+
+	tests := []struct {
+		name string
+		fn   func(context.Context, device.Equatorial) error
+		mode string
+	}{
+		{name: "Slew", fn: tel.Slew, mode: "SLEW"},
+		{name: "Track", fn: tel.Track, mode: "TRACK"},
+		{name: "Sync", fn: tel.Sync, mode: "SYNC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.fn(ctx, target))
+
+			assert.Equal(t, string(indiclient.SwitchStateOn), lastValues(t, s, mount, "ON_COORD_SET")[tt.mode])
+			assert.Equal(t, map[string]string{"RA": "5.5881", "DEC": "-5.3911"}, lastValues(t, s, mount, "EQUATORIAL_EOD_COORD"))
+
+			coords, err := tel.Coordinates()
+			require.NoError(t, err)
+			assert.Equal(t, device.Equatorial{RA: 5.5881, Dec: -5.3911}, coords)
+		})
+	}
+
+	horizontal, err := tel.HorizontalCoordinates()
+	require.NoError(t, err)
+	assert.Equal(t, device.Horizontal{Alt: 45, Az: 180}, horizontal)
+
+	s.Script(mount, "EQUATORIAL_EOD_COORD",
+		inditest.Step{State: indiclient.PropertyStateBusy},
+		inditest.Step{Delay: 10 * time.Millisecond, State: indiclient.PropertyStateAlert, Message: "below horizon"},
+	)
+
+	err = tel.Slew(ctx, device.Equatorial{RA: 1, Dec: -89})
+	require.Error(t, err)
+
+	alert, ok := err.(*indiclient.PropertyAlertError)
+	require.True(t, ok)
+	assert.Equal(t, "below horizon", alert.Message)
+}
+
+func Test_Telescope_GoTo_StaleUpdate(t *testing.T) {
+	s, c := connect(t, mountProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	// A tracking update of where the mount was is already on its way when the slew starts.
+	s.Script(mount, "EQUATORIAL_EOD_COORD",
+		inditest.Step{State: indiclient.PropertyStateOk, Values: map[string]string{"RA": "0", "DEC": "90"}},
+		inditest.Step{State: indiclient.PropertyStateBusy, Values: map[string]string{"RA": "3", "DEC": "45"}},
+		inditest.Step{Delay: 100 * time.Millisecond, State: indiclient.PropertyStateOk},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	tel := device.NewTelescope(c, mount)
+
+	start := time.Now()
+	require.NoError(t, tel.Slew(ctx, device.Equatorial{RA: 5.5881, Dec: -5.3911}))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	coords, err := tel.Coordinates()
+	require.NoError(t, err)
+	assert.Equal(t, device.Equatorial{RA: 5.5881, Dec: -5.3911}, coords)
+}
+
+func Test_Telescope_State(t *testing.T) {
+	s, c := connect(t, mountProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	tel := device.NewTelescope(c, mount)
+
+	parked, err := tel.Parked()
+	require.NoError(t, err)
+	assert.True(t, parked)
+
+	require.NoError(t, tel.Unpark(ctx))
+
+	parked, err = tel.Parked()
+	require.NoError(t, err)
+	assert.False(t, parked)
+
+	require.NoError(t, tel.Park(ctx))
+	assert.Equal(t, string(indiclient.SwitchStateOn), lastValues(t, s, mount, "TELESCOPE_PARK")["PARK"])
+
+	rate, err := tel.TrackRate()
+	require.NoError(t, err)
+	assert.Equal(t, device.TrackSidereal, rate)
+
+	require.NoError(t, tel.SetTrackRate(ctx, device.TrackLunar))
+
+	rate, err = tel.TrackRate()
+	require.NoError(t, err)
+	assert.Equal(t, device.TrackLunar, rate)
+
+	tracking, err := tel.Tracking()
+	require.NoError(t, err)
+	assert.False(t, tracking)
+
+	require.NoError(t, tel.SetTracking(ctx, true))
+
+	tracking, err = tel.Tracking()
+	require.NoError(t, err)
+	assert.True(t, tracking)
+
+	require.NoError(t, tel.Abort(ctx))
+	assert.Equal(t, string(indiclient.SwitchStateOn), lastValues(t, s, mount, "TELESCOPE_ABORT_MOTION")["ABORT"])
+
+	side, err := tel.PierSide()
+	require.NoError(t, err)
+	assert.Equal(t, device.PierWest, side)
+}
+
+func Test_Telescope_Motion(t *testing.T) {
+	s, c := connect(t, mountProperties()...)
+	defer s.Close()
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	tel := device.NewTelescope(c, mount)
+
+	rates, err := tel.SlewRates()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SLEW_CENTERING", "SLEW_FIND", "SLEW_GUIDE", "SLEW_MAX"}, rates)
+
+	require.NoError(t, tel.SetSlewRate(ctx, "SLEW_FIND"))
+
+	rate, err := tel.SlewRate()
+	require.NoError(t, err)
+	assert.Equal(t, "SLEW_FIND", rate)
+
+	// The mount stays Busy while it moves.
+	s.Handle(mount, "TELESCOPE_MOTION_WE", func(cmd inditest.Command) []inditest.Step {
+		if cmd.Values["MOTION_EAST"] == string(indiclient.SwitchStateOn) {
+			return []inditest.Step{{State: indiclient.PropertyStateBusy}}
+		}
+
+		return []inditest.Step{{State: indiclient.PropertyStateIdle}}
+	})
+
+	start := time.Now()
+	require.NoError(t, tel.Move(ctx, device.MotionEast, 50*time.Millisecond))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	received := s.Received()
+
+	var sent []string
+	for _, cmd := range received {
+		if cmd.Name == "TELESCOPE_MOTION_WE" {
+			sent = append(sent, cmd.Values["MOTION_EAST"])
+		}
+	}
+
+	assert.Equal(t, []string{"On", "Off"}, sent)
+
+	// A move that is cut short still stops the mount.
+	short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelShort()
+
+	err = tel.Move(short, device.MotionNorth, time.Minute)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, string(indiclient.SwitchStateOff), lastValues(t, s, mount, "TELESCOPE_MOTION_NS")["MOTION_NORTH"])
+}
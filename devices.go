@@ -14,6 +14,9 @@ type Device struct {
 	BlobProperties   map[string]BlobProperty   `json:"blobProperties"`
 	LightProperties  map[string]LightProperty  `json:"lightProperties"`
 	Messages         []MessageJSON             `json:"messages"`
+
+	// Revision is the revision of the client's devices at which this device last changed. See INDIClient.Revision.
+	Revision uint64 `json:"revision"`
 }
 
 // MessageJSON is a message received from indiserver.
@@ -124,6 +127,13 @@ type BlobValue struct {
 	Label string `json:"label"`
 	Value string `json:"value"`
 	Size  int64  `json:"size"`
+
+	// Format is the format the BLOB was sent in, such as ".fits.z".
+	Format string `json:"format"`
+
+	// EffectiveFormat is the format of the file in Value, which is Format without the compression suffix when the client
+	// decompressed the BLOB, such as ".fits" for ".fits.z".
+	EffectiveFormat string `json:"effectiveFormat"`
 }
 
 // Groups retreives a list of all the groups for a device for display purposes. Groups are returned in alphabetical order.
@@ -160,3 +170,190 @@ func (d Device) Groups() []string {
 
 	return groups
 }
+
+// MaxMessages is the most messages kept for each device and property. The oldest are dropped as new ones arrive.
+const MaxMessages = 100
+
+// appendMessage returns messages with m added, keeping the last MaxMessages. It never writes to the array behind
+// messages, which earlier copies of the device or property may still share.
+func appendMessage(messages []MessageJSON, m MessageJSON) []MessageJSON {
+	if len(messages) >= MaxMessages {
+		messages = messages[len(messages)-MaxMessages+1:]
+	}
+
+	out := make([]MessageJSON, len(messages), len(messages)+1)
+	copy(out, messages)
+
+	return append(out, m)
+}
+
+func cloneMessages(messages []MessageJSON) []MessageJSON {
+	if messages == nil {
+		return nil
+	}
+
+	return append([]MessageJSON{}, messages...)
+}
+
+func (p TextProperty) clone() TextProperty {
+	values := make(map[string]TextValue, len(p.Values))
+	for k, v := range p.Values {
+		values[k] = v
+	}
+
+	p.Values = values
+	p.Messages = cloneMessages(p.Messages)
+
+	return p
+}
+
+func (p SwitchProperty) clone() SwitchProperty {
+	values := make(map[string]SwitchValue, len(p.Values))
+	for k, v := range p.Values {
+		values[k] = v
+	}
+
+	p.Values = values
+	p.Messages = cloneMessages(p.Messages)
+
+	return p
+}
+
+func (p NumberProperty) clone() NumberProperty {
+	values := make(map[string]NumberValue, len(p.Values))
+	for k, v := range p.Values {
+		values[k] = v
+	}
+
+	p.Values = values
+	p.Messages = cloneMessages(p.Messages)
+
+	return p
+}
+
+func (p LightProperty) clone() LightProperty {
+	values := make(map[string]LightValue, len(p.Values))
+	for k, v := range p.Values {
+		values[k] = v
+	}
+
+	p.Values = values
+	p.Messages = cloneMessages(p.Messages)
+
+	return p
+}
+
+func (p BlobProperty) clone() BlobProperty {
+	values := make(map[string]BlobValue, len(p.Values))
+	for k, v := range p.Values {
+		values[k] = v
+	}
+
+	p.Values = values
+	p.Messages = cloneMessages(p.Messages)
+
+	return p
+}
+
+// clone returns a deep copy of d, sharing nothing with it.
+func (d Device) clone() Device {
+	c := d.copyForWrite()
+	c.Messages = cloneMessages(c.Messages)
+
+	for k, p := range c.TextProperties {
+		c.TextProperties[k] = p.clone()
+	}
+
+	for k, p := range c.SwitchProperties {
+		c.SwitchProperties[k] = p.clone()
+	}
+
+	for k, p := range c.NumberProperties {
+		c.NumberProperties[k] = p.clone()
+	}
+
+	for k, p := range c.LightProperties {
+		c.LightProperties[k] = p.clone()
+	}
+
+	for k, p := range c.BlobProperties {
+		c.BlobProperties[k] = p.clone()
+	}
+
+	return c
+}
+
+// copyForWrite returns a copy of d with its own property maps, but sharing the properties themselves, and the messages,
+// which appendMessage never changes in place.
+func (d Device) copyForWrite() Device {
+	text := make(map[string]TextProperty, len(d.TextProperties))
+	for k, p := range d.TextProperties {
+		text[k] = p
+	}
+
+	switches := make(map[string]SwitchProperty, len(d.SwitchProperties))
+	for k, p := range d.SwitchProperties {
+		switches[k] = p
+	}
+
+	numbers := make(map[string]NumberProperty, len(d.NumberProperties))
+	for k, p := range d.NumberProperties {
+		numbers[k] = p
+	}
+
+	lights := make(map[string]LightProperty, len(d.LightProperties))
+	for k, p := range d.LightProperties {
+		lights[k] = p
+	}
+
+	blobs := make(map[string]BlobProperty, len(d.BlobProperties))
+	for k, p := range d.BlobProperties {
+		blobs[k] = p
+	}
+
+	d.TextProperties = text
+	d.SwitchProperties = switches
+	d.NumberProperties = numbers
+	d.LightProperties = lights
+	d.BlobProperties = blobs
+
+	return d
+}
+
+// propertyTimeout returns the timeout, in seconds, the device gave for the named property.
+func (d Device) propertyTimeout(name string) (int, error) {
+	if p, ok := d.TextProperties[name]; ok {
+		return p.Timeout, nil
+	}
+
+	if p, ok := d.NumberProperties[name]; ok {
+		return p.Timeout, nil
+	}
+
+	if p, ok := d.SwitchProperties[name]; ok {
+		return p.Timeout, nil
+	}
+
+	if p, ok := d.BlobProperties[name]; ok {
+		return p.Timeout, nil
+	}
+
+	if _, ok := d.LightProperties[name]; ok {
+		return 0, nil
+	}
+
+	return 0, ErrPropertyNotFound
+}
+
+// active returns the name of the first switch that is On, in alphabetical order, or an empty string if none are.
+func (p SwitchProperty) active() string {
+	active := ""
+
+	for name, v := range p.Values {
+		if v.Value == SwitchStateOn && (len(active) == 0 || name < active) {
+			active = name
+		}
+	}
+
+	return active
+}
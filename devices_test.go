@@ -1,6 +1,7 @@
 package indiclient
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,3 +34,20 @@ func Test_Groups(t *testing.T) {
 	require.NotNil(t, groups)
 	assert.Equal(t, expected, groups)
 }
+
+func Test_AppendMessage(t *testing.T) {
+	var messages []MessageJSON
+
+	for i := 0; i < MaxMessages+50; i++ {
+		messages = appendMessage(messages, MessageJSON{Message: strconv.Itoa(i)})
+	}
+
+	require.Len(t, messages, MaxMessages)
+	assert.Equal(t, "50", messages[0].Message)
+	assert.Equal(t, strconv.Itoa(MaxMessages+49), messages[MaxMessages-1].Message)
+
+	// Copies made before share the messages, so they must not change when more are added.
+	earlier := messages[:10]
+	appendMessage(earlier, MessageJSON{Message: "new"})
+	assert.Equal(t, "60", messages[10].Message)
+}
@@ -0,0 +1,176 @@
+package driver
+
+import (
+	"github.com/goastro/indiclient"
+)
+
+// property is implemented by each of the typed properties. Both methods are called with the driver's mutex held.
+type property interface {
+	propName() string
+	definition() interface{}
+}
+
+// Device is a device provided by a Driver. Create one with Driver.AddDevice.
+type Device struct {
+	driver *Driver
+	name   string
+	props  []property
+}
+
+// Name returns the name of the device.
+func (dev *Device) Name() string {
+	return dev.name
+}
+
+// DefineText adds a text property to the device, replacing any property with the same name, and sends its definition
+// if the driver is running. The Device of def is ignored. h is called when a client sends new values; if it is nil,
+// the new values are simply accepted.
+func (dev *Device) DefineText(def indiclient.DefTextVector, h TextHandler) *TextProperty {
+	def.Device = dev.name
+	def.Texts = append([]indiclient.DefText{}, def.Texts...)
+
+	p := &TextProperty{
+		device:  dev,
+		def:     def,
+		handler: h,
+	}
+
+	dev.define(p)
+
+	return p
+}
+
+// DefineNumber adds a number property to the device, replacing any property with the same name, and sends its
+// definition if the driver is running. The Device of def is ignored. h is called when a client sends new values; if it
+// is nil, the new values are simply accepted.
+func (dev *Device) DefineNumber(def indiclient.DefNumberVector, h NumberHandler) *NumberProperty {
+	def.Device = dev.name
+	def.Numbers = append([]indiclient.DefNumber{}, def.Numbers...)
+
+	p := &NumberProperty{
+		device:  dev,
+		def:     def,
+		handler: h,
+	}
+
+	dev.define(p)
+
+	return p
+}
+
+// DefineSwitch adds a switch property to the device, replacing any property with the same name, and sends its
+// definition if the driver is running. The Device of def is ignored. h is called when a client sends new values; if it
+// is nil, the new values are simply accepted.
+func (dev *Device) DefineSwitch(def indiclient.DefSwitchVector, h SwitchHandler) *SwitchProperty {
+	def.Device = dev.name
+	def.Switches = append([]indiclient.DefSwitch{}, def.Switches...)
+
+	p := &SwitchProperty{
+		device:  dev,
+		def:     def,
+		handler: h,
+	}
+
+	dev.define(p)
+
+	return p
+}
+
+// DefineLight adds a light property to the device, replacing any property with the same name, and sends its
+// definition if the driver is running. The Device of def is ignored. Lights are read only, so there is no handler.
+func (dev *Device) DefineLight(def indiclient.DefLightVector) *LightProperty {
+	def.Device = dev.name
+	def.Lights = append([]indiclient.DefLight{}, def.Lights...)
+
+	p := &LightProperty{
+		device: dev,
+		def:    def,
+	}
+
+	dev.define(p)
+
+	return p
+}
+
+// DefineBlob adds a BLOB property to the device, replacing any property with the same name, and sends its definition
+// if the driver is running. The Device of def is ignored. h is called when a client uploads BLOBs; if it is nil,
+// uploads are acknowledged and discarded.
+func (dev *Device) DefineBlob(def indiclient.DefBlobVector, h BlobHandler) *BlobProperty {
+	def.Device = dev.name
+	def.Blobs = append([]indiclient.DefBlob{}, def.Blobs...)
+
+	p := &BlobProperty{
+		device:  dev,
+		def:     def,
+		handler: h,
+	}
+
+	dev.define(p)
+
+	return p
+}
+
+func (dev *Device) define(p property) {
+	dev.driver.mu.Lock()
+	defer dev.driver.mu.Unlock()
+
+	replaced := false
+	for i, existing := range dev.props {
+		if existing.propName() == p.propName() {
+			dev.props[i] = p
+			replaced = true
+			break
+		}
+	}
+
+	if !replaced {
+		dev.props = append(dev.props, p)
+	}
+
+	dev.driver.send(p.definition())
+}
+
+// Delete removes a property from the device and tells clients about it. If name is empty, every property of the
+// device is removed.
+func (dev *Device) Delete(name string) {
+	dev.driver.mu.Lock()
+	defer dev.driver.mu.Unlock()
+
+	kept := dev.props[:0]
+	for _, p := range dev.props {
+		if len(name) > 0 && p.propName() != name {
+			kept = append(kept, p)
+		}
+	}
+
+	dev.props = kept
+
+	dev.driver.send(&indiclient.DelProperty{
+		Device:    dev.name,
+		Name:      name,
+		Timestamp: timestamp(),
+	})
+}
+
+// Message sends a message from the device to clients.
+func (dev *Device) Message(message string) {
+	dev.driver.mu.Lock()
+	defer dev.driver.mu.Unlock()
+
+	dev.driver.send(&indiclient.Message{
+		Device:    dev.name,
+		Message:   message,
+		Timestamp: timestamp(),
+	})
+}
+
+// find returns the named property, or nil. The driver's mutex must be held.
+func (dev *Device) find(name string) property {
+	for _, p := range dev.props {
+		if p.propName() == name {
+			return p
+		}
+	}
+
+	return nil
+}
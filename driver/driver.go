@@ -0,0 +1,227 @@
+// Package driver is a framework for writing INDI drivers in Go, using the same types as indiclient.
+//
+// A Driver holds one or more devices, each with typed properties. It answers getProperties with the definitions of
+// those properties, calls the handler of a property when a client sends it new values, and sends updates and messages
+// whenever the driver changes something. Run it over stdin and stdout with RunStdio, and indiserver can launch it
+// like any other driver:
+//
+//	indiserver -v ./my_roof_driver
+//
+// Since stdout carries the INDI protocol, the logger given to New must write somewhere else, such as stderr.
+package driver
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rickbassham/logging"
+
+	"github.com/goastro/indiclient"
+)
+
+// timestampFormat is the format INDI uses for timestamps, always in UTC.
+const timestampFormat = "2006-01-02T15:04:05"
+
+// Driver is an INDI driver. Create one with New, add devices with AddDevice, then call Run or RunStdio.
+type Driver struct {
+	log logging.Logger
+
+	// mu guards the devices, the properties they hold, and w. It is held while writing, so everything is sent in the
+	// order it happened.
+	mu      sync.Mutex
+	devices []*Device
+	w       io.Writer
+}
+
+// New creates a Driver with no devices.
+func New(log logging.Logger) *Driver {
+	return &Driver{
+		log: log,
+	}
+}
+
+// AddDevice adds a device to the driver, or returns the existing device if one with the same name was added before.
+func (d *Driver) AddDevice(name string) *Device {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dev := range d.devices {
+		if dev.name == name {
+			return dev
+		}
+	}
+
+	dev := &Device{
+		driver: d,
+		name:   name,
+	}
+
+	d.devices = append(d.devices, dev)
+
+	return dev
+}
+
+// RunStdio runs the driver over stdin and stdout, which is how indiserver talks to the drivers it launches.
+func (d *Driver) RunStdio(ctx context.Context) error {
+	return d.Run(ctx, os.Stdin, os.Stdout)
+}
+
+// Run reads commands from r and writes definitions and updates to w until r is closed or ctx is done. nil is returned
+// when r is closed, and ctx.Err() when ctx is done. A read from r that is in progress when ctx is done cannot be
+// interrupted, so close r as well to release it.
+//
+// Property handlers are called one at a time, on the goroutine reading r, so a handler that starts something slow,
+// such as moving a roof, should do it on its own goroutine and report progress with Set.
+func (d *Driver) Run(ctx context.Context, r io.Reader, w io.Writer) error {
+	d.mu.Lock()
+	d.w = w
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		d.w = nil
+		d.mu.Unlock()
+	}()
+
+	items := make(chan interface{})
+	readErr := make(chan error, 1)
+
+	go func() {
+		dec := indiclient.NewDecoder(r)
+
+		for {
+			item, err := dec.Decode()
+			if err != nil {
+				if elemErr, ok := err.(*indiclient.ElementError); ok {
+					d.log.WithField("element", elemErr.Element).WithError(elemErr.Err).Warn("error in dec.Decode")
+					continue
+				}
+
+				readErr <- err
+				return
+			}
+
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		case item := <-items:
+			d.dispatch(item)
+		}
+	}
+}
+
+func (d *Driver) dispatch(item interface{}) {
+	switch cmd := item.(type) {
+	case *indiclient.GetProperties:
+		d.getProperties(cmd)
+	case *indiclient.NewTextVector:
+		if p, ok := d.findProperty(cmd.Device, cmd.Name).(*TextProperty); ok {
+			p.handleNew(cmd)
+			return
+		}
+
+		d.log.WithField("device", cmd.Device).WithField("property", cmd.Name).Warn("could not find property")
+	case *indiclient.NewNumberVector:
+		if p, ok := d.findProperty(cmd.Device, cmd.Name).(*NumberProperty); ok {
+			p.handleNew(cmd)
+			return
+		}
+
+		d.log.WithField("device", cmd.Device).WithField("property", cmd.Name).Warn("could not find property")
+	case *indiclient.NewSwitchVector:
+		if p, ok := d.findProperty(cmd.Device, cmd.Name).(*SwitchProperty); ok {
+			p.handleNew(cmd)
+			return
+		}
+
+		d.log.WithField("device", cmd.Device).WithField("property", cmd.Name).Warn("could not find property")
+	case *indiclient.NewBlobVector:
+		if p, ok := d.findProperty(cmd.Device, cmd.Name).(*BlobProperty); ok {
+			p.handleNew(cmd)
+			return
+		}
+
+		d.log.WithField("device", cmd.Device).WithField("property", cmd.Name).Warn("could not find property")
+	default:
+		// Snooping on other devices is not supported, so their definitions and updates are of no interest.
+	}
+}
+
+// getProperties sends the definition of every property matching cmd.
+func (d *Driver) getProperties(cmd *indiclient.GetProperties) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dev := range d.devices {
+		if len(cmd.Device) > 0 && cmd.Device != dev.name {
+			continue
+		}
+
+		for _, p := range dev.props {
+			if len(cmd.Name) > 0 && cmd.Name != p.propName() {
+				continue
+			}
+
+			d.send(p.definition())
+		}
+	}
+}
+
+func (d *Driver) findProperty(device, name string) property {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dev := range d.devices {
+		if dev.name == device {
+			return dev.find(name)
+		}
+	}
+
+	return nil
+}
+
+// send writes item, if the driver is running. d.mu must be held.
+func (d *Driver) send(item interface{}) {
+	if d.w == nil {
+		return
+	}
+
+	b, err := xml.Marshal(item)
+	if err != nil {
+		d.log.WithError(err).Error("error in xml.Marshal")
+		return
+	}
+
+	_, err = d.w.Write(append(b, '\n'))
+	if err != nil {
+		d.log.WithError(err).Error("error in d.w.Write")
+	}
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(timestampFormat)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,263 @@
+package driver_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/driver"
+)
+
+// connDialer hands out a single connection that already exists.
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d connDialer) Dial(network, address string) (io.ReadWriteCloser, error) {
+	return d.conn, nil
+}
+
+var discard = logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+
+// runDriver runs d, and returns a client connected straight to it that has received every definition.
+func runDriver(t *testing.T, d *driver.Driver, properties int) (*indiclient.INDIClient, func()) {
+	clientEnd, driverEnd := net.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ran := make(chan error, 1)
+	go func() {
+		ran <- d.Run(ctx, driverEnd, driverEnd)
+	}()
+
+	c := indiclient.NewINDIClient(discard, connDialer{clientEnd}, afero.NewMemMapFs(), 10)
+	require.NoError(t, c.Connect("tcp", "driver"))
+
+	defined := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyDefined}}, properties, indiclient.DropPolicyNewest)
+	defer defined.Close()
+
+	require.NoError(t, c.GetProperties("", ""))
+
+	for i := 0; i < properties; i++ {
+		select {
+		case <-defined.Events():
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "timed out waiting for definitions")
+		}
+	}
+
+	return c, func() {
+		c.Disconnect()
+		cancel()
+		driverEnd.Close()
+		<-ran
+	}
+}
+
+func newRoof(d *driver.Driver) (*driver.SwitchProperty, *driver.LightProperty) {
+	dev := d.AddDevice("Roof")
+
+	status := dev.DefineLight(indiclient.DefLightVector{
+		Name:  "ROOF_STATUS",
+		State: indiclient.PropertyStateIdle,
+		Lights: []indiclient.DefLight{
+			{Name: "OPENED", Value: indiclient.PropertyStateIdle},
+			{Name: "CLOSED", Value: indiclient.PropertyStateOk},
+		},
+	})
+
+	motion := dev.DefineSwitch(indiclient.DefSwitchVector{
+		Name:    "DOME_MOTION",
+		State:   indiclient.PropertyStateIdle,
+		Perm:    indiclient.PropertyPermissionReadWrite,
+		Rule:    indiclient.SwitchRuleOneOfMany,
+		Timeout: 60,
+		Switches: []indiclient.DefSwitch{
+			{Name: "DOME_CW", Value: indiclient.SwitchStateOff},
+			{Name: "DOME_CCW", Value: indiclient.SwitchStateOn},
+		},
+	}, func(p *driver.SwitchProperty, values map[string]indiclient.SwitchState) error {
+		if values["DOME_CW"] != indiclient.SwitchStateOn {
+			return errors.New("roof is already closed")
+		}
+
+		p.Set(indiclient.PropertyStateBusy, values, "opening")
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+
+			status.Set(indiclient.PropertyStateOk, map[string]indiclient.PropertyState{
+				"OPENED": indiclient.PropertyStateOk,
+				"CLOSED": indiclient.PropertyStateIdle,
+			}, "")
+
+			p.SetState(indiclient.PropertyStateOk, "opened")
+		}()
+
+		return nil
+	})
+
+	return motion, status
+}
+
+func Test_Driver_Handler(t *testing.T) {
+	d := driver.New(discard)
+	motion, _ := newRoof(d)
+
+	c, stop := runDriver(t, d, 2)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := c.SetSwitchValueAndWait(ctx, "Roof", "DOME_MOTION", "DOME_CW", indiclient.SwitchStateOn)
+	require.NoError(t, err)
+
+	active, err := c.ActiveSwitch("Roof", "DOME_MOTION")
+	require.NoError(t, err)
+	assert.Equal(t, "DOME_CW", active)
+
+	lights, err := c.LightProperty("Roof", "ROOF_STATUS")
+	require.NoError(t, err)
+	assert.Equal(t, indiclient.PropertyStateOk, lights.Values["OPENED"].Value)
+	assert.Equal(t, indiclient.PropertyStateIdle, lights.Values["CLOSED"].Value)
+
+	v, err := motion.Value("DOME_CCW")
+	require.NoError(t, err)
+	assert.Equal(t, indiclient.SwitchStateOff, v)
+	assert.Equal(t, indiclient.PropertyStateOk, motion.State())
+}
+
+func Test_Driver_HandlerError(t *testing.T) {
+	d := driver.New(discard)
+	newRoof(d)
+
+	c, stop := runDriver(t, d, 2)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := c.SetSwitchValueAndWait(ctx, "Roof", "DOME_MOTION", "DOME_CCW", indiclient.SwitchStateOn)
+	require.Error(t, err)
+
+	alert, ok := err.(*indiclient.PropertyAlertError)
+	require.True(t, ok)
+	assert.Equal(t, "roof is already closed", alert.Message)
+}
+
+func Test_Driver_DefaultHandler(t *testing.T) {
+	d := driver.New(discard)
+
+	heater := d.AddDevice("Dew Heater").DefineNumber(indiclient.DefNumberVector{
+		Name:  "HEATER_POWER",
+		State: indiclient.PropertyStateIdle,
+		Perm:  indiclient.PropertyPermissionReadWrite,
+		Numbers: []indiclient.DefNumber{
+			{Name: "POWER", Format: "%3.0f", Min: "0", Max: "100", Step: "1", Value: "0"},
+		},
+	}, nil)
+
+	c, stop := runDriver(t, d, 1)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := c.SetNumberValueAndWait(ctx, "Dew Heater", "HEATER_POWER", "POWER", "40")
+	require.NoError(t, err)
+
+	v, err := heater.Value("POWER")
+	require.NoError(t, err)
+	assert.Equal(t, 40.0, v)
+
+	v, err = c.NumberValueFloat("Dew Heater", "HEATER_POWER", "POWER")
+	require.NoError(t, err)
+	assert.Equal(t, 40.0, v)
+}
+
+func Test_Driver_MessageAndDelete(t *testing.T) {
+	d := driver.New(discard)
+	dev := d.AddDevice("Roof")
+	newRoof(d)
+
+	c, stop := runDriver(t, d, 2)
+	defer stop()
+
+	events := c.Subscribe(indiclient.EventFilter{Device: "Roof"}, 10, indiclient.DropPolicyNewest)
+	defer events.Close()
+
+	next := func() indiclient.Event {
+		select {
+		case e := <-events.Events():
+			return e
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "timed out waiting for event")
+		}
+
+		return indiclient.Event{}
+	}
+
+	dev.Message("rain detected")
+
+	e := next()
+	assert.Equal(t, indiclient.EventMessage, e.Type)
+	assert.Equal(t, "rain detected", e.Message)
+
+	dev.Delete("ROOF_STATUS")
+
+	e = next()
+	assert.Equal(t, indiclient.EventPropertyDeleted, e.Type)
+	assert.Equal(t, "ROOF_STATUS", e.Property)
+
+	_, err := c.LightProperty("Roof", "ROOF_STATUS")
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+}
+
+func Test_Driver_Run(t *testing.T) {
+	d := driver.New(discard)
+	newRoof(d)
+
+	r, w := io.Pipe()
+
+	ran := make(chan error, 1)
+	go func() {
+		ran <- d.Run(context.Background(), r, ioutil.Discard)
+	}()
+
+	w.Close()
+
+	select {
+	case err := <-ran:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Run did not return when its input was closed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r, _ = io.Pipe()
+
+	go func() {
+		ran <- d.Run(ctx, r, ioutil.Discard)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-ran:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Run did not return when ctx was canceled")
+	}
+}
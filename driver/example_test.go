@@ -0,0 +1,40 @@
+package driver_test
+
+import (
+	"context"
+	"os"
+
+	"github.com/rickbassham/logging"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/driver"
+)
+
+// A dew heater controller with a single number property. Built as an executable, it can be launched by indiserver.
+func Example() {
+	// stdout carries the INDI protocol, so log to stderr.
+	log := logging.NewLogger(os.Stderr, logging.JSONFormatter{}, logging.LogLevelInfo)
+
+	d := driver.New(log)
+
+	d.AddDevice("Dew Heater").DefineNumber(indiclient.DefNumberVector{
+		Name:  "HEATER_POWER",
+		Label: "Power",
+		Group: "Main Control",
+		State: indiclient.PropertyStateIdle,
+		Perm:  indiclient.PropertyPermissionReadWrite,
+		Numbers: []indiclient.DefNumber{
+			{Name: "POWER", Label: "Power (%)", Format: "%3.0f", Min: "0", Max: "100", Step: "1", Value: "0"},
+		},
+	}, func(p *driver.NumberProperty, values map[string]float64) error {
+		// Set the PWM duty cycle of the heater here.
+
+		return p.Set(indiclient.PropertyStateOk, values, "")
+	})
+
+	err := d.RunStdio(context.Background())
+	if err != nil {
+		log.WithError(err).Error("error in d.RunStdio")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,715 @@
+package driver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/goastro/indiclient"
+)
+
+// TextHandler is called when a client sends new values for a TextProperty. values holds only the texts the client
+// sent. If the handler returns an error, the property is set to Alert with the error as its message. Otherwise the
+// handler is responsible for reporting the outcome with Set.
+type TextHandler func(p *TextProperty, values map[string]string) error
+
+// NumberHandler is called when a client sends new values for a NumberProperty. values holds only the numbers the
+// client sent, already parsed and checked against Min and Max. If the handler returns an error, the property is set
+// to Alert with the error as its message. Otherwise the handler is responsible for reporting the outcome with Set.
+type NumberHandler func(p *NumberProperty, values map[string]float64) error
+
+// SwitchHandler is called when a client sends new values for a SwitchProperty. values holds only the switches the
+// client sent, already checked against the Rule of the property. If the handler returns an error, the property is
+// set to Alert with the error as its message. Otherwise the handler is responsible for reporting the outcome with Set.
+type SwitchHandler func(p *SwitchProperty, values map[string]indiclient.SwitchState) error
+
+// BlobHandler is called when a client uploads BLOBs to a BlobProperty. If the handler returns an error, the property
+// is set to Alert with the error as its message. Otherwise the handler is responsible for reporting the outcome with
+// SetState.
+type BlobHandler func(p *BlobProperty, blobs []Blob) error
+
+// Blob is a decoded BLOB, either uploaded by a client or sent by the driver.
+type Blob struct {
+	Name   string
+	Format string
+	Data   []byte
+}
+
+// TextProperty is a text property defined with Device.DefineText.
+type TextProperty struct {
+	device  *Device
+	def     indiclient.DefTextVector
+	handler TextHandler
+}
+
+// Name returns the name of the property.
+func (p *TextProperty) Name() string {
+	return p.def.Name
+}
+
+// State returns the current state of the property.
+func (p *TextProperty) State() indiclient.PropertyState {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	return p.def.State
+}
+
+// Value returns the current value of a text, or indiclient.ErrPropertyValueNotFound.
+func (p *TextProperty) Value(name string) (string, error) {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	for _, t := range p.def.Texts {
+		if t.Name == name {
+			return t.Value, nil
+		}
+	}
+
+	return "", indiclient.ErrPropertyValueNotFound
+}
+
+// Set changes the state and some of the values of the property, and sends them to clients along with message. An
+// empty state leaves the state unchanged. indiclient.ErrPropertyValueNotFound is returned, and nothing is changed, if
+// any of the values does not exist.
+func (p *TextProperty) Set(state indiclient.PropertyState, values map[string]string, message string) error {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		indexes[i] = -1
+
+		for j, t := range p.def.Texts {
+			if t.Name == name {
+				indexes[i] = j
+			}
+		}
+
+		if indexes[i] < 0 {
+			return indiclient.ErrPropertyValueNotFound
+		}
+	}
+
+	setState(&p.def.State, state)
+
+	set := &indiclient.SetTextVector{
+		Device:    p.def.Device,
+		Name:      p.def.Name,
+		State:     p.def.State,
+		Timeout:   p.def.Timeout,
+		Timestamp: timestamp(),
+		Message:   message,
+	}
+
+	for i, name := range names {
+		p.def.Texts[indexes[i]].Value = values[name]
+		set.Texts = append(set.Texts, indiclient.OneText{Name: name, Value: values[name]})
+	}
+
+	p.device.driver.send(set)
+
+	return nil
+}
+
+// SetState changes the state of the property, and sends it to clients along with message.
+func (p *TextProperty) SetState(state indiclient.PropertyState, message string) error {
+	return p.Set(state, nil, message)
+}
+
+func (p *TextProperty) propName() string {
+	return p.def.Name
+}
+
+func (p *TextProperty) definition() interface{} {
+	def := p.def
+	def.Texts = append([]indiclient.DefText{}, p.def.Texts...)
+	def.Timestamp = timestamp()
+
+	return &def
+}
+
+func (p *TextProperty) handleNew(cmd *indiclient.NewTextVector) {
+	if p.def.Perm == indiclient.PropertyPermissionReadOnly {
+		p.device.driver.log.WithField("property", p.def.Name).Warn("ignoring new values for read only property")
+		return
+	}
+
+	values := map[string]string{}
+	for _, t := range cmd.Texts {
+		values[t.Name] = strings.TrimSpace(t.Value)
+	}
+
+	if p.handler == nil {
+		alertOnError(p.SetState, p.Set(indiclient.PropertyStateOk, values, ""))
+		return
+	}
+
+	alertOnError(p.SetState, p.handler(p, values))
+}
+
+// NumberProperty is a number property defined with Device.DefineNumber.
+type NumberProperty struct {
+	device  *Device
+	def     indiclient.DefNumberVector
+	handler NumberHandler
+}
+
+// Name returns the name of the property.
+func (p *NumberProperty) Name() string {
+	return p.def.Name
+}
+
+// State returns the current state of the property.
+func (p *NumberProperty) State() indiclient.PropertyState {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	return p.def.State
+}
+
+// Value returns the current value of a number, or indiclient.ErrPropertyValueNotFound.
+func (p *NumberProperty) Value(name string) (float64, error) {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	for _, n := range p.def.Numbers {
+		if n.Name == name {
+			return indiclient.ParseNumber(n.Value)
+		}
+	}
+
+	return 0, indiclient.ErrPropertyValueNotFound
+}
+
+// Set changes the state and some of the values of the property, and sends them to clients along with message. An
+// empty state leaves the state unchanged. indiclient.ErrPropertyValueNotFound is returned, and nothing is changed, if
+// any of the values does not exist.
+func (p *NumberProperty) Set(state indiclient.PropertyState, values map[string]float64, message string) error {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		indexes[i] = -1
+
+		for j, n := range p.def.Numbers {
+			if n.Name == name {
+				indexes[i] = j
+			}
+		}
+
+		if indexes[i] < 0 {
+			return indiclient.ErrPropertyValueNotFound
+		}
+	}
+
+	setState(&p.def.State, state)
+
+	set := &indiclient.SetNumberVector{
+		Device:    p.def.Device,
+		Name:      p.def.Name,
+		State:     p.def.State,
+		Timeout:   p.def.Timeout,
+		Timestamp: timestamp(),
+		Message:   message,
+	}
+
+	for i, name := range names {
+		v := formatFloat(values[name])
+
+		p.def.Numbers[indexes[i]].Value = v
+		set.Numbers = append(set.Numbers, indiclient.OneNumber{Name: name, Value: v})
+	}
+
+	p.device.driver.send(set)
+
+	return nil
+}
+
+// SetState changes the state of the property, and sends it to clients along with message.
+func (p *NumberProperty) SetState(state indiclient.PropertyState, message string) error {
+	return p.Set(state, nil, message)
+}
+
+func (p *NumberProperty) propName() string {
+	return p.def.Name
+}
+
+func (p *NumberProperty) definition() interface{} {
+	def := p.def
+	def.Numbers = append([]indiclient.DefNumber{}, p.def.Numbers...)
+	def.Timestamp = timestamp()
+
+	return &def
+}
+
+func (p *NumberProperty) handleNew(cmd *indiclient.NewNumberVector) {
+	if p.def.Perm == indiclient.PropertyPermissionReadOnly {
+		p.device.driver.log.WithField("property", p.def.Name).Warn("ignoring new values for read only property")
+		return
+	}
+
+	values, err := p.parse(cmd)
+	if err != nil {
+		alertOnError(p.SetState, err)
+		return
+	}
+
+	if p.handler == nil {
+		alertOnError(p.SetState, p.Set(indiclient.PropertyStateOk, values, ""))
+		return
+	}
+
+	alertOnError(p.SetState, p.handler(p, values))
+}
+
+// parse parses the numbers in cmd, and checks they are within Min and Max.
+func (p *NumberProperty) parse(cmd *indiclient.NewNumberVector) (map[string]float64, error) {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	values := map[string]float64{}
+
+	for _, one := range cmd.Numbers {
+		var def *indiclient.DefNumber
+		for i := range p.def.Numbers {
+			if p.def.Numbers[i].Name == one.Name {
+				def = &p.def.Numbers[i]
+			}
+		}
+
+		if def == nil {
+			return nil, fmt.Errorf("%s: %w", one.Name, indiclient.ErrPropertyValueNotFound)
+		}
+
+		v, err := indiclient.ParseNumber(one.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		min, minErr := indiclient.ParseNumber(def.Min)
+		max, maxErr := indiclient.ParseNumber(def.Max)
+
+		if minErr == nil && maxErr == nil && min < max && (v < min || v > max) {
+			return nil, fmt.Errorf("%s: %s is outside [%s, %s]", one.Name, formatFloat(v), def.Min, def.Max)
+		}
+
+		values[one.Name] = v
+	}
+
+	return values, nil
+}
+
+// SwitchProperty is a switch property defined with Device.DefineSwitch.
+type SwitchProperty struct {
+	device  *Device
+	def     indiclient.DefSwitchVector
+	handler SwitchHandler
+}
+
+// Name returns the name of the property.
+func (p *SwitchProperty) Name() string {
+	return p.def.Name
+}
+
+// State returns the current state of the property.
+func (p *SwitchProperty) State() indiclient.PropertyState {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	return p.def.State
+}
+
+// Value returns the current state of a switch, or indiclient.ErrPropertyValueNotFound.
+func (p *SwitchProperty) Value(name string) (indiclient.SwitchState, error) {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	for _, s := range p.def.Switches {
+		if s.Name == name {
+			return s.Value, nil
+		}
+	}
+
+	return "", indiclient.ErrPropertyValueNotFound
+}
+
+// Set changes the state and some of the switches of the property, and sends them to clients along with message. An
+// empty state leaves the state unchanged. For OneOfMany and AtMostOne properties, turning a switch On turns the
+// others Off. indiclient.ErrPropertyValueNotFound is returned, and nothing is changed, if any of the switches does not
+// exist.
+func (p *SwitchProperty) Set(state indiclient.PropertyState, values map[string]indiclient.SwitchState, message string) error {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	next, err := p.apply(values)
+	if err != nil {
+		return err
+	}
+
+	setState(&p.def.State, state)
+
+	set := &indiclient.SetSwitchVector{
+		Device:    p.def.Device,
+		Name:      p.def.Name,
+		State:     p.def.State,
+		Timeout:   p.def.Timeout,
+		Timestamp: timestamp(),
+		Message:   message,
+	}
+
+	for i := range p.def.Switches {
+		s := &p.def.Switches[i]
+
+		v, changed := next[s.Name]
+		if !changed {
+			continue
+		}
+
+		s.Value = v
+		set.Switches = append(set.Switches, indiclient.OneSwitch{Name: s.Name, Value: v})
+	}
+
+	p.device.driver.send(set)
+
+	return nil
+}
+
+// SetState changes the state of the property, and sends it to clients along with message.
+func (p *SwitchProperty) SetState(state indiclient.PropertyState, message string) error {
+	return p.Set(state, nil, message)
+}
+
+// apply returns the switches that change when values are applied, following the Rule of the property. The driver's
+// mutex must be held.
+func (p *SwitchProperty) apply(values map[string]indiclient.SwitchState) (map[string]indiclient.SwitchState, error) {
+	next := map[string]indiclient.SwitchState{}
+
+	turnsOn := false
+	for name, v := range values {
+		found := false
+		for _, s := range p.def.Switches {
+			if s.Name == name {
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, indiclient.ErrPropertyValueNotFound
+		}
+
+		if v != indiclient.SwitchStateOn && v != indiclient.SwitchStateOff {
+			return nil, indiclient.ErrInvalidSwitchState
+		}
+
+		if v == indiclient.SwitchStateOn {
+			turnsOn = true
+		}
+	}
+
+	if turnsOn && p.def.Rule != indiclient.SwitchRuleAnyOfMany {
+		for _, s := range p.def.Switches {
+			next[s.Name] = indiclient.SwitchStateOff
+		}
+	}
+
+	for name, v := range values {
+		next[name] = v
+	}
+
+	return next, nil
+}
+
+func (p *SwitchProperty) propName() string {
+	return p.def.Name
+}
+
+func (p *SwitchProperty) definition() interface{} {
+	def := p.def
+	def.Switches = append([]indiclient.DefSwitch{}, p.def.Switches...)
+	def.Timestamp = timestamp()
+
+	return &def
+}
+
+func (p *SwitchProperty) handleNew(cmd *indiclient.NewSwitchVector) {
+	if p.def.Perm == indiclient.PropertyPermissionReadOnly {
+		p.device.driver.log.WithField("property", p.def.Name).Warn("ignoring new values for read only property")
+		return
+	}
+
+	values := map[string]indiclient.SwitchState{}
+	for _, s := range cmd.Switches {
+		values[s.Name] = indiclient.SwitchState(strings.TrimSpace(string(s.Value)))
+	}
+
+	err := p.check(values)
+	if err != nil {
+		alertOnError(p.SetState, err)
+		return
+	}
+
+	if p.handler == nil {
+		alertOnError(p.SetState, p.Set(indiclient.PropertyStateOk, values, ""))
+		return
+	}
+
+	alertOnError(p.SetState, p.handler(p, values))
+}
+
+// check returns an error if applying values would break the Rule of the property.
+func (p *SwitchProperty) check(values map[string]indiclient.SwitchState) error {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	next, err := p.apply(values)
+	if err != nil {
+		return err
+	}
+
+	on := 0
+	for _, s := range p.def.Switches {
+		v, ok := next[s.Name]
+		if !ok {
+			v = s.Value
+		}
+
+		if v == indiclient.SwitchStateOn {
+			on++
+		}
+	}
+
+	switch {
+	case p.def.Rule == indiclient.SwitchRuleOneOfMany && on != 1:
+		return fmt.Errorf("exactly one switch must be On, not %d", on)
+	case p.def.Rule == indiclient.SwitchRuleAtMostOne && on > 1:
+		return fmt.Errorf("at most one switch may be On, not %d", on)
+	}
+
+	return nil
+}
+
+// LightProperty is a light property defined with Device.DefineLight.
+type LightProperty struct {
+	device *Device
+	def    indiclient.DefLightVector
+}
+
+// Name returns the name of the property.
+func (p *LightProperty) Name() string {
+	return p.def.Name
+}
+
+// State returns the current state of the property.
+func (p *LightProperty) State() indiclient.PropertyState {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	return p.def.State
+}
+
+// Set changes the state and some of the lights of the property, and sends them to clients along with message. An
+// empty state leaves the state unchanged. indiclient.ErrPropertyValueNotFound is returned, and nothing is changed, if
+// any of the lights does not exist.
+func (p *LightProperty) Set(state indiclient.PropertyState, values map[string]indiclient.PropertyState, message string) error {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		indexes[i] = -1
+
+		for j, l := range p.def.Lights {
+			if l.Name == name {
+				indexes[i] = j
+			}
+		}
+
+		if indexes[i] < 0 {
+			return indiclient.ErrPropertyValueNotFound
+		}
+	}
+
+	setState(&p.def.State, state)
+
+	set := &indiclient.SetLightVector{
+		Device:    p.def.Device,
+		Name:      p.def.Name,
+		State:     p.def.State,
+		Timestamp: timestamp(),
+		Message:   message,
+	}
+
+	for i, name := range names {
+		p.def.Lights[indexes[i]].Value = values[name]
+		set.Lights = append(set.Lights, indiclient.OneLight{Name: name, Value: values[name]})
+	}
+
+	p.device.driver.send(set)
+
+	return nil
+}
+
+func (p *LightProperty) propName() string {
+	return p.def.Name
+}
+
+func (p *LightProperty) definition() interface{} {
+	def := p.def
+	def.Lights = append([]indiclient.DefLight{}, p.def.Lights...)
+	def.Timestamp = timestamp()
+
+	return &def
+}
+
+// BlobProperty is a BLOB property defined with Device.DefineBlob.
+type BlobProperty struct {
+	device  *Device
+	def     indiclient.DefBlobVector
+	handler BlobHandler
+}
+
+// Name returns the name of the property.
+func (p *BlobProperty) Name() string {
+	return p.def.Name
+}
+
+// State returns the current state of the property.
+func (p *BlobProperty) State() indiclient.PropertyState {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	return p.def.State
+}
+
+// Send sends BLOBs to clients that have enabled them, along with the new state of the property and message. An empty
+// state leaves the state unchanged. indiclient.ErrPropertyValueNotFound is returned, and nothing is sent, if any of the
+// BLOBs does not exist.
+func (p *BlobProperty) Send(state indiclient.PropertyState, blobs []Blob, message string) error {
+	p.device.driver.mu.Lock()
+	defer p.device.driver.mu.Unlock()
+
+	for _, b := range blobs {
+		found := false
+		for _, def := range p.def.Blobs {
+			if def.Name == b.Name {
+				found = true
+			}
+		}
+
+		if !found {
+			return indiclient.ErrPropertyValueNotFound
+		}
+	}
+
+	setState(&p.def.State, state)
+
+	set := &indiclient.SetBlobVector{
+		Device:    p.def.Device,
+		Name:      p.def.Name,
+		State:     p.def.State,
+		Timeout:   p.def.Timeout,
+		Timestamp: timestamp(),
+		Message:   message,
+	}
+
+	for _, b := range blobs {
+		set.Blobs = append(set.Blobs, indiclient.OneBlob{
+			Name:   b.Name,
+			Size:   len(b.Data),
+			Format: b.Format,
+			Value:  base64.StdEncoding.EncodeToString(b.Data),
+		})
+	}
+
+	p.device.driver.send(set)
+
+	return nil
+}
+
+// SetState changes the state of the property, and sends it to clients along with message.
+func (p *BlobProperty) SetState(state indiclient.PropertyState, message string) error {
+	return p.Send(state, nil, message)
+}
+
+func (p *BlobProperty) propName() string {
+	return p.def.Name
+}
+
+func (p *BlobProperty) definition() interface{} {
+	def := p.def
+	def.Blobs = append([]indiclient.DefBlob{}, p.def.Blobs...)
+	def.Timestamp = timestamp()
+
+	return &def
+}
+
+func (p *BlobProperty) handleNew(cmd *indiclient.NewBlobVector) {
+	if p.def.Perm == indiclient.PropertyPermissionReadOnly {
+		p.device.driver.log.WithField("property", p.def.Name).Warn("ignoring new values for read only property")
+		return
+	}
+
+	blobs := make([]Blob, 0, len(cmd.Blobs))
+
+	for _, one := range cmd.Blobs {
+		data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.TrimSpace(one.Value))))
+		if err != nil {
+			alertOnError(p.SetState, fmt.Errorf("%s: %w", one.Name, err))
+			return
+		}
+
+		blobs = append(blobs, Blob{
+			Name:   one.Name,
+			Format: one.Format,
+			Data:   data,
+		})
+	}
+
+	if p.handler == nil {
+		alertOnError(p.SetState, p.SetState(indiclient.PropertyStateOk, ""))
+		return
+	}
+
+	alertOnError(p.SetState, p.handler(p, blobs))
+}
+
+// alertOnError sets a property to Alert with err as its message, if err is not nil. setState is the SetState method of
+// the property, which cannot fail since it changes no values.
+func alertOnError(setState func(indiclient.PropertyState, string) error, err error) {
+	if err == nil {
+		return
+	}
+
+	setState(indiclient.PropertyStateAlert, err.Error())
+}
+
+func setState(state *indiclient.PropertyState, next indiclient.PropertyState) {
+	if len(next) > 0 {
+		*state = next
+	}
+}
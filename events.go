@@ -0,0 +1,299 @@
+package indiclient
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType identifies what happened to cause an Event.
+type EventType string
+
+const (
+	// EventDeviceAdded is published when the first property of a new device is defined.
+	EventDeviceAdded = EventType("deviceAdded")
+	// EventDeviceRemoved is published when a device is deleted, or when all devices are cleared on connect or disconnect.
+	EventDeviceRemoved = EventType("deviceRemoved")
+	// EventPropertyDefined is published when a def*Vector is received. Old is set if the property was already defined.
+	EventPropertyDefined = EventType("propertyDefined")
+	// EventPropertyUpdated is published when a set*Vector is received. Old and New hold the property before and after the update.
+	EventPropertyUpdated = EventType("propertyUpdated")
+	// EventPropertyDeleted is published when a delProperty is received for a single property.
+	EventPropertyDeleted = EventType("propertyDeleted")
+	// EventMessage is published when a message is received from a device or from indiserver itself.
+	EventMessage = EventType("message")
+	// EventBlobReceived is published for each BLOB received, with Blob set. It is only delivered to handlers
+	// registered with HandleBlobs, and to subscriptions whose filter asks for it in Types, which get it in order with
+	// the other events.
+	EventBlobReceived = EventType("blobReceived")
+)
+
+// PropertyKind identifies the type of an INDI property. "text", "number", "switch", "light", or "blob".
+type PropertyKind string
+
+const (
+	// PropertyKindText represents a TextProperty.
+	PropertyKindText = PropertyKind("text")
+	// PropertyKindNumber represents a NumberProperty.
+	PropertyKindNumber = PropertyKind("number")
+	// PropertyKindSwitch represents a SwitchProperty.
+	PropertyKindSwitch = PropertyKind("switch")
+	// PropertyKindLight represents a LightProperty.
+	PropertyKindLight = PropertyKind("light")
+	// PropertyKindBlob represents a BlobProperty.
+	PropertyKindBlob = PropertyKind("blob")
+)
+
+// DropPolicy decides what happens when an event is published to a Subscription whose buffer is full.
+// The client never blocks while publishing, so a slow subscriber can only lose its own events.
+type DropPolicy string
+
+const (
+	// DropPolicyNewest (default) discards the event being published, keeping everything already buffered.
+	DropPolicyNewest = DropPolicy("newest")
+	// DropPolicyOldest discards the oldest buffered event to make room for the event being published.
+	DropPolicyOldest = DropPolicy("oldest")
+)
+
+// Event describes a change to the devices known by an INDIClient.
+//
+// Old and New hold a TextProperty, NumberProperty, SwitchProperty, LightProperty, or BlobProperty depending on Kind.
+// They are shared with every other subscriber, so treat them as read-only. Device level events (EventDeviceAdded,
+// EventDeviceRemoved, EventMessage) have an empty Property and Kind.
+type Event struct {
+	Type      EventType    `json:"type"`
+	Device    string       `json:"device"`
+	Property  string       `json:"property"`
+	Kind      PropertyKind `json:"kind"`
+	Old       interface{}  `json:"old,omitempty"`
+	New       interface{}  `json:"new,omitempty"`
+	Message   string       `json:"message"`
+	Timestamp time.Time    `json:"timestamp"`
+	Blob      *Blob        `json:"-"`
+}
+
+// State returns the state of New, or of Old if New is not set. An empty PropertyState is returned for device level
+// events.
+func (e Event) State() PropertyState {
+	p := e.New
+	if p == nil {
+		p = e.Old
+	}
+
+	switch prop := p.(type) {
+	case TextProperty:
+		return prop.State
+	case NumberProperty:
+		return prop.State
+	case SwitchProperty:
+		return prop.State
+	case LightProperty:
+		return prop.State
+	case BlobProperty:
+		return prop.State
+	}
+
+	return ""
+}
+
+// EventFilter selects which events are delivered to a Subscription. Empty fields match everything. When Property or
+// Kinds are set, device level events will not match, since they have neither.
+type EventFilter struct {
+	Device   string
+	Property string
+	Kinds    []PropertyKind
+	Types    []EventType
+}
+
+// Match returns true if e should be delivered to a Subscription using this filter.
+func (f EventFilter) Match(e Event) bool {
+	if len(f.Device) > 0 && f.Device != e.Device {
+		return false
+	}
+
+	if len(f.Property) > 0 && f.Property != e.Property {
+		return false
+	}
+
+	if len(f.Kinds) > 0 {
+		found := false
+		for _, k := range f.Kinds {
+			if k == e.Kind {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Subscription receives the events published by an INDIClient that match its filter. Call Close when you are done
+// with it.
+type Subscription struct {
+	id     string
+	client *INDIClient
+	filter EventFilter
+	policy DropPolicy
+
+	// blobs is set for subscriptions made by HandleBlobs, which are the only ones that get EventBlobReceived.
+	blobs bool
+
+	mu      sync.Mutex
+	events  chan Event
+	closed  bool
+	dropped uint64
+}
+
+// Events returns the channel events are delivered on. It is closed when the Subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events this Subscription has lost because its buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// Close stops delivery of events and closes the Events channel. It is safe to call Close more than once.
+func (s *Subscription) Close() {
+	s.client.subscriptions.Delete(s.id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	close(s.events)
+}
+
+func (s *Subscription) deliver(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || !s.wants(e.Type) || !s.filter.Match(e) {
+		return
+	}
+
+	select {
+	case s.events <- e:
+		return
+	default:
+	}
+
+	if s.policy == DropPolicyOldest {
+		select {
+		case <-s.events:
+		default:
+		}
+
+		select {
+		case s.events <- e:
+			s.dropped++
+			return
+		default:
+		}
+	}
+
+	s.dropped++
+}
+
+// wants returns true if s takes events of type t, whatever its filter says otherwise: subscriptions made by HandleBlobs
+// take only EventBlobReceived, and the rest take it only if their filter asks for it by type.
+func (s *Subscription) wants(t EventType) bool {
+	if t != EventBlobReceived {
+		return !s.blobs
+	}
+
+	if s.blobs {
+		return true
+	}
+
+	for _, ft := range s.filter.Types {
+		if ft == EventBlobReceived {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscribe registers a new Subscription that receives every event matching filter. Up to bufferSize events are held
+// for the subscriber; once full, policy decides which events are lost. If bufferSize is less than 1, the bufferSize
+// the client was created with is used.
+func (c *INDIClient) Subscribe(filter EventFilter, bufferSize int, policy DropPolicy) *Subscription {
+	s := c.newSubscription(filter, bufferSize, policy)
+
+	c.subscriptions.Store(s.id, s)
+
+	return s
+}
+
+func (c *INDIClient) newSubscription(filter EventFilter, bufferSize int, policy DropPolicy) *Subscription {
+	if bufferSize < 1 {
+		bufferSize = c.bufferSize
+	}
+
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	return &Subscription{
+		id:     uuid.New().String(),
+		client: c,
+		filter: filter,
+		policy: policy,
+		events: make(chan Event, bufferSize),
+	}
+}
+
+// SubscribeFunc is like Subscribe, but calls fn for each event on its own goroutine instead of exposing the channel.
+// fn is never called concurrently with itself. Close the returned Subscription to stop receiving events.
+func (c *INDIClient) SubscribeFunc(filter EventFilter, bufferSize int, policy DropPolicy, fn func(Event)) *Subscription {
+	s := c.Subscribe(filter, bufferSize, policy)
+
+	go func() {
+		for e := range s.events {
+			fn(e)
+		}
+	}()
+
+	return s
+}
+
+func (c *INDIClient) publish(e Event) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	c.subscriptions.Range(func(key, value interface{}) bool {
+		value.(*Subscription).deliver(e)
+
+		return true
+	})
+}
@@ -0,0 +1,141 @@
+package indiclient_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+const focuserDefXML = `<defNumberVector device="Focuser" name="ABS_FOCUS_POSITION" label="Absolute" group="Main" state="Idle" perm="rw" timeout="60">
+	<defNumber name="FOCUS_ABSOLUTE_POSITION" label="Steps" format="%6.0f" min="0" max="10000" step="10">100</defNumber>
+</defNumberVector>`
+
+func nextEvent(t *testing.T, s *indiclient.Subscription) indiclient.Event {
+	select {
+	case e, ok := <-s.Events():
+		require.True(t, ok, "subscription closed")
+		return e
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for event")
+	}
+
+	return indiclient.Event{}
+}
+
+func Test_Subscribe_PropertyLifecycle(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	s := c.Subscribe(indiclient.EventFilter{Device: "Focuser"}, 10, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, focuserDefXML)
+	io.WriteString(server, `<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Busy" timeout="60" message="moving">
+	<oneNumber name="FOCUS_ABSOLUTE_POSITION">200</oneNumber>
+</setNumberVector>`)
+	io.WriteString(server, `<delProperty device="Focuser" name="ABS_FOCUS_POSITION"/>`)
+
+	e := nextEvent(t, s)
+	assert.Equal(t, indiclient.EventDeviceAdded, e.Type)
+	assert.Equal(t, "Focuser", e.Device)
+
+	e = nextEvent(t, s)
+	assert.Equal(t, indiclient.EventPropertyDefined, e.Type)
+	assert.Equal(t, indiclient.PropertyKindNumber, e.Kind)
+	assert.Nil(t, e.Old)
+	assert.Equal(t, "100", e.New.(indiclient.NumberProperty).Values["FOCUS_ABSOLUTE_POSITION"].Value)
+
+	e = nextEvent(t, s)
+	assert.Equal(t, indiclient.EventPropertyUpdated, e.Type)
+	assert.Equal(t, "moving", e.Message)
+	assert.Equal(t, "100", e.Old.(indiclient.NumberProperty).Values["FOCUS_ABSOLUTE_POSITION"].Value)
+	assert.Equal(t, "200", e.New.(indiclient.NumberProperty).Values["FOCUS_ABSOLUTE_POSITION"].Value)
+	assert.Equal(t, indiclient.PropertyStateBusy, e.New.(indiclient.NumberProperty).State)
+
+	e = nextEvent(t, s)
+	assert.Equal(t, indiclient.EventPropertyDeleted, e.Type)
+	assert.Equal(t, indiclient.PropertyKindNumber, e.Kind)
+	assert.Equal(t, "ABS_FOCUS_POSITION", e.Property)
+}
+
+func Test_Subscribe_Filter(t *testing.T) {
+	filter := indiclient.EventFilter{
+		Device: "Focuser",
+		Kinds:  []indiclient.PropertyKind{indiclient.PropertyKindNumber},
+		Types:  []indiclient.EventType{indiclient.EventPropertyUpdated},
+	}
+
+	assert.True(t, filter.Match(indiclient.Event{Type: indiclient.EventPropertyUpdated, Device: "Focuser", Kind: indiclient.PropertyKindNumber}))
+	assert.False(t, filter.Match(indiclient.Event{Type: indiclient.EventPropertyUpdated, Device: "Mount", Kind: indiclient.PropertyKindNumber}))
+	assert.False(t, filter.Match(indiclient.Event{Type: indiclient.EventPropertyUpdated, Device: "Focuser", Kind: indiclient.PropertyKindSwitch}))
+	assert.False(t, filter.Match(indiclient.Event{Type: indiclient.EventDeviceAdded, Device: "Focuser"}))
+	assert.True(t, indiclient.EventFilter{}.Match(indiclient.Event{Type: indiclient.EventMessage}))
+}
+
+func Test_Subscribe_DropPolicy(t *testing.T) {
+	testCases := []struct {
+		policy   indiclient.DropPolicy
+		expected string
+	}{
+		{policy: indiclient.DropPolicyNewest, expected: "1"},
+		{policy: indiclient.DropPolicyOldest, expected: "3"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			c, server := newPipeClient(t)
+			defer c.Disconnect()
+
+			io.WriteString(server, focuserDefXML)
+
+			filter := indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}
+			s := c.Subscribe(filter, 1, tc.policy)
+			defer s.Close()
+
+			// A second subscriber that is drained tells us when all updates have been dispatched.
+			all := c.Subscribe(filter, 10, indiclient.DropPolicyNewest)
+			defer all.Close()
+
+			for _, v := range []string{"1", "2", "3"} {
+				io.WriteString(server, `<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Ok"><oneNumber name="FOCUS_ABSOLUTE_POSITION">`+v+`</oneNumber></setNumberVector>`)
+				nextEvent(t, all)
+			}
+
+			assert.Equal(t, uint64(2), s.Dropped())
+
+			e := nextEvent(t, s)
+			assert.Equal(t, tc.expected, e.New.(indiclient.NumberProperty).Values["FOCUS_ABSOLUTE_POSITION"].Value)
+		})
+	}
+}
+
+func Test_SubscribeFunc(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	received := make(chan indiclient.Event, 1)
+
+	s := c.SubscribeFunc(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventMessage}}, 1, indiclient.DropPolicyNewest, func(e indiclient.Event) {
+		received <- e
+	})
+
+	io.WriteString(server, `<message device="" timestamp="2020-01-01T00:00:00" message="hello"/>`)
+
+	select {
+	case e := <-received:
+		assert.Equal(t, "hello", e.Message)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for callback")
+	}
+
+	s.Close()
+	s.Close()
+
+	_, ok := <-s.Events()
+	assert.False(t, ok)
+}
@@ -0,0 +1,159 @@
+package fits
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxString is the longest string value that fits on a card, leaving room for the quotes.
+const maxString = 68
+
+// Set sets the value and comment of the first card with the given keyword, or adds a card to the end of the header if
+// there is none. value must be a string, bool, int, int64 or float64.
+func (h *Header) Set(key string, value interface{}, comment string) {
+	key = strings.ToUpper(key)
+
+	if i, ok := value.(int); ok {
+		value = int64(i)
+	}
+
+	for i := range h.Cards {
+		if h.Cards[i].Key == key {
+			h.Cards[i] = Card{Key: key, Value: value, Comment: comment}
+			return
+		}
+	}
+
+	h.Cards = append(h.Cards, Card{Key: key, Value: value, Comment: comment})
+}
+
+// Delete removes every card with the given keyword.
+func (h *Header) Delete(key string) {
+	key = strings.ToUpper(key)
+
+	cards := h.Cards[:0]
+	for _, c := range h.Cards {
+		if c.Key != key {
+			cards = append(cards, c)
+		}
+	}
+
+	h.Cards = cards
+}
+
+// Encode writes the header, followed by an END card and the padding to the end of the block. Cards that have not
+// changed since they were read are written exactly as they were. Strings too long for a single card are cut short.
+func (h Header) Encode(w io.Writer) error {
+	var b bytes.Buffer
+
+	for _, c := range h.Cards {
+		if c.raw != nil {
+			b.Write(c.raw)
+			continue
+		}
+
+		b.WriteString(formatCard(c))
+	}
+
+	b.WriteString(fmt.Sprintf("%-80s", "END"))
+
+	for b.Len()%blockSize != 0 {
+		b.WriteByte(' ')
+	}
+
+	_, err := w.Write(b.Bytes())
+
+	return err
+}
+
+// RewriteHeader reads the primary header of a FITS file from r, lets fn change it, and returns a reader over the whole
+// file with the new header. Only the header is read before RewriteHeader returns, so the data can be streamed. If the
+// header cannot be read, or fn returns an error, the error is returned along with a reader over the file as it was.
+func RewriteHeader(r io.Reader, fn func(h *Header) error) (io.Reader, error) {
+	var read bytes.Buffer
+
+	h, err := readHeader(io.TeeReader(r, &read), true)
+	if err == io.EOF {
+		err = ErrNotFITS
+	}
+
+	if err == nil {
+		err = fn(&h)
+	}
+
+	if err != nil {
+		return io.MultiReader(&read, r), err
+	}
+
+	var b bytes.Buffer
+
+	err = h.Encode(&b)
+	if err != nil {
+		return io.MultiReader(&read, r), err
+	}
+
+	return io.MultiReader(&b, r), nil
+}
+
+// formatCard formats a card in the fixed format, with values other than strings right justified to column 30.
+func formatCard(c Card) string {
+	var s string
+
+	switch v := c.Value.(type) {
+	case nil:
+		if c.Key == "COMMENT" || c.Key == "HISTORY" || c.Key == "" {
+			return fmt.Sprintf("%-8s%-72.72s", c.Key, c.Comment)
+		}
+
+		s = fmt.Sprintf("%-8s= %20s", c.Key, "")
+	case string:
+		v = strings.Replace(v, "'", "''", -1)
+		if len(v) > maxString-2 {
+			v = v[:maxString-2]
+
+			// Don't leave half of a doubled quote.
+			if strings.Count(v, "'")%2 == 1 {
+				v = v[:len(v)-1]
+			}
+		}
+
+		s = fmt.Sprintf("%-8s= '%-8s'", c.Key, v)
+	case bool:
+		value := "F"
+		if v {
+			value = "T"
+		}
+
+		s = fmt.Sprintf("%-8s= %20s", c.Key, value)
+	case int64:
+		s = fmt.Sprintf("%-8s= %20d", c.Key, v)
+	case float64:
+		s = fmt.Sprintf("%-8s= %20s", c.Key, formatFloat(v))
+	default:
+		s = fmt.Sprintf("%-8s= '%-8v'", c.Key, v)
+	}
+
+	if len(c.Comment) > 0 {
+		s += " / " + c.Comment
+	}
+
+	return fmt.Sprintf("%-80.80s", s)
+}
+
+// formatFloat formats v with the decimal point FITS requires of floating point values.
+func formatFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'G', -1, 64)
+
+	if strings.ContainsAny(s, ".NI") {
+		return s
+	}
+
+	if i := strings.Index(s, "E"); i >= 0 {
+		return s[:i] + "." + s[i:]
+	}
+
+	return s + "."
+}
@@ -0,0 +1,304 @@
+// Package fits reads FITS files, the format nearly every INDI camera sends its images in.
+//
+// Decode reads a whole file, and DecodeHeader reads just the primary header, which is all that is needed to find out
+// the exposure time, filter, and so on of a BLOB:
+//
+//	rdr, _, _, err := client.GetBlob("CCD Simulator", "CCD1", "CCD1")
+//	...
+//	header, err := fits.DecodeHeader(rdr)
+//	...
+//	exptime, ok := header.Exptime()
+//
+// RewriteHeader changes the primary header of a file as it is streamed, leaving the data untouched.
+package fits
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// recordSize is the length of a header card.
+	recordSize = 80
+
+	// blockSize is the size that headers and data are padded to.
+	blockSize = 2880
+
+	// maxHeaderBlocks is the most blocks a header is read from before giving up on finding its END card. Real headers
+	// take a few blocks at most, so without a limit a file with no END card would be read to its end as header.
+	maxHeaderBlocks = 256
+)
+
+var (
+	// ErrNotFITS is returned when a file does not start with SIMPLE = T, so is not a FITS file.
+	ErrNotFITS = errors.New("not a FITS file")
+
+	// ErrUnterminatedString is returned when a string value has no closing quote.
+	ErrUnterminatedString = errors.New("unterminated string")
+
+	// ErrNoEnd is returned when a header has no END card within 256 blocks.
+	ErrNoEnd = errors.New("header has no END card")
+
+	// ErrNotImage is returned by Image for an HDU that does not hold an image.
+	ErrNotImage = errors.New("not an image")
+
+	// ErrUnsupportedBitpix is returned by Image for a BITPIX that is not 8, 16, 32, 64, -32 or -64.
+	ErrUnsupportedBitpix = errors.New("unsupported BITPIX")
+
+	// ErrInvalidDataSize is returned when NAXIS, the length of an axis, PCOUNT or GCOUNT is negative, or NAXIS is
+	// more than 999.
+	ErrInvalidDataSize = errors.New("invalid data size")
+
+	// ErrDataTooLarge is returned when a header describes more data than MaxDataSize.
+	ErrDataTooLarge = errors.New("data too large")
+)
+
+// MaxDataSize is the most data, in bytes, Decode reads for an HDU, and Image allocates for its pixels, which take 8
+// bytes each whatever BITPIX is. A header that describes more is much more likely to be corrupt than to come from a
+// real camera, and could otherwise use up all the memory there is.
+var MaxDataSize int64 = 1 << 30
+
+// CardError is returned when a header card cannot be parsed.
+type CardError struct {
+	Key string
+	Err error
+}
+
+func (e *CardError) Error() string {
+	return fmt.Sprintf("fits: card %s: %s", e.Key, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *CardError) Unwrap() error {
+	return e.Err
+}
+
+// HDU is a header and data unit. A FITS file holds a primary HDU, followed by any number of extensions.
+type HDU struct {
+	Header Header
+
+	// Data holds the data of the HDU as it is in the file, without the padding. Use Image to decode an image.
+	Data []byte
+}
+
+// File is a decoded FITS file.
+type File struct {
+	HDUs []HDU
+}
+
+// Primary returns the primary HDU.
+func (f *File) Primary() *HDU {
+	return &f.HDUs[0]
+}
+
+// Decode reads a FITS file, the primary HDU and every extension, from r.
+func Decode(r io.Reader) (*File, error) {
+	br := bufio.NewReader(r)
+
+	f := &File{}
+
+	for {
+		h, err := readHeader(br, len(f.HDUs) == 0)
+		if err == io.EOF && len(f.HDUs) > 0 {
+			return f, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := dataSize(h)
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size)
+
+		_, err = io.ReadFull(br, data)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+
+		// Some writers leave out the padding at the end of the file.
+		_, err = br.Discard(padding(size))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		f.HDUs = append(f.HDUs, HDU{Header: h, Data: data})
+	}
+}
+
+// DecodeHeader reads only the primary header of a FITS file from r.
+func DecodeHeader(r io.Reader) (Header, error) {
+	h, err := readHeader(r, true)
+	if err == io.EOF {
+		return Header{}, ErrNotFITS
+	}
+
+	return h, err
+}
+
+// readHeader reads a header, up to and including its END card and padding. io.EOF is returned if r is already at its
+// end.
+func readHeader(r io.Reader, primary bool) (Header, error) {
+	var h Header
+
+	block := make([]byte, blockSize)
+
+	for blocks := 0; ; blocks++ {
+		if blocks == maxHeaderBlocks {
+			return h, ErrNoEnd
+		}
+
+		_, err := io.ReadFull(r, block)
+		if err == io.EOF && blocks == 0 {
+			return h, io.EOF
+		}
+
+		if err != nil {
+			return h, unexpected(err)
+		}
+
+		for i := 0; i < blockSize; i += recordSize {
+			record := block[i : i+recordSize]
+
+			if blocks == 0 && i == 0 {
+				if primary && !strings.HasPrefix(string(record), "SIMPLE  =") {
+					return h, ErrNotFITS
+				}
+			}
+
+			if strings.TrimRight(string(record), " ") == "END" {
+				return h, nil
+			}
+
+			c, err := parseCard(record)
+			if err != nil {
+				return h, err
+			}
+
+			if c.Key == "CONTINUE" && continues(h) {
+				err = continueString(&h, record)
+				if err != nil {
+					return h, err
+				}
+
+				continue
+			}
+
+			h.Cards = append(h.Cards, c)
+		}
+	}
+}
+
+// continues returns true if the last card in h is a long string that goes on in a CONTINUE card.
+func continues(h Header) bool {
+	if len(h.Cards) == 0 {
+		return false
+	}
+
+	s, ok := h.Cards[len(h.Cards)-1].Value.(string)
+
+	return ok && strings.HasSuffix(s, "&")
+}
+
+// continueString adds the string in a CONTINUE card to the end of the last card in h.
+func continueString(h *Header, record []byte) error {
+	last := &h.Cards[len(h.Cards)-1]
+
+	value, after, err := parseString(strings.TrimLeft(string(record[8:]), " "))
+	if err != nil {
+		return &CardError{Key: last.Key, Err: err}
+	}
+
+	s := last.Value.(string)
+	last.Value = s[:len(s)-1] + value
+	last.raw = append(last.raw, record...)
+
+	if comment := parseComment(after); len(comment) > 0 {
+		last.Comment = strings.TrimSpace(last.Comment + " " + comment)
+	}
+
+	return nil
+}
+
+// dataSize returns the size of the data following a header, without padding.
+func dataSize(h Header) (int64, error) {
+	axes, err := h.axes()
+	if err != nil || len(axes) == 0 {
+		return 0, err
+	}
+
+	n, err := elements(axes)
+	if err != nil {
+		return 0, err
+	}
+
+	pcount, _ := h.Int("PCOUNT")
+
+	gcount, ok := h.Int("GCOUNT")
+	if !ok {
+		gcount = 1
+	}
+
+	if pcount < 0 || gcount < 0 {
+		return 0, ErrInvalidDataSize
+	}
+
+	if pcount > MaxDataSize {
+		return 0, ErrDataTooLarge
+	}
+
+	bitpix := int64(h.Bitpix())
+	if bitpix < 0 {
+		bitpix = -bitpix
+	}
+
+	size, err := multiply(bitpix/8, gcount)
+	if err != nil {
+		return 0, err
+	}
+
+	return multiply(size, pcount+n)
+}
+
+// elements returns the number of values in an array with the given axes, none of which are negative.
+func elements(axes []int) (int64, error) {
+	n := int64(1)
+
+	for _, a := range axes {
+		var err error
+
+		n, err = multiply(n, int64(a))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
+// multiply returns a*b, or ErrDataTooLarge if it is more than MaxDataSize. Neither may be negative.
+func multiply(a, b int64) (int64, error) {
+	if a != 0 && b > MaxDataSize/a {
+		return 0, ErrDataTooLarge
+	}
+
+	return a * b, nil
+}
+
+func padding(size int64) int {
+	return int((blockSize - size%blockSize) % blockSize)
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
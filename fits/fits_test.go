@@ -0,0 +1,385 @@
+package fits_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient/fits"
+)
+
+// header returns a header block holding records, each padded to 80 characters, followed by END.
+func header(records ...string) []byte {
+	var b bytes.Buffer
+
+	for _, r := range append(records, "END") {
+		b.WriteString(fmt.Sprintf("%-80s", r))
+	}
+
+	pad(&b)
+
+	return b.Bytes()
+}
+
+func pad(b *bytes.Buffer) {
+	for b.Len()%2880 != 0 {
+		b.WriteByte(' ')
+	}
+}
+
+func card(key, value string) string {
+	return fmt.Sprintf("%-8s= %20s", key, value)
+}
+
+func imageFile(t *testing.T, bitpix int, extra []string, pixels interface{}) []byte {
+	var b bytes.Buffer
+
+	b.Write(header(append([]string{
+		card("SIMPLE", "T"),
+		card("BITPIX", fmt.Sprint(bitpix)),
+		card("NAXIS", "2"),
+		card("NAXIS1", "3"),
+		card("NAXIS2", "2"),
+	}, extra...)...))
+
+	require.NoError(t, binary.Write(&b, binary.BigEndian, pixels))
+	pad(&b)
+
+	return b.Bytes()
+}
+
+func Test_DecodeHeader(t *testing.T) {
+	data := header(
+		card("SIMPLE", "T")+" / conforms to FITS standard",
+		card("BITPIX", "16"),
+		card("NAXIS", "2"),
+		card("NAXIS1", "4656"),
+		card("NAXIS2", "3520"),
+		card("EXPTIME", "1.20000000000000E+02")+" / Total Exposure Time (s)",
+		card("CCD-TEMP", "-1.0D+01"),
+		"FILTER  = 'Ha      '           / Filter",
+		"OBJECT  = 'Barnard''s Star'",
+		"DATE-OBS= '2020-01-02T03:04:05.678' / UTC start date of observation",
+		card("ROWORDER", "'TOP-DOWN'"),
+		"COMMENT Generated by INDI",
+		"HISTORY   calibrated",
+		"LONGSTR = 'a string that &'",
+		"CONTINUE  'goes on'           / and a comment",
+		card("UNDEF", ""),
+	)
+
+	h, err := fits.DecodeHeader(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, 16, h.Bitpix())
+	assert.Equal(t, []int{4656, 3520}, h.Naxis())
+
+	exptime, ok := h.Exptime()
+	assert.True(t, ok)
+	assert.Equal(t, 120.0, exptime)
+
+	temp, ok := h.CCDTemp()
+	assert.True(t, ok)
+	assert.Equal(t, -10.0, temp)
+
+	filter, ok := h.Filter()
+	assert.True(t, ok)
+	assert.Equal(t, "Ha", filter)
+
+	object, ok := h.String("object")
+	assert.True(t, ok)
+	assert.Equal(t, "Barnard's Star", object)
+
+	obs, ok := h.DateObs()
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 678000000, time.UTC), obs)
+
+	simple, ok := h.Bool("SIMPLE")
+	assert.True(t, ok)
+	assert.True(t, simple)
+
+	c, ok := h.Get("SIMPLE")
+	require.True(t, ok)
+	assert.Equal(t, "conforms to FITS standard", c.Comment)
+
+	c, ok = h.Get("COMMENT")
+	require.True(t, ok)
+	assert.Nil(t, c.Value)
+	assert.Equal(t, "Generated by INDI", c.Comment)
+
+	long, ok := h.String("LONGSTR")
+	assert.True(t, ok)
+	assert.Equal(t, "a string that goes on", long)
+
+	c, ok = h.Get("UNDEF")
+	require.True(t, ok)
+	assert.Nil(t, c.Value)
+
+	_, ok = h.Int("EXPTIME")
+	assert.True(t, ok)
+
+	_, ok = h.Float("FILTER")
+	assert.False(t, ok)
+
+	assert.False(t, h.Has("GAIN"))
+}
+
+func Test_DecodeHeader_Errors(t *testing.T) {
+	_, err := fits.DecodeHeader(bytes.NewReader(header(card("BITPIX", "16"))))
+	assert.Equal(t, fits.ErrNotFITS, err)
+
+	_, err = fits.DecodeHeader(strings.NewReader(""))
+	assert.Equal(t, fits.ErrNotFITS, err)
+
+	_, err = fits.DecodeHeader(bytes.NewReader(header(card("SIMPLE", "T"))[:1000]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = fits.DecodeHeader(bytes.NewReader(header(card("SIMPLE", "T"), "OBJECT  = 'M31")))
+	require.Error(t, err)
+
+	cardErr, ok := err.(*fits.CardError)
+	require.True(t, ok)
+	assert.Equal(t, "OBJECT", cardErr.Key)
+	assert.Equal(t, fits.ErrUnterminatedString, cardErr.Err)
+
+	// A header with no END is not read forever.
+	endless := &blanks{}
+
+	_, err = fits.DecodeHeader(io.MultiReader(strings.NewReader(card("SIMPLE", "T")), endless))
+	assert.Equal(t, fits.ErrNoEnd, err)
+	assert.True(t, endless.n < 1<<20)
+}
+
+// blanks is an endless reader of blank header cards, that counts how much has been read from it.
+type blanks struct {
+	n int64
+}
+
+func (b *blanks) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+
+	b.n += int64(len(p))
+
+	return len(p), nil
+}
+
+func Test_Decode_InvalidSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []string
+		expected error
+		imageErr error
+	}{
+		{"Negative NAXIS", []string{card("NAXIS", "-1")}, fits.ErrInvalidDataSize, fits.ErrInvalidDataSize},
+		{"Too Many Axes", []string{card("NAXIS", "1000")}, fits.ErrInvalidDataSize, fits.ErrInvalidDataSize},
+		{"Negative Axis", []string{card("NAXIS", "2"), card("NAXIS1", "-4"), card("NAXIS2", "2")}, fits.ErrInvalidDataSize, fits.ErrInvalidDataSize},
+		{"Huge", []string{card("NAXIS", "2"), card("NAXIS1", "1000000"), card("NAXIS2", "1000000")}, fits.ErrDataTooLarge, fits.ErrDataTooLarge},
+		{"Overflow", []string{card("NAXIS", "3"), card("NAXIS1", "4294967296"), card("NAXIS2", "4294967296"), card("NAXIS3", "4294967296")}, fits.ErrDataTooLarge, fits.ErrDataTooLarge},
+
+		// Image has no use for PCOUNT.
+		{"Negative PCOUNT", []string{card("NAXIS", "1"), card("NAXIS1", "4"), card("PCOUNT", "-8")}, fits.ErrInvalidDataSize, nil},
+		{"Huge PCOUNT", []string{card("NAXIS", "1"), card("NAXIS1", "4"), card("PCOUNT", "9223372036854775807")}, fits.ErrDataTooLarge, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := append([]string{card("SIMPLE", "T"), card("BITPIX", "16")}, tt.records...)
+
+			_, err := fits.Decode(bytes.NewReader(header(records...)))
+			assert.Equal(t, tt.expected, err)
+
+			// The same header on data that was decoded some other way.
+			h, err := fits.DecodeHeader(bytes.NewReader(header(records...)))
+			require.NoError(t, err)
+
+			hdu := fits.HDU{Header: h, Data: make([]byte, 16)}
+
+			_, err = hdu.Image()
+			assert.Equal(t, tt.imageErr, err)
+		})
+	}
+
+	h, err := fits.DecodeHeader(bytes.NewReader(header(card("SIMPLE", "T"), card("NAXIS", "-1"))))
+	require.NoError(t, err)
+	assert.Nil(t, h.Naxis())
+}
+
+func Test_Image_TooLarge(t *testing.T) {
+	defer func(max int64) { fits.MaxDataSize = max }(fits.MaxDataSize)
+	fits.MaxDataSize = 64
+
+	// 16 bytes of data is within the limit, but the 128 bytes of pixels Image would allocate for it are not.
+	data := append(header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "1"), card("NAXIS1", "16")), make([]byte, 2880)...)
+
+	f, err := fits.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	_, err = f.Primary().Image()
+	assert.Equal(t, fits.ErrDataTooLarge, err)
+}
+
+func Test_Image(t *testing.T) {
+	tests := []struct {
+		name   string
+		bitpix int
+		extra  []string
+		pixels interface{}
+		want   []float64
+	}{
+		{name: "8", bitpix: 8, pixels: []uint8{0, 1, 2, 253, 254, 255}, want: []float64{0, 1, 2, 253, 254, 255}},
+		{name: "16 unsigned", bitpix: 16, extra: []string{card("BZERO", "32768"), card("BSCALE", "1")},
+			pixels: []int16{-32768, -1, 0, 1, 100, 32767}, want: []float64{0, 32767, 32768, 32769, 32868, 65535}},
+		{name: "32", bitpix: 32, pixels: []int32{-100000, 0, 1, 2, 3, 100000}, want: []float64{-100000, 0, 1, 2, 3, 100000}},
+		{name: "64 scaled", bitpix: 64, extra: []string{card("BSCALE", "0.5")}, pixels: []int64{0, 1, 2, 3, 4, 5}, want: []float64{0, 0.5, 1, 1.5, 2, 2.5}},
+		{name: "float", bitpix: -32, pixels: []float32{0.5, 1, 1.5, 2, 2.5, 3}, want: []float64{0.5, 1, 1.5, 2, 2.5, 3}},
+		{name: "double", bitpix: -64, pixels: []float64{math.Pi, 1, 2, 3, 4, 5}, want: []float64{math.Pi, 1, 2, 3, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := fits.Decode(bytes.NewReader(imageFile(t, tt.bitpix, tt.extra, tt.pixels)))
+			require.NoError(t, err)
+			require.Len(t, f.HDUs, 1)
+
+			im, err := f.Primary().Image()
+			require.NoError(t, err)
+
+			assert.Equal(t, 3, im.Width())
+			assert.Equal(t, 2, im.Height())
+			assert.Equal(t, 1, im.Planes())
+			assert.Equal(t, tt.bitpix, im.Bitpix)
+			assert.Equal(t, tt.want, im.Pixels)
+			assert.Equal(t, tt.want[4], im.At(1, 1, 0))
+		})
+	}
+}
+
+func Test_Decode_Extensions(t *testing.T) {
+	var b bytes.Buffer
+
+	b.Write(header(card("SIMPLE", "T"), card("BITPIX", "8"), card("NAXIS", "0"), card("EXTEND", "T")))
+
+	b.Write(header(
+		card("XTENSION", "'IMAGE   '"),
+		card("BITPIX", "16"),
+		card("NAXIS", "2"),
+		card("NAXIS1", "3"),
+		card("NAXIS2", "2"),
+		card("PCOUNT", "0"),
+		card("GCOUNT", "1"),
+		card("EXTNAME", "'SCI'"),
+	))
+	require.NoError(t, binary.Write(&b, binary.BigEndian, []int16{1, 2, 3, 4, 5, 6}))
+	pad(&b)
+
+	// A table extension, which Image refuses. The padding at the end of the file is left out.
+	b.Write(header(
+		card("XTENSION", "'BINTABLE'"),
+		card("BITPIX", "8"),
+		card("NAXIS", "2"),
+		card("NAXIS1", "4"),
+		card("NAXIS2", "1"),
+		card("PCOUNT", "0"),
+		card("GCOUNT", "1"),
+	))
+	b.Write([]byte{0, 0, 0, 1})
+
+	data := b.Bytes()
+
+	f, err := fits.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, f.HDUs, 3)
+
+	assert.Empty(t, f.Primary().Data)
+
+	_, err = f.Primary().Image()
+	assert.Equal(t, fits.ErrNotImage, err)
+
+	name, _ := f.HDUs[1].Header.String("EXTNAME")
+	assert.Equal(t, "SCI", name)
+
+	im, err := f.HDUs[1].Image()
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1, 2, 3, 4, 5, 6}, im.Pixels)
+
+	_, err = f.HDUs[2].Image()
+	assert.Equal(t, fits.ErrNotImage, err)
+	assert.Equal(t, []byte{0, 0, 0, 1}, f.HDUs[2].Data)
+}
+
+func Test_RewriteHeader(t *testing.T) {
+	data := imageFile(t, 8, []string{
+		"OBJECT  = 'M31     '           / kept exactly as written",
+		card("FOCUSPOS", "100"),
+		"LONGSTR = 'a string that &'",
+		"CONTINUE  'goes on'",
+	}, []uint8{1, 2, 3, 4, 5, 6})
+
+	r, err := fits.RewriteHeader(bytes.NewReader(data), func(h *fits.Header) error {
+		h.Set("FOCUSPOS", 2500, "Focuser position")
+		h.Set("RA", 1e6, "")
+		h.Set("DEC", -45.0, "Declination")
+		h.Set("TRACKING", true, "")
+		h.Set("OBSERVER", "O'Brien", "")
+		h.Set("LONG", strings.Repeat("x", 100), "")
+		h.Delete("NAXIS9")
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	rewritten, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(rewritten), "OBJECT  = 'M31     '           / kept exactly as written")
+	assert.Contains(t, string(rewritten), fmt.Sprintf("%-80s%-80s", "LONGSTR = 'a string that &'", "CONTINUE  'goes on'"))
+	assert.Contains(t, string(rewritten), card("FOCUSPOS", "2500")+" / Focuser position")
+	assert.Contains(t, string(rewritten), card("RA", "1.E+06"))
+	assert.Contains(t, string(rewritten), card("DEC", "-45.")+" / Declination")
+	assert.Contains(t, string(rewritten), "OBSERVER= 'O''Brien'")
+
+	f, err := fits.Decode(bytes.NewReader(rewritten))
+	require.NoError(t, err)
+
+	h := f.Primary().Header
+
+	pos, _ := h.Int("FOCUSPOS")
+	assert.Equal(t, int64(2500), pos)
+
+	ra, _ := h.Float("RA")
+	assert.Equal(t, 1e6, ra)
+
+	tracking, _ := h.Bool("TRACKING")
+	assert.True(t, tracking)
+
+	observer, _ := h.String("OBSERVER")
+	assert.Equal(t, "O'Brien", observer)
+
+	long, _ := h.String("LONG")
+	assert.Equal(t, strings.Repeat("x", 66), long)
+
+	im, err := f.Primary().Image()
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1, 2, 3, 4, 5, 6}, im.Pixels)
+
+	// A file that is not FITS comes back as it was.
+	r, err = fits.RewriteHeader(strings.NewReader("not a FITS file"), func(h *fits.Header) error {
+		return nil
+	})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "not a FITS file", string(b))
+}
@@ -0,0 +1,310 @@
+package fits
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Card is a single keyword record of a header. Value is a string, bool, int64 or float64, depending on how it was
+// written, or nil for commentary keywords such as COMMENT and HISTORY, and keywords without a value.
+type Card struct {
+	Key     string
+	Value   interface{}
+	Comment string
+
+	// raw holds the records the card was read from, so it is written back exactly as it was unless it is changed.
+	raw []byte
+}
+
+// Header is the header of an HDU, holding its cards in the order they were written. The END card is left out.
+type Header struct {
+	Cards []Card
+}
+
+// Get returns the first card with the given keyword.
+func (h Header) Get(key string) (Card, bool) {
+	key = strings.ToUpper(key)
+
+	for _, c := range h.Cards {
+		if c.Key == key {
+			return c, true
+		}
+	}
+
+	return Card{}, false
+}
+
+// Has returns true if the header has a card with the given keyword.
+func (h Header) Has(key string) bool {
+	_, ok := h.Get(key)
+	return ok
+}
+
+// String returns the value of a keyword as a string. Values that are not strings are formatted the way they would be
+// written in a header.
+func (h Header) String(key string) (string, bool) {
+	c, ok := h.Get(key)
+	if !ok || c.Value == nil {
+		return "", false
+	}
+
+	switch v := c.Value.(type) {
+	case string:
+		return v, true
+	case bool:
+		if v {
+			return "T", true
+		}
+
+		return "F", true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'G', -1, 64), true
+	}
+
+	return "", false
+}
+
+// Int returns the value of a keyword as an integer. Floating point values are only returned if they are whole numbers.
+func (h Header) Int(key string) (int64, bool) {
+	c, ok := h.Get(key)
+	if !ok {
+		return 0, false
+	}
+
+	switch v := c.Value.(type) {
+	case int64:
+		return v, true
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), true
+		}
+	}
+
+	return 0, false
+}
+
+// Float returns the value of a keyword as a floating point number. Integer values are converted.
+func (h Header) Float(key string) (float64, bool) {
+	c, ok := h.Get(key)
+	if !ok {
+		return 0, false
+	}
+
+	switch v := c.Value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	}
+
+	return 0, false
+}
+
+// Bool returns the value of a logical keyword.
+func (h Header) Bool(key string) (bool, bool) {
+	c, ok := h.Get(key)
+	if !ok {
+		return false, false
+	}
+
+	v, ok := c.Value.(bool)
+
+	return v, ok
+}
+
+// timeLayouts are the forms of date FITS allows, which are ISO 8601 in UTC, with or without a time.
+var timeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// Time returns the value of a date keyword such as DATE-OBS, which is always UTC.
+func (h Header) Time(key string) (time.Time, bool) {
+	s, ok := h.String(key)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	s = strings.TrimSpace(s)
+
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		if err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Bitpix returns BITPIX, the number of bits per data value. Negative values are floating point.
+func (h Header) Bitpix() int {
+	v, _ := h.Int("BITPIX")
+	return int(v)
+}
+
+// maxAxes is the most axes FITS allows.
+const maxAxes = 999
+
+// Naxis returns the length of each axis, from NAXIS1 to NAXISn. For an image, that is the width and then the height.
+// It is nil if NAXIS is negative or more than 999.
+func (h Header) Naxis() []int {
+	n, _ := h.Int("NAXIS")
+	if n < 0 || n > maxAxes {
+		return nil
+	}
+
+	axes := make([]int, n)
+	for i := range axes {
+		v, _ := h.Int("NAXIS" + strconv.Itoa(i+1))
+		axes[i] = int(v)
+	}
+
+	return axes
+}
+
+// axes is like Naxis, but returns ErrInvalidDataSize if NAXIS or the length of an axis is out of range.
+func (h Header) axes() ([]int, error) {
+	n, _ := h.Int("NAXIS")
+	if n < 0 || n > maxAxes {
+		return nil, ErrInvalidDataSize
+	}
+
+	axes := h.Naxis()
+
+	for _, a := range axes {
+		if a < 0 {
+			return nil, ErrInvalidDataSize
+		}
+	}
+
+	return axes, nil
+}
+
+// Exptime returns EXPTIME, the exposure time in seconds.
+func (h Header) Exptime() (float64, bool) {
+	return h.Float("EXPTIME")
+}
+
+// CCDTemp returns CCD-TEMP, the temperature of the sensor in degrees Celsius.
+func (h Header) CCDTemp() (float64, bool) {
+	return h.Float("CCD-TEMP")
+}
+
+// Filter returns FILTER, the name of the filter in use.
+func (h Header) Filter() (string, bool) {
+	s, ok := h.String("FILTER")
+	return strings.TrimSpace(s), ok
+}
+
+// DateObs returns DATE-OBS, when the exposure started.
+func (h Header) DateObs() (time.Time, bool) {
+	return h.Time("DATE-OBS")
+}
+
+// parseCard parses a single 80 character record.
+func parseCard(record []byte) (Card, error) {
+	key := strings.TrimSpace(string(record[:8]))
+
+	c := Card{
+		Key: key,
+		raw: append([]byte(nil), record...),
+	}
+
+	// Only cards with "= " in columns 9 and 10 have a value. The rest, such as COMMENT and HISTORY, are all text.
+	if len(record) < 10 || record[8] != '=' || record[9] != ' ' {
+		if key == "COMMENT" || key == "HISTORY" || key == "" || key == "CONTINUE" {
+			c.Comment = strings.TrimRight(string(record[8:]), " ")
+		}
+
+		return c, nil
+	}
+
+	rest := string(record[10:])
+	trimmed := strings.TrimLeft(rest, " ")
+
+	if strings.HasPrefix(trimmed, "'") {
+		value, after, err := parseString(trimmed)
+		if err != nil {
+			return c, &CardError{Key: key, Err: err}
+		}
+
+		c.Value = value
+		c.Comment = parseComment(after)
+
+		return c, nil
+	}
+
+	raw := trimmed
+	comment := ""
+
+	if i := strings.Index(trimmed, "/"); i >= 0 {
+		raw = trimmed[:i]
+		comment = parseComment(trimmed[i:])
+	}
+
+	raw = strings.TrimSpace(raw)
+	c.Comment = comment
+
+	switch {
+	case raw == "":
+		// An undefined value.
+	case raw == "T":
+		c.Value = true
+	case raw == "F":
+		c.Value = false
+	default:
+		if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			c.Value = i
+			break
+		}
+
+		f, err := strconv.ParseFloat(strings.Replace(strings.ToUpper(raw), "D", "E", 1), 64)
+		if err != nil {
+			// Complex numbers, and values that are just wrong, are kept as written.
+			c.Value = raw
+			break
+		}
+
+		c.Value = f
+	}
+
+	return c, nil
+}
+
+// parseString parses a quoted string value, in which a quote is written as two quotes, and returns what follows it.
+// Trailing spaces are not significant, so are removed.
+func parseString(s string) (string, string, error) {
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		if s[i] != '\'' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+1 < len(s) && s[i+1] == '\'' {
+			b.WriteByte('\'')
+			i++
+
+			continue
+		}
+
+		return strings.TrimRight(b.String(), " "), s[i+1:], nil
+	}
+
+	return "", "", ErrUnterminatedString
+}
+
+func parseComment(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "/") {
+		return ""
+	}
+
+	return strings.TrimSpace(s[1:])
+}
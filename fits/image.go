@@ -0,0 +1,150 @@
+package fits
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Image is the image held by an HDU, with BSCALE and BZERO applied to every pixel.
+type Image struct {
+	// Naxis holds the length of each axis: the width, the height, and for a color image, the number of planes.
+	Naxis []int
+
+	// Bitpix is the BITPIX the image was stored with.
+	Bitpix int
+
+	// Pixels holds every pixel, a row at a time, starting at the bottom left, a plane at a time.
+	Pixels []float64
+}
+
+// Width returns the length of the first axis.
+func (im *Image) Width() int {
+	if len(im.Naxis) < 1 {
+		return 0
+	}
+
+	return im.Naxis[0]
+}
+
+// Height returns the length of the second axis, or 1 for an image with a single axis.
+func (im *Image) Height() int {
+	if len(im.Naxis) < 2 {
+		return 1
+	}
+
+	return im.Naxis[1]
+}
+
+// Planes returns the number of planes, which is 3 for an RGB image and 1 for a mono one.
+func (im *Image) Planes() int {
+	planes := 1
+	for i := 2; i < len(im.Naxis); i++ {
+		planes *= im.Naxis[i]
+	}
+
+	return planes
+}
+
+// At returns the pixel at x, y in a plane.
+func (im *Image) At(x, y, plane int) float64 {
+	return im.Pixels[(plane*im.Height()+y)*im.Width()+x]
+}
+
+// Plane returns the pixels of a single plane. The slice shares its memory with the image.
+func (im *Image) Plane(plane int) []float64 {
+	size := im.Width() * im.Height()
+	return im.Pixels[plane*size : (plane+1)*size]
+}
+
+// Image decodes the data of the HDU as an image. The primary HDU, and IMAGE extensions, hold images.
+func (hdu *HDU) Image() (*Image, error) {
+	h := hdu.Header
+
+	if xtension, ok := h.String("XTENSION"); ok && xtension != "IMAGE" {
+		return nil, ErrNotImage
+	}
+
+	axes, err := h.axes()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(axes) == 0 {
+		return nil, ErrNotImage
+	}
+
+	bitpix := h.Bitpix()
+
+	n, err := elements(axes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pixels are float64s, so an 8 bit image takes 8 times the memory its data does.
+	_, err = multiply(n, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(n)
+
+	bzero, _ := h.Float("BZERO")
+
+	bscale, ok := h.Float("BSCALE")
+	if !ok {
+		bscale = 1
+	}
+
+	data := hdu.Data
+
+	var width int
+
+	switch bitpix {
+	case 8:
+		width = 1
+	case 16:
+		width = 2
+	case 32, -32:
+		width = 4
+	case 64, -64:
+		width = 8
+	default:
+		return nil, ErrUnsupportedBitpix
+	}
+
+	if int64(len(data)) < n*int64(width) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	pixels := make([]float64, size)
+
+	for i := range pixels {
+		b := data[i*width:]
+
+		var v float64
+
+		switch bitpix {
+		case 8:
+			v = float64(b[0])
+		case 16:
+			v = float64(int16(binary.BigEndian.Uint16(b)))
+		case 32:
+			v = float64(int32(binary.BigEndian.Uint32(b)))
+		case 64:
+			v = float64(int64(binary.BigEndian.Uint64(b)))
+		case -32:
+			v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case -64:
+			v = math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+
+		pixels[i] = bzero + bscale*v
+	}
+
+	return &Image{
+		Naxis:  axes,
+		Bitpix: bitpix,
+		Pixels: pixels,
+	}, nil
+}
@@ -0,0 +1,154 @@
+package indiclient
+
+import (
+	"io"
+	"math"
+	"strings"
+
+	"github.com/rickbassham/logging"
+
+	"github.com/goastro/indiclient/fits"
+)
+
+// FITSKeyword maps a property element to the FITS keyword it is written to.
+type FITSKeyword struct {
+	Keyword string
+	Comment string
+
+	// Device is the device that defines the property. If it is empty, the first device that defines it is used.
+	Device   string
+	Property string
+
+	// Element is the element of the property. For a switch property, leave it empty to write the name of the switch
+	// that is on.
+	Element string
+
+	// Scale, if it is not 0, multiplies number values, for example by 15 to write RA in degrees rather than hours.
+	Scale float64
+
+	// Integer writes number values rounded to whole numbers.
+	Integer bool
+
+	// Value, if it is set, finds the value to write instead of Device, Property and Element. It returns false if there
+	// is none.
+	Value func(snapshot Snapshot) (interface{}, bool)
+}
+
+// DefaultFITSKeywords are the keywords NewFITSHeaderProcessor writes if it is given none: the pointing of the mount,
+// the position and temperature of the focuser, the filter, the site, and the weather.
+var DefaultFITSKeywords = []FITSKeyword{
+	{Keyword: "RA", Comment: "Right ascension of the mount (deg)", Property: "EQUATORIAL_EOD_COORD", Element: "RA", Scale: 15},
+	{Keyword: "DEC", Comment: "Declination of the mount (deg)", Property: "EQUATORIAL_EOD_COORD", Element: "DEC"},
+	{Keyword: "FOCUSPOS", Comment: "Focuser position (steps)", Property: "ABS_FOCUS_POSITION", Element: "FOCUS_ABSOLUTE_POSITION", Integer: true},
+	{Keyword: "FOCUSTEM", Comment: "Focuser temperature (C)", Property: "FOCUS_TEMPERATURE", Element: "TEMPERATURE"},
+	{Keyword: "FILTER", Comment: "Filter", Value: func(snapshot Snapshot) (interface{}, bool) {
+		filter := currentFilter(snapshot)
+		return filter, len(filter) > 0
+	}},
+	{Keyword: "SITELAT", Comment: "Latitude of the site (deg)", Property: "GEOGRAPHIC_COORD", Element: "LAT"},
+	{Keyword: "SITELONG", Comment: "Longitude of the site (deg)", Property: "GEOGRAPHIC_COORD", Element: "LONG"},
+	{Keyword: "SITEELEV", Comment: "Elevation of the site (m)", Property: "GEOGRAPHIC_COORD", Element: "ELEV"},
+	{Keyword: "AMBTEMP", Comment: "Ambient temperature (C)", Property: "WEATHER_PARAMETERS", Element: "WEATHER_TEMPERATURE"},
+	{Keyword: "HUMIDITY", Comment: "Relative humidity (%)", Property: "WEATHER_PARAMETERS", Element: "WEATHER_HUMIDITY"},
+	{Keyword: "PRESSURE", Comment: "Atmospheric pressure (hPa)", Property: "WEATHER_PARAMETERS", Element: "WEATHER_PRESSURE"},
+	{Keyword: "DEWPOINT", Comment: "Dew point (C)", Property: "WEATHER_PARAMETERS", Element: "WEATHER_DEWPOINT"},
+	{Keyword: "WINDSPD", Comment: "Wind speed (km/h)", Property: "WEATHER_PARAMETERS", Element: "WEATHER_WIND_SPEED"},
+}
+
+type fitsHeaderProcessor struct {
+	log      logging.Logger
+	keywords []FITSKeyword
+}
+
+// NewFITSHeaderProcessor returns a BlobProcessor that writes keywords into the header of every FITS BLOB, with the
+// values the client holds when the BLOB is received. Drivers don't know where the mount is pointing, or which filter
+// is in use, so this fills in what they leave out, replacing what they wrote for the same keywords. Keywords without
+// a value are left as they are, as are BLOBs that are not FITS files. A FITS BLOB whose header cannot be read is logged
+// to log and left as it is too, rather than losing the image. If keywords is nil, DefaultFITSKeywords is used.
+func NewFITSHeaderProcessor(log logging.Logger, keywords []FITSKeyword) BlobProcessor {
+	if keywords == nil {
+		keywords = DefaultFITSKeywords
+	}
+
+	return &fitsHeaderProcessor{
+		log:      log,
+		keywords: keywords,
+	}
+}
+
+func (p *fitsHeaderProcessor) ProcessBlob(info BlobInfo, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(info.Format) {
+	case ".fits", ".fit", ".fts":
+	default:
+		return r, nil
+	}
+
+	// A header that cannot be read is left as it was, rather than losing the image.
+	rewritten, err := fits.RewriteHeader(r, func(h *fits.Header) error {
+		for _, k := range p.keywords {
+			if v, ok := k.value(info.Snapshot); ok {
+				h.Set(k.Keyword, v, k.Comment)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		p.log.WithField("device", info.Device).WithField("property", info.Property).WithError(err).Warn("error in fits.RewriteHeader")
+	}
+
+	return rewritten, nil
+}
+
+// value returns the value of the keyword in snapshot.
+func (k FITSKeyword) value(snapshot Snapshot) (interface{}, bool) {
+	if k.Value != nil {
+		return k.Value(snapshot)
+	}
+
+	for _, d := range snapshot.Devices {
+		if len(k.Device) > 0 && d.Name != k.Device {
+			continue
+		}
+
+		if p, ok := d.NumberProperties[k.Property]; ok {
+			v, ok := p.Values[k.Element]
+			if !ok {
+				return nil, false
+			}
+
+			f, err := ParseNumber(v.Value)
+			if err != nil {
+				return nil, false
+			}
+
+			if k.Scale != 0 {
+				f *= k.Scale
+			}
+
+			if k.Integer {
+				return int64(math.Round(f)), true
+			}
+
+			return f, true
+		}
+
+		if p, ok := d.TextProperties[k.Property]; ok {
+			v, ok := p.Values[k.Element]
+			return v.Value, ok
+		}
+
+		if p, ok := d.SwitchProperties[k.Property]; ok {
+			if len(k.Element) == 0 {
+				active := p.active()
+				return active, len(active) > 0
+			}
+
+			v, ok := p.Values[k.Element]
+
+			return v.Value == SwitchStateOn, ok
+		}
+	}
+
+	return nil, false
+}
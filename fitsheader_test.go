@@ -0,0 +1,136 @@
+package indiclient_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/fits"
+)
+
+// fitsFile returns a FITS file holding a 2x1 8 bit image, with the given header records.
+func fitsFile(records ...string) []byte {
+	var b bytes.Buffer
+
+	for _, r := range append([]string{"SIMPLE  =                    T", "BITPIX  =                    8", "NAXIS   =                    2",
+		"NAXIS1  =                    2", "NAXIS2  =                    1"}, append(records, "END")...) {
+		b.WriteString(fmt.Sprintf("%-80s", r))
+	}
+
+	for b.Len()%2880 != 0 {
+		b.WriteByte(' ')
+	}
+
+	b.Write([]byte{1, 2})
+
+	for b.Len()%2880 != 0 {
+		b.WriteByte(0)
+	}
+
+	return b.Bytes()
+}
+
+// receiveFITS sends data as a BLOB from the camera, and returns the header of the BLOB the client stored.
+func receiveFITS(t *testing.T, keywords []indiclient.FITSKeyword, data []byte) fits.Header {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	c.SetBlobStore(indiclient.NewMemoryBlobStore())
+	c.SetBlobProcessor(indiclient.NewFITSHeaderProcessor(discardLog, keywords))
+
+	defineProperties(t, c, server, cameraDefXML+mountDefXML+focuserDefXML, 3)
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, setBlobXML(".fits", len(data), data))
+	nextEvent(t, s)
+
+	r, _, length, err := c.GetBlob("Camera", "CCD1", "CCD1")
+	require.NoError(t, err)
+	defer r.Close()
+
+	f, err := fits.Decode(r)
+	require.NoError(t, err)
+
+	prop, err := c.BlobProperty("Camera", "CCD1")
+	require.NoError(t, err)
+	assert.Equal(t, length, prop.Values["CCD1"].Size)
+
+	im, err := f.Primary().Image()
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1, 2}, im.Pixels)
+
+	return f.Primary().Header
+}
+
+func Test_FITSHeaderProcessor(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		h := receiveFITS(t, nil, fitsFile("FOCUSPOS=                   50", "OBJECT  = 'M31     '"))
+
+		dec, ok := h.Float("DEC")
+		assert.True(t, ok)
+		assert.Equal(t, 90.0, dec)
+
+		ra, ok := h.Float("RA")
+		assert.True(t, ok)
+		assert.Equal(t, 0.0, ra)
+
+		pos, ok := h.Int("FOCUSPOS")
+		assert.True(t, ok)
+		assert.Equal(t, int64(100), pos)
+
+		object, _ := h.String("OBJECT")
+		assert.Equal(t, "M31", object)
+
+		// There is no filter wheel, site or weather.
+		assert.False(t, h.Has("FILTER"))
+		assert.False(t, h.Has("SITELAT"))
+		assert.False(t, h.Has("AMBTEMP"))
+	})
+
+	t.Run("Keywords", func(t *testing.T) {
+		h := receiveFITS(t, []indiclient.FITSKeyword{
+			{Keyword: "DECDEG", Device: "Mount", Property: "EQUATORIAL_EOD_COORD", Element: "DEC", Integer: true},
+			{Keyword: "MISSING", Device: "Focuser", Property: "EQUATORIAL_EOD_COORD", Element: "DEC"},
+			{Keyword: "OBSERVER", Value: func(indiclient.Snapshot) (interface{}, bool) { return "Hubble", true }},
+		}, fitsFile())
+
+		dec, ok := h.Int("DECDEG")
+		assert.True(t, ok)
+		assert.Equal(t, int64(90), dec)
+
+		observer, _ := h.String("OBSERVER")
+		assert.Equal(t, "Hubble", observer)
+
+		assert.False(t, h.Has("MISSING"))
+		assert.False(t, h.Has("FOCUSPOS"))
+	})
+}
+
+func Test_FITSHeaderProcessor_NotFITS(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	fs := afero.NewMemMapFs()
+	c.SetBlobStore(indiclient.NewLatestBlobStore(fs))
+	c.SetBlobProcessor(indiclient.NewFITSHeaderProcessor(discardLog, nil))
+
+	defineProperties(t, c, server, cameraDefXML, 1)
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, setBlobXML(".fits", 5, []byte("hello")))
+	nextEvent(t, s)
+
+	b, err := afero.ReadFile(fs, "Camera_CCD1_CCD1.fits")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
@@ -11,17 +11,17 @@
 // calls and will return an error if something doesn't look right.
 package indiclient
 
-// TODO: Handle device timeouts
-
 import (
-	"encoding/base64"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
-	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +49,32 @@ var (
 
 	// ErrInvalidBlobEnable is returned when a value other than Only, Also, Never is specified for BlobEnable.
 	ErrInvalidBlobEnable = errors.New("invalid BlobEnable value")
+
+	// ErrNoValues is returned when an attempt to change a property does not specify any values.
+	ErrNoValues = errors.New("no values specified")
+
+	// ErrInvalidNumber is returned when a value cannot be parsed as an INDI number.
+	ErrInvalidNumber = errors.New("invalid number")
+
+	// ErrNotConnected is returned when a command is sent while the client is not connected to an INDI server.
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrWriteQueueFull is returned when a command cannot be queued because commands are being sent faster than the
+	// connection can take them.
+	ErrWriteQueueFull = errors.New("write queue full")
+
+	// ErrInvalidSwitchState is returned when a value other than On or Off is specified for a switch.
+	ErrInvalidSwitchState = errors.New("invalid SwitchState value")
+
+	// ErrUnknownElement is returned by Decoder.Decode for XML elements that are not part of the INDI protocol.
+	ErrUnknownElement = errors.New("unknown element")
+
+	// ErrBlobSizeMismatch is reported when a BLOB received does not have the size its oneBLOB said it would.
+	ErrBlobSizeMismatch = errors.New("blob size mismatch")
+
+	// ErrUnsafeBlobName is returned by the BlobStores of this package when a BLOB would be stored outside the root of
+	// their afero.Fs.
+	ErrUnsafeBlobName = errors.New("blob name is outside the store")
 )
 
 // PropertyState represents the current state of a property. "Idle", "Ok", "Busy", or "Alert".
@@ -116,6 +142,13 @@ type Dialer interface {
 	Dial(network, address string) (io.ReadWriteCloser, error)
 }
 
+// ContextDialer is a Dialer that can also be canceled. ConnectContext uses DialContext when the client's Dialer
+// implements it.
+type ContextDialer interface {
+	Dialer
+	DialContext(ctx context.Context, network, address string) (io.ReadWriteCloser, error)
+}
+
 // NetworkDialer is an implementation of Dialer that uses the built-in net package.
 type NetworkDialer struct{}
 
@@ -124,20 +157,38 @@ func (NetworkDialer) Dial(network, address string) (io.ReadWriteCloser, error) {
 	return net.Dial(network, address)
 }
 
+// DialContext connects to the address on the named network, giving up when ctx is done.
+func (NetworkDialer) DialContext(ctx context.Context, network, address string) (io.ReadWriteCloser, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
 // INDIClient is the struct used to keep a connection alive to an indiserver.
 type INDIClient struct {
 	log        logging.Logger
 	dialer     Dialer
-	fs         afero.Fs
+	blobs      BlobStore
+	processor  BlobProcessor
 	bufferSize int
 
-	conn io.ReadWriteCloser
+	stepValidation bool
+	waitGrace      time.Duration
+
+	connMu  sync.Mutex
+	sess    *session
+	network string
+	address string
 
-	write chan interface{}
-	read  chan interface{}
+	reconnect    *ReconnectPolicy
+	reconnecting *reconnection
 
-	devices     sync.Map
-	blobStreams sync.Map
+	devices       deviceStore
+	blobStreams   sync.Map
+	subscriptions sync.Map
+
+	replayMu            sync.Mutex
+	requestedProperties map[propertyKey]GetProperties
+	enabledBlobs        map[propertyKey]EnableBlob
 }
 
 // NewINDIClient creates a client to connect to an INDI server.
@@ -145,79 +196,171 @@ func NewINDIClient(log logging.Logger, dialer Dialer, fs afero.Fs, bufferSize in
 	return &INDIClient{
 		log:         log,
 		dialer:      dialer,
-		devices:     sync.Map{},
 		blobStreams: sync.Map{},
-		fs:          fs,
+		blobs:       NewLatestBlobStore(fs),
 		bufferSize:  bufferSize,
+		waitGrace:   DefaultWaitGracePeriod,
 	}
 }
 
+// SetStepValidation controls whether SetNumberValue and friends reject values that are not a whole number of Steps
+// from Min. It is disabled by default, since libindi drivers use Step only as a hint for GUI spinners: cameras define
+// CCD_EXPOSURE_VALUE with a min of 0.01 and a step of 1, and focusers FOCUS_ABSOLUTE_POSITION with a step of 1000, but
+// accept any value between Min and Max. Min and Max are always checked. This should be called before Connect.
+func (c *INDIClient) SetStepValidation(enabled bool) {
+	c.stepValidation = enabled
+}
+
+// StepValidation returns true if SetNumberValue and friends reject values that are not a whole number of Steps from
+// Min. See SetStepValidation.
+func (c *INDIClient) StepValidation() bool {
+	return c.stepValidation
+}
+
 // Connect dials to create a connection to address. address should be in the format that the provided Dialer expects.
 func (c *INDIClient) Connect(network, address string) error {
-	conn, err := c.dialer.Dial(network, address)
+	return c.ConnectContext(context.Background(), network, address)
+}
+
+// ConnectContext is like Connect, but gives up dialing when ctx is done. If already connected, the current connection
+// is closed first.
+func (c *INDIClient) ConnectContext(ctx context.Context, network, address string) error {
+	c.stopReconnecting()
+	c.clearReplay()
+
+	return c.connect(ctx, network, address)
+}
+
+func (c *INDIClient) connect(ctx context.Context, network, address string) error {
+	conn, err := c.dial(ctx, network, address)
 	if err != nil {
 		return err
 	}
 
-	// Clear out all devices
-	c.delProperty(&DelProperty{})
+	sess := newSession(conn, c.bufferSize)
 
-	c.conn = conn
+	c.connMu.Lock()
 
-	c.read = make(chan interface{}, c.bufferSize)
-	c.write = make(chan interface{}, c.bufferSize)
+	// ctx is checked while holding the lock so that stopReconnecting can reliably cancel a reconnection.
+	if ctx.Err() != nil {
+		c.connMu.Unlock()
+		conn.Close()
 
-	c.startRead()
-	c.startWrite()
+		return ctx.Err()
+	}
 
-	return nil
-}
+	old := c.sess
+	c.sess = sess
+	c.network = network
+	c.address = address
+
+	c.connMu.Unlock()
+
+	if old != nil {
+		old.shutdown()
+	}
 
-// Disconnect clears out all devices from memory, closes the connection, and closes the read and write channels.
-func (c *INDIClient) Disconnect() error {
 	// Clear out all devices
 	c.delProperty(&DelProperty{})
 
-	if c.conn == nil {
-		return nil
+	c.startRead(sess)
+	c.startWrite(sess)
+
+	return nil
+}
+
+func (c *INDIClient) dial(ctx context.Context, network, address string) (io.ReadWriteCloser, error) {
+	if d, ok := c.dialer.(ContextDialer); ok {
+		return d.DialContext(ctx, network, address)
+	}
+
+	type result struct {
+		conn io.ReadWriteCloser
+		err  error
 	}
 
-	err := c.conn.Close()
-	c.conn = nil
+	dialed := make(chan result, 1)
 
-	if c.read != nil {
-		close(c.read)
-		c.read = nil
+	go func() {
+		conn, err := c.dialer.Dial(network, address)
+		dialed <- result{conn, err}
+	}()
+
+	select {
+	case r := <-dialed:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			// Don't leak a connection that shows up after we have given up on it.
+			if r := <-dialed; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+
+		return nil, ctx.Err()
 	}
+}
 
-	if c.write != nil {
-		close(c.write)
-		c.write = nil
+// Disconnect closes the connection and clears out all devices from memory. Commands already queued are given a
+// moment to be written before the connection is closed, and Disconnect returns once the goroutines writing commands
+// and dispatching messages have stopped. Any reconnection in progress is stopped.
+func (c *INDIClient) Disconnect() error {
+	c.stopReconnecting()
+
+	c.connMu.Lock()
+	sess := c.sess
+	c.sess = nil
+	c.connMu.Unlock()
+
+	var err error
+	if sess != nil {
+		err = sess.shutdown()
 	}
 
+	// Clear out all devices
+	c.delProperty(&DelProperty{})
+
 	return err
 }
 
+// Close implements io.Closer. It is the same as Disconnect.
+func (c *INDIClient) Close() error {
+	return c.Disconnect()
+}
+
 // IsConnected returns true if the client is currently connected to an INDI server. Otherwise, returns false.
 func (c *INDIClient) IsConnected() bool {
-	if c.conn != nil {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.sess != nil {
 		return true
 	}
 
 	return false
 }
 
-// Devices returns the current list of INDI devices with their current state.
-func (c *INDIClient) Devices() []Device {
-	devices := []Device{}
+// Done returns a channel that is closed when the current connection ends, whether by Disconnect or because it was
+// lost. If the client is not connected, the channel returned is already closed.
+func (c *INDIClient) Done() <-chan struct{} {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
 
-	c.devices.Range(func(key, value interface{}) bool {
-		devices = append(devices, value.(Device))
+	if c.sess == nil {
+		done := make(chan struct{})
+		close(done)
 
-		return true
-	})
+		return done
+	}
+
+	return c.sess.done
+}
 
-	return devices
+// Devices returns the current list of INDI devices with their current state, sorted by name. The devices returned are
+// deep copies, so they are safe to use while the client keeps receiving updates. Use Snapshot to also get the revision
+// they were copied at.
+func (c *INDIClient) Devices() []Device {
+	return c.devices.snapshot().Devices
 }
 
 // GetBlob finds a BLOB with the given deviceName, propName, blobName. Be sure to close rdr when you are done with it.
@@ -239,7 +382,7 @@ func (c *INDIClient) GetBlob(deviceName, propName, blobName string) (rdr io.Read
 		return
 	}
 
-	rdr, err = c.fs.Open(val.Value)
+	rdr, err = c.blobs.Open(val.Value)
 	if err != nil {
 		return
 	}
@@ -253,6 +396,9 @@ func (c *INDIClient) GetBlob(deviceName, propName, blobName string) (rdr io.Read
 // GetBlobStream finds a BLOB with the given deviceName, propName, blobName. This will return an io.Pipe that can stream the BLOBs that are received from the indiserver.
 // The client will keep track of all open streams and write to them as blobs are received from indiserver. Remember to call CloseBlobStream when you are done. If you don't,
 // all blobs received for that device, property, blob will fail to write once the reader is closed.
+//
+// An unread stream holds up everything the client receives, and a stream has no way to tell where one BLOB ends and
+// the next begins. HandleBlobs has neither problem.
 func (c *INDIClient) GetBlobStream(deviceName, propName, blobName string) (rdr io.ReadCloser, id string, err error) {
 	device, err := c.findDevice(deviceName)
 	if err != nil {
@@ -341,9 +487,9 @@ func (c *INDIClient) GetProperties(deviceName, propName string) error {
 		Name:    propName,
 	}
 
-	c.write <- cmd
+	c.recordGetProperties(cmd)
 
-	return nil
+	return c.send(cmd)
 }
 
 // EnableBlob sends a command to the INDI server to enable/disable BLOBs for the current connection.
@@ -365,209 +511,367 @@ func (c *INDIClient) EnableBlob(deviceName, propName string, val BlobEnable) err
 		Value:  val,
 	}
 
-	c.write <- cmd
+	c.recordEnableBlob(cmd)
 
-	return nil
+	return c.send(cmd)
 }
 
-// SetTextValue sends a command to the INDI server to change the value of a textVector.
-func (c *INDIClient) SetTextValue(deviceName, propName, textName, textValue string) error {
-	device, err := c.findDevice(deviceName)
-	if err != nil {
-		return err
-	}
-
-	prop, ok := device.TextProperties[propName]
-	if !ok {
-		return ErrPropertyNotFound
-	}
+// BlobEnabled returns the setting last sent with EnableBlob for a property, or for its whole device if there was none
+// for the property. It is BlobEnableNever, the INDI default, if EnableBlob has not been called for either since
+// Connect.
+func (c *INDIClient) BlobEnabled(deviceName, propName string) BlobEnable {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
 
-	if prop.Permissions == PropertyPermissionReadOnly {
-		return ErrPropertyReadOnly
+	if cmd, ok := c.enabledBlobs[propertyKey{device: deviceName, name: propName}]; ok {
+		return cmd.Value
 	}
 
-	_, ok = prop.Values[textName]
-	if !ok {
-		return ErrPropertyValueNotFound
+	if cmd, ok := c.enabledBlobs[propertyKey{device: deviceName}]; ok {
+		return cmd.Value
 	}
 
-	prop.State = PropertyStateBusy
+	return BlobEnableNever
+}
 
-	device.TextProperties[propName] = prop
+// SetTextValue sends a command to the INDI server to change the value of a textVector.
+func (c *INDIClient) SetTextValue(deviceName, propName, textName, textValue string) error {
+	return c.SetTextValues(deviceName, propName, map[string]string{textName: textValue})
+}
 
-	c.devices.Store(deviceName, device)
+// SetTextValues sends a single command to the INDI server to change several values of a textVector at once. values
+// maps the name of each text to its new value.
+func (c *INDIClient) SetTextValues(deviceName, propName string, values map[string]string) error {
+	if len(values) == 0 {
+		return ErrNoValues
+	}
 
 	cmd := NewTextVector{
 		Device: deviceName,
 		Name:   propName,
-		Texts: []OneText{
-			{
-				Name:  textName,
-				Value: textValue,
-			},
-		},
 	}
 
-	c.write <- cmd
+	var state PropertyState
 
-	return nil
-}
+	_, err := c.devices.update(deviceName, false, func(device *Device) error {
+		prop, ok := device.TextProperties[propName]
+		if !ok {
+			return ErrPropertyNotFound
+		}
 
-// SetNumberValue sends a command to the INDI server to change the value of a numberVector.
-func (c *INDIClient) SetNumberValue(deviceName, propName, NumberName, NumberValue string) error {
-	device, err := c.findDevice(deviceName)
+		if prop.Permissions == PropertyPermissionReadOnly {
+			return ErrPropertyReadOnly
+		}
+
+		for _, textName := range sortedKeys(values) {
+			_, ok = prop.Values[textName]
+			if !ok {
+				return ErrPropertyValueNotFound
+			}
+
+			cmd.Texts = append(cmd.Texts, OneText{
+				Name:  textName,
+				Value: values[textName],
+			})
+		}
+
+		state = prop.State
+		prop.State = PropertyStateBusy
+
+		device.TextProperties[propName] = prop
+
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	prop, ok := device.NumberProperties[propName]
-	if !ok {
-		return ErrPropertyNotFound
-	}
-
-	if prop.Permissions == PropertyPermissionReadOnly {
-		return ErrPropertyReadOnly
-	}
+	err = c.send(cmd)
+	if err != nil {
+		c.devices.update(deviceName, false, func(device *Device) error {
+			if prop, ok := device.TextProperties[propName]; ok {
+				prop.State = state
+				device.TextProperties[propName] = prop
+			}
 
-	_, ok = prop.Values[NumberName]
-	if !ok {
-		return ErrPropertyValueNotFound
+			return nil
+		})
 	}
 
-	prop.State = PropertyStateBusy
+	return err
+}
 
-	device.NumberProperties[propName] = prop
+// SetNumberValue sends a command to the INDI server to change the value of a numberVector.
+func (c *INDIClient) SetNumberValue(deviceName, propName, NumberName, NumberValue string) error {
+	return c.SetNumberValues(deviceName, propName, map[string]string{NumberName: NumberValue})
+}
 
-	c.devices.Store(deviceName, device)
+// SetNumberValues sends a single command to the INDI server to change several values of a numberVector at once.
+// values maps the name of each number to its new value. Many drivers require this, for example EQUATORIAL_EOD_COORD
+// expects RA and DEC together.
+//
+// Values may be given in any format ParseNumber accepts, and are sent as plain decimals. An error wrapping
+// ErrInvalidNumber is returned for values that cannot be parsed, and a *NumberRangeError for values outside the Min,
+// Max, and Step the device defined.
+func (c *INDIClient) SetNumberValues(deviceName, propName string, values map[string]string) error {
+	if len(values) == 0 {
+		return ErrNoValues
+	}
 
 	cmd := NewNumberVector{
 		Device: deviceName,
 		Name:   propName,
-		Numbers: []OneNumber{
-			{
-				Name:  NumberName,
-				Value: NumberValue,
-			},
-		},
 	}
 
-	c.write <- cmd
+	var state PropertyState
 
-	return nil
-}
+	_, err := c.devices.update(deviceName, false, func(device *Device) error {
+		prop, ok := device.NumberProperties[propName]
+		if !ok {
+			return ErrPropertyNotFound
+		}
 
-// SetSwitchValue sends a command to the INDI server to change the value of a switchVector.
-// Note that you will ususally set the desired property on SwitchStateOn, and let the device
-// decide how to switch the other values off.
-func (c *INDIClient) SetSwitchValue(deviceName, propName, switchName string, switchValue SwitchState) error {
-	device, err := c.findDevice(deviceName)
+		if prop.Permissions == PropertyPermissionReadOnly {
+			return ErrPropertyReadOnly
+		}
+
+		for _, numberName := range sortedKeys(values) {
+			nv, ok := prop.Values[numberName]
+			if !ok {
+				return ErrPropertyValueNotFound
+			}
+
+			v, err := ParseNumber(values[numberName])
+			if err != nil {
+				return err
+			}
+
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%w: %q", ErrInvalidNumber, values[numberName])
+			}
+
+			if rangeErr := nv.checkRange(v, c.stepValidation); rangeErr != nil {
+				rangeErr.Device = deviceName
+				rangeErr.Property = propName
+				return rangeErr
+			}
+
+			cmd.Numbers = append(cmd.Numbers, OneNumber{
+				Name:  numberName,
+				Value: strconv.FormatFloat(v, 'f', -1, 64),
+			})
+		}
+
+		state = prop.State
+		prop.State = PropertyStateBusy
+
+		device.NumberProperties[propName] = prop
+
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	prop, ok := device.SwitchProperties[propName]
-	if !ok {
-		return ErrPropertyNotFound
-	}
-
-	if prop.Permissions == PropertyPermissionReadOnly {
-		return ErrPropertyReadOnly
-	}
+	err = c.send(cmd)
+	if err != nil {
+		c.devices.update(deviceName, false, func(device *Device) error {
+			if prop, ok := device.NumberProperties[propName]; ok {
+				prop.State = state
+				device.NumberProperties[propName] = prop
+			}
 
-	_, ok = prop.Values[switchName]
-	if !ok {
-		return ErrPropertyValueNotFound
+			return nil
+		})
 	}
 
-	prop.State = PropertyStateBusy
+	return err
+}
 
-	device.SwitchProperties[propName] = prop
+// SetSwitchValue sends a command to the INDI server to change the value of a switchVector.
+// Note that you will usually set the desired switch to SwitchStateOn. For OneOfMany and AtMostOne
+// vectors, the other switches are sent as SwitchStateOff in the same command.
+func (c *INDIClient) SetSwitchValue(deviceName, propName, switchName string, switchValue SwitchState) error {
+	return c.SetSwitchValues(deviceName, propName, map[string]SwitchState{switchName: switchValue})
+}
 
-	c.devices.Store(deviceName, device)
+// SetSwitchValues sends a single command to the INDI server to change several values of a switchVector at once.
+// values maps the name of each switch to its new state.
+//
+// The Rule of the vector is enforced before anything is sent, and a *SwitchRuleError is returned if it would be
+// broken. A OneOfMany vector is always sent in full with exactly one switch On, and turning a switch On in an
+// AtMostOne vector explicitly turns the others Off.
+func (c *INDIClient) SetSwitchValues(deviceName, propName string, values map[string]SwitchState) error {
+	if len(values) == 0 {
+		return ErrNoValues
+	}
 
 	cmd := NewSwitchVector{
 		Device: deviceName,
 		Name:   propName,
-		Switches: []OneSwitch{
-			{
+	}
+
+	var state PropertyState
+
+	_, err := c.devices.update(deviceName, false, func(device *Device) error {
+		prop, ok := device.SwitchProperties[propName]
+		if !ok {
+			return ErrPropertyNotFound
+		}
+
+		if prop.Permissions == PropertyPermissionReadOnly {
+			return ErrPropertyReadOnly
+		}
+
+		resolved, err := prop.resolve(values)
+		if err != nil {
+			if ruleErr, ok := err.(*SwitchRuleError); ok {
+				ruleErr.Device = deviceName
+				ruleErr.Property = propName
+			}
+
+			return err
+		}
+
+		names := make([]string, 0, len(resolved))
+		for name := range resolved {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, switchName := range names {
+			cmd.Switches = append(cmd.Switches, OneSwitch{
 				Name:  switchName,
-				Value: switchValue,
-			},
-		},
+				Value: resolved[switchName],
+			})
+		}
+
+		state = prop.State
+		prop.State = PropertyStateBusy
+
+		device.SwitchProperties[propName] = prop
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	c.write <- cmd
+	err = c.send(cmd)
+	if err != nil {
+		c.devices.update(deviceName, false, func(device *Device) error {
+			if prop, ok := device.SwitchProperties[propName]; ok {
+				prop.State = state
+				device.SwitchProperties[propName] = prop
+			}
 
-	return nil
+			return nil
+		})
+	}
+
+	return err
 }
 
 // SetBlobValue sends a command to the INDI server to change the value of a blobVector.
 func (c *INDIClient) SetBlobValue(deviceName, propName, blobName, blobValue, blobFormat string, blobSize int) error {
-	device, err := c.findDevice(deviceName)
-	if err != nil {
-		return err
-	}
+	return c.SetBlobValues(deviceName, propName, []OneBlob{
+		{
+			Name:   blobName,
+			Value:  blobValue,
+			Size:   blobSize,
+			Format: blobFormat,
+		},
+	})
+}
 
-	prop, ok := device.BlobProperties[propName]
-	if !ok {
-		return ErrPropertyNotFound
+// SetBlobValues sends a single command to the INDI server to change several values of a blobVector at once. Each
+// OneBlob names the blob it changes, and its Value must already be base64 encoded.
+func (c *INDIClient) SetBlobValues(deviceName, propName string, blobs []OneBlob) error {
+	if len(blobs) == 0 {
+		return ErrNoValues
 	}
 
-	if prop.Permissions == PropertyPermissionReadOnly {
-		return ErrPropertyReadOnly
+	cmd := NewBlobVector{
+		Device: deviceName,
+		Name:   propName,
+		Blobs:  blobs,
 	}
 
-	_, ok = prop.Values[blobName]
-	if !ok {
-		return ErrPropertyValueNotFound
+	var state PropertyState
+
+	_, err := c.devices.update(deviceName, false, func(device *Device) error {
+		prop, ok := device.BlobProperties[propName]
+		if !ok {
+			return ErrPropertyNotFound
+		}
+
+		if prop.Permissions == PropertyPermissionReadOnly {
+			return ErrPropertyReadOnly
+		}
+
+		for _, blob := range blobs {
+			_, ok = prop.Values[blob.Name]
+			if !ok {
+				return ErrPropertyValueNotFound
+			}
+		}
+
+		state = prop.State
+		prop.State = PropertyStateBusy
+
+		device.BlobProperties[propName] = prop
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	prop.State = PropertyStateBusy
+	err = c.send(cmd)
+	if err != nil {
+		c.devices.update(deviceName, false, func(device *Device) error {
+			if prop, ok := device.BlobProperties[propName]; ok {
+				prop.State = state
+				device.BlobProperties[propName] = prop
+			}
 
-	device.BlobProperties[propName] = prop
+			return nil
+		})
+	}
 
-	c.devices.Store(deviceName, device)
+	return err
+}
 
-	cmd := NewBlobVector{
-		Device: deviceName,
-		Name:   propName,
-		Blobs: []OneBlob{
-			{
-				Name:   blobName,
-				Value:  blobValue,
-				Size:   blobSize,
-				Format: blobFormat,
-			},
-		},
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
 
-	c.write <- cmd
+	sort.Strings(keys)
 
-	return nil
+	return keys
 }
 
+// findDevice returns the stored device with the given name. It is shared with the device store, so it must not be
+// modified or handed to callers outside the package.
 func (c *INDIClient) findDevice(name string) (Device, error) {
-	if d, ok := c.devices.Load(name); ok {
-		return d.(Device), nil
+	if d, ok := c.devices.load(name); ok {
+		return d, nil
 	}
 
 	return Device{}, ErrDeviceNotFound
 }
 
-func (c *INDIClient) findOrCreateDevice(name string) Device {
-	device, err := c.findDevice(name)
+// logUpdateError logs why a set*Vector or message could not be applied.
+func (c *INDIClient) logUpdateError(deviceName, propName string, err error) {
 	if err == ErrDeviceNotFound {
-		device = Device{
-			Name:             name,
-			TextProperties:   map[string]TextProperty{},
-			SwitchProperties: map[string]SwitchProperty{},
-			NumberProperties: map[string]NumberProperty{},
-			LightProperties:  map[string]LightProperty{},
-			BlobProperties:   map[string]BlobProperty{},
-		}
+		c.log.WithField("device", deviceName).WithError(err).Warn("could not find device")
+		return
 	}
 
-	return device
+	c.log.WithField("device", deviceName).WithField("property", propName).Warn("could not find property")
 }
 
 type indiMessageHandler interface {
@@ -580,19 +884,18 @@ type indiMessageHandler interface {
 	setTextVector(item *SetTextVector)
 	setNumberVector(item *SetNumberVector)
 	setLightVector(item *SetLightVector)
-	setBlobVector(item *SetBlobVector)
+	setBlobVector(item *SetBlobVector, written map[string]BlobValue)
 	message(item *Message)
 	delProperty(item *DelProperty)
 }
 
 func (c *INDIClient) defTextVector(item *DefTextVector) {
-	device := c.findOrCreateDevice(item.Device)
-
 	prop := TextProperty{
 		Name:        item.Name,
 		Label:       item.Label,
 		Group:       item.Group,
 		Permissions: item.Perm,
+		Timeout:     item.Timeout,
 		State:       item.State,
 		Values:      map[string]TextValue{},
 		LastUpdated: time.Now(),
@@ -608,25 +911,45 @@ func (c *INDIClient) defTextVector(item *DefTextVector) {
 	}
 
 	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
+		prop.Messages = appendMessage(prop.Messages, MessageJSON{
 			Message:   item.Message,
 			Timestamp: time.Now(),
 		})
 	}
 
-	device.TextProperties[item.Name] = prop
+	var old TextProperty
+	var existed bool
+
+	created, _ := c.devices.update(item.Device, true, func(device *Device) error {
+		old, existed = device.TextProperties[item.Name]
+		device.TextProperties[item.Name] = prop
+
+		return nil
+	})
+
+	e := Event{
+		Type:     EventPropertyDefined,
+		Device:   item.Device,
+		Property: item.Name,
+		Kind:     PropertyKindText,
+		New:      prop,
+		Message:  item.Message,
+	}
+
+	if existed {
+		e.Old = old
+	}
 
-	c.devices.Store(item.Device, device)
+	c.publishDefinition(created, e)
 }
 
 func (c *INDIClient) defSwitchVector(item *DefSwitchVector) {
-	device := c.findOrCreateDevice(item.Device)
-
 	prop := SwitchProperty{
 		Name:        item.Name,
 		Label:       item.Label,
 		Group:       item.Group,
 		Permissions: item.Perm,
+		Timeout:     item.Timeout,
 		Rule:        item.Rule,
 		State:       item.State,
 		Values:      map[string]SwitchValue{},
@@ -643,25 +966,45 @@ func (c *INDIClient) defSwitchVector(item *DefSwitchVector) {
 	}
 
 	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
+		prop.Messages = appendMessage(prop.Messages, MessageJSON{
 			Message:   item.Message,
 			Timestamp: time.Now(),
 		})
 	}
 
-	device.SwitchProperties[item.Name] = prop
+	var old SwitchProperty
+	var existed bool
+
+	created, _ := c.devices.update(item.Device, true, func(device *Device) error {
+		old, existed = device.SwitchProperties[item.Name]
+		device.SwitchProperties[item.Name] = prop
+
+		return nil
+	})
+
+	e := Event{
+		Type:     EventPropertyDefined,
+		Device:   item.Device,
+		Property: item.Name,
+		Kind:     PropertyKindSwitch,
+		New:      prop,
+		Message:  item.Message,
+	}
+
+	if existed {
+		e.Old = old
+	}
 
-	c.devices.Store(item.Device, device)
+	c.publishDefinition(created, e)
 }
 
 func (c *INDIClient) defNumberVector(item *DefNumberVector) {
-	device := c.findOrCreateDevice(item.Device)
-
 	prop := NumberProperty{
 		Name:        item.Name,
 		Label:       item.Label,
 		Group:       item.Group,
 		Permissions: item.Perm,
+		Timeout:     item.Timeout,
 		State:       item.State,
 		Values:      map[string]NumberValue{},
 		LastUpdated: time.Now(),
@@ -681,22 +1024,41 @@ func (c *INDIClient) defNumberVector(item *DefNumberVector) {
 	}
 
 	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
+		prop.Messages = appendMessage(prop.Messages, MessageJSON{
 			Message:   item.Message,
 			Timestamp: time.Now(),
 		})
 	}
 
-	device.NumberProperties[item.Name] = prop
+	var old NumberProperty
+	var existed bool
 
-	c.devices.Store(item.Device, device)
-}
+	created, _ := c.devices.update(item.Device, true, func(device *Device) error {
+		old, existed = device.NumberProperties[item.Name]
+		device.NumberProperties[item.Name] = prop
 
-func (c *INDIClient) defLightVector(item *DefLightVector) {
-	device := c.findOrCreateDevice(item.Device)
+		return nil
+	})
 
-	prop := LightProperty{
-		Name:        item.Name,
+	e := Event{
+		Type:     EventPropertyDefined,
+		Device:   item.Device,
+		Property: item.Name,
+		Kind:     PropertyKindNumber,
+		New:      prop,
+		Message:  item.Message,
+	}
+
+	if existed {
+		e.Old = old
+	}
+
+	c.publishDefinition(created, e)
+}
+
+func (c *INDIClient) defLightVector(item *DefLightVector) {
+	prop := LightProperty{
+		Name:        item.Name,
 		Label:       item.Label,
 		Group:       item.Group,
 		State:       item.State,
@@ -714,24 +1076,45 @@ func (c *INDIClient) defLightVector(item *DefLightVector) {
 	}
 
 	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
+		prop.Messages = appendMessage(prop.Messages, MessageJSON{
 			Message:   item.Message,
 			Timestamp: time.Now(),
 		})
 	}
 
-	device.LightProperties[item.Name] = prop
+	var old LightProperty
+	var existed bool
+
+	created, _ := c.devices.update(item.Device, true, func(device *Device) error {
+		old, existed = device.LightProperties[item.Name]
+		device.LightProperties[item.Name] = prop
 
-	c.devices.Store(item.Device, device)
+		return nil
+	})
+
+	e := Event{
+		Type:     EventPropertyDefined,
+		Device:   item.Device,
+		Property: item.Name,
+		Kind:     PropertyKindLight,
+		New:      prop,
+		Message:  item.Message,
+	}
+
+	if existed {
+		e.Old = old
+	}
+
+	c.publishDefinition(created, e)
 }
 
 func (c *INDIClient) defBlobVector(item *DefBlobVector) {
-	device := c.findOrCreateDevice(item.Device)
-
 	prop := BlobProperty{
 		Name:        item.Name,
 		Label:       item.Label,
 		Group:       item.Group,
+		Permissions: item.Perm,
+		Timeout:     item.Timeout,
 		State:       item.State,
 		Values:      map[string]BlobValue{},
 		LastUpdated: time.Now(),
@@ -746,359 +1129,645 @@ func (c *INDIClient) defBlobVector(item *DefBlobVector) {
 	}
 
 	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
+		prop.Messages = appendMessage(prop.Messages, MessageJSON{
 			Message:   item.Message,
 			Timestamp: time.Now(),
 		})
 	}
 
-	device.BlobProperties[item.Name] = prop
+	var old BlobProperty
+	var existed bool
 
-	c.devices.Store(item.Device, device)
-}
+	created, _ := c.devices.update(item.Device, true, func(device *Device) error {
+		old, existed = device.BlobProperties[item.Name]
+		device.BlobProperties[item.Name] = prop
 
-func (c *INDIClient) setSwitchVector(item *SetSwitchVector) {
-	device, err := c.findDevice(item.Device)
-	if err != nil {
-		c.log.WithField("device", item.Device).WithError(err).Warn("could not find device")
-		return
+		return nil
+	})
+
+	e := Event{
+		Type:     EventPropertyDefined,
+		Device:   item.Device,
+		Property: item.Name,
+		Kind:     PropertyKindBlob,
+		New:      prop,
+		Message:  item.Message,
 	}
 
-	var prop SwitchProperty
-	if p, ok := device.SwitchProperties[item.Name]; ok {
-		prop = p
-	} else {
-		c.log.WithField("device", item.Device).WithField("property", item.Name).Warn("could not find property")
-		return
+	if existed {
+		e.Old = old
 	}
 
-	prop.State = item.State
-	prop.Timeout = item.Timeout
+	c.publishDefinition(created, e)
+}
 
-	if len(item.Timestamp) == 0 {
-		prop.LastUpdated = time.Now()
-	} else {
-		var err error
-		prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+func (c *INDIClient) setSwitchVector(item *SetSwitchVector) {
+	var old, prop SwitchProperty
 
-		if err != nil {
-			c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
-			prop.LastUpdated = time.Now()
+	_, err := c.devices.update(item.Device, false, func(device *Device) error {
+		p, ok := device.SwitchProperties[item.Name]
+		if !ok {
+			return ErrPropertyNotFound
 		}
-	}
 
-	for _, val := range item.Switches {
-		v, ok := prop.Values[val.Name]
-		if !ok {
-			continue
+		old = p
+		prop = p.clone()
+
+		prop.State = item.State
+		prop.Timeout = item.Timeout
+
+		if len(item.Timestamp) == 0 {
+			prop.LastUpdated = time.Now()
+		} else {
+			var err error
+			prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+
+			if err != nil {
+				c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+				prop.LastUpdated = time.Now()
+			}
 		}
 
-		v.Value = SwitchState(strings.TrimSpace(string(val.Value)))
+		for _, val := range item.Switches {
+			v, ok := prop.Values[val.Name]
+			if !ok {
+				continue
+			}
 
-		prop.Values[val.Name] = v
-	}
+			v.Value = SwitchState(strings.TrimSpace(string(val.Value)))
 
-	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
-			Message:   item.Message,
-			Timestamp: time.Now(),
-		})
-	}
+			prop.Values[val.Name] = v
+		}
 
-	device.SwitchProperties[item.Name] = prop
+		if len(item.Message) > 0 {
+			prop.Messages = appendMessage(prop.Messages, MessageJSON{
+				Message:   item.Message,
+				Timestamp: time.Now(),
+			})
+		}
 
-	c.devices.Store(item.Device, device)
-}
+		device.SwitchProperties[item.Name] = prop
 
-func (c *INDIClient) setTextVector(item *SetTextVector) {
-	device, err := c.findDevice(item.Device)
+		return nil
+	})
 	if err != nil {
-		c.log.WithField("device", item.Device).WithError(err).Warn("could not find device")
+		c.logUpdateError(item.Device, item.Name, err)
 		return
 	}
 
-	var prop TextProperty
-	if p, ok := device.TextProperties[item.Name]; ok {
-		prop = p
-	} else {
-		c.log.WithField("device", item.Device).WithField("property", item.Name).Warn("could not find property")
-		return
-	}
+	c.publish(Event{
+		Type:      EventPropertyUpdated,
+		Device:    item.Device,
+		Property:  item.Name,
+		Kind:      PropertyKindSwitch,
+		Old:       old,
+		New:       prop,
+		Message:   item.Message,
+		Timestamp: prop.LastUpdated,
+	})
+}
+
+func (c *INDIClient) setTextVector(item *SetTextVector) {
+	var old, prop TextProperty
 
-	prop.State = item.State
-	prop.Timeout = item.Timeout
+	_, err := c.devices.update(item.Device, false, func(device *Device) error {
+		p, ok := device.TextProperties[item.Name]
+		if !ok {
+			return ErrPropertyNotFound
+		}
 
-	if len(item.Timestamp) == 0 {
-		prop.LastUpdated = time.Now()
-	} else {
-		var err error
-		prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+		old = p
+		prop = p.clone()
 
-		if err != nil {
-			c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+		prop.State = item.State
+		prop.Timeout = item.Timeout
+
+		if len(item.Timestamp) == 0 {
 			prop.LastUpdated = time.Now()
+		} else {
+			var err error
+			prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+
+			if err != nil {
+				c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+				prop.LastUpdated = time.Now()
+			}
 		}
-	}
 
-	for _, val := range item.Texts {
-		v, ok := prop.Values[val.Name]
-		if !ok {
-			continue
+		for _, val := range item.Texts {
+			v, ok := prop.Values[val.Name]
+			if !ok {
+				continue
+			}
+
+			v.Value = strings.TrimSpace(val.Value)
+
+			prop.Values[val.Name] = v
 		}
 
-		v.Value = strings.TrimSpace(val.Value)
+		if len(item.Message) > 0 {
+			prop.Messages = appendMessage(prop.Messages, MessageJSON{
+				Message:   item.Message,
+				Timestamp: time.Now(),
+			})
+		}
 
-		prop.Values[val.Name] = v
-	}
+		device.TextProperties[item.Name] = prop
 
-	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
-			Message:   item.Message,
-			Timestamp: time.Now(),
-		})
+		return nil
+	})
+	if err != nil {
+		c.logUpdateError(item.Device, item.Name, err)
+		return
 	}
 
-	device.TextProperties[item.Name] = prop
-
-	c.devices.Store(item.Device, device)
+	c.publish(Event{
+		Type:      EventPropertyUpdated,
+		Device:    item.Device,
+		Property:  item.Name,
+		Kind:      PropertyKindText,
+		Old:       old,
+		New:       prop,
+		Message:   item.Message,
+		Timestamp: prop.LastUpdated,
+	})
 }
 
 func (c *INDIClient) setNumberVector(item *SetNumberVector) {
-	device, err := c.findDevice(item.Device)
+	var old, prop NumberProperty
+
+	_, err := c.devices.update(item.Device, false, func(device *Device) error {
+		p, ok := device.NumberProperties[item.Name]
+		if !ok {
+			return ErrPropertyNotFound
+		}
+
+		old = p
+		prop = p.clone()
+
+		prop.State = item.State
+		prop.Timeout = item.Timeout
+
+		if len(item.Timestamp) == 0 {
+			prop.LastUpdated = time.Now()
+		} else {
+			var err error
+			prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+
+			if err != nil {
+				c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+				prop.LastUpdated = time.Now()
+			}
+		}
+
+		for _, val := range item.Numbers {
+			v, ok := prop.Values[val.Name]
+			if !ok {
+				continue
+			}
+
+			v.Value = strings.TrimSpace(val.Value)
+
+			prop.Values[val.Name] = v
+		}
+
+		if len(item.Message) > 0 {
+			prop.Messages = appendMessage(prop.Messages, MessageJSON{
+				Message:   item.Message,
+				Timestamp: time.Now(),
+			})
+		}
+
+		device.NumberProperties[item.Name] = prop
+
+		return nil
+	})
 	if err != nil {
-		c.log.WithField("device", item.Device).WithError(err).Warn("could not find device")
+		c.logUpdateError(item.Device, item.Name, err)
 		return
 	}
 
-	var prop NumberProperty
-	if p, ok := device.NumberProperties[item.Name]; ok {
-		prop = p
-	} else {
-		c.log.WithField("device", item.Device).WithField("property", item.Name).Warn("could not find property")
-		return
-	}
+	c.publish(Event{
+		Type:      EventPropertyUpdated,
+		Device:    item.Device,
+		Property:  item.Name,
+		Kind:      PropertyKindNumber,
+		Old:       old,
+		New:       prop,
+		Message:   item.Message,
+		Timestamp: prop.LastUpdated,
+	})
+}
 
-	prop.State = item.State
-	prop.Timeout = item.Timeout
+func (c *INDIClient) setLightVector(item *SetLightVector) {
+	var old, prop LightProperty
 
-	if len(item.Timestamp) == 0 {
-		prop.LastUpdated = time.Now()
-	} else {
-		var err error
-		prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+	_, err := c.devices.update(item.Device, false, func(device *Device) error {
+		p, ok := device.LightProperties[item.Name]
+		if !ok {
+			return ErrPropertyNotFound
+		}
 
-		if err != nil {
-			c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+		old = p
+		prop = p.clone()
+
+		prop.State = item.State
+
+		if len(item.Timestamp) == 0 {
 			prop.LastUpdated = time.Now()
+		} else {
+			var err error
+			prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+
+			if err != nil {
+				c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+				prop.LastUpdated = time.Now()
+			}
 		}
-	}
 
-	for _, val := range item.Numbers {
-		v, ok := prop.Values[val.Name]
-		if !ok {
-			continue
+		for _, val := range item.Lights {
+			v, ok := prop.Values[val.Name]
+			if !ok {
+				continue
+			}
+
+			v.Value = PropertyState(strings.TrimSpace(string(val.Value)))
+
+			prop.Values[val.Name] = v
 		}
 
-		v.Value = strings.TrimSpace(val.Value)
+		if len(item.Message) > 0 {
+			prop.Messages = appendMessage(prop.Messages, MessageJSON{
+				Message:   item.Message,
+				Timestamp: time.Now(),
+			})
+		}
 
-		prop.Values[val.Name] = v
-	}
+		device.LightProperties[item.Name] = prop
 
-	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
-			Message:   item.Message,
-			Timestamp: time.Now(),
-		})
+		return nil
+	})
+	if err != nil {
+		c.logUpdateError(item.Device, item.Name, err)
+		return
 	}
 
-	device.NumberProperties[item.Name] = prop
+	c.publish(Event{
+		Type:      EventPropertyUpdated,
+		Device:    item.Device,
+		Property:  item.Name,
+		Kind:      PropertyKindLight,
+		Old:       old,
+		New:       prop,
+		Message:   item.Message,
+		Timestamp: prop.LastUpdated,
+	})
+}
 
-	c.devices.Store(item.Device, device)
+// receivedBlobVector is a setBLOBVector whose BLOBs have already been written out by the reader, keyed by BLOB name.
+type receivedBlobVector struct {
+	vector  *SetBlobVector
+	written map[string]BlobValue
 }
 
-func (c *INDIClient) setLightVector(item *SetLightVector) {
-	device, err := c.findDevice(item.Device)
+// receiveBlob writes a BLOB to its file and any open streams while it is still being read from the connection, so
+// only a small buffer of it is ever held in memory. Compressed BLOBs are decompressed, and then processed, on the way.
+// If it cannot be written, or is not the size it should be, false is returned, and the BLOB is left out of the
+// property.
+func (c *INDIClient) receiveBlob(vector *SetBlobVector, blob *OneBlob, r io.Reader) (BlobValue, bool) {
+	r, format, err := decompress(blob.Format, r)
 	if err != nil {
-		c.log.WithField("device", item.Device).WithError(err).Warn("could not find device")
-		return
+		c.log.WithField("format", blob.Format).WithError(err).Warn("error in decompress")
+		return BlobValue{}, false
 	}
 
-	var prop LightProperty
-	if p, ok := device.LightProperties[item.Name]; ok {
-		prop = p
-	} else {
-		c.log.WithField("device", item.Device).WithField("property", item.Name).Warn("could not find property")
-		return
+	info := BlobInfo{
+		Device:    vector.Device,
+		Property:  vector.Name,
+		Name:      blob.Name,
+		Format:    format,
+		Timestamp: time.Now(),
+		Snapshot:  c.devices.snapshot(),
 	}
 
-	prop.State = item.State
+	if len(vector.Timestamp) > 0 {
+		ts, err := time.ParseInLocation("2006-01-02T15:04:05.9", vector.Timestamp, time.UTC)
+		if err != nil {
+			c.log.WithField("timestamp", vector.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+		} else {
+			info.Timestamp = ts
+		}
+	}
+
+	f, err := c.blobs.Create(info)
+	if err != nil {
+		c.log.WithField("device", vector.Device).WithField("property", vector.Name).WithError(err).Warn("error in c.blobs.Create")
+		return BlobValue{}, false
+	}
 
-	if len(item.Timestamp) == 0 {
-		prop.LastUpdated = time.Now()
-	} else {
-		var err error
-		prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+	fname := f.Name()
 
+	// discard closes and removes a BLOB that could not be received.
+	discard := func() {
+		f.Close()
+
+		err := c.blobs.Remove(fname)
 		if err != nil {
-			c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
-			prop.LastUpdated = time.Now()
+			c.log.WithField("file", fname).WithError(err).Warn("error in c.blobs.Remove")
 		}
 	}
 
-	for _, val := range item.Lights {
-		v, ok := prop.Values[val.Name]
-		if !ok {
-			continue
-		}
+	// The size sent is that of the BLOB before it was processed.
+	counted := &countingReader{r: r}
+	r = counted
 
-		v.Value = PropertyState(strings.TrimSpace(string(val.Value)))
+	// Some drivers leave size at 0, so only a size that was given can be checked. Reading one byte past it is enough to
+	// tell the BLOB is too big, without writing out the rest.
+	checkSize := blob.Size > 0 && !tileCompressed(format)
+	if checkSize {
+		r = io.LimitReader(r, int64(blob.Size)+1)
+	}
 
-		prop.Values[val.Name] = v
+	if c.processor != nil {
+		r, err = c.processor.ProcessBlob(info, r)
+		if err != nil {
+			c.log.WithField("file", fname).WithError(err).Warn("error in c.processor.ProcessBlob")
+			discard()
+
+			return BlobValue{}, false
+		}
 	}
 
-	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
-			Message:   item.Message,
-			Timestamp: time.Now(),
-		})
+	var writers []io.Writer
+
+	if ws, ok := c.blobStreams.Load(fmt.Sprintf("%s_%s_%s", vector.Device, vector.Name, blob.Name)); ok {
+		wss := ws.(map[string]io.Writer)
+
+		for _, w := range wss {
+			writers = append(writers, w)
+		}
 	}
 
-	device.LightProperties[item.Name] = prop
+	writers = append(writers, f)
 
-	c.devices.Store(item.Device, device)
-}
+	dest := io.MultiWriter(writers...)
 
-func (c *INDIClient) setBlobVector(item *SetBlobVector) {
-	device, err := c.findDevice(item.Device)
+	n, err := io.Copy(dest, r)
 	if err != nil {
-		c.log.WithField("device", item.Device).WithError(err).Warn("could not find device")
-		return
-	}
+		c.log.WithError(err).Warn("error in io.Copy")
+		discard()
 
-	var prop BlobProperty
-	if p, ok := device.BlobProperties[item.Name]; ok {
-		prop = p
-	} else {
-		c.log.WithField("device", item.Device).WithField("property", item.Name).Warn("could not find property")
-		return
+		return BlobValue{}, false
 	}
 
-	prop.State = item.State
-	prop.Timeout = item.Timeout
+	if checkSize && counted.n != int64(blob.Size) {
+		c.log.WithField("file", fname).WithField("size", blob.Size).WithField("received", counted.n).WithError(ErrBlobSizeMismatch).Warn("discarding blob")
+		discard()
 
-	if len(item.Timestamp) == 0 {
-		prop.LastUpdated = time.Now()
-	} else {
-		var err error
-		prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+		return BlobValue{}, false
+	}
 
-		if err != nil {
-			c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
-			prop.LastUpdated = time.Now()
-		}
+	err = f.Close()
+	if err != nil {
+		c.log.WithField("file", fname).WithError(err).Warn("error in f.Close")
+		return BlobValue{}, false
 	}
 
-	for _, val := range item.Blobs {
-		v, ok := prop.Values[val.Name]
-		if !ok {
-			continue
-		}
+	return BlobValue{
+		Value:           fname,
+		Size:            n,
+		Format:          blob.Format,
+		EffectiveFormat: format,
+	}, true
+}
 
-		fname := fmt.Sprintf("%s_%s_%s%s", item.Device, item.Name, val.Name, val.Format)
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-		f, err := c.fs.OpenFile(fname, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-		if err != nil {
-			c.log.WithField("file", fname).WithError(err).Warn("error in c.fs.OpenFile")
-			continue
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	return n, err
+}
+
+func (c *INDIClient) setBlobVector(item *SetBlobVector, written map[string]BlobValue) {
+	var old, prop BlobProperty
+
+	_, err := c.devices.update(item.Device, false, func(device *Device) error {
+		p, ok := device.BlobProperties[item.Name]
+		if !ok {
+			return ErrPropertyNotFound
 		}
 
-		var writers []io.Writer
+		old = p
+		prop = p.clone()
 
-		if ws, ok := c.blobStreams.Load(fmt.Sprintf("%s_%s_%s", item.Device, item.Name, val.Name)); ok {
-			wss := ws.(map[string]io.Writer)
+		prop.State = item.State
+		prop.Timeout = item.Timeout
 
-			for _, w := range wss {
-				writers = append(writers, w)
+		if len(item.Timestamp) == 0 {
+			prop.LastUpdated = time.Now()
+		} else {
+			var err error
+			prop.LastUpdated, err = time.ParseInLocation("2006-01-02T15:04:05.9", item.Timestamp, time.UTC)
+
+			if err != nil {
+				c.log.WithField("timestamp", item.Timestamp).WithError(err).Warn("error in time.ParseInLocation")
+				prop.LastUpdated = time.Now()
 			}
 		}
 
-		writers = append(writers, f)
-
-		val.Value = strings.TrimSpace(val.Value)
-		r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(val.Value))
+		for name, w := range written {
+			v, ok := prop.Values[name]
+			if !ok {
+				continue
+			}
 
-		dest := io.MultiWriter(writers...)
+			v.Value = w.Value
+			v.Size = w.Size
+			v.Format = w.Format
+			v.EffectiveFormat = w.EffectiveFormat
 
-		written, err := io.Copy(dest, r)
-		if err != nil {
-			c.log.WithError(err).Warn("error in io.Copy")
-			continue
+			prop.Values[name] = v
 		}
 
-		v.Value = f.Name()
-		v.Size = written
+		if len(item.Message) > 0 {
+			prop.Messages = appendMessage(prop.Messages, MessageJSON{
+				Message:   item.Message,
+				Timestamp: time.Now(),
+			})
+		}
 
-		f.Close()
+		device.BlobProperties[item.Name] = prop
 
-		prop.Values[val.Name] = v
+		return nil
+	})
+	if err != nil {
+		c.logUpdateError(item.Device, item.Name, err)
+		return
 	}
 
-	if len(item.Message) > 0 {
-		prop.Messages = append(prop.Messages, MessageJSON{
+	c.publish(Event{
+		Type:      EventPropertyUpdated,
+		Device:    item.Device,
+		Property:  item.Name,
+		Kind:      PropertyKindBlob,
+		Old:       old,
+		New:       prop,
+		Message:   item.Message,
+		Timestamp: prop.LastUpdated,
+	})
+
+	// BLOBs are handed to their handlers in the order they were sent.
+	for _, blob := range item.Blobs {
+		w, ok := written[blob.Name]
+		if !ok {
+			continue
+		}
+
+		if _, ok := prop.Values[blob.Name]; !ok {
+			continue
+		}
+
+		c.publish(Event{
+			Type:      EventBlobReceived,
+			Device:    item.Device,
+			Property:  item.Name,
+			Kind:      PropertyKindBlob,
+			New:       prop,
 			Message:   item.Message,
-			Timestamp: time.Now(),
+			Timestamp: prop.LastUpdated,
+			Blob: &Blob{
+				Device:          item.Device,
+				Property:        item.Name,
+				Name:            blob.Name,
+				Format:          w.Format,
+				EffectiveFormat: w.EffectiveFormat,
+				Size:            w.Size,
+				Timestamp:       prop.LastUpdated,
+				State:           prop.State,
+				StoredAs:        w.Value,
+				store:           c.blobs,
+			},
 		})
 	}
-
-	device.BlobProperties[item.Name] = prop
-
-	c.devices.Store(item.Device, device)
 }
 
 func (c *INDIClient) message(item *Message) {
-	device, err := c.findDevice(item.Device)
+	c.publish(Event{
+		Type:    EventMessage,
+		Device:  item.Device,
+		Message: item.Message,
+	})
+
+	_, err := c.devices.update(item.Device, false, func(device *Device) error {
+		device.Messages = appendMessage(device.Messages, MessageJSON{
+			Message:   item.Message,
+			Timestamp: time.Now(),
+		})
+
+		return nil
+	})
 	if err != nil {
 		c.log.WithField("device", item.Device).WithError(err).Warn("could not find device")
-		return
 	}
-
-	device.Messages = append(device.Messages, MessageJSON{
-		Message:   item.Message,
-		Timestamp: time.Now(),
-	})
-
-	c.devices.Store(item.Device, device)
 }
 
 func (c *INDIClient) delProperty(item *DelProperty) {
 	if len(item.Device) == 0 {
-		c.devices.Range(func(key, value interface{}) bool {
-			c.devices.Delete(key)
-			return true
-		})
+		for _, name := range c.devices.clear() {
+			c.publish(Event{
+				Type:    EventDeviceRemoved,
+				Device:  name,
+				Message: item.Message,
+			})
+		}
 
 		return
 	}
 
 	if len(item.Name) == 0 {
-		c.devices.Delete(item.Device)
+		if !c.devices.delete(item.Device) {
+			c.log.WithField("device", item.Device).WithError(ErrDeviceNotFound).Warn("could not find device")
+			return
+		}
+
+		c.publish(Event{
+			Type:    EventDeviceRemoved,
+			Device:  item.Device,
+			Message: item.Message,
+		})
+
+		return
+	}
+
+	e := Event{
+		Type:     EventPropertyDeleted,
+		Device:   item.Device,
+		Property: item.Name,
+		Message:  item.Message,
+	}
+
+	_, err := c.devices.update(item.Device, false, func(device *Device) error {
+		if p, ok := device.TextProperties[item.Name]; ok {
+			e.Kind, e.Old = PropertyKindText, p
+		} else if p, ok := device.NumberProperties[item.Name]; ok {
+			e.Kind, e.Old = PropertyKindNumber, p
+		} else if p, ok := device.SwitchProperties[item.Name]; ok {
+			e.Kind, e.Old = PropertyKindSwitch, p
+		} else if p, ok := device.LightProperties[item.Name]; ok {
+			e.Kind, e.Old = PropertyKindLight, p
+		} else if p, ok := device.BlobProperties[item.Name]; ok {
+			e.Kind, e.Old = PropertyKindBlob, p
+		} else {
+			return ErrPropertyNotFound
+		}
+
+		delete(device.TextProperties, item.Name)
+		delete(device.NumberProperties, item.Name)
+		delete(device.SwitchProperties, item.Name)
+		delete(device.LightProperties, item.Name)
+		delete(device.BlobProperties, item.Name)
+
+		return nil
+	})
+	if err != nil {
+		c.logUpdateError(item.Device, item.Name, err)
 		return
 	}
 
-	device := c.findOrCreateDevice(item.Device)
+	c.publish(e)
+}
 
-	delete(device.TextProperties, item.Name)
-	delete(device.NumberProperties, item.Name)
-	delete(device.SwitchProperties, item.Name)
-	delete(device.LightProperties, item.Name)
-	delete(device.BlobProperties, item.Name)
+// publishDefinition publishes e, preceded by EventDeviceAdded if the property being defined created its device.
+func (c *INDIClient) publishDefinition(created bool, e Event) {
+	if created {
+		c.publish(Event{
+			Type:   EventDeviceAdded,
+			Device: e.Device,
+		})
+	}
 
-	c.devices.Store(item.Device, device)
+	c.publish(e)
 }
 
-func (c *INDIClient) startRead() {
-	go func(r <-chan interface{}, log logging.Logger, handler indiMessageHandler) {
-		for i := range r {
+func (c *INDIClient) startRead(sess *session) {
+	go func(r <-chan interface{}, done <-chan struct{}, dispatcherDone chan<- struct{}, log logging.Logger, handler indiMessageHandler) {
+		defer close(dispatcherDone)
+
+		for {
+			var i interface{}
+
+			select {
+			case i = <-r:
+			case <-done:
+				return
+			}
+
 			log.WithField("item", i).Debug("got message")
 
 			switch item := i.(type) {
@@ -1120,8 +1789,8 @@ func (c *INDIClient) startRead() {
 				handler.setNumberVector(item)
 			case *SetLightVector:
 				handler.setLightVector(item)
-			case *SetBlobVector:
-				handler.setBlobVector(item)
+			case *receivedBlobVector:
+				handler.setBlobVector(item.vector, item.written)
 			case *Message:
 				handler.message(item)
 			case *DelProperty:
@@ -1130,91 +1799,71 @@ func (c *INDIClient) startRead() {
 				log.WithField("type", fmt.Sprintf("%T", item)).Warn("unknown type")
 			}
 		}
-	}(c.read, c.log, c)
+	}(sess.read, sess.done, sess.dispatcherDone, c.log, c)
+
+	go func(conn io.Reader, r chan<- interface{}, done <-chan struct{}, log logging.Logger) {
+		decoder := NewDecoder(conn)
 
-	go func(conn io.Reader, r chan<- interface{}, log logging.Logger) {
-		decoder := xml.NewDecoder(conn)
+		// BLOBs are written out as they are read, and only what was written is handed to the dispatcher.
+		var written map[string]BlobValue
+
+		decoder.StreamBlobs(func(vector *SetBlobVector, blob *OneBlob, r io.Reader) error {
+			if v, ok := c.receiveBlob(vector, blob, r); ok {
+				written[blob.Name] = v
+			}
+
+			return nil
+		})
 
-		var inElement string
 		for {
-			t, err := decoder.Token()
+			written = map[string]BlobValue{}
+
+			item, err := decoder.Decode()
 			if err != nil {
-				if strings.Contains(err.Error(), "use of closed network connection") {
+				if elemErr, ok := err.(*ElementError); ok {
+					log.WithField("element", elemErr.Element).WithError(elemErr.Err).Error("error in decoder.Decode")
+					continue
+				}
+
+				select {
+				case <-done:
 					// We've disconnected.
 					return
+				default:
 				}
 
-				log.WithError(err).Warn("error in decoder.Token")
+				// The decoder cannot recover from any other error, including malformed xml, so treat them all as a
+				// lost connection.
+				log.WithError(err).Warn("error in decoder.Decode")
 
-				if err == io.EOF {
-					c.Disconnect()
-					return
-				}
-				continue
+				c.connectionLost(sess, err)
+				return
 			}
 
-			var item interface{}
-
-			switch se := t.(type) {
-			case xml.StartElement:
-				log.WithField("startElement", se.Name.Local).Debug("read start element")
-
-				var inner interface{}
-				inElement = se.Name.Local
-				switch inElement {
-				case "defSwitchVector":
-					inner = &DefSwitchVector{}
-				case "defTextVector":
-					inner = &DefTextVector{}
-				case "defNumberVector":
-					inner = &DefNumberVector{}
-				case "defLightVector":
-					inner = &DefLightVector{}
-				case "defBLOBVector":
-					inner = &DefBlobVector{}
-				case "setSwitchVector":
-					inner = &SetSwitchVector{}
-				case "setTextVector":
-					inner = &SetTextVector{}
-				case "setNumberVector":
-					inner = &SetNumberVector{}
-				case "setLightVector":
-					inner = &SetLightVector{}
-				case "setBLOBVector":
-					inner = &SetBlobVector{}
-				case "message":
-					inner = &Message{}
-				case "delProperty":
-					inner = &DelProperty{}
-				default:
-					log.WithField("element", inElement).Error("unknown element")
-				}
-
-				if inner != nil {
-					err = decoder.DecodeElement(&inner, &se)
-					if err != nil {
-						log.WithField("element", inElement).WithError(err).Error("error in decoder.DecodeElement")
-						continue
-					}
+			log.WithField("item", fmt.Sprintf("%T", item)).Debug("read element")
 
-					item = inner
-				}
+			if vector, ok := item.(*SetBlobVector); ok {
+				item = &receivedBlobVector{vector: vector, written: written}
 			}
 
-			if item != nil {
-				r <- item
+			select {
+			case r <- item:
+			case <-done:
+				return
 			}
 		}
-	}(c.conn, c.read, c.log)
+	}(sess.conn, sess.read, sess.done, c.log)
 }
 
-func (c *INDIClient) startWrite() {
-	go func(conn io.Writer, w <-chan interface{}, log logging.Logger) {
-		for item := range w {
+func (c *INDIClient) startWrite(sess *session) {
+	go func(conn io.Writer, w <-chan interface{}, done <-chan struct{}, writerDone chan<- struct{}, log logging.Logger) {
+		defer close(writerDone)
+
+		write := func(item interface{}) {
 			b, err := xml.Marshal(item)
 			if err != nil {
 				log.WithError(err).Error("error in xml.Marshal")
-				continue
+				return
 			}
 
 			log.WithField("cmd", string(b)).Debug("sending command")
@@ -1222,8 +1871,25 @@ func (c *INDIClient) startWrite() {
 			_, err = conn.Write(b)
 			if err != nil {
 				log.WithError(err).Error("error in conn.Write")
-				continue
+				return
+			}
+		}
+
+		for {
+			select {
+			case item := <-w:
+				write(item)
+			case <-done:
+				// Flush whatever was queued before the session was shut down.
+				for {
+					select {
+					case item := <-w:
+						write(item)
+					default:
+						return
+					}
+				}
 			}
 		}
-	}(c.conn, c.write, c.log)
+	}(sess.conn, sess.write, sess.done, sess.writerDone, c.log)
 }
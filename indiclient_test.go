@@ -2,9 +2,12 @@ package indiclient_test
 
 import (
 	"bytes"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -60,6 +63,51 @@ func (m *mockConnection) Close() error {
 	return nil
 }
 
+// pipeDialer connects clients over net.Pipe, keeping the server end of the most recent connection.
+type pipeDialer struct {
+	server net.Conn
+}
+
+func (d *pipeDialer) Dial(network, address string) (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+	d.server = server
+
+	return client, nil
+}
+
+// newPipeClient returns a connected client and the server end of its connection.
+func newPipeClient(t *testing.T) (*indiclient.INDIClient, net.Conn) {
+	dialer := &pipeDialer{}
+
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+	fs := afero.NewMemMapFs()
+
+	c := indiclient.NewINDIClient(log, dialer, fs, 5)
+
+	err := c.Connect("tcp", "localhost:1")
+	require.NoError(t, err)
+
+	return c, dialer.server
+}
+
+// readCommand decodes the next command the client sent to server into v.
+func readCommand(t *testing.T, server net.Conn, v interface{}) {
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer server.SetReadDeadline(time.Time{})
+
+	dec := xml.NewDecoder(server)
+
+	for {
+		tok, err := dec.Token()
+		require.NoError(t, err)
+
+		if se, ok := tok.(xml.StartElement); ok {
+			require.NoError(t, dec.DecodeElement(v, &se))
+			return
+		}
+	}
+}
+
 func TestClient(t *testing.T) {
 	testXML := `<defSwitchVector device="Camera" name="Binning" rule="OneOfMany" state="Ok" perm="w" timeout="0"
 	label="Binning">
@@ -162,12 +210,13 @@ func Test_GetProperties(t *testing.T) {
 
 	time.Sleep(1 * time.Second) // Wait for the client to write the xml
 
+	// Disconnect waits for the writer to stop, so w can be read safely afterwards.
+	err = c.Disconnect()
+	require.NoError(t, err)
+
 	result := w.String()
 
 	assert.Equal(t, "<getProperties version=\"1.7\"></getProperties>", result)
-
-	err = c.Disconnect()
-	require.NoError(t, err)
 }
 
 func Test_GetProperties_PropWithNoDevice(t *testing.T) {
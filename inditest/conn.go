@@ -0,0 +1,101 @@
+package inditest
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/goastro/indiclient"
+)
+
+// conn is a client connected to a Server. watching and blobModes are guarded by the server's mutex.
+type conn struct {
+	rwc  io.ReadWriteCloser
+	out  chan []byte
+	done chan struct{}
+	once sync.Once
+
+	watching  []indiclient.GetProperties
+	blobModes map[string]indiclient.BlobEnable
+}
+
+// wants returns true if the client should be sent updates for the property, or for the whole device if name is
+// empty. blob selects between setBLOBVectors and everything else, as enableBLOB does.
+func (c *conn) wants(device, name string, blob bool) bool {
+	watching := false
+	for _, gp := range c.watching {
+		if len(gp.Device) > 0 && len(device) > 0 && gp.Device != device {
+			continue
+		}
+
+		if len(gp.Name) > 0 && len(name) > 0 && gp.Name != name {
+			continue
+		}
+
+		watching = true
+		break
+	}
+
+	if !watching {
+		return false
+	}
+
+	mode, ok := c.blobModes[device+"\x00"+name]
+	if !ok {
+		mode, ok = c.blobModes[device+"\x00"]
+	}
+
+	if !ok {
+		mode = indiclient.BlobEnableNever
+	}
+
+	if blob {
+		return mode == indiclient.BlobEnableAlso || mode == indiclient.BlobEnableOnly
+	}
+
+	return mode != indiclient.BlobEnableOnly
+}
+
+// setBlobMode records an enableBLOB. One for a whole device replaces any the connection sent for its properties, as
+// it does in indiserver, so the latest one always applies.
+func (c *conn) setBlobMode(device, name string, mode indiclient.BlobEnable) {
+	if len(name) == 0 {
+		for key := range c.blobModes {
+			if strings.HasPrefix(key, device+"\x00") {
+				delete(c.blobModes, key)
+			}
+		}
+	}
+
+	c.blobModes[device+"\x00"+name] = mode
+}
+
+// send queues b to be written to the client, unless the connection is closed.
+func (c *conn) send(b []byte) {
+	select {
+	case c.out <- b:
+	case <-c.done:
+	}
+}
+
+func (c *conn) writeLoop() {
+	for {
+		select {
+		case b := <-c.out:
+			_, err := c.rwc.Write(b)
+			if err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.rwc.Close()
+	})
+}
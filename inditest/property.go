@@ -0,0 +1,226 @@
+package inditest
+
+import (
+	"sort"
+
+	"github.com/goastro/indiclient"
+)
+
+// property is a property defined on a Server. def is a pointer to one of the Def*Vector types, and always holds the
+// current state and values of the property.
+type property struct {
+	device string
+	name   string
+	def    interface{}
+}
+
+func newProperty(def interface{}) (*property, error) {
+	switch d := def.(type) {
+	case *indiclient.DefTextVector:
+		return newProperty(*d)
+	case *indiclient.DefNumberVector:
+		return newProperty(*d)
+	case *indiclient.DefSwitchVector:
+		return newProperty(*d)
+	case *indiclient.DefLightVector:
+		return newProperty(*d)
+	case *indiclient.DefBlobVector:
+		return newProperty(*d)
+	case indiclient.DefTextVector:
+		d.Texts = append([]indiclient.DefText{}, d.Texts...)
+		return &property{device: d.Device, name: d.Name, def: &d}, nil
+	case indiclient.DefNumberVector:
+		d.Numbers = append([]indiclient.DefNumber{}, d.Numbers...)
+		return &property{device: d.Device, name: d.Name, def: &d}, nil
+	case indiclient.DefSwitchVector:
+		d.Switches = append([]indiclient.DefSwitch{}, d.Switches...)
+		return &property{device: d.Device, name: d.Name, def: &d}, nil
+	case indiclient.DefLightVector:
+		d.Lights = append([]indiclient.DefLight{}, d.Lights...)
+		return &property{device: d.Device, name: d.Name, def: &d}, nil
+	case indiclient.DefBlobVector:
+		d.Blobs = append([]indiclient.DefBlob{}, d.Blobs...)
+		return &property{device: d.Device, name: d.Name, def: &d}, nil
+	}
+
+	return nil, ErrInvalidDefinition
+}
+
+// set applies step to the property, setting the given values, and returns the set*Vector that tells clients about
+// it. Nothing is changed if any of the values does not exist.
+func (p *property) set(step Step, values map[string]string) (interface{}, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	switch def := p.def.(type) {
+	case *indiclient.DefTextVector:
+		indexes, err := indexesOf(names, func(i int) (string, bool) {
+			if i < len(def.Texts) {
+				return def.Texts[i].Name, true
+			}
+			return "", false
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		setState(&def.State, step.State)
+
+		set := &indiclient.SetTextVector{
+			Device:    def.Device,
+			Name:      def.Name,
+			State:     def.State,
+			Timeout:   def.Timeout,
+			Timestamp: timestamp(),
+			Message:   step.Message,
+		}
+
+		for i, name := range names {
+			def.Texts[indexes[i]].Value = values[name]
+			set.Texts = append(set.Texts, indiclient.OneText{Name: name, Value: values[name]})
+		}
+
+		return set, nil
+
+	case *indiclient.DefNumberVector:
+		indexes, err := indexesOf(names, func(i int) (string, bool) {
+			if i < len(def.Numbers) {
+				return def.Numbers[i].Name, true
+			}
+			return "", false
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		setState(&def.State, step.State)
+
+		set := &indiclient.SetNumberVector{
+			Device:    def.Device,
+			Name:      def.Name,
+			State:     def.State,
+			Timeout:   def.Timeout,
+			Timestamp: timestamp(),
+			Message:   step.Message,
+		}
+
+		for i, name := range names {
+			def.Numbers[indexes[i]].Value = values[name]
+			set.Numbers = append(set.Numbers, indiclient.OneNumber{Name: name, Value: values[name]})
+		}
+
+		return set, nil
+
+	case *indiclient.DefSwitchVector:
+		indexes, err := indexesOf(names, func(i int) (string, bool) {
+			if i < len(def.Switches) {
+				return def.Switches[i].Name, true
+			}
+			return "", false
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		setState(&def.State, step.State)
+
+		set := &indiclient.SetSwitchVector{
+			Device:    def.Device,
+			Name:      def.Name,
+			State:     def.State,
+			Timeout:   def.Timeout,
+			Timestamp: timestamp(),
+			Message:   step.Message,
+		}
+
+		for i, name := range names {
+			v := indiclient.SwitchState(values[name])
+			def.Switches[indexes[i]].Value = v
+			set.Switches = append(set.Switches, indiclient.OneSwitch{Name: name, Value: v})
+		}
+
+		return set, nil
+
+	case *indiclient.DefLightVector:
+		indexes, err := indexesOf(names, func(i int) (string, bool) {
+			if i < len(def.Lights) {
+				return def.Lights[i].Name, true
+			}
+			return "", false
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		setState(&def.State, step.State)
+
+		set := &indiclient.SetLightVector{
+			Device:    def.Device,
+			Name:      def.Name,
+			State:     def.State,
+			Timestamp: timestamp(),
+			Message:   step.Message,
+		}
+
+		for i, name := range names {
+			v := indiclient.PropertyState(values[name])
+			def.Lights[indexes[i]].Value = v
+			set.Lights = append(set.Lights, indiclient.OneLight{Name: name, Value: v})
+		}
+
+		return set, nil
+
+	case *indiclient.DefBlobVector:
+		setState(&def.State, step.State)
+
+		return &indiclient.SetBlobVector{
+			Device:    def.Device,
+			Name:      def.Name,
+			State:     def.State,
+			Timeout:   def.Timeout,
+			Timestamp: timestamp(),
+			Message:   step.Message,
+		}, nil
+	}
+
+	return nil, ErrInvalidDefinition
+}
+
+// indexesOf finds the index of each of names among the elements of a property. element returns the name of the
+// element at an index, and false once there are no more.
+func indexesOf(names []string, element func(i int) (string, bool)) ([]int, error) {
+	indexes := make([]int, len(names))
+
+	for n, name := range names {
+		found := false
+
+		for i := 0; ; i++ {
+			e, ok := element(i)
+			if !ok {
+				break
+			}
+
+			if e == name {
+				indexes[n] = i
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, indiclient.ErrPropertyValueNotFound
+		}
+	}
+
+	return indexes, nil
+}
+
+func setState(state *indiclient.PropertyState, next indiclient.PropertyState) {
+	if len(next) > 0 {
+		*state = next
+	}
+}
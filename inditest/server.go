@@ -0,0 +1,626 @@
+// Package inditest provides an in-process INDI server for testing clients without hardware.
+//
+// Declare virtual devices with the Def*Vector types from indiclient, and connect an INDIClient to the Server with
+// Dialer (over net.Pipe) or Listen (over a loopback listener). The server answers getProperties and enableBLOB like
+// indiserver does, and answers new*Vector commands like a simple driver: by applying the new values and setting the
+// property to Ok. Handle and Script change how a property answers, and everything clients send is recorded so tests
+// can make assertions about it.
+package inditest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goastro/indiclient"
+)
+
+var (
+	// ErrServerClosed is returned when connecting to a Server that has been closed.
+	ErrServerClosed = errors.New("server closed")
+
+	// ErrInvalidDefinition is returned by Define for anything other than a Def*Vector.
+	ErrInvalidDefinition = errors.New("invalid definition")
+)
+
+// timestampFormat is the format INDI uses for timestamps, always in UTC.
+const timestampFormat = "2006-01-02T15:04:05"
+
+// Step is one update the server sends for a property, either in reply to a command or through Update.
+type Step struct {
+	// Delay is how long to wait before sending this step, counted from the previous step. It is ignored by Update.
+	Delay time.Duration
+
+	// State is the new state of the property. If empty, the state is left unchanged.
+	State indiclient.PropertyState
+
+	// Values maps element names to their new values. When replying to a command, nil means the values from the
+	// command. Values are ignored for BLOB properties; use SendBlob to send BLOBs.
+	Values map[string]string
+
+	// Message is sent along with the update.
+	Message string
+}
+
+// Handler returns the steps the server sends, in order, in reply to a new*Vector command. Returning no steps leaves
+// the property Busy in the client, which is handy for testing timeouts.
+type Handler func(cmd Command) []Step
+
+// Command is something a client sent to the server.
+type Command struct {
+	// Type is the name of the element, for example "newNumberVector" or "getProperties".
+	Type string
+
+	Device string
+	Name   string
+
+	// Values maps element names to the values sent for them in a new*Vector command. For BLOBs, the values are still
+	// base64 encoded.
+	Values map[string]string
+
+	// Element is the decoded command, for example a *indiclient.NewNumberVector.
+	Element interface{}
+
+	Received time.Time
+}
+
+// Server is an in-process INDI server. Create one with NewServer.
+type Server struct {
+	mu        sync.Mutex
+	props     []*property
+	handlers  map[string]Handler
+	conns     map[*conn]struct{}
+	listeners []net.Listener
+	received  []Command
+	notify    chan struct{}
+	closed    bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewServer creates a Server with no devices.
+func NewServer() *Server {
+	return &Server{
+		handlers: map[string]Handler{},
+		conns:    map[*conn]struct{}{},
+		notify:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Define adds properties to the server, or replaces them if they already exist. Each def must be one of the
+// indiclient Def*Vector types, or a pointer to one; the server keeps its own copy. Clients that have already asked
+// for the properties are sent the definitions straight away.
+func (s *Server) Define(defs ...interface{}) error {
+	props := make([]*property, 0, len(defs))
+
+	for _, def := range defs {
+		p, err := newProperty(def)
+		if err != nil {
+			return err
+		}
+
+		props = append(props, p)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range props {
+		if i := s.find(p.device, p.name); i >= 0 {
+			s.props[i] = p
+		} else {
+			s.props = append(s.props, p)
+		}
+
+		s.broadcast(p.def, func(c *conn) bool {
+			return c.wants(p.device, p.name, false)
+		})
+	}
+
+	return nil
+}
+
+// Delete removes a property from the server, or the whole device if name is empty, and tells clients about it.
+func (s *Server) Delete(device, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.props[:0]
+	for _, p := range s.props {
+		if p.device != device || (len(name) > 0 && p.name != name) {
+			kept = append(kept, p)
+		}
+	}
+
+	s.props = kept
+
+	s.broadcast(&indiclient.DelProperty{
+		Device:    device,
+		Name:      name,
+		Timestamp: timestamp(),
+	}, func(c *conn) bool {
+		return c.wants(device, name, false)
+	})
+}
+
+// Handle sets how the server replies to commands for a property, replacing any earlier Handler or Script.
+func (s *Server) Handle(device, name string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[device+"\x00"+name] = h
+}
+
+// Script makes the server reply to every command for a property with the same steps. For example, a slow move that
+// fails could be scripted as a Busy step followed by an Alert step with a Delay.
+func (s *Server) Script(device, name string, steps ...Step) {
+	s.Handle(device, name, func(Command) []Step {
+		return steps
+	})
+}
+
+// Update changes a property and sends the new values to clients, as a driver does when something changes on its
+// own. indiclient.ErrPropertyNotFound or indiclient.ErrPropertyValueNotFound is returned if the property or one of the
+// values does not exist.
+func (s *Server) Update(device, name string, step Step) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(device, name, step, step.Values)
+}
+
+// SendBlob sends data as the new value of a BLOB to clients that have enabled BLOBs for the property. format is the
+// INDI format of data, such as ".fits".
+func (s *Server) SendBlob(device, name, blob string, data []byte, format string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(device, name)
+	if i < 0 {
+		return indiclient.ErrPropertyNotFound
+	}
+
+	def, ok := s.props[i].def.(*indiclient.DefBlobVector)
+	if !ok {
+		return indiclient.ErrPropertyNotFound
+	}
+
+	found := false
+	for _, b := range def.Blobs {
+		if b.Name == blob {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return indiclient.ErrPropertyValueNotFound
+	}
+
+	def.State = indiclient.PropertyStateOk
+
+	s.broadcast(&indiclient.SetBlobVector{
+		Device:    device,
+		Name:      name,
+		State:     def.State,
+		Timeout:   def.Timeout,
+		Timestamp: timestamp(),
+		Blobs: []indiclient.OneBlob{
+			{
+				Name:   blob,
+				Size:   len(data),
+				Format: format,
+				Value:  base64.StdEncoding.EncodeToString(data),
+			},
+		},
+	}, func(c *conn) bool {
+		return c.wants(device, name, true)
+	})
+
+	return nil
+}
+
+// Message sends a message to clients. If device is empty, the message comes from the server itself.
+func (s *Server) Message(device, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.broadcast(&indiclient.Message{
+		Device:    device,
+		Message:   message,
+		Timestamp: timestamp(),
+	}, func(c *conn) bool {
+		return c.wants(device, "", false)
+	})
+}
+
+// Received returns every command clients have sent, in the order they arrived.
+func (s *Server) Received() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Command{}, s.received...)
+}
+
+// ClearReceived forgets every command received so far.
+func (s *Server) ClearReceived() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.received = nil
+}
+
+// WaitFor returns the first received command for which match returns true, waiting up to timeout for one to arrive.
+// Commands received before WaitFor was called are included; use ClearReceived to ignore them.
+func (s *Server) WaitFor(timeout time.Duration, match func(Command) bool) (Command, bool) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	checked := 0
+
+	for {
+		s.mu.Lock()
+		if checked > len(s.received) {
+			// ClearReceived was called while waiting.
+			checked = 0
+		}
+
+		for _, cmd := range s.received[checked:] {
+			if match(cmd) {
+				s.mu.Unlock()
+				return cmd, true
+			}
+		}
+
+		checked = len(s.received)
+		notify := s.notify
+		s.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return Command{}, false
+		}
+	}
+}
+
+// Dialer returns an indiclient.Dialer that connects to the server over net.Pipe. The network and address given to
+// the client are ignored.
+func (s *Server) Dialer() indiclient.Dialer {
+	return pipeDialer{s: s}
+}
+
+type pipeDialer struct {
+	s *Server
+}
+
+func (d pipeDialer) Dial(network, address string) (io.ReadWriteCloser, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d pipeDialer) DialContext(ctx context.Context, network, address string) (io.ReadWriteCloser, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	client, server := net.Pipe()
+
+	err := d.s.Serve(server)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Listen accepts connections on a TCP address, such as "127.0.0.1:0", until the server is closed. The address actually
+// listened on is returned.
+func (s *Server) Listen(address string) (net.Addr, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil, ErrServerClosed
+	}
+
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			if s.Serve(nc) != nil {
+				nc.Close()
+				return
+			}
+		}
+	}()
+
+	return l.Addr(), nil
+}
+
+// Serve speaks INDI over rwc until it is closed or the server is. It returns straight away.
+func (s *Server) Serve(rwc io.ReadWriteCloser) error {
+	c := &conn{
+		rwc:       rwc,
+		out:       make(chan []byte, 1024),
+		done:      make(chan struct{}),
+		blobModes: map[string]indiclient.BlobEnable{},
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	s.wg.Add(2)
+
+	go func() {
+		defer s.wg.Done()
+
+		c.writeLoop()
+	}()
+
+	go func() {
+		defer s.wg.Done()
+
+		s.readLoop(c)
+	}()
+
+	return nil
+}
+
+// DropConnections closes every client connection, as if the network went away, but keeps the server running.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.close()
+	}
+}
+
+// Close stops the server, closing every listener and connection, and waits for them to finish. Scripts still in
+// progress are abandoned.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	close(s.done)
+
+	for _, l := range s.listeners {
+		l.Close()
+	}
+
+	for c := range s.conns {
+		c.close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *Server) readLoop(c *conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+
+		c.close()
+	}()
+
+	dec := indiclient.NewDecoder(c.rwc)
+
+	for {
+		item, err := dec.Decode()
+		if err != nil {
+			if _, ok := err.(*indiclient.ElementError); ok {
+				continue
+			}
+
+			return
+		}
+
+		s.receive(c, item)
+	}
+}
+
+func (s *Server) receive(c *conn, item interface{}) {
+	cmd := newCommand(item)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.received = append(s.received, cmd)
+	close(s.notify)
+	s.notify = make(chan struct{})
+
+	switch e := item.(type) {
+	case *indiclient.GetProperties:
+		c.watching = append(c.watching, *e)
+
+		for _, p := range s.props {
+			if (len(e.Device) == 0 || e.Device == p.device) && (len(e.Name) == 0 || e.Name == p.name) {
+				c.send(marshal(p.def))
+			}
+		}
+
+	case *indiclient.EnableBlob:
+		c.setBlobMode(e.Device, e.Name, e.Value)
+
+	case *indiclient.NewTextVector, *indiclient.NewNumberVector, *indiclient.NewSwitchVector, *indiclient.NewBlobVector:
+		if s.find(cmd.Device, cmd.Name) < 0 {
+			return
+		}
+
+		h, ok := s.handlers[cmd.Device+"\x00"+cmd.Name]
+		if !ok {
+			h = func(Command) []Step {
+				return []Step{{State: indiclient.PropertyStateOk}}
+			}
+		}
+
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+
+			s.run(cmd, h(cmd))
+		}()
+	}
+}
+
+// run sends steps in reply to cmd.
+func (s *Server) run(cmd Command, steps []Step) {
+	for _, step := range steps {
+		if step.Delay > 0 {
+			select {
+			case <-time.After(step.Delay):
+			case <-s.done:
+				return
+			}
+		}
+
+		values := step.Values
+		if values == nil {
+			values = cmd.Values
+		}
+
+		s.mu.Lock()
+		err := s.apply(cmd.Device, cmd.Name, step, values)
+		s.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// apply updates a property and sends the update to clients. s.mu must be held.
+func (s *Server) apply(device, name string, step Step, values map[string]string) error {
+	i := s.find(device, name)
+	if i < 0 {
+		return indiclient.ErrPropertyNotFound
+	}
+
+	p := s.props[i]
+
+	set, err := p.set(step, values)
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(set, func(c *conn) bool {
+		return c.wants(device, name, false)
+	})
+
+	return nil
+}
+
+// find returns the index of a property in s.props, or -1. s.mu must be held.
+func (s *Server) find(device, name string) int {
+	for i, p := range s.props {
+		if p.device == device && p.name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// broadcast sends item to every connection for which to returns true. s.mu must be held, which keeps what each
+// connection receives in order.
+func (s *Server) broadcast(item interface{}, to func(c *conn) bool) {
+	b := marshal(item)
+
+	for c := range s.conns {
+		if to(c) {
+			c.send(b)
+		}
+	}
+}
+
+func marshal(item interface{}) []byte {
+	b, err := xml.Marshal(item)
+	if err != nil {
+		// Only the indiclient types are ever marshaled, and they always can be.
+		panic(err)
+	}
+
+	return append(b, '\n')
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(timestampFormat)
+}
+
+func newCommand(item interface{}) Command {
+	cmd := Command{
+		Element:  item,
+		Received: time.Now(),
+	}
+
+	switch e := item.(type) {
+	case *indiclient.GetProperties:
+		cmd.Type, cmd.Device, cmd.Name = "getProperties", e.Device, e.Name
+	case *indiclient.EnableBlob:
+		cmd.Type, cmd.Device, cmd.Name = "enableBLOB", e.Device, e.Name
+	case *indiclient.NewTextVector:
+		cmd.Type, cmd.Device, cmd.Name = "newTextVector", e.Device, e.Name
+		cmd.Values = map[string]string{}
+		for _, v := range e.Texts {
+			cmd.Values[v.Name] = v.Value
+		}
+	case *indiclient.NewNumberVector:
+		cmd.Type, cmd.Device, cmd.Name = "newNumberVector", e.Device, e.Name
+		cmd.Values = map[string]string{}
+		for _, v := range e.Numbers {
+			cmd.Values[v.Name] = v.Value
+		}
+	case *indiclient.NewSwitchVector:
+		cmd.Type, cmd.Device, cmd.Name = "newSwitchVector", e.Device, e.Name
+		cmd.Values = map[string]string{}
+		for _, v := range e.Switches {
+			cmd.Values[v.Name] = string(v.Value)
+		}
+	case *indiclient.NewBlobVector:
+		cmd.Type, cmd.Device, cmd.Name = "newBLOBVector", e.Device, e.Name
+		cmd.Values = map[string]string{}
+		for _, v := range e.Blobs {
+			cmd.Values[v.Name] = v.Value
+		}
+	}
+
+	return cmd
+}
@@ -0,0 +1,275 @@
+package inditest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/inditest"
+)
+
+var focuser = indiclient.DefNumberVector{
+	Device:  "Focuser",
+	Name:    "ABS_FOCUS_POSITION",
+	Label:   "Absolute",
+	Group:   "Main",
+	State:   indiclient.PropertyStateIdle,
+	Perm:    indiclient.PropertyPermissionReadWrite,
+	Timeout: 60,
+	Numbers: []indiclient.DefNumber{
+		{Name: "FOCUS_ABSOLUTE_POSITION", Format: "%6.0f", Min: "0", Max: "10000", Step: "1", Value: "100"},
+	},
+}
+
+var camera = indiclient.DefBlobVector{
+	Device: "Camera",
+	Name:   "CCD1",
+	State:  indiclient.PropertyStateIdle,
+	Perm:   indiclient.PropertyPermissionReadOnly,
+	Blobs: []indiclient.DefBlob{
+		{Name: "CCD1"},
+	},
+}
+
+func newClient(t *testing.T, dialer indiclient.Dialer, address string) *indiclient.INDIClient {
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+
+	c := indiclient.NewINDIClient(log, dialer, afero.NewMemMapFs(), 10)
+
+	err := c.Connect("tcp", address)
+	require.NoError(t, err)
+
+	return c
+}
+
+// waitForProperty waits until the client knows about the property.
+func waitForProperty(t *testing.T, c *indiclient.INDIClient, device, name string) {
+	assert.Eventually(t, func() bool {
+		d, err := c.Device(device)
+		if err != nil {
+			return false
+		}
+
+		_, ok := d.NumberProperties[name]
+		if !ok {
+			_, ok = d.BlobProperties[name]
+		}
+
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func Test_Server_DefaultReply(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	require.NoError(t, s.Define(focuser))
+
+	c := newClient(t, s.Dialer(), "")
+	defer c.Disconnect()
+
+	require.NoError(t, c.GetProperties("", ""))
+	waitForProperty(t, c, "Focuser", "ABS_FOCUS_POSITION")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := c.SetNumberValueAndWait(ctx, "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "2500")
+	require.NoError(t, err)
+
+	v, err := c.NumberValueFloat("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION")
+	require.NoError(t, err)
+	assert.Equal(t, 2500.0, v)
+
+	received := s.Received()
+	require.Len(t, received, 2)
+	assert.Equal(t, "getProperties", received[0].Type)
+	assert.Equal(t, "newNumberVector", received[1].Type)
+	assert.Equal(t, "Focuser", received[1].Device)
+	assert.Equal(t, map[string]string{"FOCUS_ABSOLUTE_POSITION": "2500"}, received[1].Values)
+	assert.IsType(t, &indiclient.NewNumberVector{}, received[1].Element)
+}
+
+func Test_Server_Script(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	require.NoError(t, s.Define(focuser))
+
+	s.Script("Focuser", "ABS_FOCUS_POSITION",
+		inditest.Step{State: indiclient.PropertyStateBusy, Values: map[string]string{"FOCUS_ABSOLUTE_POSITION": "150"}},
+		inditest.Step{Delay: 50 * time.Millisecond, State: indiclient.PropertyStateAlert, Values: map[string]string{}, Message: "motor stalled"},
+	)
+
+	c := newClient(t, s.Dialer(), "")
+	defer c.Disconnect()
+
+	require.NoError(t, c.GetProperties("Focuser", ""))
+	waitForProperty(t, c, "Focuser", "ABS_FOCUS_POSITION")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := c.SetNumberValueAndWait(ctx, "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "2500")
+	require.Error(t, err)
+
+	alert, ok := err.(*indiclient.PropertyAlertError)
+	require.True(t, ok)
+	assert.Equal(t, "motor stalled", alert.Message)
+
+	v, err := c.NumberValueFloat("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION")
+	require.NoError(t, err)
+	assert.Equal(t, 150.0, v)
+}
+
+func Test_Server_NoReply(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	require.NoError(t, s.Define(focuser))
+
+	s.Script("Focuser", "ABS_FOCUS_POSITION")
+
+	c := newClient(t, s.Dialer(), "")
+	defer c.Disconnect()
+
+	require.NoError(t, c.GetProperties("", ""))
+	waitForProperty(t, c, "Focuser", "ABS_FOCUS_POSITION")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := c.SetNumberValueAndWait(ctx, "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "2500")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func Test_Server_Listen(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	require.NoError(t, s.Define(&focuser, &camera))
+
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	c := newClient(t, indiclient.NetworkDialer{}, addr.String())
+	defer c.Disconnect()
+
+	require.NoError(t, c.GetProperties("", ""))
+	waitForProperty(t, c, "Camera", "CCD1")
+
+	require.NoError(t, c.EnableBlob("Camera", "CCD1", indiclient.BlobEnableAlso))
+
+	_, ok := s.WaitFor(2*time.Second, func(cmd inditest.Command) bool {
+		return cmd.Type == "enableBLOB"
+	})
+	require.True(t, ok)
+
+	updated := c.Subscribe(indiclient.EventFilter{Device: "Camera", Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer updated.Close()
+
+	require.NoError(t, s.SendBlob("Camera", "CCD1", "CCD1", []byte("SIMPLE  =                    T"), ".fits"))
+
+	select {
+	case <-updated.Events():
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for BLOB")
+	}
+
+	rdr, _, length, err := c.GetBlob("Camera", "CCD1", "CCD1")
+	require.NoError(t, err)
+	defer rdr.Close()
+
+	b, err := ioutil.ReadAll(rdr)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), length)
+	assert.Equal(t, "SIMPLE  =                    T", string(b))
+}
+
+func Test_Server_UpdateMessageDelete(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	require.NoError(t, s.Define(focuser))
+
+	c := newClient(t, s.Dialer(), "")
+	defer c.Disconnect()
+
+	events := c.Subscribe(indiclient.EventFilter{Device: "Focuser"}, 10, indiclient.DropPolicyNewest)
+	defer events.Close()
+
+	require.NoError(t, c.GetProperties("", ""))
+
+	next := func() indiclient.Event {
+		select {
+		case e := <-events.Events():
+			return e
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "timed out waiting for event")
+		}
+
+		return indiclient.Event{}
+	}
+
+	assert.Equal(t, indiclient.EventDeviceAdded, next().Type)
+	assert.Equal(t, indiclient.EventPropertyDefined, next().Type)
+
+	err := s.Update("Focuser", "ABS_FOCUS_POSITION", inditest.Step{
+		State:  indiclient.PropertyStateBusy,
+		Values: map[string]string{"FOCUS_ABSOLUTE_POSITION": "120"},
+	})
+	require.NoError(t, err)
+
+	e := next()
+	assert.Equal(t, indiclient.EventPropertyUpdated, e.Type)
+	assert.Equal(t, indiclient.PropertyStateBusy, e.State())
+	assert.False(t, e.Timestamp.IsZero())
+
+	s.Message("Focuser", "hello")
+
+	e = next()
+	assert.Equal(t, indiclient.EventMessage, e.Type)
+	assert.Equal(t, "hello", e.Message)
+
+	s.Delete("Focuser", "")
+
+	assert.Equal(t, indiclient.EventDeviceRemoved, next().Type)
+
+	err = s.Update("Focuser", "ABS_FOCUS_POSITION", inditest.Step{})
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+}
+
+func Test_Server_DropConnections(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	c := newClient(t, s.Dialer(), "")
+	defer c.Disconnect()
+
+	done := c.Done()
+
+	s.DropConnections()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "client did not notice the connection was dropped")
+	}
+
+	assert.False(t, c.IsConnected())
+}
+
+func Test_Server_Define_Invalid(t *testing.T) {
+	s := inditest.NewServer()
+	defer s.Close()
+
+	err := s.Define(indiclient.SetNumberVector{})
+	assert.Equal(t, inditest.ErrInvalidDefinition, err)
+}
@@ -0,0 +1,130 @@
+package indiclient
+
+// Device returns a copy of the device with the given name, or ErrDeviceNotFound. The copy belongs to the caller.
+func (c *INDIClient) Device(name string) (Device, error) {
+	device, err := c.findDevice(name)
+	if err != nil {
+		return Device{}, err
+	}
+
+	return device.clone(), nil
+}
+
+// TextProperty returns a copy of the named text property, or ErrDeviceNotFound or ErrPropertyNotFound.
+func (c *INDIClient) TextProperty(deviceName, propName string) (TextProperty, error) {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return TextProperty{}, err
+	}
+
+	prop, ok := device.TextProperties[propName]
+	if !ok {
+		return TextProperty{}, ErrPropertyNotFound
+	}
+
+	return prop.clone(), nil
+}
+
+// NumberProperty returns a copy of the named number property, or ErrDeviceNotFound or ErrPropertyNotFound.
+func (c *INDIClient) NumberProperty(deviceName, propName string) (NumberProperty, error) {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return NumberProperty{}, err
+	}
+
+	prop, ok := device.NumberProperties[propName]
+	if !ok {
+		return NumberProperty{}, ErrPropertyNotFound
+	}
+
+	return prop.clone(), nil
+}
+
+// SwitchProperty returns a copy of the named switch property, or ErrDeviceNotFound or ErrPropertyNotFound.
+func (c *INDIClient) SwitchProperty(deviceName, propName string) (SwitchProperty, error) {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return SwitchProperty{}, err
+	}
+
+	prop, ok := device.SwitchProperties[propName]
+	if !ok {
+		return SwitchProperty{}, ErrPropertyNotFound
+	}
+
+	return prop.clone(), nil
+}
+
+// LightProperty returns a copy of the named light property, or ErrDeviceNotFound or ErrPropertyNotFound.
+func (c *INDIClient) LightProperty(deviceName, propName string) (LightProperty, error) {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return LightProperty{}, err
+	}
+
+	prop, ok := device.LightProperties[propName]
+	if !ok {
+		return LightProperty{}, ErrPropertyNotFound
+	}
+
+	return prop.clone(), nil
+}
+
+// BlobProperty returns a copy of the named BLOB property, or ErrDeviceNotFound or ErrPropertyNotFound.
+func (c *INDIClient) BlobProperty(deviceName, propName string) (BlobProperty, error) {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return BlobProperty{}, err
+	}
+
+	prop, ok := device.BlobProperties[propName]
+	if !ok {
+		return BlobProperty{}, ErrPropertyNotFound
+	}
+
+	return prop.clone(), nil
+}
+
+// NumberValueFloat returns the current value of a single number, parsed with ParseNumber. ErrDeviceNotFound,
+// ErrPropertyNotFound, or ErrPropertyValueNotFound is returned if it does not exist, and an error wrapping
+// ErrInvalidNumber if the device sent something that is not a number.
+func (c *INDIClient) NumberValueFloat(deviceName, propName, numberName string) (float64, error) {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return 0, err
+	}
+
+	prop, ok := device.NumberProperties[propName]
+	if !ok {
+		return 0, ErrPropertyNotFound
+	}
+
+	nv, ok := prop.Values[numberName]
+	if !ok {
+		return 0, ErrPropertyValueNotFound
+	}
+
+	return nv.Float()
+}
+
+// ActiveSwitch returns the name of the switch that is On in a switch property, which is most useful with OneOfMany
+// and AtMostOne properties. If several switches are On, the first in alphabetical order is returned. If none are,
+// ErrPropertyValueNotFound is returned.
+func (c *INDIClient) ActiveSwitch(deviceName, propName string) (string, error) {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return "", err
+	}
+
+	prop, ok := device.SwitchProperties[propName]
+	if !ok {
+		return "", ErrPropertyNotFound
+	}
+
+	active := prop.active()
+	if len(active) == 0 {
+		return "", ErrPropertyValueNotFound
+	}
+
+	return active, nil
+}
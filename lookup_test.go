@@ -0,0 +1,76 @@
+package indiclient_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+func Test_Lookups(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, mountDefXML, 1)
+	defineProperties(t, c, server, binningDefXML, 2)
+
+	device, err := c.Device("Mount")
+	require.NoError(t, err)
+	assert.Equal(t, "Mount", device.Name)
+	assert.Contains(t, device.NumberProperties, "EQUATORIAL_EOD_COORD")
+
+	prop, err := c.NumberProperty("Mount", "EQUATORIAL_EOD_COORD")
+	require.NoError(t, err)
+	assert.Len(t, prop.Values, 2)
+
+	// The property returned is a copy.
+	delete(prop.Values, "DEC")
+
+	v, err := c.NumberValueFloat("Mount", "EQUATORIAL_EOD_COORD", "DEC")
+	require.NoError(t, err)
+	assert.Equal(t, 90.0, v)
+
+	sw, err := c.SwitchProperty("Camera", "Binning")
+	require.NoError(t, err)
+	assert.Equal(t, indiclient.SwitchRuleOneOfMany, sw.Rule)
+
+	active, err := c.ActiveSwitch("Camera", "Binning")
+	require.NoError(t, err)
+	assert.Equal(t, "One", active)
+
+	_, err = c.ActiveSwitch("Camera", "Abort")
+	assert.Equal(t, indiclient.ErrPropertyValueNotFound, err)
+}
+
+func Test_Lookups_NotFound(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, mountDefXML, 1)
+
+	_, err := c.Device("Camera")
+	assert.Equal(t, indiclient.ErrDeviceNotFound, err)
+
+	_, err = c.TextProperty("Camera", "DEVICE_PORT")
+	assert.Equal(t, indiclient.ErrDeviceNotFound, err)
+
+	_, err = c.TextProperty("Mount", "DEVICE_PORT")
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+
+	_, err = c.SwitchProperty("Mount", "EQUATORIAL_EOD_COORD")
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+
+	_, err = c.LightProperty("Mount", "EQUATORIAL_EOD_COORD")
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+
+	_, err = c.BlobProperty("Mount", "EQUATORIAL_EOD_COORD")
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+
+	_, err = c.NumberValueFloat("Mount", "EQUATORIAL_EOD_COORD", "ALT")
+	assert.Equal(t, indiclient.ErrPropertyValueNotFound, err)
+
+	_, err = c.ActiveSwitch("Mount", "TELESCOPE_PARK")
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+}
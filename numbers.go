@@ -0,0 +1,202 @@
+package indiclient
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// numberFormatPattern splits a printf style INDI number format into its prefix, flags, width, precision, verb, and
+// suffix. C length modifiers such as the l in %lf are accepted and ignored.
+var numberFormatPattern = regexp.MustCompile(`^([^%]*)%([-+ 0#]*)(\d*)(\.\d*)?(?:hh|h|ll|l|L)?([a-zA-Z])(.*)$`)
+
+// ParseNumber parses a number in any of the representations INDI allows. Besides plain decimals, sexagesimal values
+// such as "12:30:15.2", "12 30 15", "12;30" or "-05:12" are accepted, with up to three fields for degrees (or hours),
+// minutes, and seconds. The sign of the first field applies to the whole value.
+func ParseNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		return v, nil
+	}
+
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ':' || r == ';' || r == ' ' || r == '\t'
+	})
+
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidNumber, s)
+	}
+
+	negative := strings.HasPrefix(fields[0], "-")
+	scale := 1.0
+	v = 0
+
+	for i, field := range fields {
+		if i > 0 && (strings.HasPrefix(field, "-") || strings.HasPrefix(field, "+")) {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidNumber, s)
+		}
+
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidNumber, s)
+		}
+
+		v += math.Abs(f) / scale
+		scale *= 60
+	}
+
+	if negative {
+		v = -v
+	}
+
+	return v, nil
+}
+
+// FormatNumber renders v according to an INDI number format. These are the printf formats of C, plus the INDI
+// specific sexagesimal %<w>.<f>m, where w is the total width and f selects the precision of the fraction:
+//
+//	5: :mm.m
+//	6: :mm:ss
+//	8: :mm:ss.s
+//	9: :mm:ss.ss
+//	anything else, usually 3: :mm
+//
+// Integer verbs such as %d round v to the nearest integer. Formats that cannot be understood fall back to %g.
+func FormatNumber(format string, v float64) string {
+	m := numberFormatPattern.FindStringSubmatch(strings.TrimSpace(format))
+	if m == nil {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	prefix, flags, width, precision, verb, suffix := m[1], m[2], m[3], m[4], m[5], m[6]
+
+	switch verb {
+	case "m":
+		w, _ := strconv.Atoi(width)
+		f, _ := strconv.Atoi(strings.TrimPrefix(precision, "."))
+
+		return prefix + formatSexagesimal(v, w-f, f) + suffix
+	case "d", "i", "u", "x", "X", "o":
+		if verb == "i" || verb == "u" {
+			verb = "d"
+		}
+
+		return prefix + fmt.Sprintf("%"+flags+width+precision+verb, int64(math.Round(v))) + suffix
+	case "e", "E", "f", "F", "g", "G":
+		// C defaults to 6 digits of precision, Go to the smallest number necessary.
+		if len(precision) == 0 {
+			precision = ".6"
+		} else if precision == "." {
+			precision = ".0"
+		}
+
+		return prefix + fmt.Sprintf("%"+flags+width+precision+verb, v) + suffix
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatSexagesimal renders v as whole degrees (or hours) padded to width, followed by minutes and seconds to the
+// precision selected by fraction, as fs_sexa does in libindi.
+func formatSexagesimal(v float64, width, fraction int) string {
+	var fracbase uint64
+
+	switch fraction {
+	case 5:
+		fracbase = 600
+	case 6:
+		fracbase = 3600
+	case 8:
+		fracbase = 36000
+	case 9:
+		fracbase = 360000
+	default:
+		fracbase = 60
+	}
+
+	if width < 0 {
+		width = 0
+	}
+
+	negative := v < 0
+	if negative {
+		v = -v
+	}
+
+	n := uint64(v*float64(fracbase) + 0.5)
+	d := int64(n / fracbase)
+	f := n % fracbase
+
+	var b strings.Builder
+
+	if negative && d == 0 {
+		pad := width - 2
+		if pad < 0 {
+			pad = 0
+		}
+
+		fmt.Fprintf(&b, "%*s-0", pad, "")
+	} else {
+		if negative {
+			d = -d
+		}
+
+		fmt.Fprintf(&b, "%*d", width, d)
+	}
+
+	switch fracbase {
+	case 600:
+		fmt.Fprintf(&b, ":%02d.%1d", f/10, f%10)
+	case 3600:
+		fmt.Fprintf(&b, ":%02d:%02d", f/60, f%60)
+	case 36000:
+		s := f % 600
+		fmt.Fprintf(&b, ":%02d:%02d.%1d", f/600, s/10, s%10)
+	case 360000:
+		s := f % 6000
+		fmt.Fprintf(&b, ":%02d:%02d.%02d", f/6000, s/100, s%100)
+	default:
+		fmt.Fprintf(&b, ":%02d", f)
+	}
+
+	return b.String()
+}
+
+// Float parses Value as an INDI number. See ParseNumber.
+func (nv NumberValue) Float() (float64, error) {
+	return ParseNumber(nv.Value)
+}
+
+// MinFloat parses Min as an INDI number. See ParseNumber.
+func (nv NumberValue) MinFloat() (float64, error) {
+	return ParseNumber(nv.Min)
+}
+
+// MaxFloat parses Max as an INDI number. See ParseNumber.
+func (nv NumberValue) MaxFloat() (float64, error) {
+	return ParseNumber(nv.Max)
+}
+
+// StepFloat parses Step as an INDI number. See ParseNumber.
+func (nv NumberValue) StepFloat() (float64, error) {
+	return ParseNumber(nv.Step)
+}
+
+// FormatFloat renders v according to the Format of nv. See FormatNumber.
+func (nv NumberValue) FormatFloat(v float64) string {
+	return FormatNumber(nv.Format, v)
+}
+
+// Formatted renders Value according to Format for display. If Value cannot be parsed, it is returned unchanged.
+func (nv NumberValue) Formatted() string {
+	v, err := nv.Float()
+	if err != nil {
+		return nv.Value
+	}
+
+	return nv.FormatFloat(v)
+}
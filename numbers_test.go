@@ -0,0 +1,106 @@
+package indiclient_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+func Test_ParseNumber(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected float64
+	}{
+		{input: "12.5", expected: 12.5},
+		{input: " -3e2 ", expected: -300},
+		{input: "12:30:15.2", expected: 12 + 30.0/60 + 15.2/3600},
+		{input: "12 30 15", expected: 12 + 30.0/60 + 15.0/3600},
+		{input: "12;30", expected: 12.5},
+		{input: "-05:12", expected: -(5 + 12.0/60)},
+		{input: "-0:30:00", expected: -0.5},
+		{input: "+10:06", expected: 10.1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			v, err := indiclient.ParseNumber(tc.input)
+			require.NoError(t, err)
+			assert.InDelta(t, tc.expected, v, 1e-9)
+		})
+	}
+}
+
+func Test_ParseNumber_Invalid(t *testing.T) {
+	for _, input := range []string{"", "abc", "12:xx", "1:2:3:4", "12:-30"} {
+		t.Run(input, func(t *testing.T) {
+			_, err := indiclient.ParseNumber(input)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, indiclient.ErrInvalidNumber))
+		})
+	}
+}
+
+func Test_FormatNumber(t *testing.T) {
+	testCases := []struct {
+		format   string
+		value    float64
+		expected string
+	}{
+		{format: "%010.6m", value: 12.5, expected: "  12:30:00"},
+		{format: "%9.6m", value: -0.5, expected: " -0:30:00"},
+		{format: "%9.6m", value: -23.75, expected: "-23:45:00"},
+		{format: "%6.3m", value: 5.25, expected: "  5:15"},
+		{format: "%8.5m", value: 5.2525, expected: "  5:15.2"},
+		{format: "%11.8m", value: 12 + 30.0/60 + 15.25/3600, expected: " 12:30:15.3"},
+		{format: "%12.9m", value: 12 + 30.0/60 + 15.25/3600, expected: " 12:30:15.25"},
+		{format: "%.6m", value: 1.5, expected: "1:30:00"},
+		{format: "%9m", value: 12.5, expected: "       12:30"},
+		{format: "%.4m", value: 1.5, expected: "1:30"},
+		{format: "%7.4m", value: -5.25, expected: " -5:15"},
+		{format: "%.2f", value: 3.14159, expected: "3.14"},
+		{format: "%6.0f", value: 100, expected: "   100"},
+		{format: "%g", value: 1234567, expected: "1.23457e+06"},
+		{format: "%g", value: 0.5, expected: "0.5"},
+		{format: "%lf", value: 0.5, expected: "0.500000"},
+		{format: "%d", value: 3.6, expected: "4"},
+		{format: "%4i", value: 7, expected: "   7"},
+		{format: "%.f", value: 2.5, expected: "2"},
+		{format: "%5.1f C", value: -10.25, expected: "-10.2 C"},
+		{format: "", value: 1.25, expected: "1.25"},
+		{format: "%s", value: 1.25, expected: "1.25"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			assert.Equal(t, tc.expected, indiclient.FormatNumber(tc.format, tc.value))
+		})
+	}
+}
+
+func Test_NumberValue_Accessors(t *testing.T) {
+	nv := indiclient.NumberValue{
+		Value:  "12:30:00",
+		Format: "%010.6m",
+		Min:    "0",
+		Max:    "24",
+		Step:   "0",
+	}
+
+	v, err := nv.Float()
+	require.NoError(t, err)
+	assert.Equal(t, 12.5, v)
+
+	max, err := nv.MaxFloat()
+	require.NoError(t, err)
+	assert.Equal(t, 24.0, max)
+
+	assert.Equal(t, "  12:30:00", nv.Formatted())
+	assert.Equal(t, "   6:00:00", nv.FormatFloat(6))
+
+	nv.Value = "unknown"
+	assert.Equal(t, "unknown", nv.Formatted())
+}
@@ -0,0 +1,81 @@
+package preview
+
+// debayer turns the raw data of a color sensor into red, green and blue channels, interpolating the two colors each
+// pixel lacks from its neighbors. xoff and yoff are where the image starts in the pattern, for a subframe that does not
+// start on an even pixel.
+func debayer(raw channel, pattern string, xoff, yoff int) ([]channel, error) {
+	if len(pattern) != 4 {
+		return nil, ErrUnknownBayerPattern
+	}
+
+	// colors holds the color of each pixel of the 2x2 pattern, a row at a time.
+	var colors [4]int
+
+	counts := [3]int{}
+
+	for i, r := range pattern {
+		switch r {
+		case 'R':
+			colors[i] = 0
+		case 'G':
+			colors[i] = 1
+		case 'B':
+			colors[i] = 2
+		default:
+			return nil, ErrUnknownBayerPattern
+		}
+
+		counts[colors[i]]++
+	}
+
+	if counts != [3]int{1, 2, 1} {
+		return nil, ErrUnknownBayerPattern
+	}
+
+	width, height := raw.width, raw.height
+
+	colorAt := func(x, y int) int {
+		return colors[((y+yoff)&1)*2+((x+xoff)&1)]
+	}
+
+	out := make([]channel, 3)
+	for i := range out {
+		out[i] = channel{width: width, height: height, pixels: make([]float64, width*height)}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			own := colorAt(x, y)
+			out[own].pixels[y*width+x] = raw.pixels[y*width+x]
+
+			var sums [3]float64
+			var n [3]int
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= width || ny >= height {
+						continue
+					}
+
+					c := colorAt(nx, ny)
+					sums[c] += raw.pixels[ny*width+nx]
+					n[c]++
+				}
+			}
+
+			for c := 0; c < 3; c++ {
+				if c == own {
+					continue
+				}
+
+				// A single pixel wide image has no neighbors of some colors.
+				if n[c] > 0 {
+					out[c].pixels[y*width+x] = sums[c] / float64(n[c])
+				}
+			}
+		}
+	}
+
+	return out, nil
+}
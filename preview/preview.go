@@ -0,0 +1,283 @@
+// Package preview renders the images cameras send as previews a browser can show: stretched so the faint sky is
+// visible, debayered if they come from a color sensor, and scaled down to a size that suits a screen.
+//
+// Convert turns a FITS BLOB straight into a JPEG or PNG, for example from a BLOB handler:
+//
+//	client.HandleBlobs(filter, 1, indiclient.DropPolicyOldest, func(b *indiclient.Blob) {
+//		rdr, err := b.Open()
+//		...
+//		defer rdr.Close()
+//
+//		var buf bytes.Buffer
+//		err = preview.Convert(&buf, rdr, preview.Options{MaxWidth: 1024, MaxHeight: 1024})
+//		...
+//	})
+package preview
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/goastro/indiclient/fits"
+)
+
+// Format is the format a preview is encoded in.
+type Format string
+
+const (
+	// FormatJPEG encodes previews as JPEG, which is the default.
+	FormatJPEG = Format("jpeg")
+
+	// FormatPNG encodes previews as PNG.
+	FormatPNG = Format("png")
+)
+
+// BayerNone, as the Bayer option, shows the raw data of a color sensor without debayering it.
+const BayerNone = "NONE"
+
+var (
+	// ErrUnknownFormat is returned when asked to encode a preview in a format other than JPEG or PNG.
+	ErrUnknownFormat = errors.New("unknown format")
+
+	// ErrUnknownBayerPattern is returned for a Bayer pattern other than RGGB, BGGR, GRBG or GBRG.
+	ErrUnknownBayerPattern = errors.New("unknown bayer pattern")
+
+	// ErrUnsupportedPlanes is returned for an image that has a number of planes other than 1 or 3.
+	ErrUnsupportedPlanes = errors.New("unsupported number of planes")
+)
+
+// Options controls how a preview is rendered. The zero value auto-stretches an image at its full size, and debayers it
+// if its header has a BAYERPAT.
+type Options struct {
+	// Stretch is how the values of the image are mapped to brightness. The default is StretchAuto.
+	Stretch Stretch
+
+	// TargetBackground is the brightness, from 0 to 1, StretchAuto brings the background to. The default is
+	// DefaultTargetBackground.
+	TargetBackground float64
+
+	// ClipLow and ClipHigh are the percentiles StretchPercentile maps to black and white. The defaults are
+	// DefaultClipLow and DefaultClipHigh.
+	ClipLow  float64
+	ClipHigh float64
+
+	// Bayer is the Bayer pattern of the sensor, which overrides the BAYERPAT of the header if it is set. Set it to
+	// BayerNone to show the raw data.
+	Bayer string
+
+	// MaxWidth and MaxHeight are the largest size of the preview. A larger image is scaled down to fit, keeping its
+	// aspect ratio. If they are 0, there is no limit.
+	MaxWidth  int
+	MaxHeight int
+
+	// Format and Quality are what Convert encodes the preview with. Quality is only used for JPEG, and is
+	// jpeg.DefaultQuality if it is 0.
+	Format  Format
+	Quality int
+}
+
+// channel is a single color of an image, held a row at a time.
+type channel struct {
+	width, height int
+	pixels        []float64
+}
+
+// Convert reads a FITS file from r and writes a preview of the image in its primary HDU to w.
+func Convert(w io.Writer, r io.Reader, opts Options) error {
+	f, err := fits.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	img, err := Render(f.Primary(), opts)
+	if err != nil {
+		return err
+	}
+
+	return Encode(w, img, opts.Format, opts.Quality)
+}
+
+// Render renders a preview of the image in an HDU. The result is an *image.Gray for a mono image, and an *image.RGBA
+// for a color one.
+func Render(hdu *fits.HDU, opts Options) (image.Image, error) {
+	im, err := hdu.Image()
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []channel
+
+	switch im.Planes() {
+	case 1:
+		channels = []channel{{width: im.Width(), height: im.Height(), pixels: im.Plane(0)}}
+	case 3:
+		for i := 0; i < 3; i++ {
+			channels = append(channels, channel{width: im.Width(), height: im.Height(), pixels: im.Plane(i)})
+		}
+	default:
+		return nil, ErrUnsupportedPlanes
+	}
+
+	pattern := opts.Bayer
+	if len(pattern) == 0 {
+		pattern, _ = hdu.Header.String("BAYERPAT")
+	}
+
+	pattern = strings.ToUpper(strings.TrimSpace(pattern))
+
+	if len(channels) == 1 && len(pattern) > 0 && pattern != BayerNone {
+		xoff, _ := hdu.Header.Int("XBAYROFF")
+		yoff, _ := hdu.Header.Int("YBAYROFF")
+
+		channels, err = debayer(channels[0], pattern, int(xoff), int(yoff))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// FITS images start at the bottom left, unless the camera says otherwise.
+	order, _ := hdu.Header.String("ROWORDER")
+	bottomUp := strings.TrimSpace(order) != "TOP-DOWN"
+
+	for i := range channels {
+		c := downsample(channels[i], opts.MaxWidth, opts.MaxHeight)
+
+		if bottomUp {
+			c = flip(c)
+		}
+
+		channels[i] = stretch(c, opts)
+	}
+
+	return toImage(channels), nil
+}
+
+// Encode encodes a preview as JPEG or PNG. If format is empty, it is JPEG.
+func Encode(w io.Writer, img image.Image, format Format, quality int) error {
+	switch format {
+	case FormatJPEG, "":
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	}
+
+	return ErrUnknownFormat
+}
+
+// downsample scales c down to fit within maxWidth and maxHeight, averaging the pixels that make up each new one.
+func downsample(c channel, maxWidth, maxHeight int) channel {
+	scale := 1.0
+
+	if maxWidth > 0 && c.width > maxWidth {
+		scale = float64(maxWidth) / float64(c.width)
+	}
+
+	if maxHeight > 0 && float64(c.height)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(c.height)
+	}
+
+	if scale == 1 {
+		return c
+	}
+
+	width := int(float64(c.width) * scale)
+	height := int(float64(c.height) * scale)
+
+	if width < 1 {
+		width = 1
+	}
+
+	if height < 1 {
+		height = 1
+	}
+
+	out := channel{width: width, height: height, pixels: make([]float64, width*height)}
+
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, c.height)
+
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, width, c.width)
+
+			var sum float64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sum += c.pixels[sy*c.width+sx]
+				}
+			}
+
+			out.pixels[y*width+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+
+	return out
+}
+
+// span returns the range of the pixels of an axis of length from that make up pixel i of an axis of length to.
+func span(i, to, from int) (int, int) {
+	start := i * from / to
+
+	end := (i + 1) * from / to
+	if end <= start {
+		end = start + 1
+	}
+
+	return start, end
+}
+
+// flip turns c upside down.
+func flip(c channel) channel {
+	out := channel{width: c.width, height: c.height, pixels: make([]float64, len(c.pixels))}
+
+	for y := 0; y < c.height; y++ {
+		copy(out.pixels[y*c.width:(y+1)*c.width], c.pixels[(c.height-1-y)*c.width:])
+	}
+
+	return out
+}
+
+// toImage turns stretched channels, with values from 0 to 1, into an image.
+func toImage(channels []channel) image.Image {
+	width, height := channels[0].width, channels[0].height
+
+	if len(channels) == 1 {
+		img := image.NewGray(image.Rect(0, 0, width, height))
+
+		for i, p := range channels[0].pixels {
+			img.Pix[i] = toByte(p)
+		}
+
+		return img
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for i := 0; i < width*height; i++ {
+		img.Pix[i*4] = toByte(channels[0].pixels[i])
+		img.Pix[i*4+1] = toByte(channels[1].pixels[i])
+		img.Pix[i*4+2] = toByte(channels[2].pixels[i])
+		img.Pix[i*4+3] = 255
+	}
+
+	return img
+}
+
+func toByte(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 255
+	}
+
+	return uint8(v*255 + 0.5)
+}
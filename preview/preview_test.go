@@ -0,0 +1,207 @@
+package preview_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient/fits"
+	"github.com/goastro/indiclient/preview"
+)
+
+// fitsFile returns a 16 bit FITS file of the given size holding pixels, a row at a time, with extra header records.
+func fitsFile(t *testing.T, width, height int, pixels []uint16, records ...string) []byte {
+	var b bytes.Buffer
+
+	for _, r := range append([]string{
+		"SIMPLE  =                    T",
+		"BITPIX  =                   16",
+		"NAXIS   =                    2",
+		fmt.Sprintf("NAXIS1  = %20d", width),
+		fmt.Sprintf("NAXIS2  = %20d", height),
+		"BZERO   =                32768",
+	}, append(records, "END")...) {
+		b.WriteString(fmt.Sprintf("%-80s", r))
+	}
+
+	for b.Len()%2880 != 0 {
+		b.WriteByte(' ')
+	}
+
+	for _, p := range pixels {
+		require.NoError(t, binary.Write(&b, binary.BigEndian, int16(int(p)-32768)))
+	}
+
+	for b.Len()%2880 != 0 {
+		b.WriteByte(0)
+	}
+
+	return b.Bytes()
+}
+
+func render(t *testing.T, data []byte, opts preview.Options) image.Image {
+	f, err := fits.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	img, err := preview.Render(f.Primary(), opts)
+	require.NoError(t, err)
+
+	return img
+}
+
+func Test_Render_Mono(t *testing.T) {
+	data := fitsFile(t, 3, 2, []uint16{100, 200, 300, 400, 500, 600})
+
+	img := render(t, data, preview.Options{Stretch: preview.StretchLinear})
+
+	gray, ok := img.(*image.Gray)
+	require.True(t, ok)
+	require.Equal(t, image.Rect(0, 0, 3, 2), gray.Bounds())
+
+	// The first row of the data is the bottom of the image.
+	assert.Equal(t, []uint8{153, 204, 255, 0, 51, 102}, gray.Pix)
+
+	img = render(t, fitsFile(t, 3, 2, []uint16{100, 200, 300, 400, 500, 600}, "ROWORDER= 'TOP-DOWN'"), preview.Options{Stretch: preview.StretchLinear})
+	assert.Equal(t, []uint8{0, 51, 102, 153, 204, 255}, img.(*image.Gray).Pix)
+
+	img = render(t, data, preview.Options{Stretch: preview.StretchNone})
+	assert.Equal(t, uint8(2), img.(*image.Gray).Pix[2])
+}
+
+func Test_Render_Debayer(t *testing.T) {
+	// A sensor under light that is pure orange: full red, half green, no blue.
+	raw := func(pattern string) []uint16 {
+		values := map[byte]uint16{'R': 65535, 'G': 32768, 'B': 0}
+
+		pixels := make([]uint16, 16)
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				pixels[y*4+x] = values[pattern[(y%2)*2+x%2]]
+			}
+		}
+
+		return pixels
+	}
+
+	tests := []struct {
+		pattern string
+		records []string
+		opts    preview.Options
+	}{
+		{pattern: "RGGB", records: []string{"BAYERPAT= 'RGGB'"}},
+		{pattern: "BGGR", records: []string{"BAYERPAT= 'BGGR    '"}},
+		{pattern: "GRBG", records: []string{"BAYERPAT= 'GRBG'"}},
+		{pattern: "GBRG", records: []string{"BAYERPAT= 'RGGB'"}, opts: preview.Options{Bayer: "gbrg"}},
+		{pattern: "GRBG", records: []string{"BAYERPAT= 'RGGB'", "XBAYROFF=                    1"}},
+		{pattern: "BGGR", records: []string{"BAYERPAT= 'RGGB'", "XBAYROFF=                    1", "YBAYROFF=                    1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			tt.opts.Stretch = preview.StretchNone
+
+			img := render(t, fitsFile(t, 4, 4, raw(tt.pattern), tt.records...), tt.opts)
+
+			rgba, ok := img.(*image.RGBA)
+			require.True(t, ok)
+
+			for i := 0; i < len(rgba.Pix); i += 4 {
+				assert.Equal(t, []uint8{255, 128, 0, 255}, rgba.Pix[i:i+4])
+			}
+		})
+	}
+
+	// Without debayering, the raw data is shown.
+	img := render(t, fitsFile(t, 4, 4, raw("RGGB"), "BAYERPAT= 'RGGB'"), preview.Options{Bayer: preview.BayerNone})
+	_, ok := img.(*image.Gray)
+	assert.True(t, ok)
+
+	f, err := fits.Decode(bytes.NewReader(fitsFile(t, 4, 4, raw("RGGB"), "BAYERPAT= 'RGBB'")))
+	require.NoError(t, err)
+
+	_, err = preview.Render(f.Primary(), preview.Options{})
+	assert.Equal(t, preview.ErrUnknownBayerPattern, err)
+}
+
+func Test_Render_Stretch(t *testing.T) {
+	const width, height = 64, 64
+
+	rnd := rand.New(rand.NewSource(1))
+
+	pixels := make([]uint16, width*height)
+	for i := range pixels {
+		pixels[i] = uint16(1000 + rnd.Intn(20))
+	}
+
+	// A star.
+	pixels[32*width+32] = 60000
+
+	data := fitsFile(t, width, height, pixels)
+
+	median := func(img image.Image) uint8 {
+		pix := append([]uint8(nil), img.(*image.Gray).Pix...)
+		sort.Slice(pix, func(i, j int) bool { return pix[i] < pix[j] })
+
+		return pix[len(pix)/2]
+	}
+
+	// The background is brought to a quarter of full brightness, which a linear stretch leaves nearly black.
+	assert.InDelta(t, 64, median(render(t, data, preview.Options{})), 3)
+	assert.InDelta(t, 128, median(render(t, data, preview.Options{TargetBackground: 0.5})), 3)
+	assert.Less(t, median(render(t, data, preview.Options{Stretch: preview.StretchLinear})), uint8(5))
+
+	// Clipping the star leaves the background spread across the whole range.
+	assert.InDelta(t, 128, median(render(t, data, preview.Options{Stretch: preview.StretchPercentile, ClipLow: 1, ClipHigh: 99})), 20)
+}
+
+func Test_Render_Downsample(t *testing.T) {
+	pixels := make([]uint16, 100*50)
+	for i := range pixels {
+		pixels[i] = uint16(i % 100)
+	}
+
+	data := fitsFile(t, 100, 50, pixels)
+
+	assert.Equal(t, image.Rect(0, 0, 10, 5), render(t, data, preview.Options{MaxWidth: 10}).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 20, 10), render(t, data, preview.Options{MaxWidth: 40, MaxHeight: 10}).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 100, 50), render(t, data, preview.Options{MaxWidth: 200}).Bounds())
+
+	// Each pixel is the mean of the ten it replaces.
+	img := render(t, data, preview.Options{MaxWidth: 10, Stretch: preview.StretchLinear}).(*image.Gray)
+	assert.Equal(t, uint8(0), img.Pix[0])
+	assert.Equal(t, uint8(255), img.Pix[9])
+	assert.Equal(t, uint8(142), img.Pix[5])
+}
+
+func Test_Convert(t *testing.T) {
+	data := fitsFile(t, 3, 2, []uint16{100, 200, 300, 400, 500, 600})
+
+	var b bytes.Buffer
+	require.NoError(t, preview.Convert(&b, bytes.NewReader(data), preview.Options{Format: preview.FormatPNG}))
+
+	img, err := png.Decode(&b)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), img.Bounds())
+
+	b.Reset()
+	require.NoError(t, preview.Convert(&b, bytes.NewReader(data), preview.Options{Quality: 90}))
+
+	img, err = jpeg.Decode(&b)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), img.Bounds())
+
+	err = preview.Convert(&b, bytes.NewReader(data), preview.Options{Format: "gif"})
+	assert.Equal(t, preview.ErrUnknownFormat, err)
+
+	err = preview.Convert(&b, bytes.NewReader([]byte("not a FITS file")), preview.Options{})
+	assert.Error(t, err)
+}
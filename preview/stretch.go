@@ -0,0 +1,178 @@
+package preview
+
+import (
+	"math"
+	"sort"
+)
+
+// Stretch is how the values of an image are mapped to brightness.
+type Stretch string
+
+const (
+	// StretchAuto applies a midtone transfer function, lifting the background to TargetBackground, and clipping the
+	// shadows a little below it, the way PixInsight's screen transfer function does. Each color is stretched on its own,
+	// which also balances them.
+	StretchAuto = Stretch("")
+
+	// StretchPercentile maps the values between the ClipLow and ClipHigh percentiles linearly to black and white.
+	StretchPercentile = Stretch("percentile")
+
+	// StretchLinear maps the darkest value to black, the brightest to white, and those between linearly.
+	StretchLinear = Stretch("linear")
+
+	// StretchNone shows the values as they are, with 0 as black and the largest 16 bit value as white.
+	StretchNone = Stretch("none")
+)
+
+const (
+	// DefaultTargetBackground is the brightness StretchAuto brings the background to.
+	DefaultTargetBackground = 0.25
+
+	// DefaultClipLow and DefaultClipHigh are the percentiles StretchPercentile maps to black and white.
+	DefaultClipLow  = 0.5
+	DefaultClipHigh = 99.9
+
+	// shadowClip is how far below the median StretchAuto clips the shadows, in normalized median absolute deviations.
+	shadowClip = -2.8
+
+	// madToSigma converts a median absolute deviation to the standard deviation of a normal distribution.
+	madToSigma = 1.4826
+
+	// maxSamples is the most pixels the statistics of a stretch are taken from.
+	maxSamples = 1 << 18
+)
+
+// stretch maps the values of c to brightness from 0 to 1.
+func stretch(c channel, opts Options) channel {
+	out := channel{width: c.width, height: c.height, pixels: make([]float64, len(c.pixels))}
+
+	if len(c.pixels) == 0 {
+		return out
+	}
+
+	sorted := sample(c.pixels)
+
+	var transfer func(v float64) float64
+
+	switch opts.Stretch {
+	case StretchNone:
+		transfer = func(v float64) float64 {
+			return v / 65535
+		}
+	case StretchLinear:
+		transfer = linear(sorted[0], sorted[len(sorted)-1])
+	case StretchPercentile:
+		low, high := opts.ClipLow, opts.ClipHigh
+		if low == 0 && high == 0 {
+			low, high = DefaultClipLow, DefaultClipHigh
+		}
+
+		transfer = linear(percentile(sorted, low), percentile(sorted, high))
+	default:
+		target := opts.TargetBackground
+		if target <= 0 || target >= 1 {
+			target = DefaultTargetBackground
+		}
+
+		transfer = auto(sorted, target)
+	}
+
+	for i, v := range c.pixels {
+		out.pixels[i] = transfer(v)
+	}
+
+	return out
+}
+
+// linear maps low to 0 and high to 1.
+func linear(low, high float64) func(float64) float64 {
+	if high <= low {
+		return func(v float64) float64 {
+			return 0
+		}
+	}
+
+	return func(v float64) float64 {
+		return (v - low) / (high - low)
+	}
+}
+
+// auto returns the midtone transfer function that brings the median of sorted to target.
+func auto(sorted []float64, target float64) func(float64) float64 {
+	min, max := sorted[0], sorted[len(sorted)-1]
+	if max <= min {
+		return func(v float64) float64 {
+			return 0
+		}
+	}
+
+	normalize := linear(min, max)
+
+	median := normalize(percentile(sorted, 50))
+
+	deviations := make([]float64, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = math.Abs(normalize(v) - median)
+	}
+
+	sort.Float64s(deviations)
+
+	mad := percentile(deviations, 50) * madToSigma
+
+	shadows := median + shadowClip*mad
+	if shadows < 0 || mad == 0 {
+		shadows = 0
+	}
+
+	// The midtones balance that maps the median to target is found with the transfer function itself.
+	midtones := mtf(target, (median-shadows)/(1-shadows))
+
+	return func(v float64) float64 {
+		x := (normalize(v) - shadows) / (1 - shadows)
+		if x <= 0 {
+			return 0
+		}
+
+		return mtf(midtones, x)
+	}
+}
+
+// mtf is the midtone transfer function, which maps 0 to 0, 1 to 1, and m to 0.5.
+func mtf(m, x float64) float64 {
+	switch {
+	case x <= 0:
+		return 0
+	case x >= 1:
+		return 1
+	}
+
+	return (m - 1) * x / ((2*m-1)*x - m)
+}
+
+// sample returns up to maxSamples of pixels, evenly spread, sorted.
+func sample(pixels []float64) []float64 {
+	step := len(pixels)/maxSamples + 1
+
+	s := make([]float64, 0, len(pixels)/step+1)
+	for i := 0; i < len(pixels); i += step {
+		s = append(s, pixels[i])
+	}
+
+	sort.Float64s(s)
+
+	return s
+}
+
+// percentile returns the value p percent of the way through sorted.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(p / 100 * float64(len(sorted)-1))
+
+	switch {
+	case i < 0:
+		i = 0
+	case i >= len(sorted):
+		i = len(sorted) - 1
+	}
+
+	return sorted[i]
+}
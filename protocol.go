@@ -0,0 +1,338 @@
+package indiclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ElementError is returned by Decoder.Decode when a single INDI element could not be decoded. The stream is still
+// usable, and Decode can be called again to read the next element.
+type ElementError struct {
+	Element string
+	Err     error
+}
+
+func (e *ElementError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Element, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *ElementError) Unwrap() error {
+	return e.Err
+}
+
+// NewElement returns a pointer to a new, empty value of the type the INDI element with the given name decodes into,
+// for example a *SetNumberVector for "setNumberVector". nil is returned if name is not an INDI element.
+func NewElement(name string) interface{} {
+	switch name {
+	case "getProperties":
+		return &GetProperties{}
+	case "enableBLOB":
+		return &EnableBlob{}
+	case "defSwitchVector":
+		return &DefSwitchVector{}
+	case "defTextVector":
+		return &DefTextVector{}
+	case "defNumberVector":
+		return &DefNumberVector{}
+	case "defLightVector":
+		return &DefLightVector{}
+	case "defBLOBVector":
+		return &DefBlobVector{}
+	case "setSwitchVector":
+		return &SetSwitchVector{}
+	case "setTextVector":
+		return &SetTextVector{}
+	case "setNumberVector":
+		return &SetNumberVector{}
+	case "setLightVector":
+		return &SetLightVector{}
+	case "setBLOBVector":
+		return &SetBlobVector{}
+	case "newSwitchVector":
+		return &NewSwitchVector{}
+	case "newTextVector":
+		return &NewTextVector{}
+	case "newNumberVector":
+		return &NewNumberVector{}
+	case "newBLOBVector":
+		return &NewBlobVector{}
+	case "message":
+		return &Message{}
+	case "delProperty":
+		return &DelProperty{}
+	}
+
+	return nil
+}
+
+// BlobFunc is called by a Decoder for each oneBLOB in a setBLOBVector, while the BLOB is still being read. vector has
+// its attributes set, but not its Blobs, and blob has its attributes set, but not its Value. r reads the BLOB already
+// decoded from base64. Anything left unread in r when BlobFunc returns is discarded.
+type BlobFunc func(vector *SetBlobVector, blob *OneBlob, r io.Reader) error
+
+// Decoder reads INDI elements from a stream. It understands both directions of the protocol, so it can be used to
+// read what a client sends as well as what a device or indiserver sends.
+type Decoder struct {
+	r     *bufio.Reader
+	dec   *xml.Decoder
+	blobs BlobFunc
+}
+
+// NewDecoder creates a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	br := bufio.NewReader(r)
+
+	return &Decoder{
+		r: br,
+		// Since br is an io.ByteReader, the xml.Decoder reads from it without buffering ahead, so BLOBs can be read
+		// straight from br in between its tokens.
+		dec: xml.NewDecoder(br),
+	}
+}
+
+// StreamBlobs makes the Decoder pass the contents of each oneBLOB to fn as it is read, instead of holding the whole
+// base64 encoded BLOB in memory. The SetBlobVectors returned by Decode then have an empty Value for every BLOB. If fn
+// returns an error, the rest of the setBLOBVector is skipped, and Decode returns an *ElementError wrapping it.
+func (d *Decoder) StreamBlobs(fn BlobFunc) {
+	d.blobs = fn
+}
+
+// Decode returns the next INDI element from the stream, as returned by NewElement. Elements that are not part of the
+// protocol are skipped, and reported with an *ElementError wrapping ErrUnknownElement.
+//
+// An *ElementError means only that element was lost. Any other error is fatal to the stream, and Decode will keep
+// returning it.
+func (d *Decoder) Decode() (interface{}, error) {
+	for {
+		t, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		item := NewElement(se.Name.Local)
+		if item == nil {
+			err = d.dec.Skip()
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, &ElementError{Element: se.Name.Local, Err: ErrUnknownElement}
+		}
+
+		if vector, ok := item.(*SetBlobVector); ok && d.blobs != nil {
+			err = d.streamBlobVector(vector, se)
+		} else {
+			err = d.dec.DecodeElement(item, &se)
+		}
+
+		if err != nil {
+			if _, ok := err.(*xml.SyntaxError); ok {
+				return nil, err
+			}
+
+			return nil, &ElementError{Element: se.Name.Local, Err: err}
+		}
+
+		return item, nil
+	}
+}
+
+// streamBlobVector reads a setBLOBVector, passing the contents of each oneBLOB to d.blobs.
+func (d *Decoder) streamBlobVector(vector *SetBlobVector, se xml.StartElement) error {
+	err := decodeAttrs(se, vector)
+	if err != nil {
+		d.dec.Skip()
+		return err
+	}
+
+	for {
+		t, err := d.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := t.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			if t.Name.Local != "oneBLOB" {
+				err = d.dec.Skip()
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			var blob OneBlob
+
+			err = decodeAttrs(t, &blob)
+			if err != nil {
+				d.dec.Skip()
+				d.dec.Skip()
+
+				return err
+			}
+
+			r := &blobReader{r: d.r, dec: d.dec, raw: true}
+
+			err = d.blobs(vector, &blob, base64.NewDecoder(base64.StdEncoding, r))
+
+			// Read up to the end of the oneBLOB, whether or not all of it was used.
+			_, drainErr := io.Copy(ioutil.Discard, r)
+			if drainErr != nil {
+				return drainErr
+			}
+
+			if err != nil {
+				d.dec.Skip()
+				return err
+			}
+
+			vector.Blobs = append(vector.Blobs, blob)
+		}
+	}
+}
+
+// decodeAttrs decodes just the attributes of se into v.
+func decodeAttrs(se xml.StartElement, v interface{}) error {
+	var buf bytes.Buffer
+
+	enc := xml.NewEncoder(&buf)
+
+	err := enc.EncodeToken(se)
+	if err == nil {
+		err = enc.EncodeToken(se.End())
+	}
+
+	if err == nil {
+		err = enc.Flush()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return xml.Unmarshal(buf.Bytes(), v)
+}
+
+// blobReader reads the base64 contents of a oneBLOB, leaving out whitespace, and stops at its end tag.
+//
+// Plain text is read straight from r a buffer at a time, so the xml.Decoder never sees it. Whenever the next byte is
+// a '<', the xml.Decoder takes over again for a token, which is either the end of the oneBLOB or something like a
+// CDATA section or comment.
+type blobReader struct {
+	r       *bufio.Reader
+	dec     *xml.Decoder
+	raw     bool
+	pending []byte
+	done    bool
+	err     error
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		if len(b.pending) > 0 {
+			read, written := copyBase64(p[n:], b.pending)
+			n += written
+			b.pending = b.pending[read:]
+
+			continue
+		}
+
+		if b.done {
+			break
+		}
+
+		if b.raw {
+			buf, err := b.r.Peek(1)
+			if err != nil {
+				b.done, b.err = true, err
+				break
+			}
+
+			buf, _ = b.r.Peek(b.r.Buffered())
+
+			end := bytes.IndexByte(buf, '<')
+			if end == 0 {
+				b.raw = false
+				continue
+			}
+
+			if end > 0 {
+				buf = buf[:end]
+			}
+
+			read, written := copyBase64(p[n:], buf)
+			n += written
+			b.r.Discard(read)
+
+			continue
+		}
+
+		t, err := b.dec.Token()
+		if err != nil {
+			b.done, b.err = true, err
+			break
+		}
+
+		switch t := t.(type) {
+		case xml.EndElement:
+			b.done = true
+		case xml.StartElement:
+			b.done, b.err = true, fmt.Errorf("unexpected <%s> in oneBLOB", t.Name.Local)
+		case xml.CharData:
+			// A CDATA section.
+			b.pending = t.Copy()
+			b.raw = true
+		default:
+			b.raw = true
+		}
+	}
+
+	if n > 0 {
+		return n, nil
+	}
+
+	if b.err != nil {
+		if b.err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		return 0, b.err
+	}
+
+	return 0, io.EOF
+}
+
+// copyBase64 copies from src into dst, leaving out whitespace, until dst is full or src runs out. It returns how many
+// bytes of src were used, and how many were copied into dst.
+func copyBase64(dst, src []byte) (read, written int) {
+	for read < len(src) && written < len(dst) {
+		ch := src[read]
+		read++
+
+		switch ch {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+
+		dst[written] = ch
+		written++
+	}
+
+	return read, written
+}
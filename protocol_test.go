@@ -0,0 +1,159 @@
+package indiclient_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+const cameraDefXML = `<defBLOBVector device="Camera" name="CCD1" state="Idle" perm="ro" timeout="0"><defBLOB name="CCD1"/></defBLOBVector>`
+
+func Test_Decoder_StreamBlobs(t *testing.T) {
+	input := `<setBLOBVector device="Camera" name="CCD1" state="Ok" timeout="5" timestamp="2020-01-02T03:04:05">
+	<oneBLOB name="CCD1" size="11" format=".fits">
+		aGVsbG8g
+		<!-- a comment -->
+		<![CDATA[d29y]]>bGQ=
+	</oneBLOB>
+	<oneBLOB name="EMPTY" size="0" format=".txt"/>
+</setBLOBVector>
+<message device="Camera" message="done"/>`
+
+	dec := indiclient.NewDecoder(strings.NewReader(input))
+
+	received := map[string]string{}
+
+	dec.StreamBlobs(func(vector *indiclient.SetBlobVector, blob *indiclient.OneBlob, r io.Reader) error {
+		assert.Equal(t, "Camera", vector.Device)
+		assert.Equal(t, 5, vector.Timeout)
+
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+
+		received[blob.Name+blob.Format] = string(b)
+
+		return nil
+	})
+
+	item, err := dec.Decode()
+	require.NoError(t, err)
+
+	vector, ok := item.(*indiclient.SetBlobVector)
+	require.True(t, ok)
+	assert.Equal(t, "CCD1", vector.Name)
+	assert.Equal(t, indiclient.PropertyStateOk, vector.State)
+	assert.Equal(t, "2020-01-02T03:04:05", vector.Timestamp)
+	require.Len(t, vector.Blobs, 2)
+	assert.Equal(t, 11, vector.Blobs[0].Size)
+	assert.Empty(t, vector.Blobs[0].Value)
+
+	assert.Equal(t, map[string]string{
+		"CCD1.fits": "hello world",
+		"EMPTY.txt": "",
+	}, received)
+
+	item, err = dec.Decode()
+	require.NoError(t, err)
+
+	msg, ok := item.(*indiclient.Message)
+	require.True(t, ok)
+	assert.Equal(t, "done", msg.Message)
+}
+
+func Test_Decoder_StreamBlobs_Error(t *testing.T) {
+	input := `<setBLOBVector device="Camera" name="CCD1" state="Ok"><oneBLOB name="CCD1" size="5" format=".fits">aGVsbG8=</oneBLOB><oneBLOB name="CCD2" size="5" format=".fits">aGVsbG8=</oneBLOB></setBLOBVector>
+<message device="Camera" message="done"/>`
+
+	dec := indiclient.NewDecoder(strings.NewReader(input))
+
+	errFull := errors.New("disk full")
+
+	dec.StreamBlobs(func(vector *indiclient.SetBlobVector, blob *indiclient.OneBlob, r io.Reader) error {
+		return errFull
+	})
+
+	_, err := dec.Decode()
+	require.Error(t, err)
+
+	elemErr, ok := err.(*indiclient.ElementError)
+	require.True(t, ok)
+	assert.Equal(t, "setBLOBVector", elemErr.Element)
+	assert.Equal(t, errFull, elemErr.Err)
+
+	// The rest of the setBLOBVector is skipped, and the stream is still usable.
+	item, err := dec.Decode()
+	require.NoError(t, err)
+
+	_, ok = item.(*indiclient.Message)
+	assert.True(t, ok)
+}
+
+var zeroLine = []byte(strings.Repeat("A", 76) + "\n")
+
+// lineReader returns lines of base64 encoded zeros, 76 characters long like INDI drivers send.
+type lineReader struct {
+	lines int
+	buf   []byte
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.lines == 0 {
+			return 0, io.EOF
+		}
+
+		r.buf = zeroLine
+		r.lines--
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+func Test_Decoder_StreamBlobs_BoundedMemory(t *testing.T) {
+	const lines = 500000 // 38 MB of base64
+
+	size := lines * len(zeroLine)
+
+	r := io.MultiReader(
+		strings.NewReader(fmt.Sprintf(`<setBLOBVector device="Camera" name="CCD1" state="Ok"><oneBLOB name="CCD1" size="%d" format=".fits">`, lines*57)),
+		&lineReader{lines: lines},
+		strings.NewReader(`</oneBLOB></setBLOBVector>`),
+	)
+
+	dec := indiclient.NewDecoder(r)
+
+	var decoded int64
+
+	dec.StreamBlobs(func(vector *indiclient.SetBlobVector, blob *indiclient.OneBlob, r io.Reader) error {
+		var err error
+		decoded, err = io.Copy(ioutil.Discard, r)
+
+		return err
+	})
+
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	_, err := dec.Decode()
+	require.NoError(t, err)
+
+	runtime.ReadMemStats(&after)
+
+	assert.Equal(t, int64(base64.StdEncoding.DecodedLen(lines*76)), decoded)
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < uint64(size/4), "allocated %d bytes decoding a %d byte BLOB", after.TotalAlloc-before.TotalAlloc, size)
+}
@@ -0,0 +1,302 @@
+package indiclient
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ReconnectPolicy controls how an INDIClient reconnects after losing its connection to indiserver. Connections
+// closed with Disconnect are never reconnected.
+//
+// After reconnecting, the GetProperties and EnableBlob calls made since Connect are sent again, so devices are
+// redefined and BLOBs keep arriving. A call for a whole device, or for every device, replaces the earlier calls for
+// the properties it covers, so only what is still in effect is sent. Streams opened with GetBlobStream stay registered and resume receiving BLOBs.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of times to try reconnecting before giving up. 0 means try forever.
+	MaxAttempts int
+
+	// InitialBackoff is how long to wait before the first attempt. Defaults to 1 second.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between attempts. Defaults to 1 minute.
+	MaxBackoff time.Duration
+
+	// Multiplier is applied to the wait after each failed attempt. Defaults to 2.
+	Multiplier float64
+
+	// Jitter randomizes each wait by up to this fraction of it, in either direction, so many clients don't reconnect
+	// to the same server in lockstep. Should be between 0 and 1.
+	Jitter float64
+
+	// OnDisconnect, if set, is called when the connection is lost, with the error that caused it.
+	OnDisconnect func(err error)
+
+	// OnConnect, if set, is called once the client has reconnected, with the number of attempts it took.
+	OnConnect func(attempt int)
+
+	// OnGiveUp, if set, is called after MaxAttempts attempts have failed, with the error from the last one.
+	OnGiveUp func(err error)
+}
+
+// backoff returns how long to wait before the given attempt, starting at 1.
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = time.Second
+	}
+
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = time.Minute
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if d > float64(max) {
+		d = float64(max)
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// SetReconnectPolicy sets how the client reconnects after losing its connection. A nil policy (the default) disables
+// reconnecting, and the client simply disconnects.
+func (c *INDIClient) SetReconnectPolicy(policy *ReconnectPolicy) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	c.reconnect = policy
+}
+
+// reconnection is a reconnect loop in progress.
+type reconnection struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// connectionLost tears down sess after the server closed it or it failed, and starts reconnecting if there is a
+// ReconnectPolicy.
+func (c *INDIClient) connectionLost(sess *session, err error) {
+	c.connMu.Lock()
+
+	if c.sess != sess {
+		// Already torn down by Disconnect or replaced by a newer connection.
+		c.connMu.Unlock()
+		return
+	}
+
+	c.sess = nil
+
+	policy := c.reconnect
+
+	var r *reconnection
+	if policy != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		r = &reconnection{ctx: ctx, cancel: cancel}
+		c.reconnecting = r
+	}
+
+	c.connMu.Unlock()
+
+	sess.shutdown()
+
+	// Clear out all devices
+	c.delProperty(&DelProperty{})
+
+	if policy == nil {
+		return
+	}
+
+	if policy.OnDisconnect != nil {
+		policy.OnDisconnect(err)
+	}
+
+	go c.reconnectLoop(*policy, r)
+}
+
+func (c *INDIClient) reconnectLoop(policy ReconnectPolicy, r *reconnection) {
+	defer r.cancel()
+
+	c.connMu.Lock()
+	network, address := c.network, c.address
+	c.connMu.Unlock()
+
+	var err error
+
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(policy.backoff(attempt)):
+		}
+
+		err = c.connect(r.ctx, network, address)
+		if r.ctx.Err() != nil {
+			// Disconnect or Connect was called while we were dialing.
+			return
+		}
+
+		if err != nil {
+			c.log.WithField("attempt", attempt).WithError(err).Warn("error reconnecting")
+			continue
+		}
+
+		c.finishReconnecting(r)
+
+		err = c.resync(r.ctx)
+		if err != nil {
+			c.log.WithError(err).Warn("error in c.resync")
+		}
+
+		if policy.OnConnect != nil {
+			policy.OnConnect(attempt)
+		}
+
+		return
+	}
+
+	c.finishReconnecting(r)
+
+	if policy.OnGiveUp != nil {
+		policy.OnGiveUp(err)
+	}
+}
+
+// propertyKey identifies a property. An empty name means every property of the device, and an empty device every
+// device.
+type propertyKey struct {
+	device string
+	name   string
+}
+
+// covers returns true if k is o, or includes it.
+func (k propertyKey) covers(o propertyKey) bool {
+	return (len(k.device) == 0 || k.device == o.device) && (len(k.name) == 0 || k.name == o.name)
+}
+
+// recordGetProperties remembers a GetProperties command to send again after reconnecting, unless an earlier one
+// already covers it, and forgets the earlier ones it covers.
+func (c *INDIClient) recordGetProperties(cmd GetProperties) {
+	key := propertyKey{device: cmd.Device, name: cmd.Name}
+
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+
+	for k := range c.requestedProperties {
+		if k.covers(key) {
+			return
+		}
+	}
+
+	for k := range c.requestedProperties {
+		if key.covers(k) {
+			delete(c.requestedProperties, k)
+		}
+	}
+
+	if c.requestedProperties == nil {
+		c.requestedProperties = map[propertyKey]GetProperties{}
+	}
+
+	c.requestedProperties[key] = cmd
+}
+
+// recordEnableBlob remembers an EnableBlob command to send again after reconnecting, replacing the earlier ones it
+// covers. indiserver applies an EnableBlob for a whole device to each of its properties.
+func (c *INDIClient) recordEnableBlob(cmd EnableBlob) {
+	key := propertyKey{device: cmd.Device, name: cmd.Name}
+
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+
+	for k := range c.enabledBlobs {
+		if key.covers(k) {
+			delete(c.enabledBlobs, k)
+		}
+	}
+
+	if c.enabledBlobs == nil {
+		c.enabledBlobs = map[propertyKey]EnableBlob{}
+	}
+
+	c.enabledBlobs[key] = cmd
+}
+
+// clearReplay forgets the commands recorded for a previous connection.
+func (c *INDIClient) clearReplay() {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+
+	c.requestedProperties = nil
+	c.enabledBlobs = nil
+}
+
+// resync repeats the GetProperties and EnableBlob calls made before the connection was lost.
+func (c *INDIClient) resync(ctx context.Context) error {
+	c.replayMu.Lock()
+
+	cmds := make([]interface{}, 0, len(c.requestedProperties)+len(c.enabledBlobs))
+
+	for _, cmd := range c.requestedProperties {
+		cmds = append(cmds, cmd)
+	}
+
+	// A call for a whole device is sent before those for its properties, which were made after it.
+	blobs := make([]EnableBlob, 0, len(c.enabledBlobs))
+	for _, cmd := range c.enabledBlobs {
+		blobs = append(blobs, cmd)
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		if blobs[i].Device != blobs[j].Device {
+			return blobs[i].Device < blobs[j].Device
+		}
+
+		return blobs[i].Name < blobs[j].Name
+	})
+
+	for _, cmd := range blobs {
+		cmds = append(cmds, cmd)
+	}
+
+	c.replayMu.Unlock()
+
+	for _, cmd := range cmds {
+		err := c.sendContext(ctx, cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *INDIClient) finishReconnecting(r *reconnection) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.reconnecting == r {
+		c.reconnecting = nil
+	}
+}
+
+func (c *INDIClient) stopReconnecting() {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.reconnecting != nil {
+		c.reconnecting.cancel()
+		c.reconnecting = nil
+	}
+}
@@ -0,0 +1,257 @@
+package indiclient_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+// queueDialer hands the server end of every connection it makes to conns, and can be made to fail.
+type queueDialer struct {
+	mu    sync.Mutex
+	fail  int
+	conns chan net.Conn
+}
+
+func (d *queueDialer) Dial(network, address string) (io.ReadWriteCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fail > 0 {
+		d.fail--
+		return nil, errors.New("connection refused")
+	}
+
+	client, server := net.Pipe()
+	d.conns <- server
+
+	return client, nil
+}
+
+func Test_Reconnect(t *testing.T) {
+	dialer := &queueDialer{conns: make(chan net.Conn, 5)}
+
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+	c := indiclient.NewINDIClient(log, dialer, afero.NewMemMapFs(), 5)
+
+	disconnected := make(chan error, 1)
+	connected := make(chan int, 1)
+
+	c.SetReconnectPolicy(&indiclient.ReconnectPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxAttempts:    5,
+		OnDisconnect:   func(err error) { disconnected <- err },
+		OnConnect:      func(attempt int) { connected <- attempt },
+	})
+
+	require.NoError(t, c.Connect("tcp", "localhost:7624"))
+	defer c.Disconnect()
+
+	server := <-dialer.conns
+
+	require.NoError(t, c.GetProperties("", ""))
+	readCommand(t, server, &indiclient.GetProperties{})
+
+	defineProperties(t, c, server, `<defBLOBVector device="Camera" name="CCD1" state="Idle" perm="ro" timeout="0"><defBLOB name="CCD1"/></defBLOBVector>`, 1)
+
+	require.NoError(t, c.EnableBlob("Camera", "CCD1", indiclient.BlobEnableAlso))
+	readCommand(t, server, &indiclient.EnableBlob{})
+
+	dialer.mu.Lock()
+	dialer.fail = 2
+	dialer.mu.Unlock()
+
+	server.Close()
+
+	select {
+	case err := <-disconnected:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for disconnect")
+	}
+
+	assert.Empty(t, c.Devices())
+
+	server = <-dialer.conns
+
+	var getProperties indiclient.GetProperties
+	readCommand(t, server, &getProperties)
+	assert.Equal(t, "1.7", getProperties.Version)
+
+	var enableBlob indiclient.EnableBlob
+	readCommand(t, server, &enableBlob)
+	assert.Equal(t, "Camera", enableBlob.Device)
+	assert.Equal(t, indiclient.BlobEnableAlso, enableBlob.Value)
+
+	select {
+	case attempt := <-connected:
+		assert.Equal(t, 3, attempt)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for reconnect")
+	}
+
+	assert.True(t, c.IsConnected())
+}
+
+// replayedCommand is a getProperties or enableBLOB command sent after reconnecting.
+type replayedCommand struct {
+	XMLName xml.Name
+	Device  string `xml:"device,attr"`
+	Name    string `xml:"name,attr"`
+	Value   string `xml:",chardata"`
+}
+
+func Test_Reconnect_Replay(t *testing.T) {
+	dialer := &queueDialer{conns: make(chan net.Conn, 5)}
+
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+	c := indiclient.NewINDIClient(log, dialer, afero.NewMemMapFs(), 5)
+
+	connected := make(chan int, 1)
+
+	c.SetReconnectPolicy(&indiclient.ReconnectPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		OnConnect:      func(attempt int) { connected <- attempt },
+	})
+
+	require.NoError(t, c.Connect("tcp", "localhost:7624"))
+	defer c.Disconnect()
+
+	server := <-dialer.conns
+
+	defineProperties(t, c, server, `<defBLOBVector device="Camera" name="CCD1" state="Idle" perm="ro" timeout="0"><defBLOB name="CCD1"/></defBLOBVector>`, 1)
+
+	// Calls that later calls replace, or that earlier calls cover, are not sent again.
+	calls := []func() error{
+		func() error { return c.GetProperties("Camera", "CCD1") },
+		func() error { return c.GetProperties("Camera", "") },
+		func() error { return c.GetProperties("", "") },
+		func() error { return c.GetProperties("", "") },
+		func() error { return c.GetProperties("Camera", "CCD2") },
+		func() error { return c.EnableBlob("Camera", "CCD1", indiclient.BlobEnableAlso) },
+		func() error { return c.EnableBlob("Camera", "CCD1", indiclient.BlobEnableAlso) },
+		func() error { return c.EnableBlob("Camera", "", indiclient.BlobEnableNever) },
+		func() error { return c.EnableBlob("Camera", "CCD1", indiclient.BlobEnableOnly) },
+	}
+
+	for _, call := range calls {
+		require.NoError(t, call())
+		readCommand(t, server, &replayedCommand{})
+	}
+
+	reconnect := func() net.Conn {
+		server.Close()
+
+		select {
+		case <-connected:
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "timed out waiting for reconnect")
+		}
+
+		return <-dialer.conns
+	}
+
+	// replayed reads commands until the one sent after the replay.
+	replayed := func() []replayedCommand {
+		require.NoError(t, c.GetProperties("Marker", ""))
+
+		var cmds []replayedCommand
+
+		for {
+			var cmd replayedCommand
+			readCommand(t, server, &cmd)
+
+			if cmd.Device == "Marker" {
+				return cmds
+			}
+
+			cmds = append(cmds, cmd)
+		}
+	}
+
+	server = reconnect()
+
+	assert.Equal(t, []replayedCommand{
+		{XMLName: xml.Name{Local: "getProperties"}},
+		{XMLName: xml.Name{Local: "enableBLOB"}, Device: "Camera", Value: "Never"},
+		{XMLName: xml.Name{Local: "enableBLOB"}, Device: "Camera", Name: "CCD1", Value: "Only"},
+	}, replayed())
+
+	// Connect starts over, forgetting the calls made on the previous connection.
+	require.NoError(t, c.Connect("tcp", "localhost:7624"))
+	server = <-dialer.conns
+
+	require.NoError(t, c.GetProperties("Focuser", ""))
+	readCommand(t, server, &replayedCommand{})
+
+	server = reconnect()
+
+	assert.Equal(t, []replayedCommand{
+		{XMLName: xml.Name{Local: "getProperties"}, Device: "Focuser"},
+	}, replayed())
+}
+
+func Test_Reconnect_GiveUp(t *testing.T) {
+	dialer := &queueDialer{conns: make(chan net.Conn, 5)}
+
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+	c := indiclient.NewINDIClient(log, dialer, afero.NewMemMapFs(), 5)
+
+	gaveUp := make(chan error, 1)
+
+	c.SetReconnectPolicy(&indiclient.ReconnectPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxAttempts:    2,
+		OnGiveUp:       func(err error) { gaveUp <- err },
+	})
+
+	require.NoError(t, c.Connect("tcp", "localhost:7624"))
+	defer c.Disconnect()
+
+	dialer.mu.Lock()
+	dialer.fail = 2
+	dialer.mu.Unlock()
+
+	(<-dialer.conns).Close()
+
+	select {
+	case err := <-gaveUp:
+		assert.EqualError(t, err, "connection refused")
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting to give up")
+	}
+
+	assert.False(t, c.IsConnected())
+}
+
+func Test_Disconnect_DoesNotReconnect(t *testing.T) {
+	dialer := &queueDialer{conns: make(chan net.Conn, 5)}
+
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+	c := indiclient.NewINDIClient(log, dialer, afero.NewMemMapFs(), 5)
+
+	c.SetReconnectPolicy(&indiclient.ReconnectPolicy{InitialBackoff: time.Millisecond})
+
+	require.NoError(t, c.Connect("tcp", "localhost:7624"))
+	<-dialer.conns
+
+	require.NoError(t, c.Disconnect())
+
+	select {
+	case <-dialer.conns:
+		assert.Fail(t, "client reconnected after Disconnect")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
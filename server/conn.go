@@ -0,0 +1,154 @@
+package server
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/goastro/indiclient"
+)
+
+// conn is a client or driver connected to a Server. watching, blobModes, and devices are guarded by the server's
+// mutex.
+type conn struct {
+	name   string
+	rwc    io.ReadWriteCloser
+	driver bool
+	out    *queue
+	done   chan struct{}
+	once   sync.Once
+
+	// watching holds the getProperties a client sent, or for a driver, the devices it is snooping on.
+	watching  []indiclient.GetProperties
+	blobModes map[string]indiclient.BlobEnable
+
+	// devices holds the devices a driver has defined.
+	devices map[string]bool
+}
+
+func newConn(name string, rwc io.ReadWriteCloser, driver bool) *conn {
+	return &conn{
+		name:      name,
+		rwc:       rwc,
+		driver:    driver,
+		out:       newQueue(),
+		done:      make(chan struct{}),
+		blobModes: map[string]indiclient.BlobEnable{},
+		devices:   map[string]bool{},
+	}
+}
+
+// wants returns true if the connection should be sent messages about the property, or about the whole device if name
+// is empty. blob selects between setBLOBVectors and everything else, following the enableBLOB settings of the
+// connection.
+func (c *conn) wants(device, name string, blob bool) bool {
+	watching := false
+	for _, gp := range c.watching {
+		if len(gp.Device) > 0 && len(device) > 0 && gp.Device != device {
+			continue
+		}
+
+		if len(gp.Name) > 0 && len(name) > 0 && gp.Name != name {
+			continue
+		}
+
+		watching = true
+		break
+	}
+
+	if !watching {
+		return false
+	}
+
+	mode, ok := c.blobModes[device+"\x00"+name]
+	if !ok {
+		mode, ok = c.blobModes[device+"\x00"]
+	}
+
+	if !ok {
+		mode = indiclient.BlobEnableNever
+	}
+
+	if blob {
+		return mode == indiclient.BlobEnableAlso || mode == indiclient.BlobEnableOnly
+	}
+
+	return mode != indiclient.BlobEnableOnly
+}
+
+// setBlobMode records an enableBLOB. One for a whole device replaces any the connection sent for its properties, as
+// it does in indiserver, so the latest one always applies.
+func (c *conn) setBlobMode(device, name string, mode indiclient.BlobEnable) {
+	if len(name) == 0 {
+		for key := range c.blobModes {
+			if strings.HasPrefix(key, device+"\x00") {
+				delete(c.blobModes, key)
+			}
+		}
+	}
+
+	c.blobModes[device+"\x00"+name] = mode
+}
+
+func (c *conn) writeLoop() {
+	for {
+		for {
+			b, ok := c.out.pop()
+			if !ok {
+				break
+			}
+
+			_, err := c.rwc.Write(b)
+			c.out.written(b)
+
+			if err != nil {
+				c.close()
+				return
+			}
+		}
+
+		select {
+		case <-c.out.signal:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.rwc.Close()
+	})
+}
+
+// process is a driver running as a subprocess, talking INDI over its stdin and stdout.
+type process struct {
+	io.Reader
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+
+	// stderrDone is closed once everything the process wrote to stderr has been read.
+	stderrDone chan struct{}
+}
+
+func (p *process) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close closes stdin and kills the process. The process must still be waited for.
+func (p *process) Close() error {
+	err := p.stdin.Close()
+
+	p.cmd.Process.Kill()
+
+	return err
+}
+
+// wait waits for the process to exit. Call it only once stdout has been read to the end.
+func (p *process) wait() error {
+	<-p.stderrDone
+
+	return p.cmd.Wait()
+}
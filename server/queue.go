@@ -0,0 +1,64 @@
+package server
+
+import "sync"
+
+// queue holds the messages waiting to be written to a connection, and how many bytes they add up to. A message
+// counts against the size of the queue until it has been written, not just until it has been taken off the queue.
+type queue struct {
+	mu     sync.Mutex
+	items  [][]byte
+	size   int
+	signal chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// push adds b to the queue, unless limit bytes or more are already waiting. A message bigger than limit is still
+// queued while there is room, so a large BLOB is not turned away from a client that is keeping up. A limit of 0 or
+// less means no limit.
+func (q *queue) push(b []byte, limit int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limit > 0 && q.size >= limit {
+		return false
+	}
+
+	q.items = append(q.items, b)
+	q.size += len(b)
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// pop removes and returns the oldest message, or false if the queue is empty. Call written once it has been written.
+func (q *queue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return nil, false
+	}
+
+	b := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+
+	return b, true
+}
+
+// written stops counting a message returned by pop against the size of the queue.
+func (q *queue) written(b []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.size -= len(b)
+}
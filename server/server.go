@@ -0,0 +1,530 @@
+// Package server is an INDI server written in Go, a replacement for indiserver.
+//
+// A Server launches drivers as subprocesses, or accepts drivers already connected some other way, and accepts
+// clients over TCP or unix sockets. Messages from drivers are routed to the clients, and other drivers, that asked
+// for the device, following the enableBLOB settings of each. Commands from clients are routed to the driver that
+// defined the device.
+//
+// Clients never hold up drivers. Each client has a write queue; once it holds MaxBlobQueueBytes, BLOBs for that
+// client are dropped, and once it holds MaxQueueBytes, the client is disconnected.
+package server
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/rickbassham/logging"
+
+	"github.com/goastro/indiclient"
+)
+
+const (
+	// DefaultMaxQueueBytes is used when Options.MaxQueueBytes is not set. It is the same as indiserver's default.
+	DefaultMaxQueueBytes = 128 << 20
+
+	// DefaultMaxBlobQueueBytes is used when Options.MaxBlobQueueBytes is not set.
+	DefaultMaxBlobQueueBytes = 16 << 20
+)
+
+// ErrServerClosed is returned when adding a client or driver to a Server that has been closed.
+var ErrServerClosed = errors.New("server closed")
+
+// Options configures a Server.
+type Options struct {
+	// MaxQueueBytes is how much may be waiting to be written to a client before it is disconnected for being too
+	// slow. Defaults to DefaultMaxQueueBytes.
+	MaxQueueBytes int
+
+	// MaxBlobQueueBytes is how much may be waiting to be written to a client before BLOBs for it are dropped instead
+	// of queued. Defaults to DefaultMaxBlobQueueBytes.
+	MaxBlobQueueBytes int
+}
+
+// Server routes INDI messages between drivers and clients. Create one with New.
+type Server struct {
+	log  logging.Logger
+	opts Options
+
+	mu           sync.Mutex
+	clients      map[*conn]struct{}
+	drivers      map[*conn]struct{}
+	owners       map[string]*conn
+	listeners    []net.Listener
+	droppedBlobs uint64
+	closed       bool
+
+	wg sync.WaitGroup
+}
+
+// New creates a Server with no drivers or clients.
+func New(log logging.Logger, opts Options) *Server {
+	if opts.MaxQueueBytes <= 0 {
+		opts.MaxQueueBytes = DefaultMaxQueueBytes
+	}
+
+	if opts.MaxBlobQueueBytes <= 0 {
+		opts.MaxBlobQueueBytes = DefaultMaxBlobQueueBytes
+	}
+
+	return &Server{
+		log:     log,
+		opts:    opts,
+		clients: map[*conn]struct{}{},
+		drivers: map[*conn]struct{}{},
+		owners:  map[string]*conn{},
+	}
+}
+
+// StartDriver launches the driver executable at path, and talks to it over its stdin and stdout. Anything the driver
+// writes to stderr is logged.
+func (s *Server) StartDriver(path string, args ...string) error {
+	cmd := exec.Command(path, args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	p := &process{Reader: stdout, stdin: stdin, cmd: cmd, stderrDone: make(chan struct{})}
+
+	go func() {
+		defer close(p.stderrDone)
+
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			s.log.WithField("driver", name).Info(scanner.Text())
+		}
+	}()
+
+	err = s.AttachDriver(name, p)
+	if err != nil {
+		p.Close()
+		p.wait()
+
+		return err
+	}
+
+	return nil
+}
+
+// AttachDriver adds a driver that is already connected over rwc, for example one running in the same process with
+// the driver package. name is only used for logging.
+func (s *Server) AttachDriver(name string, rwc io.ReadWriteCloser) error {
+	return s.serve(newConn(name, rwc, true))
+}
+
+// ServeClient adds a client connected over rwc.
+func (s *Server) ServeClient(rwc io.ReadWriteCloser) error {
+	name := "client"
+	if nc, ok := rwc.(net.Conn); ok && nc.RemoteAddr() != nil {
+		name = nc.RemoteAddr().String()
+	}
+
+	return s.serve(newConn(name, rwc, false))
+}
+
+// Listen accepts clients on network, which is "tcp" or "unix", until the server is closed. For unix sockets, any file
+// already at address is removed first. The address actually listened on is returned.
+func (s *Server) Listen(network, address string) (net.Addr, error) {
+	if network == "unix" {
+		os.Remove(address)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+
+		return nil, ErrServerClosed
+	}
+
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			err = s.ServeClient(nc)
+			if err != nil {
+				nc.Close()
+				return
+			}
+		}
+	}()
+
+	return l.Addr(), nil
+}
+
+// DroppedBlobs returns how many BLOBs have been dropped because clients were not reading them fast enough.
+func (s *Server) DroppedBlobs() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.droppedBlobs
+}
+
+// Close stops listening, disconnects every client, stops every driver, and waits for them all to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+
+	for _, l := range s.listeners {
+		l.Close()
+	}
+
+	for c := range s.clients {
+		c.close()
+	}
+
+	for c := range s.drivers {
+		c.close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *Server) serve(c *conn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+
+	if c.driver {
+		s.drivers[c] = struct{}{}
+	} else {
+		s.clients[c] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	s.log.WithField("name", c.name).WithField("driver", c.driver).Info("connected")
+
+	s.wg.Add(2)
+
+	go func() {
+		defer s.wg.Done()
+
+		c.writeLoop()
+	}()
+
+	go func() {
+		defer s.wg.Done()
+
+		s.readLoop(c)
+	}()
+
+	return nil
+}
+
+func (s *Server) readLoop(c *conn) {
+	defer s.remove(c)
+
+	dec := indiclient.NewDecoder(c.rwc)
+
+	for {
+		item, err := dec.Decode()
+		if err != nil {
+			if elemErr, ok := err.(*indiclient.ElementError); ok {
+				s.log.WithField("name", c.name).WithField("element", elemErr.Element).WithError(elemErr.Err).Warn("error in dec.Decode")
+				continue
+			}
+
+			if err != io.EOF {
+				s.log.WithField("name", c.name).WithError(err).Warn("error in dec.Decode")
+			}
+
+			return
+		}
+
+		if c.driver {
+			s.fromDriver(c, item)
+		} else {
+			s.fromClient(c, item)
+		}
+	}
+}
+
+// remove disconnects c. If it is a driver, clients are told its devices are gone.
+func (s *Server) remove(c *conn) {
+	c.close()
+
+	if p, ok := c.rwc.(*process); ok {
+		err := p.wait()
+		if err != nil {
+			s.log.WithField("name", c.name).WithError(err).Warn("driver exited")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.log.WithField("name", c.name).WithField("driver", c.driver).Info("disconnected")
+
+	if !c.driver {
+		delete(s.clients, c)
+		return
+	}
+
+	delete(s.drivers, c)
+
+	for device := range c.devices {
+		if s.owners[device] == c {
+			delete(s.owners, device)
+
+			del := &indiclient.DelProperty{Device: device}
+
+			b, err := marshal(del)
+			if err != nil {
+				s.log.WithField("device", device).WithError(err).Warn("error in marshal")
+				continue
+			}
+
+			s.route(c, del, b)
+		}
+	}
+}
+
+// encode marshals an element read from a connection, so that it can be passed on. It is called before taking s.mu,
+// which every connection shares, since encoding a BLOB can take a while. enableBLOB is never passed on, so it is not
+// encoded. false is returned if the element cannot be encoded.
+func (s *Server) encode(item interface{}) ([]byte, bool) {
+	if _, ok := item.(*indiclient.EnableBlob); ok {
+		return nil, true
+	}
+
+	b, err := marshal(item)
+	if err != nil {
+		device, name := deviceOf(item)
+		s.log.WithField("device", device).WithField("property", name).WithError(err).Warn("error in marshal")
+
+		return nil, false
+	}
+
+	return b, true
+}
+
+func (s *Server) fromClient(c *conn, item interface{}) {
+	b, ok := s.encode(item)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd := item.(type) {
+	case *indiclient.GetProperties:
+		c.watching = append(c.watching, *cmd)
+		s.toDrivers(c, cmd.Device, b)
+
+	case *indiclient.EnableBlob:
+		c.setBlobMode(cmd.Device, cmd.Name, cmd.Value)
+
+	case *indiclient.NewTextVector, *indiclient.NewNumberVector, *indiclient.NewSwitchVector, *indiclient.NewBlobVector:
+		device, name := deviceOf(item)
+
+		d, ok := s.owners[device]
+		if !ok {
+			s.log.WithField("device", device).WithField("property", name).Warn("no driver for device")
+			return
+		}
+
+		s.enqueue(d, b, false)
+
+	default:
+		s.log.WithField("name", c.name).WithField("type", fmt.Sprintf("%T", item)).Warn("unexpected element from client")
+	}
+}
+
+func (s *Server) fromDriver(c *conn, item interface{}) {
+	b, ok := s.encode(item)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg := item.(type) {
+	case *indiclient.GetProperties:
+		// The driver wants to snoop on another device.
+		c.watching = append(c.watching, *msg)
+		s.toDrivers(c, msg.Device, b)
+
+	case *indiclient.EnableBlob:
+		c.setBlobMode(msg.Device, msg.Name, msg.Value)
+
+	case *indiclient.DefTextVector, *indiclient.DefNumberVector, *indiclient.DefSwitchVector, *indiclient.DefLightVector, *indiclient.DefBlobVector:
+		device, _ := deviceOf(item)
+
+		c.devices[device] = true
+		s.owners[device] = c
+
+		s.route(c, item, b)
+
+	case *indiclient.SetTextVector, *indiclient.SetNumberVector, *indiclient.SetSwitchVector, *indiclient.SetLightVector, *indiclient.SetBlobVector, *indiclient.DelProperty, *indiclient.Message:
+		s.route(c, item, b)
+
+	default:
+		s.log.WithField("name", c.name).WithField("type", fmt.Sprintf("%T", item)).Warn("unexpected element from driver")
+	}
+}
+
+// toDrivers sends a getProperties, encoded as b, to the driver that defined device, or to every driver other than from
+// if device is empty or not defined yet. s.mu must be held.
+func (s *Server) toDrivers(from *conn, device string, b []byte) {
+	if d, ok := s.owners[device]; ok && len(device) > 0 {
+		if d != from {
+			s.enqueue(d, b, false)
+		}
+
+		return
+	}
+
+	for d := range s.drivers {
+		if d != from {
+			s.enqueue(d, b, false)
+		}
+	}
+}
+
+// route sends a message from a driver, encoded as b, to every client, and every other driver, that wants it. s.mu must
+// be held.
+func (s *Server) route(from *conn, item interface{}, b []byte) {
+	device, name := deviceOf(item)
+	_, blob := item.(*indiclient.SetBlobVector)
+
+	for c := range s.clients {
+		if c.wants(device, name, blob) {
+			s.enqueue(c, b, blob)
+		}
+	}
+
+	for d := range s.drivers {
+		if d != from && d.wants(device, name, blob) {
+			s.enqueue(d, b, blob)
+		}
+	}
+}
+
+// enqueue queues b to be written to c. A client that has fallen too far behind has BLOBs dropped, and is disconnected
+// if it falls further. s.mu must be held.
+func (s *Server) enqueue(c *conn, b []byte, blob bool) {
+	if c.driver {
+		c.out.push(b, 0)
+		return
+	}
+
+	if blob {
+		if !c.out.push(b, s.opts.MaxBlobQueueBytes) {
+			s.droppedBlobs++
+			s.log.WithField("name", c.name).Debug("dropped BLOB for slow client")
+		}
+
+		return
+	}
+
+	if !c.out.push(b, s.opts.MaxQueueBytes) {
+		s.log.WithField("name", c.name).Warn("disconnecting slow client")
+		c.close()
+	}
+}
+
+// deviceOf returns the device and property an INDI element is about.
+func deviceOf(item interface{}) (device, name string) {
+	switch e := item.(type) {
+	case *indiclient.GetProperties:
+		return e.Device, e.Name
+	case *indiclient.EnableBlob:
+		return e.Device, e.Name
+	case *indiclient.DefTextVector:
+		return e.Device, e.Name
+	case *indiclient.DefNumberVector:
+		return e.Device, e.Name
+	case *indiclient.DefSwitchVector:
+		return e.Device, e.Name
+	case *indiclient.DefLightVector:
+		return e.Device, e.Name
+	case *indiclient.DefBlobVector:
+		return e.Device, e.Name
+	case *indiclient.SetTextVector:
+		return e.Device, e.Name
+	case *indiclient.SetNumberVector:
+		return e.Device, e.Name
+	case *indiclient.SetSwitchVector:
+		return e.Device, e.Name
+	case *indiclient.SetLightVector:
+		return e.Device, e.Name
+	case *indiclient.SetBlobVector:
+		return e.Device, e.Name
+	case *indiclient.NewTextVector:
+		return e.Device, e.Name
+	case *indiclient.NewNumberVector:
+		return e.Device, e.Name
+	case *indiclient.NewSwitchVector:
+		return e.Device, e.Name
+	case *indiclient.NewBlobVector:
+		return e.Device, e.Name
+	case *indiclient.DelProperty:
+		return e.Device, e.Name
+	case *indiclient.Message:
+		return e.Device, ""
+	}
+
+	return "", ""
+}
+
+// marshal encodes an element to send, on a line of its own.
+func marshal(item interface{}) ([]byte, error) {
+	b, err := xml.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
@@ -0,0 +1,465 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+	"github.com/goastro/indiclient/driver"
+	"github.com/goastro/indiclient/server"
+)
+
+var discard = logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+
+// helperDriverEnv makes the test binary run helperDriver instead of the tests, so StartDriver has something to launch.
+const helperDriverEnv = "INDISERVER_HELPER_DRIVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperDriverEnv) == "1" {
+		helperDriver()
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+func helperDriver() {
+	d := driver.New(logging.NewLogger(os.Stderr, logging.JSONFormatter{}, logging.LogLevelInfo))
+
+	d.AddDevice("Helper").DefineSwitch(indiclient.DefSwitchVector{
+		Name:  "EXIT",
+		State: indiclient.PropertyStateIdle,
+		Perm:  indiclient.PropertyPermissionReadWrite,
+		Rule:  indiclient.SwitchRuleAnyOfMany,
+		Switches: []indiclient.DefSwitch{
+			{Name: "NOW", Value: indiclient.SwitchStateOff},
+		},
+	}, func(p *driver.SwitchProperty, values map[string]indiclient.SwitchState) error {
+		os.Exit(0)
+		return nil
+	})
+
+	d.RunStdio(context.Background())
+}
+
+// connDialer hands out a single connection that already exists.
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d connDialer) Dial(network, address string) (io.ReadWriteCloser, error) {
+	return d.conn, nil
+}
+
+// newClient connects an INDIClient to s.
+func newClient(t *testing.T, s *server.Server) *indiclient.INDIClient {
+	clientEnd, serverEnd := net.Pipe()
+	require.NoError(t, s.ServeClient(serverEnd))
+
+	c := indiclient.NewINDIClient(discard, connDialer{clientEnd}, afero.NewMemMapFs(), 10)
+	require.NoError(t, c.Connect("tcp", "server"))
+
+	return c
+}
+
+// attachRoof runs a roof driver, written with the driver package, attached to s.
+func attachRoof(t *testing.T, s *server.Server) func() {
+	d := driver.New(discard)
+
+	d.AddDevice("Roof").DefineSwitch(indiclient.DefSwitchVector{
+		Name:  "DOME_MOTION",
+		State: indiclient.PropertyStateIdle,
+		Perm:  indiclient.PropertyPermissionReadWrite,
+		Rule:  indiclient.SwitchRuleOneOfMany,
+		Switches: []indiclient.DefSwitch{
+			{Name: "DOME_CW", Value: indiclient.SwitchStateOff},
+			{Name: "DOME_CCW", Value: indiclient.SwitchStateOn},
+		},
+	}, nil)
+
+	driverEnd, serverEnd := net.Pipe()
+	require.NoError(t, s.AttachDriver("roof", serverEnd))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ran := make(chan error, 1)
+	go func() {
+		ran <- d.Run(ctx, driverEnd, driverEnd)
+	}()
+
+	return func() {
+		cancel()
+		driverEnd.Close()
+		<-ran
+	}
+}
+
+// raw is one end of a connection to the server, that sends and receives INDI elements without an INDIClient or
+// driver in the way.
+type raw struct {
+	conn  net.Conn
+	items chan interface{}
+}
+
+func newRaw() (*raw, net.Conn) {
+	rawEnd, serverEnd := net.Pipe()
+
+	r := &raw{
+		conn:  rawEnd,
+		items: make(chan interface{}, 100),
+	}
+
+	go func() {
+		dec := indiclient.NewDecoder(rawEnd)
+
+		for {
+			item, err := dec.Decode()
+			if err != nil {
+				close(r.items)
+				return
+			}
+
+			r.items <- item
+		}
+	}()
+
+	return r, serverEnd
+}
+
+func (r *raw) send(t *testing.T, xml string) {
+	_, err := r.conn.Write([]byte(xml))
+	require.NoError(t, err)
+}
+
+func (r *raw) next(t *testing.T) interface{} {
+	select {
+	case item, ok := <-r.items:
+		require.True(t, ok, "connection closed")
+		return item
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for message")
+	}
+
+	return nil
+}
+
+const cameraDefXML = `<defBLOBVector device="Camera" name="CCD1" state="Idle" perm="ro"><defBLOB name="CCD1"/></defBLOBVector>
+<defNumberVector device="Camera" name="CCD_TEMPERATURE" state="Idle" perm="ro"><defNumber name="CCD_TEMPERATURE_VALUE" format="%5.2f" min="-50" max="50" step="0">20</defNumber></defNumberVector>
+`
+
+func setBlobXML(data string) string {
+	return fmt.Sprintf(`<setBLOBVector device="Camera" name="CCD1" state="Ok"><oneBLOB name="CCD1" size="%d" format=".fits">%s</oneBLOB></setBLOBVector>`+"\n", len(data), data)
+}
+
+func Test_Server_Routing(t *testing.T) {
+	s := server.New(discard, server.Options{})
+	defer s.Close()
+
+	defer attachRoof(t, s)()
+
+	c := newClient(t, s)
+	defer c.Disconnect()
+
+	other := newClient(t, s)
+	defer other.Disconnect()
+
+	require.NoError(t, c.GetProperties("", ""))
+	require.NoError(t, other.GetProperties("Roof", ""))
+
+	for _, client := range []*indiclient.INDIClient{c, other} {
+		client := client
+
+		assert.Eventually(t, func() bool {
+			_, err := client.SwitchProperty("Roof", "DOME_MOTION")
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := c.SetSwitchValueAndWait(ctx, "Roof", "DOME_MOTION", "DOME_CW", indiclient.SwitchStateOn)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		active, err := other.ActiveSwitch("Roof", "DOME_MOTION")
+		return err == nil && active == "DOME_CW"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func Test_Server_BlobModes(t *testing.T) {
+	s := server.New(discard, server.Options{})
+	defer s.Close()
+
+	camera, serverEnd := newRaw()
+	require.NoError(t, s.AttachDriver("camera", serverEnd))
+
+	clients := map[indiclient.BlobEnable]*raw{}
+
+	for _, mode := range []indiclient.BlobEnable{indiclient.BlobEnableNever, indiclient.BlobEnableAlso, indiclient.BlobEnableOnly} {
+		r, serverEnd := newRaw()
+		require.NoError(t, s.ServeClient(serverEnd))
+
+		r.send(t, `<enableBLOB device="Camera">`+string(mode)+`</enableBLOB>`)
+		r.send(t, `<getProperties version="1.7"/>`)
+
+		_, ok := camera.next(t).(*indiclient.GetProperties)
+		require.True(t, ok)
+
+		clients[mode] = r
+	}
+
+	camera.send(t, cameraDefXML)
+	camera.send(t, setBlobXML("AAAA"))
+	camera.send(t, `<setNumberVector device="Camera" name="CCD_TEMPERATURE" state="Ok"><oneNumber name="CCD_TEMPERATURE_VALUE">-10</oneNumber></setNumberVector>`)
+	camera.send(t, setBlobXML("BBBB"))
+	camera.send(t, `<message device="Camera" message="done"/>`)
+
+	types := func(r *raw, n int) []string {
+		var names []string
+		for i := 0; i < n; i++ {
+			names = append(names, fmt.Sprintf("%T", r.next(t)))
+		}
+
+		return names
+	}
+
+	assert.Equal(t, []string{
+		"*indiclient.DefBlobVector",
+		"*indiclient.DefNumberVector",
+		"*indiclient.SetNumberVector",
+		"*indiclient.Message",
+	}, types(clients[indiclient.BlobEnableNever], 4))
+
+	assert.Equal(t, []string{
+		"*indiclient.DefBlobVector",
+		"*indiclient.DefNumberVector",
+		"*indiclient.SetBlobVector",
+		"*indiclient.SetNumberVector",
+		"*indiclient.SetBlobVector",
+		"*indiclient.Message",
+	}, types(clients[indiclient.BlobEnableAlso], 6))
+
+	assert.Equal(t, []string{
+		"*indiclient.SetBlobVector",
+		"*indiclient.SetBlobVector",
+	}, types(clients[indiclient.BlobEnableOnly], 2))
+}
+
+func Test_Server_BlobModes_DeviceOverrides(t *testing.T) {
+	s := server.New(discard, server.Options{})
+	defer s.Close()
+
+	camera, serverEnd := newRaw()
+	require.NoError(t, s.AttachDriver("camera", serverEnd))
+
+	r, serverEnd := newRaw()
+	require.NoError(t, s.ServeClient(serverEnd))
+
+	// The enableBLOB for the whole device comes last, so it applies to CCD1 too.
+	r.send(t, `<enableBLOB device="Camera" name="CCD1">Never</enableBLOB>`)
+	r.send(t, `<enableBLOB device="Camera">Also</enableBLOB>`)
+	r.send(t, `<getProperties version="1.7"/>`)
+
+	_, ok := camera.next(t).(*indiclient.GetProperties)
+	require.True(t, ok)
+
+	camera.send(t, cameraDefXML)
+	camera.send(t, setBlobXML("AAAA"))
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		names = append(names, fmt.Sprintf("%T", r.next(t)))
+	}
+
+	assert.Equal(t, []string{
+		"*indiclient.DefBlobVector",
+		"*indiclient.DefNumberVector",
+		"*indiclient.SetBlobVector",
+	}, names)
+}
+
+func Test_Server_Snooping(t *testing.T) {
+	s := server.New(discard, server.Options{})
+	defer s.Close()
+
+	defer attachRoof(t, s)()
+
+	weather, serverEnd := newRaw()
+	require.NoError(t, s.AttachDriver("weather", serverEnd))
+
+	weather.send(t, `<getProperties version="1.7" device="Roof"/>`)
+
+	def, ok := weather.next(t).(*indiclient.DefSwitchVector)
+	require.True(t, ok)
+	assert.Equal(t, "DOME_MOTION", def.Name)
+
+	c := newClient(t, s)
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	require.NoError(t, c.GetProperties("Roof", ""))
+
+	assert.Eventually(t, func() bool {
+		_, err := c.SwitchProperty("Roof", "DOME_MOTION")
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, c.SetSwitchValueAndWait(ctx, "Roof", "DOME_MOTION", "DOME_CW", indiclient.SwitchStateOn))
+
+	// The roof sends its definitions again for the client, and the weather driver is still watching.
+	for {
+		item := weather.next(t)
+		if set, ok := item.(*indiclient.SetSwitchVector); ok {
+			assert.Equal(t, "DOME_MOTION", set.Name)
+			break
+		}
+	}
+}
+
+func Test_Server_SlowClient(t *testing.T) {
+	s := server.New(discard, server.Options{MaxBlobQueueBytes: 4096})
+	defer s.Close()
+
+	camera, serverEnd := newRaw()
+	require.NoError(t, s.AttachDriver("camera", serverEnd))
+
+	// The slow client never reads anything.
+	slow, serverEnd := net.Pipe()
+	require.NoError(t, s.ServeClient(serverEnd))
+	defer slow.Close()
+
+	_, err := slow.Write([]byte(`<enableBLOB device="Camera">Also</enableBLOB><getProperties version="1.7"/>`))
+	require.NoError(t, err)
+
+	_, ok := camera.next(t).(*indiclient.GetProperties)
+	require.True(t, ok)
+
+	fast, serverEnd := newRaw()
+	require.NoError(t, s.ServeClient(serverEnd))
+
+	fast.send(t, `<enableBLOB device="Camera">Also</enableBLOB><getProperties version="1.7"/>`)
+
+	_, ok = camera.next(t).(*indiclient.GetProperties)
+	require.True(t, ok)
+
+	camera.send(t, cameraDefXML)
+	fast.next(t)
+	fast.next(t)
+
+	data := strings.Repeat("A", 1024)
+
+	for i := 0; i < 10; i++ {
+		camera.send(t, setBlobXML(data))
+
+		_, ok := fast.next(t).(*indiclient.SetBlobVector)
+		require.True(t, ok)
+	}
+
+	camera.send(t, `<message device="Camera" message="done"/>`)
+
+	msg, ok := fast.next(t).(*indiclient.Message)
+	require.True(t, ok)
+	assert.Equal(t, "done", msg.Message)
+
+	assert.True(t, s.DroppedBlobs() > 0)
+}
+
+func Test_Server_LargeBlob(t *testing.T) {
+	s := server.New(discard, server.Options{MaxBlobQueueBytes: 1024})
+	defer s.Close()
+
+	camera, serverEnd := newRaw()
+	require.NoError(t, s.AttachDriver("camera", serverEnd))
+
+	client, serverEnd := newRaw()
+	require.NoError(t, s.ServeClient(serverEnd))
+
+	client.send(t, `<enableBLOB device="Camera">Also</enableBLOB><getProperties version="1.7"/>`)
+
+	_, ok := camera.next(t).(*indiclient.GetProperties)
+	require.True(t, ok)
+
+	camera.send(t, cameraDefXML)
+	client.next(t)
+	client.next(t)
+
+	// A BLOB bigger than the limit still reaches a client with nothing else waiting.
+	data := strings.Repeat("A", 4096)
+	camera.send(t, setBlobXML(data))
+
+	set, ok := client.next(t).(*indiclient.SetBlobVector)
+	require.True(t, ok)
+	assert.Equal(t, data, set.Blobs[0].Value)
+	assert.Equal(t, uint64(0), s.DroppedBlobs())
+}
+
+func Test_Server_StartDriver(t *testing.T) {
+	os.Setenv(helperDriverEnv, "1")
+	defer os.Unsetenv(helperDriverEnv)
+
+	s := server.New(discard, server.Options{})
+	defer s.Close()
+
+	require.NoError(t, s.StartDriver(os.Args[0]))
+
+	c := newClient(t, s)
+	defer c.Disconnect()
+
+	require.NoError(t, c.GetProperties("", ""))
+
+	assert.Eventually(t, func() bool {
+		_, err := c.SwitchProperty("Helper", "EXIT")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, c.SetSwitchValue("Helper", "EXIT", "NOW", indiclient.SwitchStateOn))
+
+	// Once the driver exits, clients are told its device is gone.
+	assert.Eventually(t, func() bool {
+		_, err := c.Device("Helper")
+		return err == indiclient.ErrDeviceNotFound
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_Server_Listen(t *testing.T) {
+	s := server.New(discard, server.Options{})
+
+	defer attachRoof(t, s)()
+
+	addr, err := s.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	c := indiclient.NewINDIClient(discard, indiclient.NetworkDialer{}, afero.NewMemMapFs(), 10)
+	require.NoError(t, c.Connect("tcp", addr.String()))
+	defer c.Disconnect()
+
+	require.NoError(t, c.GetProperties("", ""))
+
+	assert.Eventually(t, func() bool {
+		_, err := c.SwitchProperty("Roof", "DOME_MOTION")
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Close())
+
+	select {
+	case <-c.Done():
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "client was not disconnected when the server closed")
+	}
+}
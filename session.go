@@ -0,0 +1,107 @@
+package indiclient
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// drainTimeout is how long shutdown waits for queued commands to be written before closing the connection anyway.
+const drainTimeout = time.Second
+
+// session is a single connection to indiserver, and the channels between it and the goroutines serving it.
+type session struct {
+	conn io.ReadWriteCloser
+
+	write chan interface{}
+	read  chan interface{}
+
+	// done is closed when the session is shut down. Nothing is sent on write or read after that.
+	done chan struct{}
+
+	writerDone     chan struct{}
+	dispatcherDone chan struct{}
+}
+
+func newSession(conn io.ReadWriteCloser, bufferSize int) *session {
+	return &session{
+		conn:           conn,
+		write:          make(chan interface{}, bufferSize),
+		read:           make(chan interface{}, bufferSize),
+		done:           make(chan struct{}),
+		writerDone:     make(chan struct{}),
+		dispatcherDone: make(chan struct{}),
+	}
+}
+
+// shutdown stops the session and closes its connection. It waits for the writer to flush queued commands (for up to
+// drainTimeout) and for the dispatcher to stop. The reader stops on its own once the closed connection fails its
+// read. shutdown must only be called once, by whoever removed the session from the client.
+func (s *session) shutdown() error {
+	close(s.done)
+
+	select {
+	case <-s.writerDone:
+	case <-time.After(drainTimeout):
+	}
+
+	err := s.conn.Close()
+
+	<-s.writerDone
+	<-s.dispatcherDone
+
+	return err
+}
+
+// send queues cmd to be written without blocking. ErrNotConnected is returned if the client is not connected, and
+// ErrWriteQueueFull if the write queue has no room.
+func (c *INDIClient) send(cmd interface{}) error {
+	c.connMu.Lock()
+	sess := c.sess
+	c.connMu.Unlock()
+
+	if sess == nil {
+		return ErrNotConnected
+	}
+
+	select {
+	case <-sess.done:
+		return ErrNotConnected
+	default:
+	}
+
+	select {
+	case sess.write <- cmd:
+		return nil
+	case <-sess.done:
+		return ErrNotConnected
+	default:
+		return ErrWriteQueueFull
+	}
+}
+
+// sendContext is like send, but waits for room in the write queue until ctx is done.
+func (c *INDIClient) sendContext(ctx context.Context, cmd interface{}) error {
+	c.connMu.Lock()
+	sess := c.sess
+	c.connMu.Unlock()
+
+	if sess == nil {
+		return ErrNotConnected
+	}
+
+	select {
+	case <-sess.done:
+		return ErrNotConnected
+	default:
+	}
+
+	select {
+	case sess.write <- cmd:
+		return nil
+	case <-sess.done:
+		return ErrNotConnected
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,136 @@
+package indiclient_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rickbassham/logging"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+// blockingDialer never finishes dialing.
+type blockingDialer struct{}
+
+func (blockingDialer) Dial(network, address string) (io.ReadWriteCloser, error) {
+	select {}
+}
+
+func Test_ConnectContext_Canceled(t *testing.T) {
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+	c := indiclient.NewINDIClient(log, blockingDialer{}, afero.NewMemMapFs(), 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.ConnectContext(ctx, "tcp", "localhost:7624")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, c.IsConnected())
+}
+
+func Test_NotConnected(t *testing.T) {
+	log := logging.NewLogger(ioutil.Discard, logging.JSONFormatter{}, logging.LogLevelInfo)
+	c := indiclient.NewINDIClient(log, &pipeDialer{}, afero.NewMemMapFs(), 5)
+
+	err := c.GetProperties("", "")
+	assert.Equal(t, indiclient.ErrNotConnected, err)
+
+	select {
+	case <-c.Done():
+	default:
+		assert.Fail(t, "Done should be closed when not connected")
+	}
+}
+
+func Test_SetAfterDisconnect(t *testing.T) {
+	c, server := newPipeClient(t)
+
+	defineProperties(t, c, server, focuserDefXML, 1)
+
+	done := c.Done()
+	require.NoError(t, c.Disconnect())
+
+	select {
+	case <-done:
+	default:
+		assert.Fail(t, "Done should be closed after Disconnect")
+	}
+
+	err := c.GetProperties("", "")
+	assert.Equal(t, indiclient.ErrNotConnected, err)
+
+	assert.NotPanics(t, func() {
+		c.SetNumberValue("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "100")
+	})
+}
+
+func Test_WriteQueueFull(t *testing.T) {
+	c, _ := newPipeClient(t)
+
+	// Nothing reads from the server end, so the writer blocks on the first command and the rest fill the queue.
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = c.GetProperties("", "")
+	}
+
+	assert.Equal(t, indiclient.ErrWriteQueueFull, err)
+
+	require.NoError(t, c.Disconnect())
+}
+
+func Test_Disconnect_FlushesQueue(t *testing.T) {
+	c, server := newPipeClient(t)
+
+	for _, device := range []string{"A", "B", "C"} {
+		require.NoError(t, c.GetProperties(device, ""))
+	}
+
+	received := make(chan string, 3)
+
+	go func() {
+		dec := xml.NewDecoder(server)
+
+		for {
+			var cmd indiclient.GetProperties
+			if err := dec.Decode(&cmd); err != nil {
+				close(received)
+				return
+			}
+
+			received <- cmd.Device
+		}
+	}()
+
+	require.NoError(t, c.Disconnect())
+
+	devices := []string{}
+	for device := range received {
+		devices = append(devices, device)
+	}
+
+	assert.Equal(t, []string{"A", "B", "C"}, devices)
+}
+
+func Test_Done_ConnectionLost(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	done := c.Done()
+
+	server.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "timed out waiting for Done")
+	}
+
+	assert.False(t, c.IsConnected())
+}
@@ -0,0 +1,94 @@
+package indiclient_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+const mountDefXML = `<defNumberVector device="Mount" name="EQUATORIAL_EOD_COORD" label="Eq. Coordinates" group="Main" state="Idle" perm="rw" timeout="60">
+	<defNumber name="RA" label="RA (hh:mm:ss)" format="%010.6m" min="0" max="24" step="0">0</defNumber>
+	<defNumber name="DEC" label="DEC (dd:mm:ss)" format="%010.6m" min="-90" max="90" step="0">90</defNumber>
+</defNumberVector>`
+
+// defineProperties sends xml to the client and waits until it has processed count property definitions.
+func defineProperties(t *testing.T, c *indiclient.INDIClient, server io.Writer, xml string, count int) {
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyDefined}}, count, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, xml)
+
+	for i := 0; i < count; i++ {
+		nextEvent(t, s)
+	}
+}
+
+func Test_SetNumberValues(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, mountDefXML, 1)
+
+	err := c.SetNumberValues("Mount", "EQUATORIAL_EOD_COORD", map[string]string{
+		"RA":  "12.5",
+		"DEC": "-30",
+	})
+	require.NoError(t, err)
+
+	var cmd indiclient.NewNumberVector
+	readCommand(t, server, &cmd)
+
+	assert.Equal(t, "Mount", cmd.Device)
+	assert.Equal(t, "EQUATORIAL_EOD_COORD", cmd.Name)
+	require.Len(t, cmd.Numbers, 2)
+	assert.Equal(t, "DEC", cmd.Numbers[0].Name)
+	assert.Equal(t, "-30", cmd.Numbers[0].Value)
+	assert.Equal(t, "RA", cmd.Numbers[1].Name)
+	assert.Equal(t, "12.5", cmd.Numbers[1].Value)
+}
+
+func Test_SetNumberValues_Errors(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, mountDefXML, 1)
+
+	err := c.SetNumberValues("Mount", "EQUATORIAL_EOD_COORD", map[string]string{})
+	assert.Equal(t, indiclient.ErrNoValues, err)
+
+	err = c.SetNumberValues("Mount", "EQUATORIAL_EOD_COORD", map[string]string{"RA": "1", "ALT": "2"})
+	assert.Equal(t, indiclient.ErrPropertyValueNotFound, err)
+
+	err = c.SetNumberValues("Mount", "HORIZONTAL_COORD", map[string]string{"ALT": "2"})
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+
+	err = c.SetTextValues("Mount", "EQUATORIAL_EOD_COORD", map[string]string{"RA": "1"})
+	assert.Equal(t, indiclient.ErrPropertyNotFound, err)
+}
+
+func Test_SetBlobValues(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, `<defBLOBVector device="Camera" name="UPLOAD" state="Idle" perm="rw" timeout="0">
+	<defBLOB name="A" label="A"/>
+	<defBLOB name="B" label="B"/>
+</defBLOBVector>`, 1)
+
+	err := c.SetBlobValues("Camera", "UPLOAD", []indiclient.OneBlob{
+		{Name: "A", Value: "MTIz", Format: ".txt", Size: 3},
+		{Name: "B", Value: "NDU2", Format: ".txt", Size: 3},
+	})
+	require.NoError(t, err)
+
+	var cmd indiclient.NewBlobVector
+	readCommand(t, server, &cmd)
+
+	require.Len(t, cmd.Blobs, 2)
+	assert.Equal(t, "A", cmd.Blobs[0].Name)
+	assert.Equal(t, "NDU2", cmd.Blobs[1].Value)
+}
@@ -0,0 +1,171 @@
+package indiclient
+
+import (
+	"sort"
+	"sync"
+)
+
+// Snapshot is a consistent copy of every device known by an INDIClient at a single revision.
+type Snapshot struct {
+	Revision uint64   `json:"revision"`
+	Devices  []Device `json:"devices"`
+}
+
+// Device returns the device with the given name from the snapshot.
+func (s Snapshot) Device(name string) (Device, bool) {
+	for _, d := range s.Devices {
+		if d.Name == name {
+			return d, true
+		}
+	}
+
+	return Device{}, false
+}
+
+// deviceStore holds the devices known by a client.
+//
+// Writes are serialized through update, and a Device is never modified once it has been stored; update works on a
+// copy and stores that instead. This means the Device returned by load can be read without holding any lock, as long
+// as it is not modified. Anything handed to callers outside the package is deep copied first.
+//
+// Every write increments the revision of the store, and stamps it on the device that changed.
+type deviceStore struct {
+	mu       sync.RWMutex
+	devices  map[string]Device
+	revision uint64
+}
+
+// load returns the stored device, which must not be modified.
+func (s *deviceStore) load(name string) (Device, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.devices[name]
+
+	return d, ok
+}
+
+// update calls fn with a copy of the named device, and stores the copy if fn returns nil. If the device does not
+// exist, it is created when create is true, and ErrDeviceNotFound is returned otherwise. fn is called with the store
+// locked, so it should not block.
+//
+// fn may add, replace, or delete properties in the maps of the device, and append to its Messages, but must not modify
+// a property it found there without cloning it first.
+func (s *deviceStore) update(name string, create bool, fn func(device *Device) error) (created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[name]
+	if ok {
+		device = device.copyForWrite()
+	} else if create {
+		created = true
+		device = Device{
+			Name:             name,
+			TextProperties:   map[string]TextProperty{},
+			SwitchProperties: map[string]SwitchProperty{},
+			NumberProperties: map[string]NumberProperty{},
+			LightProperties:  map[string]LightProperty{},
+			BlobProperties:   map[string]BlobProperty{},
+		}
+	} else {
+		return false, ErrDeviceNotFound
+	}
+
+	err = fn(&device)
+	if err != nil {
+		return false, err
+	}
+
+	if s.devices == nil {
+		s.devices = map[string]Device{}
+	}
+
+	s.revision++
+	device.Revision = s.revision
+	s.devices[name] = device
+
+	return created, nil
+}
+
+// delete removes the named device, returning false if it did not exist.
+func (s *deviceStore) delete(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.devices[name]; !ok {
+		return false
+	}
+
+	delete(s.devices, name)
+	s.revision++
+
+	return true
+}
+
+// clear removes every device, returning their names in alphabetical order.
+func (s *deviceStore) clear() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.devices))
+	for name := range s.devices {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if len(names) > 0 {
+		s.devices = map[string]Device{}
+		s.revision++
+	}
+
+	return names
+}
+
+func (s *deviceStore) currentRevision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.revision
+}
+
+// snapshot returns a deep copy of every device, sorted by name.
+func (s *deviceStore) snapshot() Snapshot {
+	s.mu.RLock()
+
+	snap := Snapshot{
+		Revision: s.revision,
+		Devices:  make([]Device, 0, len(s.devices)),
+	}
+
+	for _, d := range s.devices {
+		snap.Devices = append(snap.Devices, d)
+	}
+
+	s.mu.RUnlock()
+
+	// Stored devices are never modified, so they can be copied without holding the lock.
+	for i, d := range snap.Devices {
+		snap.Devices[i] = d.clone()
+	}
+
+	sort.Slice(snap.Devices, func(i, j int) bool {
+		return snap.Devices[i].Name < snap.Devices[j].Name
+	})
+
+	return snap
+}
+
+// Snapshot returns a deep copy of every device the client knows about, along with the revision it was taken at. The
+// copy belongs to the caller and is never modified by the client.
+func (c *INDIClient) Snapshot() Snapshot {
+	return c.devices.snapshot()
+}
+
+// Revision returns the current revision of the devices known by the client. It is incremented every time a device or
+// property changes, so comparing it with the Revision of an earlier Snapshot is a cheap way to find out whether
+// anything has changed since.
+func (c *INDIClient) Revision() uint64 {
+	return c.devices.currentRevision()
+}
@@ -0,0 +1,138 @@
+package indiclient_test
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+const focuserMoveXML = `<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Ok" timeout="60">
+	<oneNumber name="FOCUS_ABSOLUTE_POSITION">200</oneNumber>
+</setNumberVector>`
+
+func Test_Snapshot_Isolated(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, focuserDefXML, 1)
+
+	before := c.Snapshot()
+	require.Len(t, before.Devices, 1)
+
+	// Modifying a snapshot must not affect the client.
+	device, ok := before.Device("Focuser")
+	require.True(t, ok)
+
+	nv := device.NumberProperties["ABS_FOCUS_POSITION"].Values["FOCUS_ABSOLUTE_POSITION"]
+	nv.Value = "999"
+	device.NumberProperties["ABS_FOCUS_POSITION"].Values["FOCUS_ABSOLUTE_POSITION"] = nv
+	delete(device.TextProperties, "anything")
+
+	current := c.Devices()
+	require.Len(t, current, 1)
+	assert.Equal(t, "100", current[0].NumberProperties["ABS_FOCUS_POSITION"].Values["FOCUS_ABSOLUTE_POSITION"].Value)
+
+	// Updates to the client must not affect an earlier snapshot.
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, focuserMoveXML)
+	nextEvent(t, s)
+
+	latest := c.Devices()[0]
+	assert.Equal(t, "100", current[0].NumberProperties["ABS_FOCUS_POSITION"].Values["FOCUS_ABSOLUTE_POSITION"].Value)
+	assert.Equal(t, "200", latest.NumberProperties["ABS_FOCUS_POSITION"].Values["FOCUS_ABSOLUTE_POSITION"].Value)
+}
+
+func Test_Revision(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	start := c.Revision()
+
+	defineProperties(t, c, server, focuserDefXML, 1)
+
+	defined := c.Snapshot()
+	assert.True(t, defined.Revision > start)
+	assert.Equal(t, defined.Revision, defined.Devices[0].Revision)
+
+	// Nothing changed, so the revision stays the same.
+	assert.Equal(t, defined.Revision, c.Revision())
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 1, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	io.WriteString(server, focuserMoveXML)
+	nextEvent(t, s)
+
+	updated := c.Snapshot()
+	assert.True(t, updated.Revision > defined.Revision)
+	assert.Equal(t, updated.Revision, updated.Devices[0].Revision)
+
+	// A rejected command changes nothing.
+	err := c.SetNumberValue("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "-5")
+	require.Error(t, err)
+	assert.Equal(t, updated.Revision, c.Revision())
+
+	err = c.Disconnect()
+	require.NoError(t, err)
+
+	assert.True(t, c.Revision() > updated.Revision)
+	assert.Empty(t, c.Snapshot().Devices)
+}
+
+func Test_Snapshot_ConcurrentReaders(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, focuserDefXML, 1)
+
+	s := c.Subscribe(indiclient.EventFilter{Types: []indiclient.EventType{indiclient.EventPropertyUpdated}}, 100, indiclient.DropPolicyNewest)
+	defer s.Close()
+
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for _, d := range c.Devices() {
+					for _, p := range d.NumberProperties {
+						for _, v := range p.Values {
+							_ = v.Value
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		io.WriteString(server, focuserMoveXML)
+		nextEvent(t, s)
+
+		err := c.SetNumberValue("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "300")
+		require.NoError(t, err)
+
+		var cmd indiclient.NewNumberVector
+		readCommand(t, server, &cmd)
+	}
+
+	close(stop)
+	wg.Wait()
+}
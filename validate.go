@@ -0,0 +1,160 @@
+package indiclient
+
+import (
+	"fmt"
+	"math"
+)
+
+// NumberBound names the limit of a NumberValue that a value violated. "min", "max", or "step".
+type NumberBound string
+
+const (
+	// NumberBoundMin means the value was less than the NumberValue's Min.
+	NumberBoundMin = NumberBound("min")
+	// NumberBoundMax means the value was greater than the NumberValue's Max.
+	NumberBoundMax = NumberBound("max")
+	// NumberBoundStep means the value was not Min plus a whole number of the NumberValue's Step.
+	NumberBoundStep = NumberBound("step")
+)
+
+// NumberRangeError is returned when an attempt is made to set a number outside of the limits the device defined for it.
+type NumberRangeError struct {
+	Device   string
+	Property string
+	Element  string
+	Bound    NumberBound
+	Limit    float64
+	Value    float64
+}
+
+func (e *NumberRangeError) Error() string {
+	switch e.Bound {
+	case NumberBoundMin:
+		return fmt.Sprintf("%s.%s.%s: %g is less than min %g", e.Device, e.Property, e.Element, e.Value, e.Limit)
+	case NumberBoundMax:
+		return fmt.Sprintf("%s.%s.%s: %g is greater than max %g", e.Device, e.Property, e.Element, e.Value, e.Limit)
+	default:
+		return fmt.Sprintf("%s.%s.%s: %g is not a multiple of step %g", e.Device, e.Property, e.Element, e.Value, e.Limit)
+	}
+}
+
+// SwitchRuleError is returned when an attempt is made to change a switch vector in a way its SwitchRule does not allow.
+// On is the number of switches that would have been On.
+type SwitchRuleError struct {
+	Device   string
+	Property string
+	Rule     SwitchRule
+	On       int
+}
+
+func (e *SwitchRuleError) Error() string {
+	return fmt.Sprintf("%s.%s: %d switches on violates rule %s", e.Device, e.Property, e.On, e.Rule)
+}
+
+// stepTolerance is how far, in steps, a value may be from an exact step and still be accepted. It absorbs floating
+// point error in values like 0.3 with a step of 0.1.
+const stepTolerance = 1e-6
+
+// checkRange returns a *NumberRangeError if v is outside the limits of nv. As in the INDI spec, limits are ignored
+// when Min equals Max, and Step is ignored when it is 0 or Min is not a multiple of it. Limits that cannot be parsed
+// are ignored too.
+func (nv NumberValue) checkRange(v float64, checkStep bool) *NumberRangeError {
+	min, minErr := ParseNumber(nv.Min)
+	max, maxErr := ParseNumber(nv.Max)
+
+	if minErr == nil && maxErr == nil && min < max {
+		if v < min {
+			return &NumberRangeError{Element: nv.Name, Bound: NumberBoundMin, Limit: min, Value: v}
+		}
+
+		if v > max {
+			return &NumberRangeError{Element: nv.Name, Bound: NumberBoundMax, Limit: max, Value: v}
+		}
+	}
+
+	step, stepErr := ParseNumber(nv.Step)
+	if !checkStep || stepErr != nil || step <= 0 {
+		return nil
+	}
+
+	if minErr != nil {
+		min = 0
+	}
+
+	// A Min that is not itself a whole number of steps, like the 0.01 and 1 of CCD_EXPOSURE_VALUE, means Step is only
+	// a hint.
+	if !wholeSteps(min, step) {
+		return nil
+	}
+
+	if !wholeSteps(v-min, step) {
+		return &NumberRangeError{Element: nv.Name, Bound: NumberBoundStep, Limit: step, Value: v}
+	}
+
+	return nil
+}
+
+// wholeSteps returns true if v is a whole number of steps, within stepTolerance.
+func wholeSteps(v, step float64) bool {
+	steps := v / step
+	return math.Abs(steps-math.Round(steps)) <= stepTolerance*math.Max(1, math.Abs(steps))
+}
+
+// resolve checks values against the Rule of p, and returns the switches that should actually be sent. OneOfMany
+// vectors are always sent in full, with exactly one switch On, and turning a switch On in an AtMostOne vector
+// explicitly turns every other switch Off. Many drivers silently ignore commands that leave a vector in a state its
+// rule does not allow.
+func (p SwitchProperty) resolve(values map[string]SwitchState) (map[string]SwitchState, error) {
+	on := 0
+	active := ""
+
+	for name, value := range values {
+		if value != SwitchStateOn && value != SwitchStateOff {
+			return nil, ErrInvalidSwitchState
+		}
+
+		if _, ok := p.Values[name]; !ok {
+			return nil, ErrPropertyValueNotFound
+		}
+
+		if value == SwitchStateOn {
+			on++
+			active = name
+		}
+	}
+
+	if p.Rule != SwitchRuleOneOfMany && p.Rule != SwitchRuleAtMostOne {
+		return values, nil
+	}
+
+	if on > 1 {
+		return nil, &SwitchRuleError{Rule: p.Rule, On: on}
+	}
+
+	if on == 0 {
+		if p.Rule == SwitchRuleAtMostOne {
+			return values, nil
+		}
+
+		// Only switches being turned Off were given, so exactly one of the others must already be On.
+		for name, v := range p.Values {
+			if _, ok := values[name]; !ok && v.Value == SwitchStateOn {
+				on++
+				active = name
+			}
+		}
+
+		if on != 1 {
+			return nil, &SwitchRuleError{Rule: p.Rule, On: on}
+		}
+	}
+
+	resolved := map[string]SwitchState{}
+	for name := range p.Values {
+		resolved[name] = SwitchStateOff
+	}
+
+	resolved[active] = SwitchStateOn
+
+	return resolved, nil
+}
@@ -0,0 +1,192 @@
+package indiclient_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+const binningDefXML = `<defSwitchVector device="Camera" name="Binning" rule="OneOfMany" state="Ok" perm="rw" timeout="0">
+	<defSwitch name="One" label="1:1">On</defSwitch>
+	<defSwitch name="Two" label="2:1">Off</defSwitch>
+	<defSwitch name="Three" label="3:1">Off</defSwitch>
+</defSwitchVector>
+<defSwitchVector device="Camera" name="Abort" rule="AtMostOne" state="Ok" perm="rw" timeout="0">
+	<defSwitch name="ABORT">Off</defSwitch>
+	<defSwitch name="RESET">Off</defSwitch>
+</defSwitchVector>`
+
+func Test_SetNumberValue_Range(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	c.SetStepValidation(true)
+
+	defineProperties(t, c, server, focuserDefXML, 1)
+
+	testCases := []struct {
+		value string
+		bound indiclient.NumberBound
+		limit float64
+	}{
+		{value: "-10", bound: indiclient.NumberBoundMin, limit: 0},
+		{value: "10010", bound: indiclient.NumberBoundMax, limit: 10000},
+		{value: "105", bound: indiclient.NumberBoundStep, limit: 10},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			err := c.SetNumberValue("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", tc.value)
+			require.Error(t, err)
+
+			rangeErr, ok := err.(*indiclient.NumberRangeError)
+			require.True(t, ok, "expected *NumberRangeError, got %T", err)
+
+			assert.Equal(t, "Focuser", rangeErr.Device)
+			assert.Equal(t, "ABS_FOCUS_POSITION", rangeErr.Property)
+			assert.Equal(t, "FOCUS_ABSOLUTE_POSITION", rangeErr.Element)
+			assert.Equal(t, tc.bound, rangeErr.Bound)
+			assert.Equal(t, tc.limit, rangeErr.Limit)
+		})
+	}
+
+	err := c.SetNumberValue("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "ten")
+	assert.True(t, errors.Is(err, indiclient.ErrInvalidNumber))
+}
+
+func Test_SetNumberValue_StepValidationDisabled(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, focuserDefXML, 1)
+
+	err := c.SetNumberValue("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "105")
+	require.NoError(t, err)
+
+	var cmd indiclient.NewNumberVector
+	readCommand(t, server, &cmd)
+	assert.Equal(t, "105", cmd.Numbers[0].Value)
+}
+
+// ccdExposureDefXML is CCD_EXPOSURE as libindi defines it, where Step is only a hint for GUI spinners.
+const ccdExposureDefXML = `<defNumberVector device="CCD Simulator" name="CCD_EXPOSURE" label="Expose" group="Main Control" state="Idle" perm="rw" timeout="60">
+	<defNumber name="CCD_EXPOSURE_VALUE" label="Duration (s)" format="%5.2f" min="0.01" max="3600" step="1">1</defNumber>
+</defNumberVector>`
+
+func Test_SetNumberValue_LibindiStep(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, ccdExposureDefXML, 1)
+
+	for _, value := range []string{"5", "0.5", "0.01", "3600"} {
+		t.Run(value, func(t *testing.T) {
+			err := c.SetNumberValue("CCD Simulator", "CCD_EXPOSURE", "CCD_EXPOSURE_VALUE", value)
+			require.NoError(t, err)
+
+			var cmd indiclient.NewNumberVector
+			readCommand(t, server, &cmd)
+			assert.Equal(t, value, cmd.Numbers[0].Value)
+		})
+	}
+
+	// Min and Max are still checked.
+	err := c.SetNumberValue("CCD Simulator", "CCD_EXPOSURE", "CCD_EXPOSURE_VALUE", "0")
+	rangeErr, ok := err.(*indiclient.NumberRangeError)
+	require.True(t, ok, "expected *NumberRangeError, got %T", err)
+	assert.Equal(t, indiclient.NumberBoundMin, rangeErr.Bound)
+}
+
+func Test_SetNumberValue_LibindiStepValidation(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	c.SetStepValidation(true)
+
+	defineProperties(t, c, server, ccdExposureDefXML, 1)
+
+	// A min of 0.01 is not a whole number of steps of 1, so the step is only a hint.
+	err := c.SetNumberValue("CCD Simulator", "CCD_EXPOSURE", "CCD_EXPOSURE_VALUE", "5")
+	require.NoError(t, err)
+
+	var cmd indiclient.NewNumberVector
+	readCommand(t, server, &cmd)
+	assert.Equal(t, "5", cmd.Numbers[0].Value)
+
+	err = c.SetNumberValue("CCD Simulator", "CCD_EXPOSURE", "CCD_EXPOSURE_VALUE", "4000")
+	rangeErr, ok := err.(*indiclient.NumberRangeError)
+	require.True(t, ok, "expected *NumberRangeError, got %T", err)
+	assert.Equal(t, indiclient.NumberBoundMax, rangeErr.Bound)
+}
+
+func Test_SetNumberValue_Sexagesimal(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, mountDefXML, 1)
+
+	err := c.SetNumberValues("Mount", "EQUATORIAL_EOD_COORD", map[string]string{"RA": "12:30:00", "DEC": "-45:15"})
+	require.NoError(t, err)
+
+	var cmd indiclient.NewNumberVector
+	readCommand(t, server, &cmd)
+	assert.Equal(t, "-45.25", cmd.Numbers[0].Value)
+	assert.Equal(t, "12.5", cmd.Numbers[1].Value)
+
+	err = c.SetNumberValue("Mount", "EQUATORIAL_EOD_COORD", "RA", "25:00:00")
+	_, ok := err.(*indiclient.NumberRangeError)
+	assert.True(t, ok)
+}
+
+func Test_SetSwitchValue_OneOfMany(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, binningDefXML, 2)
+
+	err := c.SetSwitchValue("Camera", "Binning", "Two", indiclient.SwitchStateOn)
+	require.NoError(t, err)
+
+	var cmd indiclient.NewSwitchVector
+	readCommand(t, server, &cmd)
+
+	assert.Equal(t, []indiclient.OneSwitch{
+		{XMLName: cmd.Switches[0].XMLName, Name: "One", Value: indiclient.SwitchStateOff},
+		{XMLName: cmd.Switches[0].XMLName, Name: "Three", Value: indiclient.SwitchStateOff},
+		{XMLName: cmd.Switches[0].XMLName, Name: "Two", Value: indiclient.SwitchStateOn},
+	}, cmd.Switches)
+
+	// Turning off the only switch that is On would leave nothing On.
+	err = c.SetSwitchValue("Camera", "Binning", "One", indiclient.SwitchStateOff)
+	assert.Equal(t, &indiclient.SwitchRuleError{Device: "Camera", Property: "Binning", Rule: indiclient.SwitchRuleOneOfMany, On: 0}, err)
+
+	err = c.SetSwitchValues("Camera", "Binning", map[string]indiclient.SwitchState{"Two": indiclient.SwitchStateOn, "Three": indiclient.SwitchStateOn})
+	assert.Equal(t, &indiclient.SwitchRuleError{Device: "Camera", Property: "Binning", Rule: indiclient.SwitchRuleOneOfMany, On: 2}, err)
+
+	err = c.SetSwitchValue("Camera", "Binning", "Two", indiclient.SwitchState("on"))
+	assert.Equal(t, indiclient.ErrInvalidSwitchState, err)
+}
+
+func Test_SetSwitchValue_AtMostOne(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, binningDefXML, 2)
+
+	err := c.SetSwitchValues("Camera", "Abort", map[string]indiclient.SwitchState{"ABORT": indiclient.SwitchStateOn, "RESET": indiclient.SwitchStateOn})
+	assert.Equal(t, &indiclient.SwitchRuleError{Device: "Camera", Property: "Abort", Rule: indiclient.SwitchRuleAtMostOne, On: 2}, err)
+
+	err = c.SetSwitchValue("Camera", "Abort", "ABORT", indiclient.SwitchStateOn)
+	require.NoError(t, err)
+
+	var cmd indiclient.NewSwitchVector
+	readCommand(t, server, &cmd)
+
+	require.Len(t, cmd.Switches, 2)
+	assert.Equal(t, indiclient.SwitchStateOn, cmd.Switches[0].Value)
+	assert.Equal(t, indiclient.SwitchStateOff, cmd.Switches[1].Value)
+}
@@ -0,0 +1,243 @@
+package indiclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PropertyAlertError is returned by the *AndWait calls when the device finishes a command with the property in the
+// Alert state. Message is the message the device sent along with the Alert, if any.
+type PropertyAlertError struct {
+	Device   string
+	Property string
+	Message  string
+}
+
+func (e *PropertyAlertError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("%s.%s: property alert", e.Device, e.Property)
+	}
+
+	return fmt.Sprintf("%s.%s: property alert: %s", e.Device, e.Property, e.Message)
+}
+
+// SetTextValueAndWait is like SetTextValue, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetTextValueAndWait(ctx context.Context, deviceName, propName, textName, textValue string) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetTextValue(deviceName, propName, textName, textValue)
+	})
+}
+
+// SetNumberValueAndWait is like SetNumberValue, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetNumberValueAndWait(ctx context.Context, deviceName, propName, numberName, numberValue string) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetNumberValue(deviceName, propName, numberName, numberValue)
+	})
+}
+
+// SetSwitchValueAndWait is like SetSwitchValue, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetSwitchValueAndWait(ctx context.Context, deviceName, propName, switchName string, switchValue SwitchState) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetSwitchValue(deviceName, propName, switchName, switchValue)
+	})
+}
+
+// SetBlobValueAndWait is like SetBlobValue, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetBlobValueAndWait(ctx context.Context, deviceName, propName, blobName, blobValue, blobFormat string, blobSize int) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetBlobValue(deviceName, propName, blobName, blobValue, blobFormat, blobSize)
+	})
+}
+
+// SetTextValuesAndWait is like SetTextValues, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetTextValuesAndWait(ctx context.Context, deviceName, propName string, values map[string]string) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetTextValues(deviceName, propName, values)
+	})
+}
+
+// SetNumberValuesAndWait is like SetNumberValues, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetNumberValuesAndWait(ctx context.Context, deviceName, propName string, values map[string]string) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetNumberValues(deviceName, propName, values)
+	})
+}
+
+// SetSwitchValuesAndWait is like SetSwitchValues, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetSwitchValuesAndWait(ctx context.Context, deviceName, propName string, values map[string]SwitchState) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetSwitchValues(deviceName, propName, values)
+	})
+}
+
+// SetBlobValuesAndWait is like SetBlobValues, but waits for the device to finish processing the command. See
+// WaitForProperty for how completion is detected.
+func (c *INDIClient) SetBlobValuesAndWait(ctx context.Context, deviceName, propName string, blobs []OneBlob) error {
+	return c.WaitForProperty(ctx, deviceName, propName, func() error {
+		return c.SetBlobValues(deviceName, propName, blobs)
+	})
+}
+
+// DefaultWaitGracePeriod is how long WaitForProperty waits for a device to report Busy before it believes an Ok, Idle
+// or Alert update that arrived first.
+const DefaultWaitGracePeriod = 500 * time.Millisecond
+
+// SetWaitGracePeriod sets how long WaitForProperty waits for a device to report Busy before it believes an update
+// that arrived first. The default is DefaultWaitGracePeriod. With 0, the first update that is not Busy finishes the
+// wait. This should be called before Connect.
+func (c *INDIClient) SetWaitGracePeriod(d time.Duration) {
+	c.waitGrace = d
+}
+
+// WaitForProperty calls send, then waits for the device to report the property is no longer Busy.
+//
+// nil is returned once the device reports the property as Ok or Idle. A *PropertyAlertError is returned if it reports
+// Alert. If ctx has no deadline, the timeout the device gave for the property is used instead; if that is zero too,
+// WaitForProperty waits until ctx is done. ErrDeviceNotFound or ErrPropertyNotFound is returned if the device or
+// property is deleted while waiting.
+//
+// Drivers re-send some properties as they change, such as EQUATORIAL_EOD_COORD while a mount tracks, so an update
+// that was already on its way when send was called can arrive before the device has seen the command. Updates are
+// only believed once the device has reported the property Busy, or if nothing else arrives within the grace period
+// set with SetWaitGracePeriod, for devices that finish a command straight away.
+//
+// send is usually one of the Set* calls. Any update to the property that arrives after send is called counts, so
+// make sure nothing else is changing the same property at the same time.
+func (c *INDIClient) WaitForProperty(ctx context.Context, deviceName, propName string, send func() error) error {
+	device, err := c.findDevice(deviceName)
+	if err != nil {
+		return err
+	}
+
+	timeout, err := device.propertyTimeout(propName)
+	if err != nil {
+		return err
+	}
+
+	// Only the latest state of the property matters, so older updates can safely be dropped.
+	s := c.Subscribe(EventFilter{Device: deviceName, Property: propName}, 0, DropPolicyOldest)
+	defer s.Close()
+
+	removed := c.Subscribe(EventFilter{Device: deviceName, Types: []EventType{EventDeviceRemoved}}, 1, DropPolicyNewest)
+	defer removed.Close()
+
+	err = send()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	w := c.NewCompletion()
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-removed.Events():
+			return ErrDeviceNotFound
+		case <-w.Expired():
+			return w.Result()
+		case e := <-s.Events():
+			switch e.Type {
+			case EventPropertyDeleted:
+				return ErrPropertyNotFound
+			case EventPropertyUpdated:
+				if done, err := w.Update(e); done {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Completion tells when a device has finished a command from the updates to the property the command was sent to,
+// the same way WaitForProperty does, for callers that need to see the updates themselves. Create one with
+// NewCompletion, feed it every update to the property that arrives after the command is sent, and call Stop when done
+// with it.
+type Completion struct {
+	grace time.Duration
+	busy  bool
+
+	// result is the result of the latest update that arrived before Busy, and is returned once timer fires.
+	result  error
+	timer   *time.Timer
+	expired <-chan time.Time
+}
+
+// NewCompletion returns a Completion that uses the grace period set with SetWaitGracePeriod.
+func (c *INDIClient) NewCompletion() *Completion {
+	return &Completion{grace: c.waitGrace}
+}
+
+// Update takes an update to the property, and returns true if the command has finished, along with nil, or a
+// *PropertyAlertError if the device reported Alert.
+func (w *Completion) Update(e Event) (bool, error) {
+	if e.State() == PropertyStateBusy {
+		w.busy = true
+		w.expired = nil
+
+		return false, nil
+	}
+
+	var err error
+
+	if e.State() == PropertyStateAlert {
+		err = &PropertyAlertError{
+			Device:   e.Device,
+			Property: e.Property,
+			Message:  e.Message,
+		}
+	}
+
+	if w.busy || w.grace <= 0 {
+		return true, err
+	}
+
+	w.result = err
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	w.timer = time.NewTimer(w.grace)
+	w.expired = w.timer.C
+
+	return false, nil
+}
+
+// Busy returns true once the device has reported the property Busy, so the updates that follow are about the command.
+func (w *Completion) Busy() bool {
+	return w.busy
+}
+
+// Expired receives once the grace period has passed since an update that arrived before the device reported Busy,
+// at which point the command is taken to have finished, with the result returned by Result.
+func (w *Completion) Expired() <-chan time.Time {
+	return w.expired
+}
+
+// Result returns the result of the command once Expired has received.
+func (w *Completion) Result() error {
+	return w.result
+}
+
+// Stop releases the resources of the Completion.
+func (w *Completion) Stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
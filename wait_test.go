@@ -0,0 +1,172 @@
+package indiclient_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goastro/indiclient"
+)
+
+func Test_SetNumberValueAndWait(t *testing.T) {
+	testCases := []struct {
+		name     string
+		replies  []string
+		expected error
+	}{
+		{
+			name: "ok",
+			replies: []string{
+				`<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Busy"><oneNumber name="FOCUS_ABSOLUTE_POSITION">150</oneNumber></setNumberVector>`,
+				`<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Ok"><oneNumber name="FOCUS_ABSOLUTE_POSITION">200</oneNumber></setNumberVector>`,
+			},
+		},
+		{
+			name: "alert",
+			replies: []string{
+				`<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Busy"/>`,
+				`<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Alert" message="motor stalled"/>`,
+			},
+			expected: &indiclient.PropertyAlertError{Device: "Focuser", Property: "ABS_FOCUS_POSITION", Message: "motor stalled"},
+		},
+		{
+			name: "deleted",
+			replies: []string{
+				`<delProperty device="Focuser" name="ABS_FOCUS_POSITION"/>`,
+			},
+			expected: indiclient.ErrPropertyNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, server := newPipeClient(t)
+			defer c.Disconnect()
+
+			defineProperties(t, c, server, focuserDefXML, 1)
+
+			result := make(chan error, 1)
+			go func() {
+				result <- c.SetNumberValueAndWait(context.Background(), "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "200")
+			}()
+
+			var cmd indiclient.NewNumberVector
+			readCommand(t, server, &cmd)
+			assert.Equal(t, "200", cmd.Numbers[0].Value)
+
+			for _, reply := range tc.replies {
+				io.WriteString(server, reply)
+			}
+
+			select {
+			case err := <-result:
+				assert.Equal(t, tc.expected, err)
+			case <-time.After(2 * time.Second):
+				require.FailNow(t, "timed out waiting for SetNumberValueAndWait")
+			}
+		})
+	}
+}
+
+func Test_SetNumberValueAndWait_Timeout(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	defineProperties(t, c, server, `<defNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Idle" perm="rw" timeout="1">
+	<defNumber name="FOCUS_ABSOLUTE_POSITION" format="%6.0f" min="0" max="10000" step="1">100</defNumber>
+</defNumberVector>`, 1)
+
+	go readCommand(t, server, &indiclient.NewNumberVector{})
+
+	// The device's own timeout applies when ctx has no deadline.
+	start := time.Now()
+	err := c.SetNumberValueAndWait(context.Background(), "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "200")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) >= time.Second)
+
+	go readCommand(t, server, &indiclient.NewNumberVector{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	err = c.SetNumberValueAndWait(ctx, "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "200")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func Test_SetNumberValueAndWait_MissingProperty(t *testing.T) {
+	c, _ := newPipeClient(t)
+	defer c.Disconnect()
+
+	err := c.SetNumberValueAndWait(context.Background(), "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "200")
+	assert.Equal(t, indiclient.ErrDeviceNotFound, err)
+}
+
+func Test_SetNumberValueAndWait_StaleUpdate(t *testing.T) {
+	c, server := newPipeClient(t)
+	defer c.Disconnect()
+
+	c.SetWaitGracePeriod(100 * time.Millisecond)
+
+	defineProperties(t, c, server, focuserDefXML, 1)
+
+	wait := func() chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- c.SetNumberValueAndWait(context.Background(), "Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION", "200")
+		}()
+
+		readCommand(t, server, &indiclient.NewNumberVector{})
+
+		return result
+	}
+
+	notYet := func(result chan error, d time.Duration) {
+		select {
+		case err := <-result:
+			require.FailNow(t, "SetNumberValueAndWait returned early", "%v", err)
+		case <-time.After(d):
+		}
+	}
+
+	finished := func(result chan error) error {
+		select {
+		case err := <-result:
+			return err
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "timed out waiting for SetNumberValueAndWait")
+		}
+
+		return nil
+	}
+
+	// An Ok sent before the device saw the command does not count once the device goes Busy.
+	result := wait()
+
+	io.WriteString(server, `<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Ok"><oneNumber name="FOCUS_ABSOLUTE_POSITION">100</oneNumber></setNumberVector>`)
+	notYet(result, 50*time.Millisecond)
+
+	io.WriteString(server, `<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Busy"><oneNumber name="FOCUS_ABSOLUTE_POSITION">150</oneNumber></setNumberVector>`)
+	notYet(result, 200*time.Millisecond)
+
+	io.WriteString(server, `<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Ok"><oneNumber name="FOCUS_ABSOLUTE_POSITION">200</oneNumber></setNumberVector>`)
+	require.NoError(t, finished(result))
+
+	v, err := c.NumberValueFloat("Focuser", "ABS_FOCUS_POSITION", "FOCUS_ABSOLUTE_POSITION")
+	require.NoError(t, err)
+	assert.Equal(t, 200.0, v)
+
+	// A device that answers straight away is believed once the grace period is over.
+	result = wait()
+
+	start := time.Now()
+	io.WriteString(server, `<setNumberVector device="Focuser" name="ABS_FOCUS_POSITION" state="Alert" message="out of range"/>`)
+
+	assert.Equal(t, &indiclient.PropertyAlertError{Device: "Focuser", Property: "ABS_FOCUS_POSITION", Message: "out of range"}, finished(result))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
@@ -23,7 +23,7 @@ type DefTextVector struct {
 	Perm      PropertyPermission `xml:"perm,attr"`
 	Timeout   int                `xml:"timeout,attr"`
 	Timestamp string             `xml:"timestamp,attr"`
-	Message   string             `xml:"message"`
+	Message   string             `xml:"message,attr"`
 	Texts     []DefText          `xml:"defText"`
 }
 
@@ -48,7 +48,7 @@ type DefNumberVector struct {
 	Perm      PropertyPermission `xml:"perm,attr"`
 	Timeout   int                `xml:"timeout,attr"`
 	Timestamp string             `xml:"timestamp,attr"`
-	Message   string             `xml:"message"`
+	Message   string             `xml:"message,attr"`
 	Numbers   []DefNumber        `xml:"defNumber"`
 }
 
@@ -79,7 +79,7 @@ type DefSwitchVector struct {
 	Rule      SwitchRule         `xml:"rule,attr"`
 	Timeout   int                `xml:"timeout,attr"`
 	Timestamp string             `xml:"timestamp,attr"`
-	Message   string             `xml:"message"`
+	Message   string             `xml:"message,attr"`
 	Switches  []DefSwitch        `xml:"defSwitch"`
 }
 
@@ -102,7 +102,7 @@ type DefLightVector struct {
 	Group     string        `xml:"group,attr"`
 	State     PropertyState `xml:"state,attr"`
 	Timestamp string        `xml:"timestamp,attr"`
-	Message   string        `xml:"message"`
+	Message   string        `xml:"message,attr"`
 	Lights    []DefLight    `xml:"defLight"`
 }
 
@@ -127,7 +127,7 @@ type DefBlobVector struct {
 	Perm      PropertyPermission `xml:"perm,attr"`
 	Timeout   int                `xml:"timeout,attr"`
 	Timestamp string             `xml:"timestamp,attr"`
-	Message   string             `xml:"message"`
+	Message   string             `xml:"message,attr"`
 	Blobs     []DefBlob          `xml:"defBLOB"`
 }
 
@@ -260,7 +260,7 @@ type SetTextVector struct {
 	State     PropertyState `xml:"state,attr"`
 	Timeout   int           `xml:"timeout,attr"`
 	Timestamp string        `xml:"timestamp,attr"`
-	Message   string        `xml:"message"`
+	Message   string        `xml:"message,attr"`
 	Texts     []OneText     `xml:"oneText"`
 }
 
@@ -273,7 +273,7 @@ type SetNumberVector struct {
 	State     PropertyState `xml:"state,attr"`
 	Timeout   int           `xml:"timeout,attr"`
 	Timestamp string        `xml:"timestamp,attr"`
-	Message   string        `xml:"message"`
+	Message   string        `xml:"message,attr"`
 	Numbers   []OneNumber   `xml:"oneNumber"`
 }
 
@@ -286,7 +286,7 @@ type SetSwitchVector struct {
 	State     PropertyState `xml:"state,attr"`
 	Timeout   int           `xml:"timeout,attr"`
 	Timestamp string        `xml:"timestamp,attr"`
-	Message   string        `xml:"message"`
+	Message   string        `xml:"message,attr"`
 	Switches  []OneSwitch   `xml:"oneSwitch"`
 }
 
@@ -298,7 +298,7 @@ type SetLightVector struct {
 	Name      string        `xml:"name,attr"`
 	State     PropertyState `xml:"state,attr"`
 	Timestamp string        `xml:"timestamp,attr"`
-	Message   string        `xml:"message"`
+	Message   string        `xml:"message,attr"`
 	Lights    []OneLight    `xml:"oneLight"`
 }
 
@@ -311,7 +311,7 @@ type SetBlobVector struct {
 	State     PropertyState `xml:"state,attr"`
 	Timeout   int           `xml:"timeout,attr"`
 	Timestamp string        `xml:"timestamp,attr"`
-	Message   string        `xml:"message"`
+	Message   string        `xml:"message,attr"`
 	Blobs     []OneBlob     `xml:"oneBLOB"`
 }
 